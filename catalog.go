@@ -0,0 +1,163 @@
+package zenodb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/expr"
+	"github.com/getlantern/zenodb/sql"
+)
+
+const (
+	catalogPrefix = "zeno."
+
+	// CatalogTables is the system table that lists all tables and views
+	CatalogTables = catalogPrefix + "tables"
+	// CatalogFields is the system table that lists the fields of all tables
+	CatalogFields = catalogPrefix + "fields"
+	// CatalogStreams is the system table that lists the streams that tables
+	// read from
+	CatalogStreams = catalogPrefix + "streams"
+)
+
+var (
+	catalogTablesFields = core.Fields{
+		core.NewField("resolution_seconds", expr.MAX("resolution_seconds")),
+		core.NewField("retention_seconds", expr.MAX("retention_seconds")),
+		core.NewField("backfill_seconds", expr.MAX("backfill_seconds")),
+		core.NewField("min_flush_latency_seconds", expr.MAX("min_flush_latency_seconds")),
+		core.NewField("max_flush_latency_seconds", expr.MAX("max_flush_latency_seconds")),
+		core.NewField("num_fields", expr.MAX("num_fields")),
+	}
+
+	catalogFieldsFields = core.Fields{
+		core.NewField("position", expr.MAX("position")),
+	}
+
+	catalogStreamsFields = core.Fields{
+		core.NewField("num_tables", expr.MAX("num_tables")),
+		core.NewField("num_views", expr.MAX("num_views")),
+	}
+)
+
+// isCatalogQuery indicates whether the given query ultimately selects from one
+// of the catalog tables. Catalog queries are answered from the local schema,
+// even on clustered leaders.
+func isCatalogQuery(q *sql.Query) bool {
+	for q.FromSubQuery != nil {
+		q = q.FromSubQuery
+	}
+	return strings.HasPrefix(q.From, catalogPrefix)
+}
+
+func (db *DB) catalogTables() []*table {
+	db.tablesMutex.RLock()
+	tables := make([]*table, len(db.orderedTables))
+	copy(tables, db.orderedTables)
+	db.tablesMutex.RUnlock()
+	return tables
+}
+
+// catalogTablesTable lists every table and view, keyed by its name and
+// TableOpts. Durations are reported in seconds.
+func (db *DB) catalogTablesTable() *systemTable {
+	return db.newSystemTable(CatalogTables, catalogTablesFields, func() []*systemRow {
+		var rows []*systemRow
+		for _, t := range db.catalogTables() {
+			where := ""
+			if w := t.getWhere(); w != nil {
+				where = fmt.Sprint(w)
+			}
+			groupBy := make([]string, 0, len(t.GroupBy))
+			for _, gb := range t.GroupBy {
+				groupBy = append(groupBy, gb.Name)
+			}
+			if t.GroupByAll {
+				groupBy = []string{"*"}
+			}
+			rows = append(rows, &systemRow{
+				key: map[string]interface{}{
+					"name":         t.Name,
+					"stream":       t.From,
+					"view":         t.View,
+					"virtual":      t.Virtual,
+					"partition_by": strings.Join(t.PartitionBy, ","),
+					"group_by":     strings.Join(groupBy, ","),
+					"where":        where,
+					"sql":          strings.TrimSpace(t.TableOpts.SQL),
+				},
+				vals: map[string]float64{
+					"resolution_seconds":        t.Resolution.Seconds(),
+					"retention_seconds":         t.getRetentionPeriod().Seconds(),
+					"backfill_seconds":          t.Backfill.Seconds(),
+					"min_flush_latency_seconds": t.MinFlushLatency.Seconds(),
+					"max_flush_latency_seconds": t.MaxFlushLatency.Seconds(),
+					"num_fields":                float64(len(t.getFields())),
+				},
+			})
+		}
+		return rows
+	})
+}
+
+// catalogFieldsTable lists the fields of every table and view along with the
+// expressions that define them.
+func (db *DB) catalogFieldsTable() *systemTable {
+	return db.newSystemTable(CatalogFields, catalogFieldsFields, func() []*systemRow {
+		var rows []*systemRow
+		for _, t := range db.catalogTables() {
+			for i, field := range t.getFields() {
+				rows = append(rows, &systemRow{
+					key: map[string]interface{}{
+						"table_name": t.Name,
+						"field_name": field.Name,
+						"expr":       field.Expr.String(),
+					},
+					vals: map[string]float64{
+						"position": float64(i),
+					},
+				})
+			}
+		}
+		return rows
+	})
+}
+
+// catalogStreamsTable lists every stream that's read by at least one table.
+func (db *DB) catalogStreamsTable() *systemTable {
+	return db.newSystemTable(CatalogStreams, catalogStreamsFields, func() []*systemRow {
+		numTables := make(map[string]int)
+		numViews := make(map[string]int)
+		for _, t := range db.catalogTables() {
+			if t.View {
+				numViews[t.From]++
+			} else {
+				numTables[t.From]++
+			}
+		}
+		streams := make([]string, 0, len(numTables))
+		for stream := range numTables {
+			streams = append(streams, stream)
+		}
+		for stream := range numViews {
+			if numTables[stream] == 0 {
+				streams = append(streams, stream)
+			}
+		}
+		sort.Strings(streams)
+
+		rows := make([]*systemRow, 0, len(streams))
+		for _, stream := range streams {
+			rows = append(rows, &systemRow{
+				key: map[string]interface{}{"stream_name": stream},
+				vals: map[string]float64{
+					"num_tables": float64(numTables[stream]),
+					"num_views":  float64(numViews[stream]),
+				},
+			})
+		}
+		return rows
+	})
+}
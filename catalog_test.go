@@ -0,0 +1,93 @@
+package zenodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/golog"
+	"github.com/getlantern/vtime"
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/sql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalog(t *testing.T) {
+	db := &DB{
+		log:    golog.LoggerFor("catalog_test"),
+		clock:  vtime.NewVirtualClock(time.Now()),
+		tables: make(map[string]*table),
+	}
+	for _, opts := range []*TableOpts{
+		{Name: "table_a", RetentionPeriod: time.Hour, PartitionBy: []string{"x"}, SQL: "SELECT SUM(v) AS v FROM inbound WHERE x = 'a' GROUP BY x, period(1m)"},
+		{Name: "view_a", View: true, SQL: "SELECT v FROM inbound GROUP BY x, period(1m)"},
+		{Name: "table_b", SQL: "SELECT v FROM other GROUP BY *, period(1h)"},
+	} {
+		q, err := sql.Parse(opts.SQL)
+		if !assert.NoError(t, err) {
+			return
+		}
+		fields, err := q.Fields.Get(nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		tbl := &table{TableOpts: opts, Query: *q, fields: fields, db: db, retentionPeriod: int64(opts.RetentionPeriod)}
+		db.tables[opts.Name] = tbl
+		db.orderedTables = append(db.orderedTables, tbl)
+	}
+
+	iterate := func(name string) ([]bytemap.ByteMap, []core.Vals, core.Fields) {
+		var keys []bytemap.ByteMap
+		var vals []core.Vals
+		var fields core.Fields
+		st := db.getSystemTable(name)
+		if !assert.NotNil(t, st, name) {
+			return nil, nil, nil
+		}
+		_, err := st.Iterate(context.Background(), func(inFields core.Fields) error {
+			fields = inFields
+			return nil
+		}, func(key bytemap.ByteMap, rowVals core.Vals) (bool, error) {
+			keys = append(keys, key)
+			vals = append(vals, rowVals)
+			return true, nil
+		})
+		assert.NoError(t, err, name)
+		return keys, vals, fields
+	}
+
+	keys, vals, fields := iterate(CatalogTables)
+	if assert.Len(t, keys, 3) {
+		assert.Equal(t, "table_a", keys[0].Get("name"))
+		assert.Equal(t, "inbound", keys[0].Get("stream"))
+		assert.Equal(t, "x", keys[0].Get("partition_by"))
+		assert.Equal(t, "x", keys[0].Get("group_by"))
+		assert.NotEmpty(t, keys[0].Get("where"))
+		assert.Equal(t, true, keys[1].Get("view"))
+		assert.Equal(t, "*", keys[2].Get("group_by"))
+		retention, _ := vals[0][1].ValueAt(0, fields[1].Expr)
+		assert.EqualValues(t, 3600, retention)
+		resolution, _ := vals[2][0].ValueAt(0, fields[0].Expr)
+		assert.EqualValues(t, 3600, resolution)
+	}
+
+	keys, _, _ = iterate(CatalogFields)
+	if assert.Len(t, keys, 3) {
+		assert.Equal(t, "table_a", keys[0].Get("table_name"))
+		assert.Equal(t, "v", keys[0].Get("field_name"))
+		assert.Equal(t, "SUM(v)", keys[0].Get("expr"))
+	}
+
+	keys, vals, fields = iterate(CatalogStreams)
+	if assert.Len(t, keys, 2) {
+		assert.Equal(t, "inbound", keys[0].Get("stream_name"))
+		numTables, _ := vals[0][0].ValueAt(0, fields[0].Expr)
+		numViews, _ := vals[0][1].ValueAt(0, fields[1].Expr)
+		assert.EqualValues(t, 1, numTables)
+		assert.EqualValues(t, 1, numViews)
+		assert.Equal(t, "other", keys[1].Get("stream_name"))
+	}
+
+	assert.Nil(t, db.getSystemTable("tables"))
+}
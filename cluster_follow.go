@@ -124,6 +124,15 @@ func (db *DB) processFollowers(stop <-chan interface{}) {
 	statsInterval := 1 * time.Minute
 	statsTicker := time.NewTicker(statsInterval)
 
+	// pins tracks how far back in the WAL each follower still needs data, see
+	// walPinFor.
+	pins := newFollowerPins()
+	lastPinUpdate := time.Now()
+	updateFollowerPins := func() {
+		lastPinUpdate = time.Now()
+		db.updateFollowerPins(pins, streams, followers, lastPinUpdate)
+	}
+
 	newlyJoinedStreams := make(map[string]bool)
 	onFollowerJoined := func(f *follower) {
 		metrics.FollowerJoined(f.FollowerID)
@@ -191,6 +200,8 @@ func (db *DB) processFollowers(stop <-chan interface{}) {
 	var requests chan *partitionRequest
 	var results chan *partitionsResult
 
+	defer db.recordFollowerOffsets(nil)
+
 	printStats := func() {
 		for follower, count := range stats {
 			if count > 0 {
@@ -263,6 +274,7 @@ func (db *DB) processFollowers(stop <-chan interface{}) {
 					break extraFollowersLoop
 				}
 			}
+			updateFollowerPins()
 
 			earliestOffsetByStream := make(map[string]wal.Offset)
 
@@ -353,9 +365,13 @@ func (db *DB) processFollowers(stop <-chan interface{}) {
 				f.submit(entry)
 				stats[f.FollowerID]++
 			}
+			if time.Since(lastPinUpdate) > followerPinInterval {
+				updateFollowerPins()
+			}
 
 		case <-statsTicker.C:
 			printStats()
+			updateFollowerPins()
 		}
 	}
 }
@@ -631,9 +647,14 @@ func (db *DB) followWAL(stream string, offset wal.Offset, partitions map[string]
 type tableWithOffsets struct {
 	t  *table
 	os common.OffsetsBySource
+	// remove indicates that t should stop following
+	remove bool
 }
 
 func (to *tableWithOffsets) String() string {
+	if to.remove {
+		return fmt.Sprintf("%v - removed", to.t.Name)
+	}
 	return fmt.Sprintf("%v - %v", to.t.Name, to.os)
 }
 
@@ -643,7 +664,25 @@ func (db *DB) followLeaders(stream string, newSubscriber chan *tableWithOffsets,
 	timer := time.NewTimer(30 * time.Second)
 	var tables []*table
 	var offsets []common.OffsetsBySource
-	partitions := make(map[string]*common.Partition)
+
+	updateSubscribers := func(subscriber *tableWithOffsets) {
+		if !subscriber.remove {
+			tables = append(tables, subscriber.t)
+			offsets = append(offsets, subscriber.os)
+			return
+		}
+		// Build new slices so that we don't modify the ones used by a prior
+		// doFollowLeaders
+		newTables := make([]*table, 0, len(tables))
+		newOffsets := make([]common.OffsetsBySource, 0, len(offsets))
+		for i, t := range tables {
+			if t != subscriber.t {
+				newTables = append(newTables, t)
+				newOffsets = append(newOffsets, offsets[i])
+			}
+		}
+		tables, offsets = newTables, newOffsets
+	}
 
 waitForTables:
 	for {
@@ -658,22 +697,7 @@ waitForTables:
 			break waitForTables
 		case subscriber := <-newSubscriber:
 			db.log.Debugf("Got subscriber: %v", subscriber)
-			table := subscriber.t
-			os := subscriber.os
-			tables = append(tables, table)
-			offsets = append(offsets, os)
-			partitionKeysString, partitionKeys := sortedPartitionKeys(table.PartitionBy)
-			partition := partitions[partitionKeysString]
-			if partition == nil {
-				partition = &common.Partition{
-					Keys: partitionKeys,
-				}
-				partitions[partitionKeysString] = partition
-			}
-			partition.Tables = append(partition.Tables, &common.PartitionTable{
-				Name:    table.Name,
-				Offsets: os,
-			})
+			updateSubscribers(subscriber)
 			// Got some tables, don't wait as long this time
 			timer.Reset(5 * time.Second)
 		}
@@ -681,24 +705,49 @@ waitForTables:
 
 	for {
 		cancel := make(chan bool, 100)
-		db.Go(func(stop <-chan interface{}) {
-			db.doFollowLeaders(stream, tables, offsets, partitions, cancel, stop)
-		})
+		if len(tables) > 0 {
+			followTables, followOffsets := tables, offsets
+			partitions := partitionsFor(followTables, followOffsets)
+			db.Go(func(stop <-chan interface{}) {
+				db.doFollowLeaders(stream, followTables, followOffsets, partitions, cancel, stop)
+			})
+		}
 		select {
 		case <-stop:
 			return
 		case subscriber := <-newSubscriber:
+			db.log.Debugf("Got subscriber: %v", subscriber)
 			select {
 			case <-stop:
 				return
 			case cancel <- true:
-				tables = append(tables, subscriber.t)
-				offsets = append(offsets, subscriber.os)
+				updateSubscribers(subscriber)
 			}
 		}
 	}
 }
 
+// partitionsFor groups the given tables into partitions by their partition
+// keys.
+func partitionsFor(tables []*table, offsets []common.OffsetsBySource) map[string]*common.Partition {
+	partitions := make(map[string]*common.Partition)
+	for i, table := range tables {
+		partitionKeysString, partitionKeys := sortedPartitionKeys(table.PartitionBy)
+		partition := partitions[partitionKeysString]
+		if partition == nil {
+			partition = &common.Partition{
+				Keys: partitionKeys,
+			}
+			partitions[partitionKeysString] = partition
+		}
+		partition.Tables = append(partition.Tables, &common.PartitionTable{
+			Name:    table.Name,
+			Offsets: offsets[i],
+		})
+	}
+	return partitions
+}
+
 func (db *DB) doFollowLeaders(stream string, tables []*table, offsets []common.OffsetsBySource, partitions map[string]*common.Partition, cancel chan bool, stop <-chan interface{}) {
 	var offsetsMx sync.RWMutex
 	ins := make([]chan *walRead, 0, len(tables))
@@ -706,7 +755,7 @@ func (db *DB) doFollowLeaders(stream string, tables []*table, offsets []common.O
 		t := _t
 		in := make(chan *walRead) // blocking channel so that we don't bother reading if we're in the middle of flushing
 		ins = append(ins, in)
-		db.Go(func(stop <-chan interface{}) {
+		t.Go(func(stop <-chan interface{}) {
 			t.processInserts(in, stop)
 		})
 	}
@@ -757,6 +806,9 @@ func (db *DB) doFollowLeaders(stream string, tables []*table, offsets []common.O
 
 	db.opts.Follow(makeFollows, func(data []byte, newOffset wal.Offset, source int) error {
 		for i, in := range ins {
+			if tables[i].isDropped() {
+				continue
+			}
 			offsetsMx.Lock()
 			priorOffsets := offsets[i]
 			if priorOffsets == nil {
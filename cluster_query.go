@@ -44,6 +44,9 @@ func (db *DB) remoteQueryHandlerForPartition(partition int) planner.QueryCluster
 }
 
 func (db *DB) queryForRemote(ctx context.Context, sqlString string, isSubQuery bool, subQueryResults [][]interface{}, unflat bool, onFields core.OnFields, onRow core.OnRow, onFlatRow core.OnFlatRow) (result interface{}, err error) {
+	ctx, finished := db.RegisterQuery(ctx, common.QueryID(ctx), QueryOriginCluster, sqlString)
+	defer finished()
+
 	source, prepareErr := db.Query(sqlString, isSubQuery, subQueryResults, common.ShouldIncludeMemStore(ctx))
 	if prepareErr != nil {
 		db.log.Errorf("Error on preparing query for remote: %v", prepareErr)
@@ -134,6 +137,11 @@ func (db *DB) queryCluster(ctx context.Context, sqlString string, isSubQuery boo
 		atomic.StoreInt64(&_stopped, 1)
 	}
 
+	rq := runningQueryFor(ctx)
+	if rq != nil {
+		rq.startPartitions(numPartitions)
+	}
+
 	subCtx := ctx
 	ctxDeadline, ctxHasDeadline := subCtx.Deadline()
 	if ctxHasDeadline {
@@ -151,6 +159,9 @@ func (db *DB) queryCluster(ctx context.Context, sqlString string, isSubQuery boo
 		_resultsForPartition := int64(0)
 		resultsForPartition := &_resultsForPartition
 		resultsByPartition[partition] = resultsForPartition
+		if rq != nil {
+			rq.trackPartition(partition, resultsForPartition)
+		}
 		go func() {
 			for {
 				elapsed := mtime.Stopwatch()
@@ -183,6 +194,9 @@ func (db *DB) queryCluster(ctx context.Context, sqlString string, isSubQuery boo
 							vals:      vals,
 						}
 						atomic.AddInt64(resultsForPartition, 1)
+						if rq != nil {
+							rq.received(len(key) + valsSize(vals))
+						}
 						return true, nil
 					}
 				} else {
@@ -199,6 +213,9 @@ func (db *DB) queryCluster(ctx context.Context, sqlString string, isSubQuery boo
 							flatRow:   row,
 						}
 						atomic.AddInt64(resultsForPartition, 1)
+						if rq != nil {
+							rq.received(len(row.Key) + 8*len(row.Values))
+						}
 						return true, nil
 					}
 				}
@@ -213,8 +230,11 @@ func (db *DB) queryCluster(ctx context.Context, sqlString string, isSubQuery boo
 				if err != nil {
 					switch err.(type) {
 					case common.Retriable:
-						db.log.Debugf("Failed on partition %d but error is retriable, continuing: %v", partition, err)
-						continue
+						if subCtx.Err() == nil {
+							db.log.Debugf("Failed on partition %d but error is retriable, continuing: %v", partition, err)
+							continue
+						}
+						db.log.Debugf("Failed on partition %d with retriable error, but query was canceled: %v", partition, err)
 					default:
 						db.log.Debugf("Failed on partition %d and error is not retriable, will abort: %v", partition, err)
 					}
@@ -312,8 +332,22 @@ func (db *DB) queryCluster(ctx context.Context, sqlString string, isSubQuery boo
 				fail(result.partition, result.err)
 			}
 			finish(result)
+			if rq != nil {
+				rq.finishPartition(result.partition)
+			}
 			db.log.Debugf("%d/%d got %d results from partition %d in %v", resultCount, db.opts.NumPartitions, result.totalRows, result.partition, result.elapsed)
 			delete(resultsByPartition, result.partition)
+		case <-ctx.Done():
+			err := core.Guard(ctx).Err()
+			if err == nil {
+				err = core.ErrCanceled
+			}
+			db.log.Debugf("Query canceled with %d of %d partitions reporting: %v", resultCount, numPartitions, err)
+			stop()
+			for partition := range resultsByPartition {
+				fail(partition, err)
+			}
+			return finalStats(), err
 		case <-timeoutTimer.C:
 			db.log.Errorf("Failed to get results by within %v, %d of %d partitions reporting", timeout, resultCount, numPartitions)
 			msg := bytes.NewBuffer([]byte("Missing partitions: "))
@@ -334,6 +368,14 @@ func (db *DB) queryCluster(ctx context.Context, sqlString string, isSubQuery boo
 	return finalStats(), finalErr()
 }
 
+func valsSize(vals core.Vals) int {
+	size := 0
+	for _, val := range vals {
+		size += len(val)
+	}
+	return size
+}
+
 func partitionRowMapper(canonicalFields core.Fields, partitionFields core.Fields) func(core.Vals) core.Vals {
 	if canonicalFields.Equals(partitionFields) {
 		return func(vals core.Vals) core.Vals { return vals }
@@ -9,6 +9,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -17,10 +18,12 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/getlantern/appdir"
 	"github.com/getlantern/golog"
+	"github.com/getlantern/uuid"
 	"github.com/getlantern/zenodb/common"
 	"github.com/getlantern/zenodb/core"
 	"github.com/getlantern/zenodb/encoding"
 	"github.com/getlantern/zenodb/rpc"
+	zsql "github.com/getlantern/zenodb/sql"
 	"golang.org/x/net/context"
 )
 
@@ -28,6 +31,7 @@ const (
 	basePrompt  = "zeno-cli >"
 	emptyPrompt = "            "
 	totalLabel  = "*total*"
+	nullLabel   = "NULL"
 
 	StatusMissingPartitions = 100
 	StatusResultsTooOld     = 101
@@ -45,6 +49,9 @@ var (
 	password        = flag.String("password", "", "if specified, will authenticate against server using this password")
 	allowIncomplete = flag.Bool("allowincomplete", false, "if specified, will allow incomplete results that are missing some data from 1 or more partitions")
 	maxAge          = flag.Duration("maxage", 2*time.Hour, "control how far out of date we allow results to be")
+
+	killQueryRegex = regexp.MustCompile(`(?i)^\s*KILL\s+QUERY\s+'?([^'\s]+)'?\s*$`)
+	snapshotRegex  = regexp.MustCompile(`(?i)^\s*SNAPSHOT\s+TO\s+'?([^'\s]+)'?\s*$`)
 )
 
 func main() {
@@ -147,10 +154,25 @@ func processLine(rl *readline.Instance, client rpc.Client, cmds []string, line s
 func query(stdout io.Writer, stderr io.Writer, client rpc.Client, sql string, csv bool) error {
 	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
 	defer cancel()
-	md, iterate, err := client.Query(ctx, sql, *fresh)
+
+	if match := killQueryRegex.FindStringSubmatch(sql); match != nil {
+		return killQuery(ctx, stderr, client, match[1])
+	}
+
+	if match := snapshotRegex.FindStringSubmatch(sql); match != nil {
+		return snapshot(ctx, stderr, client, match[1])
+	}
+
+	if zsql.IsDDL(sql) {
+		return executeDDL(ctx, stderr, client, sql)
+	}
+
+	queryID := uuid.New().String()
+	md, iterate, err := client.Query(common.WithQueryID(ctx, queryID), sql, *fresh)
 	if err != nil {
 		return err
 	}
+	printQueryStats(stderr, queryID, md)
 
 	now := time.Now()
 	var stats *common.QueryStats
@@ -173,9 +195,42 @@ func query(stdout io.Writer, stderr io.Writer, client rpc.Client, sql string, cs
 	return err
 }
 
-func dumpPlainText(stdout io.Writer, sql string, md *common.QueryMetaData, iterate func(onRow core.OnFlatRow) (*common.QueryStats, error)) (*common.QueryStats, error) {
-	printQueryStats(os.Stderr, md)
+// killQuery cancels the running query with the given id, which is either the
+// Query ID of a zeno-cli query or the permalink of a web query.
+func killQuery(ctx context.Context, stderr io.Writer, client rpc.Client, queryID string) error {
+	found, err := client.CancelQuery(ctx, queryID)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("query %v not found", queryID)
+	}
+	fmt.Fprintf(stderr, "Killed query %v\n", queryID)
+	return nil
+}
 
+// snapshot creates a snapshot of the server's database with the given name in
+// the server's snapshot root.
+func snapshot(ctx context.Context, stderr io.Writer, client rpc.Client, name string) error {
+	info, err := client.Snapshot(ctx, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stderr, "Snapshotted %d tables (%d segments) and %d WAL files to %v\n", info.Tables, info.Segments, info.WALFiles, info.Dir)
+	return nil
+}
+
+// executeDDL executes a CREATE, ALTER or DROP statement on the server.
+func executeDDL(ctx context.Context, stderr io.Writer, client rpc.Client, sql string) error {
+	err := client.ExecuteDDL(ctx, sql)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stderr, "Schema updated")
+	return nil
+}
+
+func dumpPlainText(stdout io.Writer, sql string, md *common.QueryMetaData, iterate func(onRow core.OnFlatRow) (*common.QueryStats, error)) (*common.QueryStats, error) {
 	// Read all rows into list and collect unique dimensions
 	var rows []*core.FlatRow
 	uniqueDims := make(map[string]bool)
@@ -235,6 +290,9 @@ func dumpPlainText(stdout io.Writer, sql string, md *common.QueryMetaData, itera
 			val = row.Values[i]
 			// }
 			width := len(fmt.Sprintf("%.4f", val))
+			if !row.IsSet(i) {
+				width = len(nullLabel)
+			}
 			if width > fieldWidths[i] {
 				fieldWidths[i] = width
 			}
@@ -344,7 +402,11 @@ func dumpPlainText(stdout io.Writer, sql string, md *common.QueryMetaData, itera
 			// } else {
 			val = row.Values[i]
 			// }
-			fmt.Fprintf(stdout, fieldFormats[outIdx], val)
+			if !row.IsSet(i) {
+				fmt.Fprintf(stdout, fieldLabelFormats[outIdx], nullLabel)
+			} else {
+				fmt.Fprintf(stdout, fieldFormats[outIdx], val)
+			}
 			outIdx++
 		}
 
@@ -365,8 +427,6 @@ func dumpPlainText(stdout io.Writer, sql string, md *common.QueryMetaData, itera
 }
 
 func dumpCSV(stdout io.Writer, md *common.QueryMetaData, iterate func(onRow core.OnFlatRow) (*common.QueryStats, error)) (*common.QueryStats, error) {
-	printQueryStats(os.Stderr, md)
-
 	w := csv.NewWriter(stdout)
 	defer w.Flush()
 
@@ -385,6 +445,11 @@ func dumpCSV(stdout io.Writer, md *common.QueryMetaData, iterate func(onRow core
 			// } else {
 			value = row.Values[i]
 			// }
+			if !row.IsSet(i) {
+				// NULL values are left empty
+				rowStrings = append(rowStrings, "")
+				continue
+			}
 			rowStrings = append(rowStrings, fmt.Sprintf("%f", value))
 		}
 		// First add known dims
@@ -511,12 +576,13 @@ func numFieldsFor(md *common.QueryMetaData) int {
 	return numFields
 }
 
-func printQueryStats(stderr io.Writer, md *common.QueryMetaData) {
+func printQueryStats(stderr io.Writer, queryID string, md *common.QueryMetaData) {
 	// TODO: maybe restore additional stats?
 	if !*queryStats {
 		return
 	}
 	fmt.Fprintln(stderr, "-------------------------------------------------")
+	fmt.Fprintf(stderr, "# Query ID:   %v\n", queryID)
 	fmt.Fprintf(stderr, "# As Of:      %v\n", md.AsOf.In(time.UTC).Format(time.RFC1123))
 	fmt.Fprintf(stderr, "# Until:      %v\n", md.Until.In(time.UTC).Format(time.RFC1123))
 	fmt.Fprintf(stderr, "# Resolution: %v\n", md.Resolution)
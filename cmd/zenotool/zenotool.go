@@ -1,4 +1,5 @@
-// zenotool provides the ability to filter and merge zeno datafiles offline.
+// zenotool provides the ability to filter and merge zeno datafiles offline and
+// to restore databases from snapshots.
 package main
 
 import (
@@ -8,6 +9,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/getlantern/golog"
 	"github.com/getlantern/zenodb"
@@ -27,6 +29,9 @@ var (
 	check      = flag.Bool("check", false, "If set, this scans the files and makes sure they're fully readable")
 	checktable = flag.Bool("checktable", false, "If set, this checks a single datafile for a given table")
 	permalinks = flag.Bool("permalinks", false, "If set, this returns a list of the permalinks in the database's webcache")
+	restore    = flag.Bool("restore", false, "If set, this restores the snapshot given as the first argument into the (new) db dir given as the second argument and replays the WAL")
+	recoverTo  = flag.String("recoverto", "", "When restoring, only replay WAL positions written up to this time (RFC3339, e.g. 2006-01-02T15:04:05Z)")
+	replayIdle = flag.Duration("replayidle", 5*time.Second, "When restoring, consider the WAL fully replayed once tables have been waiting this long for more data")
 )
 
 func main() {
@@ -67,6 +72,11 @@ func main() {
 		return
 	}
 
+	if *restore {
+		doRestore(inFiles)
+		return
+	}
+
 	if *check {
 		errors := zenodb.Check(inFiles...)
 		if len(errors) > 0 {
@@ -116,3 +126,53 @@ func main() {
 
 	log.Debugf("Merged %v -> %v", strings.Join(inFiles, " + "), *outFile)
 }
+
+// doRestore restores a snapshot into a new db dir, replays the WAL (up to
+// recoverTo if specified) and flushes the result to disk.
+func doRestore(args []string) {
+	if len(args) != 2 {
+		log.Fatal("Please specify the snapshot dir and the db dir to restore into")
+	}
+	snapshotDir, dbDir := args[0], args[1]
+	if _, err := os.Stat(dbDir); err == nil {
+		log.Fatalf("%v already exists, please restore into a new db dir", dbDir)
+	}
+
+	var _recoverTo time.Time
+	if *recoverTo != "" {
+		var err error
+		_recoverTo, err = time.Parse(time.RFC3339, *recoverTo)
+		if err != nil {
+			log.Fatalf("Unable to parse recoverto: %v", err)
+		}
+	}
+
+	// Use the snapshot's schema unless one was explicitly specified
+	schemaFile := ""
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "schema" {
+			schemaFile = *cmd.Schema
+		}
+	})
+
+	db, err := zenodb.NewDB(&zenodb.DBOpts{
+		Dir:            dbDir,
+		RestoreFrom:    snapshotDir,
+		RecoverTo:      _recoverTo,
+		SchemaFile:     schemaFile,
+		EnableGeo:      *cmd.EnableGeo,
+		ISPProvider:    cmd.ISPProvider(),
+		AliasesFile:    *cmd.AliasesFile,
+		RedisClient:    cmd.RedisClient(),
+		RedisCacheSize: *cmd.RedisCacheSize,
+	})
+	if err != nil {
+		log.Fatalf("Unable to restore DB: %v", err)
+	}
+
+	log.Debug("Replaying WAL")
+	db.WaitForWALReplay(*replayIdle)
+	db.FlushAll()
+	db.Close()
+	log.Debugf("Restored %v -> %v", snapshotDir, dbDir)
+}
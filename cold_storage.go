@@ -0,0 +1,337 @@
+package zenodb
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/getlantern/errors"
+	"github.com/getlantern/zenodb/core"
+)
+
+const (
+	coldStorageInterval = 1 * time.Minute
+)
+
+// offloadColdSegments periodically moves segments whose data is entirely older
+// than the table's ColdAfter to the database's ColdStore.
+func (rs *rowStore) offloadColdSegments(stop <-chan interface{}) {
+	ticker := time.NewTicker(coldStorageInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			rs.t.log.Debug("Stop offloading cold segments")
+			return
+		case <-ticker.C:
+			err := rs.offload(stop)
+			if err != nil {
+				rs.t.log.Errorf("Unable to offload cold segments: %v", err)
+			}
+		}
+	}
+}
+
+// getColdAfter returns the table's current ColdAfter, which can change when the
+// table is altered.
+func (t *table) getColdAfter() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.coldAfter))
+}
+
+// applyColdAfter changes the table's ColdAfter, which takes effect on the next
+// offload. Segments that are already cold stay cold.
+func (t *table) applyColdAfter(coldAfter time.Duration) error {
+	if t.Virtual || t.db.opts.Passthrough {
+		return nil
+	}
+	if coldAfter < 0 {
+		return errors.New("Please specify a ColdAfter that isn't negative")
+	}
+	if coldAfter > 0 && coldAfter != t.getColdAfter() && t.db.opts.ColdStore == nil {
+		// unchanged values are tolerated like they are when creating the table
+		return errors.New("Unable to set ColdAfter without a ColdStore")
+	}
+	old := time.Duration(atomic.SwapInt64(&t.coldAfter, int64(coldAfter)))
+	if old != coldAfter {
+		t.log.Debugf("Updated ColdAfter from %v to %v", old, coldAfter)
+	}
+	return nil
+}
+
+// offload uploads all segments that have turned cold to the ColdStore and then
+// marks them as cold in the manifest, after which removeOldFiles deletes their
+// local files. Expired segments are left for sweepRetention to drop.
+func (rs *rowStore) offload(stop <-chan interface{}) error {
+	coldAfter := rs.t.getColdAfter()
+	if coldAfter <= 0 {
+		return nil
+	}
+	coldBefore := rs.t.db.clock.Now().Add(-1 * coldAfter)
+	truncateBefore := rs.t.truncateBefore()
+
+	rs.mx.Lock()
+	var candidates []*segment
+	for _, seg := range rs.fileStore.segments {
+		if !seg.Cold && !seg.expired(truncateBefore) && !seg.End.After(coldBefore) {
+			candidates = append(candidates, seg)
+		}
+	}
+	// keep compaction from removing the files while we upload them
+	rs.pinSegments(candidates)
+	rs.mx.Unlock()
+	defer rs.unpinSegments(candidates)
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	offloaded := make(map[string]string, len(candidates))
+upload:
+	for _, seg := range candidates {
+		select {
+		case <-stop:
+			break upload
+		default:
+		}
+		key := rs.newColdKey(seg)
+		err := rs.opts.coldStore.Put(key, filepath.Join(rs.opts.dir, seg.Name))
+		if err != nil {
+			rs.t.log.Errorf("Unable to offload segment %v: %v", seg.Name, err)
+			continue
+		}
+		offloaded[seg.Name] = key
+	}
+	if len(offloaded) == 0 {
+		return errors.New("Unable to offload any of %d segments", len(candidates))
+	}
+
+	marked, err := rs.markCold(offloaded)
+	if err != nil {
+		return errors.New("Unable to commit %d offloaded segments: %v", len(offloaded), err)
+	}
+	numSegments := int64(0)
+	numBytes := int64(0)
+	for _, seg := range candidates {
+		key, found := offloaded[seg.Name]
+		if !found {
+			continue
+		}
+		if marked[seg.Name] {
+			numSegments++
+			numBytes += seg.Size
+			continue
+		}
+		// segment was removed (e.g. compacted) while we were uploading it
+		err := rs.opts.coldStore.Delete(key)
+		if err != nil {
+			rs.t.log.Errorf("Unable to delete orphaned cold segment %v: %v", seg.Name, err)
+		}
+	}
+	rs.t.log.Debugf("Offloaded %d segments older than %v (%v) in %v", numSegments, coldBefore.In(time.UTC), humanize.Bytes(uint64(numBytes)), time.Now().Sub(start))
+
+	rs.t.statsMutex.Lock()
+	rs.t.stats.OffloadedSegments += numSegments
+	rs.t.stats.OffloadedBytes += numBytes
+	rs.t.statsMutex.Unlock()
+	return nil
+}
+
+// markCold atomically updates the manifest to mark the named segments as cold,
+// recording the keys (by segment name) under which they were uploaded, and
+// returns the names of the segments that were marked. Segments that have been
+// removed from the manifest in the meantime are skipped.
+func (rs *rowStore) markCold(keys map[string]string) (map[string]bool, error) {
+	rs.manifestMx.Lock()
+	defer rs.manifestMx.Unlock()
+
+	rs.mx.RLock()
+	fs := rs.fileStore
+	obsolete := rs.obsoleteColdSegments
+	rs.mx.RUnlock()
+
+	marked := make(map[string]bool, len(keys))
+	segments := make([]*segment, 0, len(fs.segments))
+	for _, seg := range fs.segments {
+		if key, found := keys[seg.Name]; found && !seg.Cold {
+			cold := *seg
+			cold.Cold = true
+			cold.ColdKey = key
+			seg = &cold
+			marked[seg.Name] = true
+		}
+		segments = append(segments, seg)
+	}
+	if len(marked) == 0 {
+		return marked, nil
+	}
+
+	err := writeManifest(rs.opts.dir, &manifest{Segments: segments, OffsetsBySource: fs.offsetsBySource, ObsoleteColdSegments: obsolete})
+	if err != nil {
+		return nil, err
+	}
+	rs.mx.Lock()
+	rs.fileStore = &fileStore{t: rs.t, rs: rs, fields: fs.fields, segments: segments, offsetsBySource: fs.offsetsBySource}
+	rs.mx.Unlock()
+	return marked, nil
+}
+
+// removeObsoleteColdSegments deletes cold segments that have been removed from
+// the manifest (e.g. by retention or compaction) from the ColdStore once
+// they're no longer being iterated over, and then removes them from the
+// manifest's list of obsolete cold segments. Failed deletions are retried on
+// the next run.
+func (rs *rowStore) removeObsoleteColdSegments() {
+	if rs.opts.coldStore == nil {
+		return
+	}
+	rs.mx.RLock()
+	var toRemove []*segment
+	for _, seg := range rs.obsoleteColdSegments {
+		if rs.iterationsInProgress[seg.Name] == 0 {
+			toRemove = append(toRemove, seg)
+		}
+	}
+	rs.mx.RUnlock()
+	if len(toRemove) == 0 {
+		return
+	}
+
+	removed := make(map[string]bool, len(toRemove))
+	for _, seg := range toRemove {
+		key := rs.coldKey(seg)
+		rs.t.log.Debugf("Removing old cold segment %v", key)
+		err := rs.opts.coldStore.Delete(key)
+		if err != nil {
+			rs.t.log.Errorf("Unable to delete old cold segment %v, still consuming space unnecessarily: %v", key, err)
+			continue
+		}
+		removed[key] = true
+	}
+	if len(removed) == 0 {
+		return
+	}
+
+	rs.manifestMx.Lock()
+	defer rs.manifestMx.Unlock()
+	rs.mx.RLock()
+	fs := rs.fileStore
+	var remaining []*segment
+	for _, seg := range rs.obsoleteColdSegments {
+		if !removed[rs.coldKey(seg)] {
+			remaining = append(remaining, seg)
+		}
+	}
+	rs.mx.RUnlock()
+	err := writeManifest(rs.opts.dir, &manifest{Segments: fs.segments, OffsetsBySource: fs.offsetsBySource, ObsoleteColdSegments: remaining})
+	if err != nil {
+		// the deleted segments stay in the list and deleting them again is harmless
+		rs.t.log.Errorf("Unable to update manifest after removing old cold segments: %v", err)
+		return
+	}
+	rs.mx.Lock()
+	rs.obsoleteColdSegments = remaining
+	rs.mx.Unlock()
+}
+
+// deleteColdSegments deletes the obsolete cold segments and, if includeLive is
+// true, also the current cold segments of a dropped table from the ColdStore.
+func (rs *rowStore) deleteColdSegments(includeLive bool) {
+	if rs.opts.coldStore == nil {
+		return
+	}
+	rs.mx.RLock()
+	toDelete := append([]*segment{}, rs.obsoleteColdSegments...)
+	numLive := 0
+	for _, seg := range rs.fileStore.segments {
+		if seg.Cold {
+			numLive++
+			if includeLive {
+				toDelete = append(toDelete, seg)
+			}
+		}
+	}
+	rs.mx.RUnlock()
+
+	for _, seg := range toDelete {
+		key := rs.coldKey(seg)
+		err := rs.opts.coldStore.Delete(key)
+		if err != nil {
+			rs.t.log.Errorf("Unable to delete cold segment %v, still consuming space unnecessarily: %v", key, err)
+		}
+	}
+	if !includeLive && numLive > 0 {
+		rs.t.log.Debugf("Keeping %d cold segments that are referenced by the archived manifest", numLive)
+	}
+}
+
+// downloadColdSegment copies the given cold segment from the ColdStore into a
+// new local file.
+func (rs *rowStore) downloadColdSegment(seg *segment, filename string) error {
+	if rs.opts.coldStore == nil {
+		return errors.New("Segment %v is in cold storage but no ColdStore is configured", seg.Name)
+	}
+	key := rs.coldKey(seg)
+	in, err := rs.opts.coldStore.Open(key)
+	if err != nil {
+		return errors.New("Unable to open cold segment %v: %v", key, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(filename, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.New("Unable to create %v: %v", filename, err)
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return errors.New("Unable to download cold segment %v to %v: %v", key, filename, err)
+	}
+	err = out.Sync()
+	if err != nil {
+		return errors.New("Unable to sync %v: %v", filename, err)
+	}
+	return out.Close()
+}
+
+// openSegment opens the given segment for reading, either from the table's
+// directory or, if the segment is cold, from the ColdStore.
+func (rs *rowStore) openSegment(fields core.Fields, seg *segment) (*rowReader, error) {
+	if !seg.Cold {
+		df := &dataFile{t: rs.t, fields: fields, filename: filepath.Join(rs.opts.dir, seg.Name)}
+		return df.open()
+	}
+	if rs.opts.coldStore == nil {
+		return nil, errors.New("Segment %v is in cold storage but no ColdStore is configured", seg.Name)
+	}
+	key := rs.coldKey(seg)
+	file, err := rs.opts.coldStore.Open(key)
+	if err != nil {
+		return nil, errors.New("Unable to open cold segment %v: %v", key, err)
+	}
+	df := &dataFile{t: rs.t, fields: fields, filename: key}
+	return df.read(file)
+}
+
+// coldKey returns the key under which the given segment is kept in the
+// ColdStore.
+func (rs *rowStore) coldKey(seg *segment) string {
+	if seg.ColdKey != "" {
+		return seg.ColdKey
+	}
+	// segments offloaded before keys were recorded in the manifest
+	return rs.t.Name + "/" + seg.Name
+}
+
+// newColdKey returns a new key under which to offload the given segment. Keys
+// are unique to each upload, so that databases that were restored from the
+// same snapshot (and so have segments with the same names) never share objects
+// in the ColdStore.
+func (rs *rowStore) newColdKey(seg *segment) string {
+	return fmt.Sprintf("%v/%v.%d", rs.t.Name, seg.Name, time.Now().UnixNano())
+}
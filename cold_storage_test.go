@@ -0,0 +1,167 @@
+package zenodb
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/golog"
+	"github.com/getlantern/vtime"
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/encoding"
+	"github.com/getlantern/zenodb/expr"
+	"github.com/getlantern/zenodb/sql"
+	"github.com/getlantern/zenodb/tiered"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore counts how often objects are opened
+type countingStore struct {
+	tiered.Store
+	opens int
+}
+
+func (s *countingStore) Open(key string) (io.ReadCloser, error) {
+	s.opens++
+	return s.Store.Open(key)
+}
+
+func TestOffloadColdSegments(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "coldstorage")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dir := filepath.Join(tmpDir, "mytable")
+	if !assert.NoError(t, os.MkdirAll(dir, 0755)) {
+		return
+	}
+	dirStore, err := tiered.NewDirStore(filepath.Join(tmpDir, "cold"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	store := &countingStore{Store: dirStore}
+
+	res := time.Hour
+	now := time.Date(2017, time.January, 3, 0, 0, 0, 0, time.UTC)
+	db := &DB{
+		opts:  &DBOpts{ColdStore: store},
+		log:   golog.LoggerFor("coldstorage_test"),
+		clock: vtime.NewVirtualClock(now),
+	}
+	tbl := &table{
+		TableOpts:       &TableOpts{Name: "mytable"},
+		Query:           sql.Query{Resolution: res},
+		db:              db,
+		log:             db.log,
+		retentionPeriod: int64(48 * time.Hour),
+		coldAfter:       int64(12 * time.Hour),
+	}
+	e := expr.SUM("a")
+	fields := core.Fields{core.NewField("a", e)}
+	rs := &rowStore{
+		t:                    tbl,
+		opts:                 &rowStoreOptions{dir: dir, segmentDuration: 6 * time.Hour, coldStore: store},
+		iterationsInProgress: make(map[string]int),
+	}
+	rs.fileStore = &fileStore{t: tbl, rs: rs, fields: fields}
+	tbl.rowStore = rs
+
+	writeSegment := func(dim string, ts time.Time) *segment {
+		start := ts.Truncate(6 * time.Hour)
+		sw, err := rs.newSegmentWriter(fields, start, start.Add(6*time.Hour), nil, 0)
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		var seq encoding.Sequence
+		seq = seq.Update(encoding.NewTSParams(ts, bytemap.NewFloat(map[string]float64{"a": 1})), nil, e, res, time.Time{})
+		if !assert.NoError(t, sw.write(bytemap.New(map[string]interface{}{"d": dim}), []encoding.Sequence{seq})) {
+			return nil
+		}
+		seg, err := sw.finish()
+		if !assert.NoError(t, err) || !assert.NoError(t, rs.commit([]*segment{seg}, nil, nil, fields, nil)) {
+			return nil
+		}
+		return seg
+	}
+	old := writeSegment("old", now.Add(-20*time.Hour))
+	recent := writeSegment("recent", now.Add(-1*time.Hour))
+	if old == nil || recent == nil {
+		return
+	}
+
+	if !assert.NoError(t, rs.offload(nil)) {
+		return
+	}
+	assert.True(t, rs.fileStore.segments[0].Cold, "Old segment should have been offloaded")
+	assert.False(t, rs.fileStore.segments[1].Cold, "Recent segment should have stayed local")
+	assert.EqualValues(t, 1, tbl.stats.OffloadedSegments)
+	assert.EqualValues(t, old.Size, tbl.stats.OffloadedBytes)
+	m, err := readManifest(dir)
+	if assert.NoError(t, err) && assert.NotNil(t, m) {
+		assert.True(t, m.Segments[0].Cold, "Manifest should have been updated")
+		assert.Equal(t, rs.coldKey(rs.fileStore.segments[0]), m.Segments[0].ColdKey, "Manifest should record cold key")
+		assert.NotEqual(t, "mytable/"+old.Name, m.Segments[0].ColdKey, "Cold key should be unique to the upload")
+	}
+	assert.Equal(t, []string{old.Name}, rs.obsoleteFiles(), "Local file of cold segment should be obsolete")
+	os.Remove(filepath.Join(dir, old.Name))
+
+	iterate := func(asOf time.Time) []string {
+		var dims []string
+		_, err := rs.fileStore.iterate(fields, nil, asOf, time.Time{}, func(key bytemap.ByteMap, columns []encoding.Sequence) (bool, error) {
+			dims = append(dims, key.Get("d").(string))
+			return true, nil
+		})
+		assert.NoError(t, err)
+		return dims
+	}
+	assert.Equal(t, []string{"recent"}, iterate(now.Add(-2*time.Hour)))
+	assert.Equal(t, 0, store.opens, "Cold segment should not be read for recent data")
+	assert.ElementsMatch(t, []string{"old", "recent"}, iterate(time.Time{}))
+	assert.Equal(t, 1, store.opens, "Cold segment should be read for old data")
+
+	// Snapshots include a local copy of cold segments
+	snapshotDir := filepath.Join(tmpDir, "snapshot")
+	_, _, err = rs.snapshot(snapshotDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = os.Stat(filepath.Join(snapshotDir, old.Name))
+	assert.NoError(t, err, "Cold segment should have been downloaded into snapshot")
+	m, err = readManifest(snapshotDir)
+	if assert.NoError(t, err) && assert.NotNil(t, m) {
+		for _, seg := range m.Segments {
+			assert.False(t, seg.Cold, "Snapshot should not reference the cold store")
+		}
+	}
+
+	// Expiring the cold segment removes it from the cold store
+	coldOld := rs.fileStore.segments[0]
+	if !assert.NoError(t, rs.commit(nil, map[string]bool{old.Name: true}, nil, nil, nil)) {
+		return
+	}
+	m, err = readManifest(dir)
+	if assert.NoError(t, err) && assert.NotNil(t, m) && assert.Len(t, m.ObsoleteColdSegments, 1, "Obsolete cold segment should be recorded in manifest") {
+		assert.Equal(t, coldOld.ColdKey, m.ObsoleteColdSegments[0].ColdKey)
+	}
+	rs.removeObsoleteColdSegments()
+	_, err = dirStore.Open(rs.coldKey(coldOld))
+	assert.True(t, os.IsNotExist(err), "Removed cold segment should have been deleted from cold store")
+	m, err = readManifest(dir)
+	if assert.NoError(t, err) && assert.NotNil(t, m) {
+		assert.Empty(t, m.ObsoleteColdSegments, "Deleted cold segment should have been removed from manifest")
+	}
+
+	assert.Error(t, tbl.applyColdAfter(-1*time.Hour))
+	if assert.NoError(t, tbl.applyColdAfter(time.Hour)) {
+		assert.Equal(t, time.Hour, tbl.getColdAfter())
+	}
+	db.opts.ColdStore = nil
+	assert.Error(t, tbl.applyColdAfter(2*time.Hour), "ColdAfter should require a ColdStore")
+	assert.NoError(t, tbl.applyColdAfter(0))
+}
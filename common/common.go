@@ -14,6 +14,7 @@ import (
 
 const (
 	keyIncludeMemStore = "zenodb.includeMemStore"
+	keyQueryID         = "zenodb.queryID"
 
 	nanosPerMilli = 1000000
 )
@@ -63,6 +64,20 @@ type QueryStats struct {
 	MissingPartitions       []int
 }
 
+// SnapshotInfo describes a snapshot of a database
+type SnapshotInfo struct {
+	// Dir is the directory containing the snapshot
+	Dir string
+	// Created is the time at which the snapshot was taken
+	Created time.Time
+	// Tables is the number of tables in the snapshot
+	Tables int
+	// Segments is the number of table segment files in the snapshot
+	Segments int
+	// WALFiles is the number of WAL files in the snapshot
+	WALFiles int
+}
+
 // Retriable is a marker for retriable errors
 type Retriable interface {
 	error
@@ -96,6 +111,21 @@ func ShouldIncludeMemStore(ctx context.Context) bool {
 	return include != nil && include.(bool)
 }
 
+// WithQueryID attaches the given query id to the context. Queries that have an
+// id can be canceled by id.
+func WithQueryID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, keyQueryID, id)
+}
+
+// QueryID returns the query id attached to the given context, or "" if none.
+func QueryID(ctx context.Context) string {
+	id := ctx.Value(keyQueryID)
+	if id == nil {
+		return ""
+	}
+	return id.(string)
+}
+
 func NanosToMillis(nanos int64) int64 {
 	return nanos / nanosPerMilli
 }
@@ -22,6 +22,10 @@ var (
 	// exceeded. Results may be incomplete.
 	ErrDeadlineExceeded = errors.New("deadline exceeded")
 
+	// ErrCanceled indicates that iterating was stopped because the Context was
+	// canceled.
+	ErrCanceled = errors.New("query canceled")
+
 	// PointsField is the synthetic field that counts number of submitted points.
 	PointsField = NewField("_points", expr.SUM("_point"))
 
@@ -176,9 +180,19 @@ type FlatRow struct {
 	Key bytemap.ByteMap
 	// Values for each field
 	Values []float64
+	// Set indicates which of the Values are set. Values that aren't set are
+	// NULL (e.g. the result of dividing by zero) and hold 0 in Values. A nil Set
+	// means that all values are set.
+	Set    []bool
 	fields Fields
 }
 
+// IsSet indicates whether the value of the field at the given index is set,
+// i.e. not NULL.
+func (row *FlatRow) IsSet(i int) bool {
+	return row.Set == nil || (i < len(row.Set) && row.Set[i])
+}
+
 func (row *FlatRow) SetFields(fields Fields) {
 	row.fields = fields
 }
@@ -272,13 +286,18 @@ func stop() (bool, error) {
 	return false, nil
 }
 
-// TimeoutGuard provides the ability to guard against timeouts on a Context.
+// TimeoutGuard provides the ability to guard against timeouts and
+// cancellation on a Context.
 type TimeoutGuard interface {
 	// TimedOut returns true if the context deadline has been exceeded.
 	TimedOut() bool
 
+	// Err returns ErrDeadlineExceeded if the context deadline has been exceeded,
+	// ErrCanceled if the context has been canceled, otherwise nil.
+	Err() error
+
 	// Proceed returns false, ErrDeadlineExceeded if the context deadline has been
-	// exceeded
+	// exceeded and false, ErrCanceled if the context has been canceled.
 	Proceed() (more bool, err error)
 
 	// ProceedAfter returns origMore, origErr if origMore is false or origErr is
@@ -287,7 +306,9 @@ type TimeoutGuard interface {
 }
 
 type timeoutGuard struct {
-	deadline time.Time
+	done        <-chan struct{}
+	deadline    time.Time
+	hasDeadline bool
 }
 
 type noopTimeoutGuard struct{}
@@ -295,19 +316,35 @@ type noopTimeoutGuard struct{}
 // Guard creates a new TimeoutGuard for the given Context.
 func Guard(ctx context.Context) TimeoutGuard {
 	deadline, hasDeadline := ctx.Deadline()
-	if !hasDeadline {
+	done := ctx.Done()
+	if !hasDeadline && done == nil {
 		return &noopTimeoutGuard{}
 	}
-	return &timeoutGuard{deadline}
+	return &timeoutGuard{done, deadline, hasDeadline}
 }
 
 func (g *timeoutGuard) TimedOut() bool {
-	return time.Now().After(g.deadline)
+	return g.hasDeadline && time.Now().After(g.deadline)
 }
 
-func (g *timeoutGuard) Proceed() (bool, error) {
+func (g *timeoutGuard) Err() error {
 	if g.TimedOut() {
-		return false, ErrDeadlineExceeded
+		return ErrDeadlineExceeded
+	}
+	select {
+	case <-g.done:
+		if g.hasDeadline && !time.Now().Before(g.deadline) {
+			return ErrDeadlineExceeded
+		}
+		return ErrCanceled
+	default:
+		return nil
+	}
+}
+
+func (g *timeoutGuard) Proceed() (bool, error) {
+	if err := g.Err(); err != nil {
+		return false, err
 	}
 	return true, nil
 }
@@ -323,6 +360,10 @@ func (g *noopTimeoutGuard) TimedOut() bool {
 	return false
 }
 
+func (g *noopTimeoutGuard) Err() error {
+	return nil
+}
+
 func (g *noopTimeoutGuard) Proceed() (bool, error) {
 	return true, nil
 }
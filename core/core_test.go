@@ -126,6 +126,33 @@ func TestDeadlineGroup(t *testing.T) {
 	assert.EqualValues(t, 0, atomic.LoadInt64(&rowsSeen), "Should have gotten 0 rows before deadline exceeded")
 }
 
+func TestCancelGroup(t *testing.T) {
+	g := Group(&infiniteSource{}, GroupOpts{
+		By: []GroupBy{NewGroupBy("x", goexpr.Param("x"))},
+		Fields: StaticFieldSource{
+			Field{
+				Name: "total",
+				Expr: ADD(eA, eB),
+			},
+		},
+		Resolution: resolution * 2,
+		AsOf:       asOf.Add(2 * resolution),
+		Until:      until.Add(-2 * resolution),
+	})
+
+	rowsSeen := int64(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(25*time.Millisecond, cancel)
+	_, err := g.Iterate(ctx, FieldsIgnored, func(key bytemap.ByteMap, vals Vals) (bool, error) {
+		atomic.AddInt64(&rowsSeen, 1)
+		return true, nil
+	})
+
+	assert.Equal(t, ErrCanceled, err, "Should have gotten canceled error")
+	assert.EqualValues(t, 0, atomic.LoadInt64(&rowsSeen), "Should have gotten 0 rows before cancellation")
+}
+
 func TestGroupSingle(t *testing.T) {
 	eTotal := ADD(eA, eB)
 	gx := Group(&goodSource{}, GroupOpts{
@@ -351,6 +378,57 @@ func TestFlattenSortOffsetAndLimit(t *testing.T) {
 	}
 }
 
+func TestFlattenUnnest(t *testing.T) {
+	top := TOPK("host", "bytes", 2)
+	seq := encoding.NewValue(top, epoch, Map{"bytes": 10}, goexpr.MapParams{"host": "a"})
+	seq = seq.UpdateValue(epoch, Map{"bytes": 30}, goexpr.MapParams{"host": "b"}, top, resolution, asOf)
+	seq = seq.UpdateValue(epoch, Map{"bytes": 5}, goexpr.MapParams{"host": "c"}, top, resolution, asOf)
+	seq = seq.UpdateValue(epoch, Map{"bytes": 15}, goexpr.MapParams{"host": "a"}, top, resolution, asOf)
+	key := bytemap.New(map[string]interface{}{"x": 1})
+
+	eSum := SUM("bytes")
+	sum := encoding.NewValue(eSum, epoch, Map{"bytes": 60}, nil)
+
+	f := Flatten(&valsSource{fields: Fields{NewField("top", top), NewField("sum", eSum)}, key: key, vals: Vals{seq, sum}})
+	var hosts []interface{}
+	var values []float64
+	var sums []interface{}
+	_, err := f.Iterate(context.Background(), FieldsIgnored, func(row *FlatRow) (bool, error) {
+		assert.Equal(t, 1, row.Key.Get("x"))
+		hosts = append(hosts, row.Key.Get("top"))
+		values = append(values, row.Values[0])
+		sums = append(sums, row.Get("sum"))
+		return true, nil
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{"b", "a"}, hosts)
+		assert.Equal(t, []float64{30, 25}, values)
+		assert.Equal(t, []interface{}{float64(60), nil}, sums, "Other fields should only be included in the first unnested row")
+	}
+}
+
+func TestFlattenNulls(t *testing.T) {
+	eSum := SUM("a")
+	eRatio := DIV(SUM("a"), SUM("b"))
+	sumSeq := encoding.NewValue(eSum, epoch, Map{"a": 1}, nil)
+	sumSeq = sumSeq.UpdateValue(epoch.Add(-1*resolution), Map{"a": 4}, nil, eSum, resolution, asOf)
+	ratioSeq := encoding.NewValue(eRatio, epoch, Map{"a": 1, "b": 0}, nil)
+	ratioSeq = ratioSeq.UpdateValue(epoch.Add(-1*resolution), Map{"a": 4, "b": 2}, nil, eRatio, resolution, asOf)
+	key := bytemap.New(map[string]interface{}{"x": 1})
+
+	f := Flatten(&valsSource{fields: Fields{NewField("sum", eSum), NewField("ratio", eRatio)}, key: key, vals: Vals{sumSeq, ratioSeq}})
+	s := Sort(f, NewOrderBy("ratio", false))
+	var ratios []interface{}
+	_, err := s.Iterate(context.Background(), FieldsIgnored, func(row *FlatRow) (bool, error) {
+		assert.True(t, row.IsSet(0))
+		ratios = append(ratios, row.Get("ratio"))
+		return true, nil
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []interface{}{nil, float64(2)}, ratios, "Division by zero should yield NULL, which sorts first")
+	}
+}
+
 func TestUnflattenTransform(t *testing.T) {
 	avgTotal := ADD(AVG("a"), AVG("b"))
 	f := Flatten(&goodSource{})
@@ -381,12 +459,13 @@ func doTestUnflattened(t *testing.T, u RowSource, ex Expr) {
 		} else {
 			ts = row.vals[1].Until()
 		}
-		a, _ := row.vals[0].ValueAt(0, eA)
-		b, _ := row.vals[1].ValueAt(0, eB)
-		params := Map(map[string]float64{
-			"a": a,
-			"b": b,
-		})
+		params := Map(make(map[string]float64, 2))
+		if a, found := row.vals[0].ValueAt(0, eA); found {
+			params["a"] = a
+		}
+		if b, found := row.vals[1].ValueAt(0, eB); found {
+			params["b"] = b
+		}
 		expectedRow := &testRow{
 			key:  row.key,
 			vals: []encoding.Sequence{encoding.NewValue(ex, ts, params, row.key)},
@@ -560,3 +639,20 @@ func (s *totalingSource) Iterate(ctx context.Context, onFields OnFields, onRow O
 		return onRow(key, Vals{val})
 	})
 }
+
+type valsSource struct {
+	testSource
+	fields Fields
+	key    bytemap.ByteMap
+	vals   Vals
+}
+
+func (s *valsSource) Iterate(ctx context.Context, onFields OnFields, onRow OnRow) (interface{}, error) {
+	onFields(s.fields)
+	_, err := onRow(s.key, s.vals)
+	return nil, err
+}
+
+func (s *valsSource) String() string {
+	return "test.vals"
+}
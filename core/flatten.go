@@ -23,10 +23,21 @@ func (f *flatten) Iterate(ctx context.Context, onFields OnFields, onRow OnFlatRo
 
 	var fields Fields
 	var numFields int
+	unnestIdx := -1
+	var unnester expr.Unnester
 
 	return f.source.Iterate(ctx, func(inFields Fields) error {
 		fields = inFields
 		numFields = len(inFields)
+		for i, field := range inFields {
+			u, ok := field.Expr.(expr.Unnester)
+			if ok {
+				// Only the first unnestable field is unnested
+				unnestIdx = i
+				unnester = u
+				break
+			}
+		}
 		// Transform to flattened version of fields
 		outFields := make(Fields, 0, len(inFields))
 		for _, field := range inFields {
@@ -62,6 +73,7 @@ func (f *flatten) Iterate(ctx context.Context, onFields OnFields, onRow OnFlatRo
 				TS:     tsNanos,
 				Key:    key,
 				Values: make([]float64, numFields),
+				Set:    make([]bool, numFields),
 				fields: fields,
 			}
 			anyNonConstantValueFound := false
@@ -71,19 +83,71 @@ func (f *flatten) Iterate(ctx context.Context, onFields OnFields, onRow OnFlatRo
 					anyNonConstantValueFound = true
 				}
 				row.Values[i] = val
+				row.Set[i] = found
+			}
+			if !anyNonConstantValueFound {
+				continue
 			}
-			if anyNonConstantValueFound {
-				more, err := onRow(row)
-				if !more || err != nil {
-					return more, err
+			if unnester != nil {
+				data, found := vals[unnestIdx].DataAtTime(ts, unnester, resolution)
+				if found {
+					more, err := f.unnest(row, unnestIdx, unnester, data, onRow)
+					if !more || err != nil {
+						return more, err
+					}
+					continue
 				}
 			}
+			more, err := onRow(row)
+			if !more || err != nil {
+				return more, err
+			}
 		}
 
 		return guard.Proceed()
 	})
 }
 
+// unnest emits one row per key in the given Unnester data, with the key added
+// as a dimension named after the unnested field. Only the first row carries
+// the values of the other fields, they're NULL on subsequent rows so that
+// aggregating the unnested rows doesn't count them multiple times. If there are
+// no keys, the row is emitted as is.
+func (f *flatten) unnest(row *FlatRow, idx int, unnester expr.Unnester, data []byte, onRow OnFlatRow) (bool, error) {
+	keys, vals := unnester.Unnest(data)
+	if len(keys) == 0 {
+		return onRow(row)
+	}
+	name := row.fields[idx].Name
+	dims := row.Key.AsMap()
+	for i, key := range keys {
+		dims[name] = key
+		values := make([]float64, len(row.Values))
+		copy(values, row.Values)
+		values[idx] = vals[i]
+		set := make([]bool, len(row.Values))
+		for j := range set {
+			if i > 0 && j != idx {
+				values[j] = 0
+				continue
+			}
+			set[j] = row.IsSet(j)
+		}
+		set[idx] = true
+		more, err := onRow(&FlatRow{
+			TS:     row.TS,
+			Key:    bytemap.New(dims),
+			Values: values,
+			Set:    set,
+			fields: row.fields,
+		})
+		if !more || err != nil {
+			return more, err
+		}
+	}
+	return true, nil
+}
+
 func (f *flatten) String() string {
 	return "flatten"
 }
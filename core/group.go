@@ -197,7 +197,7 @@ func (g *group) Iterate(ctx context.Context, onFields OnFields, onRow OnRow) (in
 	})
 
 	var walkErr error
-	if err != ErrDeadlineExceeded {
+	if err != ErrDeadlineExceeded && err != ErrCanceled {
 		if g.Crosstab != nil {
 			origOutFields := outFields
 			sortedCtabs := make([]string, 0, len(ctabs))
@@ -208,8 +208,8 @@ func (g *group) Iterate(ctx context.Context, onFields OnFields, onRow OnRow) (in
 			outFields = make([]Field, 0, (len(sortedCtabs)+1)*len(origOutFields))
 			var havingField Field
 			for _, ctab := range sortedCtabs {
-				if guard.TimedOut() {
-					return metadata, ErrDeadlineExceeded
+				if guardErr := guard.Err(); guardErr != nil {
+					return metadata, guardErr
 				}
 				for _, outField := range origOutFields {
 					if outField.Name == HavingFieldName {
@@ -238,8 +238,8 @@ func (g *group) Iterate(ctx context.Context, onFields OnFields, onRow OnRow) (in
 			}
 
 			for _, kv := range kvs {
-				if guard.TimedOut() {
-					return metadata, ErrDeadlineExceeded
+				if guardErr := guard.Err(); guardErr != nil {
+					return metadata, guardErr
 				}
 				updateTree(kv.key, kv.vals)
 			}
@@ -253,9 +253,11 @@ func (g *group) Iterate(ctx context.Context, onFields OnFields, onRow OnRow) (in
 		if bt != nil {
 			walkErr = bt.Walk(0, func(key []byte, data []encoding.Sequence) (bool, bool, error) {
 				more, iterErr := onRow(key, data)
-				if iterErr == nil && guard.TimedOut() {
-					more = false
-					iterErr = ErrDeadlineExceeded
+				if iterErr == nil {
+					if guardErr := guard.Err(); guardErr != nil {
+						more = false
+						iterErr = guardErr
+					}
 				}
 				return more, true, iterErr
 			})
@@ -28,11 +28,15 @@ func NewOrderBy(field string, descending bool) OrderBy {
 	}
 }
 
-// Get implements the interface method from goexpr.Params
+// Get implements the interface method from goexpr.Params. Values that aren't
+// set are returned as nil, so they sort before all other values.
 func (row *FlatRow) Get(param string) interface{} {
 	// First look at values
 	for i, field := range row.fields {
 		if field.Name == param {
+			if !row.IsSet(i) {
+				return nil
+			}
 			return row.Values[i]
 		}
 	}
@@ -65,11 +69,11 @@ func (s *sorter) Iterate(ctx context.Context, onFields OnFields, onRow OnFlatRow
 		return guard.Proceed()
 	})
 
-	if err != ErrDeadlineExceeded {
+	if err != ErrDeadlineExceeded && err != ErrCanceled {
 		sort.Sort(rows)
 		for _, row := range rows.rows {
-			if guard.TimedOut() {
-				return metadata, ErrDeadlineExceeded
+			if guardErr := guard.Err(); guardErr != nil {
+				return metadata, guardErr
 			}
 
 			more, onRowErr := onRow(row)
@@ -51,8 +51,11 @@ func (f *unflatten) Iterate(ctx context.Context, onFields OnFields, onRow OnRow)
 		outRow := make(Vals, numOut)
 		params := expr.Map(make(map[string]float64, numIn))
 		for i, field := range inFields {
-			name := field.Name
-			params[name] = row.Values[i]
+			if !row.IsSet(i) {
+				// Leave NULLs unset so that they remain NULL in the outer query
+				continue
+			}
+			params[field.Name] = row.Values[i]
 		}
 		for i, field := range outFields {
 			outRow[i] = encoding.NewValue(field.Expr, ts, params, row.Key)
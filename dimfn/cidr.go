@@ -0,0 +1,87 @@
+package dimfn
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/getlantern/goexpr"
+	"github.com/getlantern/msgpack"
+)
+
+// CIDR_MATCH tests whether the given IP address falls within the given CIDR
+// range (e.g. "10.0.0.0/8"), which must be a constant. If the IP is nil or
+// invalid, returns false. An invalid CIDR matches nothing and is reported by
+// Validate.
+func CIDR_MATCH(ip goexpr.Expr, cidr goexpr.Expr) goexpr.Expr {
+	_cidr, err := constantString(cidr)
+	e := &cidrMatch{IP: ip, CIDR: _cidr}
+	if err != nil {
+		e.err = fmt.Errorf("CIDR must be a constant: %v", err)
+		return e
+	}
+	e.initNet()
+	return e
+}
+
+type cidrMatch struct {
+	IP   goexpr.Expr
+	CIDR string
+	net  *net.IPNet
+	err  error
+}
+
+func (e *cidrMatch) initNet() {
+	_, ipNet, err := net.ParseCIDR(e.CIDR)
+	if err != nil {
+		e.err = fmt.Errorf("Error parsing CIDR %v: %v", e.CIDR, err)
+		return
+	}
+	e.net = ipNet
+}
+
+func (e *cidrMatch) validate() error {
+	return e.err
+}
+
+func (e *cidrMatch) Eval(params goexpr.Params) interface{} {
+	if e.net == nil {
+		return false
+	}
+	_ip, ok := stringValue(e.IP, params)
+	if !ok {
+		return false
+	}
+	ip := net.ParseIP(_ip)
+	if ip == nil {
+		return false
+	}
+	return e.net.Contains(ip)
+}
+
+func (e *cidrMatch) WalkParams(cb func(string)) {
+	e.IP.WalkParams(cb)
+}
+
+func (e *cidrMatch) WalkOneToOneParams(cb func(string)) {
+	// this function is not one-to-one, stop
+}
+
+func (e *cidrMatch) WalkLists(cb func(goexpr.List)) {
+	e.IP.WalkLists(cb)
+}
+
+func (e *cidrMatch) String() string {
+	return fmt.Sprintf("CIDR_MATCH(%v, %v)", e.IP, e.CIDR)
+}
+
+func (e *cidrMatch) DecodeMsgpack(dec *msgpack.Decoder) error {
+	m := make(map[string]interface{})
+	err := dec.Decode(&m)
+	if err != nil {
+		return err
+	}
+	e.IP = m["IP"].(goexpr.Expr)
+	e.CIDR = m["CIDR"].(string)
+	e.initNet()
+	return nil
+}
@@ -0,0 +1,69 @@
+// Package dimfn provides goexpr-compatible functions for working with
+// dimensions, like string manipulation, regular expressions, URL parsing, CIDR
+// matching and hash bucketing. These are registered with the sql package so
+// that they can be used in GROUP BY and WHERE clauses.
+package dimfn
+
+import (
+	"fmt"
+
+	"github.com/getlantern/goexpr"
+	"github.com/getlantern/msgpack"
+)
+
+func init() {
+	msgpack.RegisterExt(110, &caseExpr{})
+	msgpack.RegisterExt(111, &regexpExtract{})
+	msgpack.RegisterExt(112, &regexpMatch{})
+	msgpack.RegisterExt(113, &urlPart{})
+	msgpack.RegisterExt(114, &cidrMatch{})
+	msgpack.RegisterExt(115, &hashBucket{})
+}
+
+// Validate returns an error if the given expression was created by one of
+// this package's functions with invalid arguments, like a malformed regular
+// expression. Such expressions don't fail at evaluation time, they just don't
+// match anything, so callers should validate them when parsing queries.
+func Validate(e goexpr.Expr) error {
+	v, ok := e.(validator)
+	if !ok {
+		return nil
+	}
+	return v.validate()
+}
+
+type validator interface {
+	validate() error
+}
+
+// constantString returns the string value of the given expression, which has
+// to be constant (i.e. not reference any params).
+func constantString(e goexpr.Expr) (string, error) {
+	referencesParams := false
+	e.WalkParams(func(string) {
+		referencesParams = true
+	})
+	if referencesParams {
+		return "", fmt.Errorf("%v is not a constant", e)
+	}
+	val := e.Eval(nil)
+	if val == nil {
+		return "", fmt.Errorf("%v is nil", e)
+	}
+	return fmt.Sprint(val), nil
+}
+
+// stringValue evaluates the given expression and returns its value as a
+// string. If the value is nil, returns false.
+func stringValue(e goexpr.Expr, params goexpr.Params) (string, bool) {
+	val := e.Eval(params)
+	if val == nil {
+		return "", false
+	}
+	switch t := val.(type) {
+	case string:
+		return t, true
+	default:
+		return fmt.Sprint(t), true
+	}
+}
@@ -0,0 +1,93 @@
+package dimfn
+
+import (
+	"testing"
+
+	"github.com/getlantern/goexpr"
+	"github.com/getlantern/msgpack"
+	"github.com/stretchr/testify/assert"
+)
+
+var params = goexpr.MapParams{
+	"name": "Hello World",
+	"url":  "https://www.example.com:8443/a/b?c=d",
+	"bare": "www.example.com/a/b",
+	"ip":   "10.1.2.3",
+	"ip2":  "192.168.1.1",
+	"num":  5,
+}
+
+func TestCase(t *testing.T) {
+	assert.Equal(t, "hello world", eval(t, LOWER(goexpr.Param("name"))))
+	assert.Equal(t, "HELLO WORLD", eval(t, UPPER(goexpr.Param("name"))))
+	assert.Equal(t, "5", eval(t, LOWER(goexpr.Param("num"))))
+	assert.Nil(t, eval(t, UPPER(goexpr.Param("unknown"))))
+	assert.Equal(t, "UPPER(name)", UPPER(goexpr.Param("name")).String())
+}
+
+func TestRegexp(t *testing.T) {
+	assert.Equal(t, "World", eval(t, REGEXP_EXTRACT(goexpr.Param("name"), goexpr.Constant("W\\w+"))))
+	assert.Equal(t, "ello", eval(t, REGEXP_EXTRACT(goexpr.Param("name"), goexpr.Constant("H(\\w+)"))))
+	assert.Nil(t, eval(t, REGEXP_EXTRACT(goexpr.Param("name"), goexpr.Constant("^World"))))
+	assert.Nil(t, eval(t, REGEXP_EXTRACT(goexpr.Param("unknown"), goexpr.Constant("W\\w+"))))
+	assert.Equal(t, true, eval(t, REGEXP_MATCH(goexpr.Param("name"), goexpr.Constant("^Hello"))))
+	assert.Equal(t, false, eval(t, REGEXP_MATCH(goexpr.Param("name"), goexpr.Constant("^World"))))
+	assert.Equal(t, false, eval(t, REGEXP_MATCH(goexpr.Param("unknown"), goexpr.Constant(".*"))))
+	assert.Equal(t, false, eval(t, REGEXP_MATCH(goexpr.Param("name"), goexpr.Constant("("))), "Invalid regex should match nothing")
+	assert.Equal(t, false, eval(t, REGEXP_MATCH(goexpr.Param("name"), goexpr.Param("name"))), "Non-constant regex should match nothing")
+	assert.NoError(t, Validate(REGEXP_MATCH(goexpr.Param("name"), goexpr.Constant("^Hello"))))
+	assert.Error(t, Validate(REGEXP_MATCH(goexpr.Param("name"), goexpr.Constant("("))), "Invalid regex should fail validation")
+	assert.Error(t, Validate(REGEXP_EXTRACT(goexpr.Param("name"), goexpr.Param("name"))), "Non-constant regex should fail validation")
+	assert.Error(t, Validate(REGEXP_EXTRACT(goexpr.Param("name"), goexpr.Constant(""))), "Empty regex should fail validation")
+}
+
+func TestURL(t *testing.T) {
+	assert.Equal(t, "www.example.com", eval(t, URL_HOST(goexpr.Param("url"))))
+	assert.Equal(t, "/a/b", eval(t, URL_PATH(goexpr.Param("url"))))
+	assert.Equal(t, "www.example.com", eval(t, URL_HOST(goexpr.Param("bare"))))
+	assert.Equal(t, "/a/b", eval(t, URL_PATH(goexpr.Param("bare"))))
+	assert.Equal(t, "/", eval(t, URL_PATH(goexpr.Constant("http://www.example.com"))))
+	assert.Nil(t, eval(t, URL_HOST(goexpr.Constant("/a/b"))))
+	assert.Nil(t, eval(t, URL_HOST(goexpr.Param("unknown"))))
+	assert.Equal(t, "URL_HOST(url)", URL_HOST(goexpr.Param("url")).String())
+}
+
+func TestCIDRMatch(t *testing.T) {
+	assert.Equal(t, true, eval(t, CIDR_MATCH(goexpr.Param("ip"), goexpr.Constant("10.0.0.0/8"))))
+	assert.Equal(t, false, eval(t, CIDR_MATCH(goexpr.Param("ip2"), goexpr.Constant("10.0.0.0/8"))))
+	assert.Equal(t, false, eval(t, CIDR_MATCH(goexpr.Param("name"), goexpr.Constant("10.0.0.0/8"))))
+	assert.Equal(t, false, eval(t, CIDR_MATCH(goexpr.Param("unknown"), goexpr.Constant("10.0.0.0/8"))))
+	assert.Equal(t, false, eval(t, CIDR_MATCH(goexpr.Param("ip"), goexpr.Constant("bad"))))
+	assert.NoError(t, Validate(CIDR_MATCH(goexpr.Param("ip"), goexpr.Constant("10.0.0.0/8"))))
+	assert.Error(t, Validate(CIDR_MATCH(goexpr.Param("ip"), goexpr.Constant("bad"))))
+	assert.Error(t, Validate(CIDR_MATCH(goexpr.Param("ip"), goexpr.Param("ip"))))
+}
+
+func TestHashBucket(t *testing.T) {
+	for _, buckets := range []int{1, 2, 10} {
+		bucket := eval(t, HASH_BUCKET(goexpr.Param("name"), goexpr.Constant(buckets))).(int)
+		assert.True(t, bucket >= 0 && bucket < buckets)
+		assert.Equal(t, bucket, HASH_BUCKET(goexpr.Constant("Hello World"), goexpr.Constant(buckets)).Eval(nil), "Bucket should be stable")
+	}
+	assert.Nil(t, eval(t, HASH_BUCKET(goexpr.Param("unknown"), goexpr.Constant(10))))
+	assert.Nil(t, eval(t, HASH_BUCKET(goexpr.Param("name"), goexpr.Constant(0))))
+}
+
+func eval(t *testing.T, e goexpr.Expr) interface{} {
+	result := e.Eval(params)
+	assert.Equal(t, result, msgpacked(t, e).Eval(params), "Result should be the same after round-tripping through msgpack")
+	return result
+}
+
+func msgpacked(t *testing.T, e goexpr.Expr) goexpr.Expr {
+	b, err := msgpack.Marshal(e)
+	if !assert.NoError(t, err) {
+		return e
+	}
+	var e2 interface{}
+	err = msgpack.Unmarshal(b, &e2)
+	if !assert.NoError(t, err) {
+		return e
+	}
+	return e2.(goexpr.Expr)
+}
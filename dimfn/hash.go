@@ -0,0 +1,62 @@
+package dimfn
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/getlantern/goexpr"
+)
+
+// HASH_BUCKET assigns the given source to one of the given number of buckets
+// (numbered 0 through buckets-1) based on a hash of its string representation.
+// This is useful for sampling or for spreading high-cardinality dimensions
+// over a fixed number of groups. If the source is nil or buckets is not a
+// positive number, returns nil.
+func HASH_BUCKET(source goexpr.Expr, buckets goexpr.Expr) goexpr.Expr {
+	return &hashBucket{Source: source, Buckets: buckets}
+}
+
+type hashBucket struct {
+	Source  goexpr.Expr
+	Buckets goexpr.Expr
+}
+
+func (e *hashBucket) Eval(params goexpr.Params) interface{} {
+	source, ok := stringValue(e.Source, params)
+	if !ok {
+		return nil
+	}
+	buckets := 0
+	switch t := e.Buckets.Eval(params).(type) {
+	case int:
+		buckets = t
+	case int64:
+		buckets = int(t)
+	case float64:
+		buckets = int(t)
+	}
+	if buckets <= 0 {
+		return nil
+	}
+	h := fnv.New32a()
+	h.Write([]byte(source))
+	return int(h.Sum32() % uint32(buckets))
+}
+
+func (e *hashBucket) WalkParams(cb func(string)) {
+	e.Source.WalkParams(cb)
+	e.Buckets.WalkParams(cb)
+}
+
+func (e *hashBucket) WalkOneToOneParams(cb func(string)) {
+	// this function is not one-to-one, stop
+}
+
+func (e *hashBucket) WalkLists(cb func(goexpr.List)) {
+	e.Source.WalkLists(cb)
+	e.Buckets.WalkLists(cb)
+}
+
+func (e *hashBucket) String() string {
+	return fmt.Sprintf("HASH_BUCKET(%v, %v)", e.Source, e.Buckets)
+}
@@ -0,0 +1,163 @@
+package dimfn
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/getlantern/goexpr"
+	"github.com/getlantern/msgpack"
+)
+
+// REGEXP_EXTRACT extracts the part of the given source that matches the given
+// regular expression, which must be a constant. If the expression contains a
+// capturing group, the first group is extracted instead of the whole match. If
+// the source is nil or doesn't match, returns nil. An invalid pattern matches
+// nothing and is reported by Validate.
+func REGEXP_EXTRACT(source goexpr.Expr, pattern goexpr.Expr) goexpr.Expr {
+	e := &regexpExtract{Source: source}
+	e.Pattern, e.err = patternFor(pattern)
+	if e.err == nil {
+		e.re, e.err = compile(e.Pattern)
+	}
+	return e
+}
+
+// REGEXP_MATCH tests whether the given source matches the given regular
+// expression, which must be a constant. If the source is nil, returns false.
+// An invalid pattern matches nothing and is reported by Validate.
+func REGEXP_MATCH(source goexpr.Expr, pattern goexpr.Expr) goexpr.Expr {
+	e := &regexpMatch{Source: source}
+	e.Pattern, e.err = patternFor(pattern)
+	if e.err == nil {
+		e.re, e.err = compile(e.Pattern)
+	}
+	return e
+}
+
+func patternFor(pattern goexpr.Expr) (string, error) {
+	result, err := constantString(pattern)
+	if err != nil {
+		return "", fmt.Errorf("Regular expression must be a constant: %v", err)
+	}
+	return result, nil
+}
+
+// compile compiles the given pattern, returning an error if the pattern is
+// empty or invalid.
+func compile(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, fmt.Errorf("Regular expression must not be empty")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Error compiling regular expression %v: %v", pattern, err)
+	}
+	return re, nil
+}
+
+type regexpExtract struct {
+	Source  goexpr.Expr
+	Pattern string
+	re      *regexp.Regexp
+	err     error
+}
+
+func (e *regexpExtract) Eval(params goexpr.Params) interface{} {
+	if e.re == nil {
+		return nil
+	}
+	source, ok := stringValue(e.Source, params)
+	if !ok {
+		return nil
+	}
+	match := e.re.FindStringSubmatch(source)
+	if match == nil {
+		return nil
+	}
+	if len(match) > 1 {
+		return match[1]
+	}
+	return match[0]
+}
+
+func (e *regexpExtract) validate() error {
+	return e.err
+}
+
+func (e *regexpExtract) WalkParams(cb func(string)) {
+	e.Source.WalkParams(cb)
+}
+
+func (e *regexpExtract) WalkOneToOneParams(cb func(string)) {
+	// this function is not one-to-one, stop
+}
+
+func (e *regexpExtract) WalkLists(cb func(goexpr.List)) {
+	e.Source.WalkLists(cb)
+}
+
+func (e *regexpExtract) String() string {
+	return fmt.Sprintf("REGEXP_EXTRACT(%v, %v)", e.Source, e.Pattern)
+}
+
+func (e *regexpExtract) DecodeMsgpack(dec *msgpack.Decoder) error {
+	m := make(map[string]interface{})
+	err := dec.Decode(&m)
+	if err != nil {
+		return err
+	}
+	e.Source = m["Source"].(goexpr.Expr)
+	e.Pattern = m["Pattern"].(string)
+	e.re, e.err = compile(e.Pattern)
+	return nil
+}
+
+type regexpMatch struct {
+	Source  goexpr.Expr
+	Pattern string
+	re      *regexp.Regexp
+	err     error
+}
+
+func (e *regexpMatch) Eval(params goexpr.Params) interface{} {
+	if e.re == nil {
+		return false
+	}
+	source, ok := stringValue(e.Source, params)
+	if !ok {
+		return false
+	}
+	return e.re.MatchString(source)
+}
+
+func (e *regexpMatch) validate() error {
+	return e.err
+}
+
+func (e *regexpMatch) WalkParams(cb func(string)) {
+	e.Source.WalkParams(cb)
+}
+
+func (e *regexpMatch) WalkOneToOneParams(cb func(string)) {
+	// this function is not one-to-one, stop
+}
+
+func (e *regexpMatch) WalkLists(cb func(goexpr.List)) {
+	e.Source.WalkLists(cb)
+}
+
+func (e *regexpMatch) String() string {
+	return fmt.Sprintf("REGEXP_MATCH(%v, %v)", e.Source, e.Pattern)
+}
+
+func (e *regexpMatch) DecodeMsgpack(dec *msgpack.Decoder) error {
+	m := make(map[string]interface{})
+	err := dec.Decode(&m)
+	if err != nil {
+		return err
+	}
+	e.Source = m["Source"].(goexpr.Expr)
+	e.Pattern = m["Pattern"].(string)
+	e.re, e.err = compile(e.Pattern)
+	return nil
+}
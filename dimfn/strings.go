@@ -0,0 +1,55 @@
+package dimfn
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/getlantern/goexpr"
+)
+
+// LOWER converts the string representation of the given value to lower case.
+// If value is nil, returns nil.
+func LOWER(source goexpr.Expr) goexpr.Expr {
+	return &caseExpr{Source: source}
+}
+
+// UPPER converts the string representation of the given value to upper case.
+// If value is nil, returns nil.
+func UPPER(source goexpr.Expr) goexpr.Expr {
+	return &caseExpr{Source: source, Upper: true}
+}
+
+type caseExpr struct {
+	Source goexpr.Expr
+	Upper  bool
+}
+
+func (e *caseExpr) Eval(params goexpr.Params) interface{} {
+	source, ok := stringValue(e.Source, params)
+	if !ok {
+		return nil
+	}
+	if e.Upper {
+		return strings.ToUpper(source)
+	}
+	return strings.ToLower(source)
+}
+
+func (e *caseExpr) WalkParams(cb func(string)) {
+	e.Source.WalkParams(cb)
+}
+
+func (e *caseExpr) WalkOneToOneParams(cb func(string)) {
+	// this function is not one-to-one, stop
+}
+
+func (e *caseExpr) WalkLists(cb func(goexpr.List)) {
+	e.Source.WalkLists(cb)
+}
+
+func (e *caseExpr) String() string {
+	if e.Upper {
+		return fmt.Sprintf("UPPER(%v)", e.Source)
+	}
+	return fmt.Sprintf("LOWER(%v)", e.Source)
+}
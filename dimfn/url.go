@@ -0,0 +1,76 @@
+package dimfn
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/getlantern/goexpr"
+)
+
+const (
+	urlHost = "HOST"
+	urlPath = "PATH"
+)
+
+// URL_HOST extracts the host name (without port) from the given URL, e.g.
+// "www.example.com" for "https://www.example.com:8443/a/b?c=d". URLs without
+// a scheme like "www.example.com/a/b" are supported. If the URL is nil, can't
+// be parsed or doesn't have a host, returns nil.
+func URL_HOST(source goexpr.Expr) goexpr.Expr {
+	return &urlPart{Source: source, Part: urlHost}
+}
+
+// URL_PATH extracts the path from the given URL, e.g. "/a/b" for
+// "https://www.example.com:8443/a/b?c=d". If the URL is nil or can't be
+// parsed, returns nil. URLs without a path have the path "/".
+func URL_PATH(source goexpr.Expr) goexpr.Expr {
+	return &urlPart{Source: source, Part: urlPath}
+}
+
+type urlPart struct {
+	Source goexpr.Expr
+	Part   string
+}
+
+func (e *urlPart) Eval(params goexpr.Params) interface{} {
+	source, ok := stringValue(e.Source, params)
+	if !ok {
+		return nil
+	}
+	if !strings.Contains(source, "://") && !strings.HasPrefix(source, "/") {
+		// Treat as URL without scheme
+		source = "//" + source
+	}
+	u, err := url.Parse(source)
+	if err != nil {
+		return nil
+	}
+	if e.Part == urlHost {
+		host := u.Hostname()
+		if host == "" {
+			return nil
+		}
+		return host
+	}
+	if u.Path == "" {
+		return "/"
+	}
+	return u.Path
+}
+
+func (e *urlPart) WalkParams(cb func(string)) {
+	e.Source.WalkParams(cb)
+}
+
+func (e *urlPart) WalkOneToOneParams(cb func(string)) {
+	// this function is not one-to-one, stop
+}
+
+func (e *urlPart) WalkLists(cb func(goexpr.List)) {
+	e.Source.WalkLists(cb)
+}
+
+func (e *urlPart) String() string {
+	return fmt.Sprintf("URL_%v(%v)", e.Part, e.Source)
+}
@@ -0,0 +1,107 @@
+package zenodb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// droppedDir is the directory (relative to the database Dir) into which the
+	// data of dropped tables is archived unless DeleteDroppedTables is set.
+	droppedDir = "_dropped"
+)
+
+// Go starts the given background task for this table. The task's stop channel
+// is closed when either the database is closed or the table is dropped.
+func (t *table) Go(task func(stop <-chan interface{})) {
+	t.tasks.Add(1)
+	t.db.Go(func(dbStop <-chan interface{}) {
+		defer t.tasks.Done()
+		stop := make(chan interface{})
+		go func() {
+			select {
+			case <-dbStop:
+			case <-t.dropped:
+			}
+			close(stop)
+		}()
+		task(stop)
+	})
+}
+
+func (t *table) isDropped() bool {
+	select {
+	case <-t.dropped:
+		return true
+	default:
+		return false
+	}
+}
+
+// dropTable removes the given table from the database, stops all of its
+// background processing, waits for in-progress iterations to finish and then
+// archives its data (or deletes it if DeleteDroppedTables is set).
+func (db *DB) dropTable(t *table) error {
+	db.tablesMutex.Lock()
+	if db.tables[t.Name] != t {
+		db.tablesMutex.Unlock()
+		return fmt.Errorf("Table %v not found", t.Name)
+	}
+	delete(db.tables, t.Name)
+	for i, ot := range db.orderedTables {
+		if ot == t {
+			db.orderedTables = append(db.orderedTables[:i:i], db.orderedTables[i+1:]...)
+			break
+		}
+	}
+	db.tablesMutex.Unlock()
+
+	t.log.Debug("Dropping")
+	close(t.dropped)
+	if t.db.opts.Follow != nil && !t.Virtual && !t.db.opts.Passthrough {
+		db.newStreamSubscriberMx.Lock()
+		newSubscriber := db.newStreamSubscriber[t.From]
+		db.newStreamSubscriberMx.Unlock()
+		if newSubscriber != nil {
+			newSubscriber <- &tableWithOffsets{t: t, remove: true}
+		}
+	}
+	if t.wal != nil {
+		// Unblock processWALInserts if it's waiting for data
+		if err := t.wal.Close(); err != nil {
+			t.log.Errorf("Unable to close WAL reader: %v", err)
+		}
+	}
+	t.tasks.Wait()
+	if t.rowStore == nil {
+		t.log.Debug("Dropped")
+		return nil
+	}
+	t.rowStore.waitForIterations()
+	// Cold segments referenced by an archived manifest are kept so that the
+	// archive can be restored
+	t.rowStore.deleteColdSegments(db.opts.DeleteDroppedTables)
+
+	dir := t.rowStore.opts.dir
+	if db.opts.DeleteDroppedTables {
+		t.log.Debugf("Removing data in %v", dir)
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("Unable to remove data for dropped table %v: %v", t.Name, err)
+		}
+	} else {
+		archiveDir := filepath.Join(db.opts.Dir, droppedDir)
+		if err := os.MkdirAll(archiveDir, 0755); err != nil {
+			return fmt.Errorf("Unable to create %v for archiving dropped table %v: %v", archiveDir, t.Name, err)
+		}
+		// Use the wall clock, the database clock may be virtual and not advance
+		archive := filepath.Join(archiveDir, fmt.Sprintf("%v.%d", t.Name, time.Now().UnixNano()))
+		t.log.Debugf("Archiving data from %v to %v", dir, archive)
+		if err := os.Rename(dir, archive); err != nil {
+			return fmt.Errorf("Unable to archive data for dropped table %v: %v", t.Name, err)
+		}
+	}
+	t.log.Debug("Dropped")
+	return nil
+}
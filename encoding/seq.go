@@ -38,7 +38,7 @@ func NewFloatValue(e expr.Expr, ts time.Time, val float64) Sequence {
 func NewValue(e expr.Expr, ts time.Time, params expr.Params, metadata goexpr.Params) Sequence {
 	seq := NewSequence(e.EncodedWidth(), 1)
 	seq.SetUntil(ts)
-	seq.UpdateValueAt(0, e, params, metadata)
+	seq.UpdateValueAt(0, e, expr.WithTime(params, ts), metadata)
 	return seq
 }
 
@@ -106,6 +106,26 @@ func (seq Sequence) ValueAtTime(t time.Time, e expr.Expr, resolution time.Durati
 	return seq.ValueAt(period, e)
 }
 
+// DataAtTime returns the encoded data at the given time within this sequence,
+// assuming each period represents 1 * resolution. If there is no data for the
+// given time, found will be false.
+func (seq Sequence) DataAtTime(t time.Time, e expr.Expr, resolution time.Duration) (data []byte, found bool) {
+	if len(seq) == 0 {
+		return nil, false
+	}
+	until := seq.Until()
+	t = RoundTimeUntilUp(t, resolution, until)
+	if t.After(until) {
+		return nil, false
+	}
+	period := int(until.Sub(t) / resolution)
+	offset := Width64bits + period*e.EncodedWidth()
+	if period < 0 || offset+e.EncodedWidth() > len(seq) {
+		return nil, false
+	}
+	return seq[offset : offset+e.EncodedWidth()], true
+}
+
 // ValueAt returns the value at the given period extracted using the given Expr.
 // If no value is set for the given period, found will be false.
 func (seq Sequence) ValueAt(period int, e expr.Expr) (val float64, found bool) {
@@ -178,6 +198,8 @@ func (seq Sequence) Update(tsp TSParams, metadata goexpr.Params, e expr.Expr, re
 func (seq Sequence) UpdateValue(ts time.Time, params expr.Params, metadata goexpr.Params, e expr.Expr, resolution time.Duration, truncateBefore time.Time) Sequence {
 	width := e.EncodedWidth()
 	until := seq.Until()
+	// Make the exact time available to expressions like FIRST and LAST
+	params = expr.WithTime(params, ts)
 	ts = RoundTimeUp(ts, resolution)
 	if until.IsZero() {
 		// sequence has no until, use ts
@@ -241,7 +263,7 @@ func (seq Sequence) UpdateValue(ts time.Time, params expr.Params, metadata goexp
 }
 
 func (seq Sequence) SubMerge(other Sequence, metadata goexpr.Params, resolution time.Duration, otherResolution time.Duration, ex expr.Expr, otherEx expr.Expr, submerge expr.SubMerge, asOf time.Time, until time.Time, strideSlice time.Duration) (result Sequence) {
-	shiftBack := -1 * ex.Shift()
+	shiftBack := -1 * ex.Shift(otherResolution)
 	result = seq
 	otherWidth := otherEx.EncodedWidth()
 	otherAsOf := other.AsOf(otherEx.EncodedWidth(), otherResolution)
@@ -59,8 +59,8 @@ func (e *aggregate) EncodedWidth() int {
 	return 1 + width64bits + e.Wrapped.EncodedWidth()
 }
 
-func (e *aggregate) Shift() time.Duration {
-	return e.Wrapped.Shift()
+func (e *aggregate) Shift(resolution time.Duration) time.Duration {
+	return e.Wrapped.Shift(resolution)
 }
 
 func (e *aggregate) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
@@ -2,6 +2,7 @@ package expr
 
 import (
 	"testing"
+	"time"
 
 	"github.com/getlantern/goexpr"
 	"github.com/stretchr/testify/assert"
@@ -31,6 +32,58 @@ func TestWAVG(t *testing.T) {
 	doTestAggregate(t, WAVG(boundedA(), "b"), 7.52)
 }
 
+func TestVARIANCE(t *testing.T) {
+	doTestAggregate(t, VARIANCE(boundedA()), 7.146667)
+}
+
+func TestWVARIANCE(t *testing.T) {
+	doTestAggregate(t, WVARIANCE(boundedA(), "b"), 6.5536)
+}
+
+func TestSTDDEV(t *testing.T) {
+	doTestAggregate(t, STDDEV(boundedA()), 2.673325)
+}
+
+func TestWSTDDEV(t *testing.T) {
+	doTestAggregate(t, WSTDDEV(boundedA(), "b"), 2.56)
+}
+
+func TestFIRST(t *testing.T) {
+	doTestAggregate(t, FIRST(boundedA()), 4.4)
+}
+
+func TestLAST(t *testing.T) {
+	doTestAggregate(t, LAST(boundedA()), 2.4)
+}
+
+func TestFIRSTAndLASTTimed(t *testing.T) {
+	first := msgpacked(t, FIRST("a"))
+	last := msgpacked(t, LAST("a"))
+	epoch := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	md := goexpr.MapParams{}
+
+	for _, e := range []Expr{first, last} {
+		b1 := make([]byte, e.EncodedWidth())
+		b2 := make([]byte, e.EncodedWidth())
+		// Points arrive out of order
+		e.Update(b1, WithTime(Map{"a": 2}, epoch.Add(2*time.Second)), md)
+		e.Update(b1, WithTime(Map{"a": 1}, epoch.Add(1*time.Second)), md)
+		e.Update(b2, WithTime(Map{"a": 4}, epoch.Add(4*time.Second)), md)
+		e.Update(b2, WithTime(Map{"a": 3}, epoch.Add(3*time.Second)), md)
+
+		b := make([]byte, e.EncodedWidth())
+		e.Merge(b, b2, b1)
+		val, wasSet, _ := e.Get(b)
+		if assert.True(t, wasSet) {
+			expected := float64(1)
+			if e == last {
+				expected = 4
+			}
+			assert.EqualValues(t, expected, val, e.String())
+		}
+	}
+}
+
 func TestSUMConditional(t *testing.T) {
 	ex := IF(goexpr.Param("i"), SUM("b"))
 	doTestAggregate(t, ex, 1)
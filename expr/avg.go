@@ -40,9 +40,9 @@ func (e *avg) EncodedWidth() int {
 	return width64bits*2 + 1 + e.Value.EncodedWidth()
 }
 
-func (e *avg) Shift() time.Duration {
-	a := e.Value.Shift()
-	b := e.Weight.Shift()
+func (e *avg) Shift(resolution time.Duration) time.Duration {
+	a := e.Value.Shift(resolution)
+	b := e.Weight.Shift(resolution)
 	if a < b {
 		return a
 	}
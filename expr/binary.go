@@ -30,7 +30,21 @@ func registerBinaryExpr(op string, calc calcFN) {
 	}
 }
 
-type calcFN func(left float64, right float64) float64
+// calcFN calculates the value of a binary expression from the values of its
+// operands and whether or not each of them was set. It returns false if the
+// result is undefined (NULL).
+type calcFN func(left float64, leftWasSet bool, right float64, rightWasSet bool) (float64, bool)
+
+// arithmetic creates a calcFN from fn that treats unset operands as 0 as long
+// as at least one of the operands is set.
+func arithmetic(fn func(left float64, right float64) (float64, bool)) calcFN {
+	return func(left float64, leftWasSet bool, right float64, rightWasSet bool) (float64, bool) {
+		if !leftWasSet && !rightWasSet {
+			return 0, false
+		}
+		return fn(left, right)
+	}
+}
 
 type binaryExpr struct {
 	Op           string
@@ -49,6 +63,12 @@ func (e *binaryExpr) Validate() error {
 }
 
 func (e *binaryExpr) validateWrappedInBinary(wrapped Expr) error {
+	return validateWrappedInCalc(wrapped, e.DeAggregated)
+}
+
+// validateWrappedInCalc validates expressions that are wrapped in calculated
+// expressions like binary expressions and functions.
+func validateWrappedInCalc(wrapped Expr, deAggregated bool) error {
 	if wrapped == nil {
 		return fmt.Errorf("Binary expression cannot wrap nil expression")
 	}
@@ -60,13 +80,21 @@ func (e *binaryExpr) validateWrappedInBinary(wrapped Expr) error {
 		typeOfWrapped == shiftType ||
 		typeOfWrapped == unaryMathType ||
 		typeOfWrapped == percentileType ||
-		typeOfWrapped == percentileOptimizedType {
+		typeOfWrapped == percentileOptimizedType ||
+		typeOfWrapped == hllType ||
+		typeOfWrapped == topkType ||
+		typeOfWrapped == quantileType ||
+		typeOfWrapped == quantileOptimizedType ||
+		typeOfWrapped == varianceType ||
+		typeOfWrapped == firstLastType ||
+		typeOfWrapped == rateType ||
+		typeOfWrapped == windowType {
 		return nil
 	}
-	if typeOfWrapped == binaryType {
+	if typeOfWrapped == binaryType || typeOfWrapped == funcType {
 		return wrapped.Validate()
 	}
-	if e.DeAggregated {
+	if deAggregated {
 		return nil
 	}
 	return fmt.Errorf("Binary expression must wrap only aggregate, if, constant or shift expressions, or other binary expressions that wrap only aggregate or constant expressions, not %v of type %v", wrapped, typeOfWrapped)
@@ -76,9 +104,9 @@ func (e *binaryExpr) EncodedWidth() int {
 	return e.Left.EncodedWidth() + e.Right.EncodedWidth()
 }
 
-func (e *binaryExpr) Shift() time.Duration {
-	a := e.Left.Shift()
-	b := e.Right.Shift()
+func (e *binaryExpr) Shift(resolution time.Duration) time.Duration {
+	a := e.Left.Shift(resolution)
+	b := e.Right.Shift(resolution)
 	if a < b {
 		return a
 	}
@@ -89,7 +117,8 @@ func (e *binaryExpr) Update(b []byte, params Params, metadata goexpr.Params) ([]
 	remain, leftValue, updatedLeft := e.Left.Update(b, params, metadata)
 	remain, rightValue, updatedRight := e.Right.Update(remain, params, metadata)
 	updated := updatedLeft || updatedRight
-	return remain, e.calc(leftValue, rightValue), updated
+	value, _ := e.calc(leftValue, true, rightValue, true)
+	return remain, value, updated
 }
 
 func (e *binaryExpr) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
@@ -143,10 +172,16 @@ func combinedSubMerge(left SubMerge, width int, right SubMerge) SubMerge {
 func (e *binaryExpr) Get(b []byte) (float64, bool, []byte) {
 	valueLeft, leftWasSet, remain := e.Left.Get(b)
 	valueRight, rightWasSet, remain := e.Right.Get(remain)
-	if !leftWasSet && !rightWasSet {
-		return 0, false, remain
+	// A constant on its own doesn't give the result a value, so that for example
+	// SUM(a) * 100 is unset whenever SUM(a) is.
+	if e.Left.IsConstant() && !rightWasSet {
+		leftWasSet = false
+	}
+	if e.Right.IsConstant() && !leftWasSet {
+		rightWasSet = false
 	}
-	return e.calc(valueLeft, valueRight), true, remain
+	value, wasSet := e.calc(valueLeft, leftWasSet, valueRight, rightWasSet)
+	return value, wasSet, remain
 }
 
 func (e *binaryExpr) IsConstant() bool {
@@ -34,8 +34,8 @@ func (e *bounded) EncodedWidth() int {
 	return e.wrapped.EncodedWidth()
 }
 
-func (e *bounded) Shift() time.Duration {
-	return e.wrapped.Shift()
+func (e *bounded) Shift(resolution time.Duration) time.Duration {
+	return e.wrapped.Shift(resolution)
 }
 
 func (e *bounded) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
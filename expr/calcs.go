@@ -1,31 +1,25 @@
 package expr
 
-import (
-	"math"
-)
-
 func init() {
-	registerBinaryExpr("+", func(left float64, right float64) float64 {
-		return left + right
-	})
+	registerBinaryExpr("+", arithmetic(func(left float64, right float64) (float64, bool) {
+		return left + right, true
+	}))
 
-	registerBinaryExpr("-", func(left float64, right float64) float64 {
-		return left - right
-	})
+	registerBinaryExpr("-", arithmetic(func(left float64, right float64) (float64, bool) {
+		return left - right, true
+	}))
 
-	registerBinaryExpr("*", func(left float64, right float64) float64 {
-		return left * right
-	})
+	registerBinaryExpr("*", arithmetic(func(left float64, right float64) (float64, bool) {
+		return left * right, true
+	}))
 
-	registerBinaryExpr("/", func(left float64, right float64) float64 {
+	registerBinaryExpr("/", arithmetic(func(left float64, right float64) (float64, bool) {
 		if right == 0 {
-			if left == 0 {
-				return 0
-			}
-			return math.MaxFloat64
+			// Division by zero is undefined
+			return 0, false
 		}
-		return left / right
-	})
+		return left / right, true
+	}))
 }
 
 // ADD creates an Expr that obtains its value by adding right and left.
@@ -44,7 +38,7 @@ func MULT(left interface{}, right interface{}) Expr {
 }
 
 // DIV creates an Expr that obtains its value by dividing left by right. If
-// right is 0, this returns 0.
+// right is 0 or unset, the result is unset (NULL), like in SQL.
 func DIV(left interface{}, right interface{}) Expr {
 	return binaryExprFor("/", left, right)
 }
@@ -2,7 +2,6 @@ package expr
 
 import (
 	"github.com/stretchr/testify/assert"
-	"math"
 	"testing"
 )
 
@@ -23,11 +22,23 @@ func TestDIV(t *testing.T) {
 }
 
 func TestDIVZero(t *testing.T) {
-	doTestCalc(t, DIV("a", "c"), math.MaxFloat64)
+	doTestCalcUnset(t, DIV(SUM("a"), SUM("c")))
 }
 
 func TestDIVZeroZero(t *testing.T) {
-	doTestCalc(t, DIV("c", "c"), 0)
+	doTestCalcUnset(t, DIV(SUM("c"), SUM("c")))
+}
+
+func TestDIVUnset(t *testing.T) {
+	doTestCalcUnset(t, DIV(SUM("a"), SUM("unknown")))
+	doTestCalc(t, DIV(SUM("unknown"), SUM("a")), 0)
+}
+
+func TestCalcWithUnset(t *testing.T) {
+	doTestCalcUnset(t, ADD(SUM("unknown"), SUM("unknown")))
+	doTestCalcUnset(t, MULT(DIV(SUM("a"), SUM("c")), CONST(100)))
+	doTestCalcUnset(t, SUB(CONST(1), SUM("unknown")))
+	doTestCalc(t, ADD(DIV(SUM("a"), SUM("c")), SUM("b")), 4.4)
 }
 
 func TestValidateBinary(t *testing.T) {
@@ -58,3 +69,18 @@ func doTestCalc(t *testing.T, e Expr, expected float64) {
 	_, val, _ := e.Update(b, params, nil)
 	AssertFloatEquals(t, expected, val)
 }
+
+func doTestCalcUnset(t *testing.T, e Expr) {
+	e = msgpacked(t, e)
+	params := Map{
+		"a": 8.8,
+		"b": 4.4,
+		"c": 0,
+		"d": 1.1,
+	}
+
+	b := make([]byte, e.EncodedWidth())
+	e.Update(b, params, nil)
+	_, wasSet, _ := e.Get(b)
+	assert.False(t, wasSet, "%v should be unset", e)
+}
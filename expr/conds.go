@@ -25,12 +25,26 @@ func init() {
 		return left > right
 	})
 
-	registerCond("AND", func(left float64, right float64) bool {
-		return left > 0 && right > 0
+	// AND and OR use SQL's three-valued logic, so the result is only unset if
+	// it depends on an unset operand.
+	registerBinaryExpr("AND", func(left float64, leftWasSet bool, right float64, rightWasSet bool) (float64, bool) {
+		if (leftWasSet && left <= 0) || (rightWasSet && right <= 0) {
+			return 0, true
+		}
+		if !leftWasSet || !rightWasSet {
+			return 0, false
+		}
+		return 1, true
 	})
 
-	registerCond("OR", func(left float64, right float64) bool {
-		return left > 0 || right > 0
+	registerBinaryExpr("OR", func(left float64, leftWasSet bool, right float64, rightWasSet bool) (float64, bool) {
+		if (leftWasSet && left > 0) || (rightWasSet && right > 0) {
+			return 1, true
+		}
+		if !leftWasSet || !rightWasSet {
+			return 0, false
+		}
+		return 0, true
 	})
 }
 
@@ -38,13 +52,17 @@ type compareFN func(left float64, right float64) bool
 
 // registerCond registers a binary expression that performs a comparison using
 // the given compareFN and that returns 0 or 1 depending on whether or not the
-// comparison evaluates to true.
+// comparison evaluates to true. Like in SQL, comparing with an unset (NULL)
+// value yields an unset value.
 func registerCond(cond string, compare compareFN) {
-	registerBinaryExpr(cond, func(left float64, right float64) float64 {
+	registerBinaryExpr(cond, func(left float64, leftWasSet bool, right float64, rightWasSet bool) (float64, bool) {
+		if !leftWasSet || !rightWasSet {
+			return 0, false
+		}
 		if compare(left, right) {
-			return 1
+			return 1, true
 		}
-		return 0
+		return 0, true
 	})
 }
 
@@ -50,6 +50,30 @@ func TestOR(t *testing.T) {
 	doTestCond(t, OR(GT(SUM("b"), SUM("a")), GT(SUM("b"), SUM("a"))), false)
 }
 
+func TestCondsWithUnset(t *testing.T) {
+	unset := DIV(SUM("a"), SUM("unknown"))
+	doTestCondUnset(t, GT(unset, SUM("b")))
+	doTestCondUnset(t, LT(unset, SUM("b")))
+	doTestCondUnset(t, EQ(SUM("b"), unset))
+	doTestCondUnset(t, AND(GT(SUM("a"), SUM("b")), LT(unset, SUM("b"))))
+	doTestCondUnset(t, OR(GT(SUM("b"), SUM("a")), LT(unset, SUM("b"))))
+	doTestCond(t, AND(GT(SUM("b"), SUM("a")), LT(unset, SUM("b"))), false)
+	doTestCond(t, OR(GT(SUM("a"), SUM("b")), LT(unset, SUM("b"))), true)
+}
+
+func doTestCondUnset(t *testing.T, e Expr) {
+	e = msgpacked(t, e)
+	params := Map{
+		"a": 1.001,
+		"b": 1.0,
+	}
+
+	b := make([]byte, e.EncodedWidth())
+	e.Update(b, params, nil)
+	_, wasSet, _ := e.Get(b)
+	assert.False(t, wasSet, "%v should be unset", e)
+}
+
 func doTestCond(t *testing.T, e Expr, expected bool) {
 	e = msgpacked(t, e)
 	params := Map{
@@ -24,7 +24,7 @@ func (e *constant) EncodedWidth() int {
 	return 0
 }
 
-func (e *constant) Shift() time.Duration {
+func (e *constant) Shift(resolution time.Duration) time.Duration {
 	return 0
 }
 
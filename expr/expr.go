@@ -33,6 +33,15 @@ var (
 	unaryMathType           = reflect.TypeOf((*unaryMathExpr)(nil))
 	percentileType          = reflect.TypeOf((*ptile)(nil))
 	percentileOptimizedType = reflect.TypeOf((*ptileOptimized)(nil))
+	hllType                 = reflect.TypeOf((*hll)(nil))
+	topkType                = reflect.TypeOf((*topk)(nil))
+	quantileType            = reflect.TypeOf((*quantile)(nil))
+	quantileOptimizedType   = reflect.TypeOf((*quantileOptimized)(nil))
+	varianceType            = reflect.TypeOf((*variance)(nil))
+	firstLastType           = reflect.TypeOf((*firstLast)(nil))
+	rateType                = reflect.TypeOf((*rate)(nil))
+	windowType              = reflect.TypeOf((*window)(nil))
+	funcType                = reflect.TypeOf((*funcExpr)(nil))
 )
 
 func init() {
@@ -47,6 +56,15 @@ func init() {
 	msgpack.RegisterExt(58, &unaryMathExpr{})
 	msgpack.RegisterExt(59, &ptile{})
 	msgpack.RegisterExt(60, &ptileOptimized{})
+	msgpack.RegisterExt(61, &hll{})
+	msgpack.RegisterExt(62, &topk{})
+	msgpack.RegisterExt(63, &quantile{})
+	msgpack.RegisterExt(64, &quantileOptimized{})
+	msgpack.RegisterExt(65, &variance{})
+	msgpack.RegisterExt(66, &firstLast{})
+	msgpack.RegisterExt(67, &rate{})
+	msgpack.RegisterExt(68, &window{})
+	msgpack.RegisterExt(69, &funcExpr{})
 }
 
 // Params is an interface for data structures that can contain named values.
@@ -73,6 +91,30 @@ func (p FloatParams) Get(name string) (val float64, found bool) {
 	return float64(p), true
 }
 
+// TimedParams is implemented by Params that know the time at which their values
+// were observed (e.g. the timestamp of an inserted point).
+type TimedParams interface {
+	Params
+
+	// Time returns the time at which the values were observed.
+	Time() time.Time
+}
+
+// WithTime returns TimedParams that get values from the given Params and report
+// the given time.
+func WithTime(params Params, ts time.Time) TimedParams {
+	return &timedParams{params, ts}
+}
+
+type timedParams struct {
+	Params
+	ts time.Time
+}
+
+func (p *timedParams) Time() time.Time {
+	return p.ts
+}
+
 // SubMerge is a function that merges other into data for a given Expr,
 // potentially taking into account the supplied metadata. otherRes is the amount
 // of time represented by each period in other.
@@ -90,8 +132,10 @@ type Expr interface {
 	EncodedWidth() int
 
 	// Shift returns the total cumulative shift in time, including
-	// subexpressions.
-	Shift() time.Duration
+	// subexpressions, when reading underlying data at the given resolution.
+	// The resolution matters for expressions that look back a number of
+	// periods rather than a fixed duration (e.g. EWMA).
+	Shift(resolution time.Duration) time.Duration
 
 	// Update updates the value in buf by applying the given Params. Metadata
 	// provides additional metadata that can be used in evaluating how to apply
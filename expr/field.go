@@ -38,7 +38,7 @@ func (e *field) EncodedWidth() int {
 	return 0
 }
 
-func (e *field) Shift() time.Duration {
+func (e *field) Shift(resolution time.Duration) time.Duration {
 	return 0
 }
 
@@ -0,0 +1,147 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/getlantern/goexpr"
+)
+
+const firstLastWidth = width64bits*2 + 1
+
+// FIRST creates an Expr that obtains its value as the earliest observed value
+// in each period. The time at which each value was observed is stored along
+// with it so that merging picks the earliest value even when periods are
+// re-resolved or combined across partitions.
+func FIRST(val interface{}) Expr {
+	return &firstLast{Value: exprFor(val)}
+}
+
+// LAST creates an Expr that obtains its value as the latest observed value in
+// each period, which is useful for rolling up gauges like queue depth or disk
+// usage. Like FIRST, it stores the time at which each value was observed.
+func LAST(val interface{}) Expr {
+	return &firstLast{Value: exprFor(val), Last: true}
+}
+
+type firstLast struct {
+	Value Expr
+	Last  bool
+}
+
+func (e *firstLast) Validate() error {
+	return validateWrappedInAggregate(e.Value)
+}
+
+func (e *firstLast) EncodedWidth() int {
+	return firstLastWidth + e.Value.EncodedWidth()
+}
+
+func (e *firstLast) Shift(resolution time.Duration) time.Duration {
+	return e.Value.Shift(resolution)
+}
+
+func (e *firstLast) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	ts, value, wasSet, remain := e.load(b)
+	remain, newValue, updated := e.Value.Update(remain, params, metadata)
+	if updated {
+		// Params without a time are treated as happening at the same time as
+		// the current value, in which case LAST takes the new value and FIRST
+		// keeps the old one.
+		newTS := ts
+		if timed, ok := params.(TimedParams); ok {
+			newTS = timed.Time().UnixNano()
+		}
+		if !wasSet || e.takeOther(ts, newTS) {
+			ts, value = newTS, newValue
+			e.save(b, ts, value)
+		}
+	}
+	return remain, value, updated
+}
+
+// takeOther indicates whether a value observed at otherTS should replace a
+// value observed at ts. Ties go to the other value for LAST only.
+func (e *firstLast) takeOther(ts int64, otherTS int64) bool {
+	if e.Last {
+		return otherTS >= ts
+	}
+	return otherTS < ts
+}
+
+func (e *firstLast) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
+	tsX, valueX, xWasSet, remainX := e.load(x)
+	tsY, valueY, yWasSet, remainY := e.load(y)
+	if !xWasSet {
+		if yWasSet {
+			// Use valueY
+			b = e.save(b, tsY, valueY)
+		} else {
+			// Nothing to save, just advance
+			b = b[firstLastWidth:]
+		}
+	} else {
+		if yWasSet && e.takeOther(tsX, tsY) {
+			tsX, valueX = tsY, valueY
+		}
+		b = e.save(b, tsX, valueX)
+	}
+	return b, remainX, remainY
+}
+
+func (e *firstLast) SubMergers(subs []Expr) []SubMerge {
+	result := make([]SubMerge, 0, len(subs))
+	for _, sub := range subs {
+		var sm SubMerge
+		if e.String() == sub.String() {
+			sm = e.subMerge
+		}
+		result = append(result, sm)
+	}
+	return result
+}
+
+func (e *firstLast) subMerge(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+	e.Merge(data, data, other)
+}
+
+func (e *firstLast) Get(b []byte) (float64, bool, []byte) {
+	_, value, wasSet, remain := e.load(b)
+	return value, wasSet, remain
+}
+
+func (e *firstLast) load(b []byte) (int64, float64, bool, []byte) {
+	remain := b[firstLastWidth:]
+	wasSet := b[0] == 1
+	ts := int64(0)
+	value := float64(0)
+	if wasSet {
+		ts = int64(binaryEncoding.Uint64(b[1:]))
+		value = math.Float64frombits(binaryEncoding.Uint64(b[width64bits+1:]))
+	}
+	return ts, value, wasSet, remain
+}
+
+func (e *firstLast) save(b []byte, ts int64, value float64) []byte {
+	b[0] = 1
+	binaryEncoding.PutUint64(b[1:], uint64(ts))
+	binaryEncoding.PutUint64(b[width64bits+1:], math.Float64bits(value))
+	return b[firstLastWidth:]
+}
+
+func (e *firstLast) IsConstant() bool {
+	return e.Value.IsConstant()
+}
+
+func (e *firstLast) DeAggregate() Expr {
+	return e.Value.DeAggregate()
+}
+
+func (e *firstLast) String() string {
+	name := "FIRST"
+	if e.Last {
+		name = "LAST"
+	}
+	return fmt.Sprintf("%v(%v)", name, e.Value)
+}
@@ -0,0 +1,380 @@
+package expr
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/getlantern/goexpr"
+	"github.com/getlantern/msgpack"
+)
+
+// funcFN calculates the value of a function from the values of its arguments
+// and whether or not each of them was set.
+type funcFN func(vals []float64, set []bool) (float64, bool)
+
+type funcDef struct {
+	fn       funcFN
+	validate func(numArgs int) error
+}
+
+var funcDefs = map[string]*funcDef{
+	"POW": {
+		fn: func(vals []float64, set []bool) (float64, bool) {
+			if !set[0] && !set[1] {
+				return 0, false
+			}
+			return math.Pow(vals[0], vals[1]), true
+		},
+		validate: exactArgs(2),
+	},
+	"GREATEST": {
+		fn: func(vals []float64, set []bool) (float64, bool) {
+			return pick(vals, set, func(a float64, b float64) bool { return a > b })
+		},
+		validate: minArgs(1),
+	},
+	"LEAST": {
+		fn: func(vals []float64, set []bool) (float64, bool) {
+			return pick(vals, set, func(a float64, b float64) bool { return a < b })
+		},
+		validate: minArgs(1),
+	},
+	"COALESCE": {
+		fn: func(vals []float64, set []bool) (float64, bool) {
+			for i, val := range vals {
+				if set[i] {
+					return val, true
+				}
+			}
+			return 0, false
+		},
+		validate: minArgs(1),
+	},
+	"NULLIF": {
+		fn: func(vals []float64, set []bool) (float64, bool) {
+			if set[0] && set[1] && vals[0] == vals[1] {
+				return 0, false
+			}
+			return vals[0], set[0]
+		},
+		validate: exactArgs(2),
+	},
+	"ISNULL": {
+		fn: func(vals []float64, set []bool) (float64, bool) {
+			if set[0] {
+				return 0, true
+			}
+			return 1, true
+		},
+		validate: exactArgs(1),
+	},
+	"ISNOTNULL": {
+		fn: func(vals []float64, set []bool) (float64, bool) {
+			if set[0] {
+				return 1, true
+			}
+			return 0, true
+		},
+		validate: exactArgs(1),
+	},
+	"CASE": {
+		fn: func(vals []float64, set []bool) (float64, bool) {
+			i := 0
+			for ; i+1 < len(vals); i += 2 {
+				if set[i] && vals[i] != 0 {
+					return vals[i+1], set[i+1]
+				}
+			}
+			if i < len(vals) {
+				// ELSE
+				return vals[i], set[i]
+			}
+			return 0, false
+		},
+		validate: minArgs(2),
+	},
+}
+
+func exactArgs(n int) func(int) error {
+	return func(numArgs int) error {
+		if numArgs != n {
+			return fmt.Errorf("requires %d arguments, not %d", n, numArgs)
+		}
+		return nil
+	}
+}
+
+func minArgs(n int) func(int) error {
+	return func(numArgs int) error {
+		if numArgs < n {
+			return fmt.Errorf("requires at least %d arguments, not %d", n, numArgs)
+		}
+		return nil
+	}
+}
+
+// pick picks the set value that is better than all others.
+func pick(vals []float64, set []bool, better func(a float64, b float64) bool) (float64, bool) {
+	result := float64(0)
+	found := false
+	for i, val := range vals {
+		if set[i] && (!found || better(val, result)) {
+			result = val
+			found = true
+		}
+	}
+	return result, found
+}
+
+// IsFunc indicates whether name is the name of a function supported by Func.
+func IsFunc(name string) bool {
+	return funcDefs[name] != nil
+}
+
+// Func creates an Expr for the named function (POW, GREATEST, LEAST, COALESCE,
+// NULLIF, ISNULL, ISNOTNULL or CASE) with the given arguments.
+func Func(name string, args ...interface{}) (Expr, error) {
+	def := funcDefs[name]
+	if def == nil {
+		return nil, fmt.Errorf("Unknown function %v", name)
+	}
+	if err := def.validate(len(args)); err != nil {
+		return nil, fmt.Errorf("%v %v", name, err)
+	}
+	return newFuncExpr(name, args...), nil
+}
+
+// POW creates an Expr that obtains its value by raising base to the power of
+// exponent.
+func POW(base interface{}, exponent interface{}) Expr {
+	return newFuncExpr("POW", base, exponent)
+}
+
+// GREATEST creates an Expr that obtains its value as the largest of its
+// arguments, ignoring arguments that don't have a value.
+func GREATEST(args ...interface{}) Expr {
+	return newFuncExpr("GREATEST", args...)
+}
+
+// LEAST creates an Expr that obtains its value as the smallest of its
+// arguments, ignoring arguments that don't have a value.
+func LEAST(args ...interface{}) Expr {
+	return newFuncExpr("LEAST", args...)
+}
+
+// COALESCE creates an Expr that obtains its value from the first of its
+// arguments that has a value.
+func COALESCE(args ...interface{}) Expr {
+	return newFuncExpr("COALESCE", args...)
+}
+
+// NULLIF creates an Expr that obtains its value from val unless val equals
+// other, in which case the result is unset (NULL). For example,
+// DIV(SUM("errors"), NULLIF(SUM("requests"), 0)).
+func NULLIF(val interface{}, other interface{}) Expr {
+	return newFuncExpr("NULLIF", val, other)
+}
+
+// ISNULL creates an Expr that tests whether wrapped is unset (NULL), for
+// example because it divides by zero. Unlike other expressions, the result of
+// ISNULL is always set.
+func ISNULL(wrapped interface{}) Expr {
+	return newFuncExpr("ISNULL", wrapped)
+}
+
+// ISNOTNULL is the opposite of ISNULL.
+func ISNOTNULL(wrapped interface{}) Expr {
+	return newFuncExpr("ISNOTNULL", wrapped)
+}
+
+// CASE creates an Expr that obtains its value from the first value whose
+// condition is true (non-zero). Arguments are pairs of conditions and values,
+// optionally followed by a value to use if none of the conditions are true
+// (ELSE), like CASE(GT(SUM("a"), 10), SUM("b"), SUM("c")).
+func CASE(condsAndValues ...interface{}) Expr {
+	return newFuncExpr("CASE", condsAndValues...)
+}
+
+func newFuncExpr(name string, args ...interface{}) *funcExpr {
+	_args := make([]Expr, 0, len(args))
+	for _, arg := range args {
+		_args = append(_args, exprFor(arg))
+	}
+	return &funcExpr{Name: name, Args: _args, fn: funcDefs[name].fn}
+}
+
+type funcExpr struct {
+	Name         string
+	Args         []Expr
+	DeAggregated bool
+	fn           funcFN
+}
+
+func (e *funcExpr) Validate() error {
+	if err := funcDefs[e.Name].validate(len(e.Args)); err != nil {
+		return fmt.Errorf("%v %v", e.Name, err)
+	}
+	for _, arg := range e.Args {
+		if err := validateWrappedInCalc(arg, e.DeAggregated); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *funcExpr) EncodedWidth() int {
+	width := 0
+	for _, arg := range e.Args {
+		width += arg.EncodedWidth()
+	}
+	return width
+}
+
+func (e *funcExpr) Shift(resolution time.Duration) time.Duration {
+	result := time.Duration(0)
+	for i, arg := range e.Args {
+		shift := arg.Shift(resolution)
+		if i == 0 || shift < result {
+			result = shift
+		}
+	}
+	return result
+}
+
+func (e *funcExpr) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	remain := b
+	updated := false
+	for _, arg := range e.Args {
+		var argUpdated bool
+		remain, _, argUpdated = arg.Update(remain, params, metadata)
+		updated = updated || argUpdated
+	}
+	value, _, _ := e.Get(b)
+	return remain, value, updated
+}
+
+func (e *funcExpr) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
+	for _, arg := range e.Args {
+		b, x, y = arg.Merge(b, x, y)
+	}
+	return b, x, y
+}
+
+func (e *funcExpr) SubMergers(subs []Expr) []SubMerge {
+	result := make([]SubMerge, len(subs))
+	// See if any of the subexpressions match top level and if so, ignore others
+	for i, sub := range subs {
+		if e.String() == sub.String() {
+			result[i] = e.subMerge
+			return result
+		}
+	}
+
+	// None of sub expressions match top level, build combined ones
+	argSubMergers := make([][]SubMerge, 0, len(e.Args))
+	for _, arg := range e.Args {
+		argSubMergers = append(argSubMergers, arg.SubMergers(subs))
+	}
+	for i := range subs {
+		// combine in reverse so that each argument's data starts after that of
+		// the prior arguments
+		var combined SubMerge
+		for a := len(e.Args) - 1; a >= 0; a-- {
+			combined = combinedSubMerge(argSubMergers[a][i], e.Args[a].EncodedWidth(), combined)
+		}
+		result[i] = combined
+	}
+	return result
+}
+
+func (e *funcExpr) subMerge(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+	e.Merge(data, data, other)
+}
+
+func (e *funcExpr) Get(b []byte) (float64, bool, []byte) {
+	vals := make([]float64, len(e.Args))
+	set := make([]bool, len(e.Args))
+	remain := b
+	for i, arg := range e.Args {
+		vals[i], set[i], remain = arg.Get(remain)
+	}
+	value, wasSet := e.fn(vals, set)
+	if wasSet && !isDefined(value) {
+		return 0, false, remain
+	}
+	return value, wasSet, remain
+}
+
+func (e *funcExpr) IsConstant() bool {
+	for _, arg := range e.Args {
+		if !arg.IsConstant() {
+			return false
+		}
+	}
+	return true
+}
+
+func (e *funcExpr) DeAggregate() Expr {
+	args := make([]interface{}, 0, len(e.Args))
+	for _, arg := range e.Args {
+		args = append(args, arg.DeAggregate())
+	}
+	result := newFuncExpr(e.Name, args...)
+	result.DeAggregated = true
+	return result
+}
+
+func (e *funcExpr) String() string {
+	buf := &bytes.Buffer{}
+	switch e.Name {
+	case "CASE":
+		buf.WriteString("CASE")
+		i := 0
+		for ; i+1 < len(e.Args); i += 2 {
+			fmt.Fprintf(buf, " WHEN %v THEN %v", e.Args[i], e.Args[i+1])
+		}
+		if i < len(e.Args) {
+			fmt.Fprintf(buf, " ELSE %v", e.Args[i])
+		}
+		buf.WriteString(" END")
+		return buf.String()
+	case "ISNULL":
+		return fmt.Sprintf("(%v IS NULL)", e.Args[0])
+	case "ISNOTNULL":
+		return fmt.Sprintf("(%v IS NOT NULL)", e.Args[0])
+	}
+
+	buf.WriteString(e.Name)
+	buf.WriteString("(")
+	for i, arg := range e.Args {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(arg.String())
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
+
+func (e *funcExpr) DecodeMsgpack(dec *msgpack.Decoder) error {
+	m := make(map[string]interface{})
+	err := dec.Decode(&m)
+	if err != nil {
+		return err
+	}
+	e.Name = m["Name"].(string)
+	def := funcDefs[e.Name]
+	if def == nil {
+		return fmt.Errorf("Unknown function %v", e.Name)
+	}
+	e.fn = def.fn
+	args := m["Args"].([]interface{})
+	e.Args = make([]Expr, 0, len(args))
+	for _, arg := range args {
+		e.Args = append(e.Args, arg.(Expr))
+	}
+	e.DeAggregated, _ = m["DeAggregated"].(bool)
+	return nil
+}
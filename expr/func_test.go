@@ -0,0 +1,115 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuncs(t *testing.T) {
+	doTestFunc(t, POW(CONST(2), CONST(10)), 1024, true)
+	doTestFunc(t, GREATEST(CONST(2), CONST(10), CONST(-1)), 10, true)
+	doTestFunc(t, LEAST(CONST(2), CONST(10), CONST(-1)), -1, true)
+	doTestFunc(t, CASE(GT(CONST(1), CONST(2)), CONST(1), CONST(3)), 3, true)
+	doTestFunc(t, CASE(LT(CONST(1), CONST(2)), CONST(1), CONST(3)), 1, true)
+	doTestFunc(t, CASE(GT(CONST(1), CONST(2)), CONST(1)), 0, false)
+
+	_, err := Func("POW", CONST(1))
+	assert.Error(t, err)
+	_, err = Func("NOSUCHFUNC", CONST(1))
+	assert.Error(t, err)
+	e, err := Func("COALESCE", CONST(1), CONST(2))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "COALESCE(1.000000, 2.000000)", e.String())
+	}
+}
+
+func TestFuncUnset(t *testing.T) {
+	e := msgpacked(t, COALESCE(SUM("a"), SUM("b"), CONST(5)))
+	b := make([]byte, e.EncodedWidth())
+	val, _, _ := e.Get(b)
+	assert.EqualValues(t, 5, val)
+	e.Update(b, Map{"b": 2}, nil)
+	val, _, _ = e.Get(b)
+	assert.EqualValues(t, 2, val)
+	e.Update(b, Map{"a": 1}, nil)
+	val, _, _ = e.Get(b)
+	assert.EqualValues(t, 1, val)
+
+	e = msgpacked(t, GREATEST(SUM("a"), SUM("b")))
+	b = make([]byte, e.EncodedWidth())
+	_, wasSet, _ := e.Get(b)
+	assert.False(t, wasSet)
+	e.Update(b, Map{"b": -2}, nil)
+	val, _, _ = e.Get(b)
+	assert.EqualValues(t, -2, val, "Unset values should be ignored")
+}
+
+func TestNullIfAndIsNull(t *testing.T) {
+	doTestFunc(t, NULLIF(CONST(2), CONST(3)), 2, true)
+	doTestFunc(t, NULLIF(CONST(2), CONST(2)), 0, false)
+	doTestFunc(t, DIV(CONST(2), NULLIF(CONST(0), CONST(0))), 0, false)
+	doTestFunc(t, ISNULL(DIV(CONST(2), CONST(0))), 1, true)
+	doTestFunc(t, ISNULL(DIV(CONST(2), CONST(1))), 0, true)
+	doTestFunc(t, ISNOTNULL(DIV(CONST(2), CONST(0))), 0, true)
+	doTestFunc(t, ISNOTNULL(DIV(CONST(2), CONST(1))), 1, true)
+	doTestFunc(t, POW(CONST(0), CONST(-1)), 0, false)
+	assert.Equal(t, "(SUM(a) IS NULL)", ISNULL(SUM("a")).String())
+	assert.Equal(t, "(SUM(a) IS NOT NULL)", ISNOTNULL(SUM("a")).String())
+
+	e := msgpacked(t, ISNULL(DIV(SUM("a"), SUM("b"))))
+	b := make([]byte, e.EncodedWidth())
+	e.Update(b, Map{"a": 1}, nil)
+	val, wasSet, _ := e.Get(b)
+	assert.True(t, wasSet)
+	assert.EqualValues(t, 1, val)
+	e.Update(b, Map{"b": 2}, nil)
+	val, _, _ = e.Get(b)
+	assert.EqualValues(t, 0, val)
+}
+
+func TestCase(t *testing.T) {
+	e := msgpacked(t, CASE(GT(SUM("a"), 10), SUM("b"), GT(SUM("a"), 5), MULT(SUM("b"), 2), SUM("c")))
+	assert.Equal(t, "CASE WHEN (SUM(a) > 10.000000) THEN SUM(b) WHEN (SUM(a) > 5.000000) THEN (SUM(b) * 2.000000) ELSE SUM(c) END", e.String())
+	assert.NoError(t, e.Validate())
+
+	check := func(a float64, expected float64) {
+		b := make([]byte, e.EncodedWidth())
+		e.Update(b, Map{"a": a, "b": 3, "c": 7}, nil)
+		val, _, _ := e.Get(b)
+		assert.EqualValues(t, expected, val)
+	}
+	check(11, 3)
+	check(6, 6)
+	check(1, 7)
+}
+
+func TestFuncSubMerge(t *testing.T) {
+	fa := SUM("a")
+	fb := SUM("b")
+	e := msgpacked(t, GREATEST(SHIFT(fa, -1*time.Hour), fb))
+	subs := e.SubMergers([]Expr{fa, fb})
+	if !assert.Len(t, subs, 2) || !assert.NotNil(t, subs[0]) || !assert.NotNil(t, subs[1]) {
+		return
+	}
+
+	a := make([]byte, fa.EncodedWidth()*2)
+	fa.Update(a, Map{"a": 1}, nil)
+	fa.Update(a[fa.EncodedWidth():], Map{"a": 10}, nil)
+	b := make([]byte, fb.EncodedWidth())
+	fb.Update(b, Map{"b": 5}, nil)
+
+	data := make([]byte, e.EncodedWidth())
+	subs[0](data, a, time.Hour, nil)
+	subs[1](data, b, time.Hour, nil)
+	val, _, _ := e.Get(data)
+	assert.EqualValues(t, 10, val)
+}
+
+func doTestFunc(t *testing.T, e Expr, expected float64, expectedSet bool) {
+	e = msgpacked(t, e)
+	val, wasSet, _ := e.Get(nil)
+	assert.Equal(t, expectedSet, wasSet, e.String())
+	AssertFloatEquals(t, expected, val)
+}
@@ -0,0 +1,178 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"reflect"
+	"time"
+
+	"github.com/getlantern/goexpr"
+	"github.com/spaolacci/murmur3"
+)
+
+const (
+	// hllPrecision is the number of bits of the hash used to select a register,
+	// giving 2^12 = 4096 registers and a standard error of about 1.6%.
+	hllPrecision = 12
+	hllRegisters = 1 << hllPrecision
+)
+
+// HLL estimates the number of distinct values of the given dimension using a
+// HyperLogLog sketch. dim may be the name of a dimension, a goexpr.Expr or a
+// FIELD whose name is taken as the name of the dimension. Because the values
+// come from the dimensions of inserted points, HLL can only be calculated at
+// insert time, so it needs to be stored in a table in order to be queried.
+//
+// HLL sketches are mergeable, so the estimate is correct when rolling up
+// periods, in views and across cluster partitions.
+//
+// WARNING - like PERCENTILE, HLL is large (4 Kilobytes per period), so it is
+// best to keep tables that use it relatively low cardinality.
+func HLL(dim interface{}) Expr {
+	var dimExpr goexpr.Expr
+	switch d := dim.(type) {
+	case goexpr.Expr:
+		dimExpr = d
+	case string:
+		dimExpr = goexpr.Param(d)
+	case *field:
+		dimExpr = goexpr.Param(d.Name)
+	default:
+		panic(fmt.Sprintf("Got a %v, please specify a dimension name or goexpr.Expr", reflect.TypeOf(dim)))
+	}
+	return &hll{Dim: dimExpr}
+}
+
+type hll struct {
+	Dim goexpr.Expr
+}
+
+func (e *hll) Validate() error {
+	if e.Dim == nil {
+		return fmt.Errorf("HLL requires a dimension")
+	}
+	return nil
+}
+
+func (e *hll) EncodedWidth() int {
+	return 1 + hllRegisters
+}
+
+func (e *hll) Shift(resolution time.Duration) time.Duration {
+	return 0
+}
+
+func (e *hll) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	remain := b[e.EncodedWidth():]
+	var val interface{}
+	if metadata != nil {
+		val = e.Dim.Eval(metadata)
+	}
+	if val == nil {
+		return remain, e.calc(b), false
+	}
+	b[0] = 1
+	registers := b[1:e.EncodedWidth()]
+	h := murmur3.Sum64(hllBytes(val))
+	idx := h >> (64 - hllPrecision)
+	rank := byte(bits.LeadingZeros64(h<<hllPrecision|1<<(hllPrecision-1)) + 1)
+	if rank > registers[idx] {
+		registers[idx] = rank
+	}
+	return remain, e.calc(b), true
+}
+
+func hllBytes(val interface{}) []byte {
+	switch v := val.(type) {
+	case string:
+		return []byte(v)
+	case []byte:
+		return v
+	default:
+		return []byte(fmt.Sprint(v))
+	}
+}
+
+func (e *hll) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
+	width := e.EncodedWidth()
+	xWasSet := x[0] == 1
+	yWasSet := y[0] == 1
+	switch {
+	case xWasSet && yWasSet:
+		b[0] = 1
+		for i := 1; i < width; i++ {
+			r := x[i]
+			if y[i] > r {
+				r = y[i]
+			}
+			b[i] = r
+		}
+	case xWasSet:
+		copy(b, x[:width])
+	case yWasSet:
+		copy(b, y[:width])
+	}
+	return b[width:], x[width:], y[width:]
+}
+
+func (e *hll) SubMergers(subs []Expr) []SubMerge {
+	result := make([]SubMerge, 0, len(subs))
+	for _, sub := range subs {
+		var sm SubMerge
+		if e.String() == sub.String() {
+			sm = e.subMerge
+		}
+		result = append(result, sm)
+	}
+	return result
+}
+
+func (e *hll) subMerge(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+	e.Merge(data, data, other)
+}
+
+func (e *hll) Get(b []byte) (float64, bool, []byte) {
+	remain := b[e.EncodedWidth():]
+	if b[0] != 1 {
+		return 0, false, remain
+	}
+	return e.calc(b), true, remain
+}
+
+// calc calculates the estimated cardinality, using linear counting for small
+// cardinalities.
+func (e *hll) calc(b []byte) float64 {
+	if b[0] != 1 {
+		return 0
+	}
+	registers := b[1:e.EncodedWidth()]
+	m := float64(hllRegisters)
+	sum := float64(0)
+	zeros := 0
+	for _, r := range registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return math.Round(estimate)
+}
+
+func (e *hll) IsConstant() bool {
+	return false
+}
+
+func (e *hll) DeAggregate() Expr {
+	// there's no per-point value to strip down to
+	return e
+}
+
+func (e *hll) String() string {
+	return fmt.Sprintf("HLL(%v)", e.Dim)
+}
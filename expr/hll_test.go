@@ -0,0 +1,56 @@
+package expr
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/goexpr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHLL(t *testing.T) {
+	e := msgpacked(t, HLL("user"))
+	assert.Equal(t, "HLL(user)", e.String())
+	assert.Equal(t, e.String(), HLL(FIELD("user")).String())
+
+	update := func(b []byte, from int, to int) {
+		for i := from; i < to; i++ {
+			e.Update(b, Map{}, goexpr.MapParams{"user": fmt.Sprintf("user%d", i)})
+			// Repeated values don't change the estimate
+			e.Update(b, Map{}, goexpr.MapParams{"user": fmt.Sprintf("user%d", i)})
+		}
+	}
+
+	x := make([]byte, e.EncodedWidth())
+	_, wasSet, _ := e.Get(x)
+	assert.False(t, wasSet)
+
+	_, _, updated := e.Update(x, Map{}, goexpr.MapParams{"other": "a"})
+	assert.False(t, updated, "Missing dimension should not update")
+
+	update(x, 0, 10)
+	val, wasSet, _ := e.Get(x)
+	if assert.True(t, wasSet) {
+		AssertFloatWithin(t, 0.1, 10, val, "Incorrect small distinct count")
+	}
+
+	y := make([]byte, e.EncodedWidth())
+	update(x, 0, 10000)
+	update(y, 5000, 15000)
+
+	merged := make([]byte, e.EncodedWidth())
+	e.Merge(merged, x, y)
+	val, _, _ = e.Get(merged)
+	AssertFloatWithin(t, 0.05, 15000, val, "Incorrect merged distinct count")
+
+	// Merging with an unset value keeps the set value
+	empty := make([]byte, e.EncodedWidth())
+	e.Merge(empty, empty, y)
+	val, _, _ = e.Get(empty)
+	AssertFloatWithin(t, 0.05, 10000, val, "Incorrect distinct count after merging with empty")
+
+	sms := e.SubMergers([]Expr{HLL("user"), HLL("other"), SUM("user")})
+	assert.NotNil(t, sms[0])
+	assert.Nil(t, sms[1])
+	assert.Nil(t, sms[2])
+}
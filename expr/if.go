@@ -26,8 +26,8 @@ func (e *ifExpr) EncodedWidth() int {
 	return e.Width
 }
 
-func (e *ifExpr) Shift() time.Duration {
-	return e.Wrapped.Shift()
+func (e *ifExpr) Shift(resolution time.Duration) time.Duration {
+	return e.Wrapped.Shift(resolution)
 }
 
 func (e *ifExpr) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
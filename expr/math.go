@@ -13,6 +13,12 @@ var unaryMathFNs = map[string]func(float64) float64{
 	"LN":    math.Log,
 	"LOG2":  math.Log2,
 	"LOG10": math.Log10,
+	"ABS":   math.Abs,
+	"SQRT":  math.Sqrt,
+	"EXP":   math.Exp,
+	"ROUND": math.Round,
+	"FLOOR": math.Floor,
+	"CEIL":  math.Ceil,
 }
 
 type unaryMathExpr struct {
@@ -39,8 +45,8 @@ func (e *unaryMathExpr) EncodedWidth() int {
 	return e.Width
 }
 
-func (e *unaryMathExpr) Shift() time.Duration {
-	return e.Wrapped.Shift()
+func (e *unaryMathExpr) Shift(resolution time.Duration) time.Duration {
+	return e.Wrapped.Shift(resolution)
 }
 
 func (e *unaryMathExpr) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
@@ -66,10 +72,19 @@ func (e *unaryMathExpr) Get(b []byte) (float64, bool, []byte) {
 	val, found, remain := e.Wrapped.Get(b)
 	if found {
 		val = e.fn(val)
+		if !isDefined(val) {
+			// Results like LN(0) and SQRT(-1) are treated as unset (NULL)
+			return 0, false, remain
+		}
 	}
 	return val, found, remain
 }
 
+// isDefined indicates whether val is a regular number (not NaN or infinite).
+func isDefined(val float64) bool {
+	return !math.IsNaN(val) && !math.IsInf(val, 0)
+}
+
 func (e *unaryMathExpr) IsConstant() bool {
 	return e.Wrapped.IsConstant()
 }
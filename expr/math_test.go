@@ -19,6 +19,42 @@ func TestLog10(t *testing.T) {
 	doTestUnaryMath(t, "LOG10", 10, 1)
 }
 
+func TestAbs(t *testing.T) {
+	doTestUnaryMath(t, "ABS", -2.5, 2.5)
+}
+
+func TestSqrt(t *testing.T) {
+	doTestUnaryMath(t, "SQRT", 16, 4)
+}
+
+func TestExp(t *testing.T) {
+	doTestUnaryMath(t, "EXP", 1, math.E)
+}
+
+func TestRound(t *testing.T) {
+	doTestUnaryMath(t, "ROUND", 2.5, 3)
+}
+
+func TestFloor(t *testing.T) {
+	doTestUnaryMath(t, "FLOOR", -2.5, -3)
+}
+
+func TestCeil(t *testing.T) {
+	doTestUnaryMath(t, "CEIL", 2.1, 3)
+}
+
+func TestUndefinedMath(t *testing.T) {
+	for _, name := range []string{"LN", "LOG2", "LOG10", "SQRT"} {
+		arg := float64(0)
+		if name == "SQRT" {
+			arg = -1
+		}
+		e, _ := UnaryMath(name, CONST(arg))
+		_, wasSet, _ := msgpacked(t, e).Get(nil)
+		assert.False(t, wasSet, "%v should be unset", e)
+	}
+}
+
 func doTestUnaryMath(t *testing.T, name string, in float64, expected float64) {
 	e, err := UnaryMath(name, CONST(in))
 	if !assert.NoError(t, err) {
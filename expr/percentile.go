@@ -103,9 +103,9 @@ func (e *ptile) EncodedWidth() int {
 	return e.Width
 }
 
-func (e *ptile) Shift() time.Duration {
-	a := e.Value.Shift()
-	b := e.Percentile.Shift()
+func (e *ptile) Shift(resolution time.Duration) time.Duration {
+	a := e.Value.Shift(resolution)
+	b := e.Percentile.Shift(resolution)
 	if a < b {
 		return a
 	}
@@ -0,0 +1,373 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/getlantern/goexpr"
+)
+
+const (
+	// quantileCompression is the t-digest compression parameter (delta). Higher
+	// values give more accurate estimates but need more centroids.
+	quantileCompression = 100
+	// quantileCapacity is the maximum number of centroids stored. Digests are
+	// compressed whenever they fill up.
+	quantileCapacity = 2 * quantileCompression
+	// quantileCentroidWidth is the width of a centroid's mean and weight
+	quantileCentroidWidth = 2 * width64bits
+	// quantileBufferSize is the number of points that Update buffers before
+	// merging them into the centroids
+	quantileBufferSize = 32
+	// quantileBufferOffset is the offset of the point buffer, following the
+	// wasSet flag, the number of centroids, min, max and the centroids
+	quantileBufferOffset = 1 + 2 + 2*width64bits + quantileCapacity*quantileCentroidWidth
+	// quantileSketchWidth is the width of everything up to the point buffer plus
+	// the number of buffered points and the buffer itself
+	quantileSketchWidth = quantileBufferOffset + 2 + quantileBufferSize*width64bits
+)
+
+// QUANTILE tracks estimated percentile values for the given expression using a
+// t-digest. Unlike PERCENTILE, it doesn't require a min, max or precision,
+// works with negative values and is more compact (about 3.5 Kilobytes per
+// period). Accuracy is relative to the rank of the quantile, so it is most
+// accurate at the extremes (e.g. 99.9). Like PERCENTILE, percentile is input in
+// percent (e.g. 0-100) and aggregates are stripped from value.
+//
+// It is possible to wrap an existing QUANTILE with a new QUANTILE (see
+// QUANTILEOPT) to reuse the original QUANTILE's storage but look at a
+// different percentile.
+func QUANTILE(value interface{}, percentile interface{}) Expr {
+	return &quantile{
+		Value:      exprFor(value).DeAggregate(),
+		Percentile: exprFor(percentile),
+	}
+}
+
+// IsQuantile indicates whether the given expression is a quantile expression.
+func IsQuantile(e Expr) bool {
+	switch e.(type) {
+	case *quantile:
+		return true
+	case *quantileOptimized:
+		return true
+	default:
+		return false
+	}
+}
+
+type centroid struct {
+	mean   float64
+	weight float64
+}
+
+type digest struct {
+	centroids []centroid
+	min       float64
+	max       float64
+}
+
+type quantile struct {
+	Value      Expr
+	Percentile Expr
+}
+
+func (e *quantile) Validate() error {
+	err := validateWrappedInAggregate(e.Value)
+	if err != nil {
+		return err
+	}
+	if e.Percentile.EncodedWidth() > 0 {
+		return fmt.Errorf("Percentile expression %v must be a constant or directly derived from a field", e.Percentile)
+	}
+	return nil
+}
+
+func (e *quantile) EncodedWidth() int {
+	return quantileSketchWidth + e.Value.EncodedWidth()
+}
+
+func (e *quantile) Shift(resolution time.Duration) time.Duration {
+	a := e.Value.Shift(resolution)
+	b := e.Percentile.Shift(resolution)
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Update adds points to a small buffer that's kept sorted and only merges them
+// into the centroids once the buffer fills up, so most updates don't need to
+// decode the digest.
+func (e *quantile) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	remain, value, updated := e.Value.Update(b[quantileSketchWidth:], params, metadata)
+	remain, percentile, _ := e.Percentile.Update(remain, params, metadata)
+	if updated {
+		e.buffer(b, value)
+	}
+	return remain, e.quantile(b, percentile/100), updated
+}
+
+func (e *quantile) buffer(b []byte, value float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	if b[0] == 1 {
+		min = math.Float64frombits(binaryEncoding.Uint64(b[3:]))
+		max = math.Float64frombits(binaryEncoding.Uint64(b[3+width64bits:]))
+	}
+	if value < min {
+		binaryEncoding.PutUint64(b[3:], math.Float64bits(value))
+	}
+	if value > max {
+		binaryEncoding.PutUint64(b[3+width64bits:], math.Float64bits(value))
+	}
+	b[0] = 1
+
+	numBuffered := int(binaryEncoding.Uint16(b[quantileBufferOffset:]))
+	i := numBuffered
+	for ; i > 0 && quantileBufferedAt(b, i-1) > value; i-- {
+		binaryEncoding.PutUint64(b[quantileBufferOffset+2+i*width64bits:], binaryEncoding.Uint64(b[quantileBufferOffset+2+(i-1)*width64bits:]))
+	}
+	binaryEncoding.PutUint64(b[quantileBufferOffset+2+i*width64bits:], math.Float64bits(value))
+	numBuffered++
+	binaryEncoding.PutUint16(b[quantileBufferOffset:], uint16(numBuffered))
+
+	if numBuffered == quantileBufferSize {
+		d, _ := e.load(b)
+		e.save(b, d)
+	}
+}
+
+// quantile estimates the value at quantile q (0-1) directly from the encoded
+// digest, whose centroids and buffered points are both sorted.
+func (e *quantile) quantile(b []byte, q float64) float64 {
+	if b[0] != 1 {
+		return 0
+	}
+	min := math.Float64frombits(binaryEncoding.Uint64(b[3:]))
+	max := math.Float64frombits(binaryEncoding.Uint64(b[3+width64bits:]))
+	numCentroids := int(binaryEncoding.Uint16(b[1:]))
+	numBuffered := int(binaryEncoding.Uint16(b[quantileBufferOffset:]))
+	total := float64(numBuffered)
+	for i := 0; i < numCentroids; i++ {
+		total += quantileCentroidAt(b, i).weight
+	}
+
+	i, j := 0, 0
+	return quantileOf(q, min, max, total, numCentroids+numBuffered, func() centroid {
+		if j == numBuffered || (i < numCentroids && quantileCentroidAt(b, i).mean <= quantileBufferedAt(b, j)) {
+			i++
+			return quantileCentroidAt(b, i-1)
+		}
+		j++
+		return centroid{quantileBufferedAt(b, j-1), 1}
+	})
+}
+
+func quantileCentroidAt(b []byte, i int) centroid {
+	cb := b[3+2*width64bits+i*quantileCentroidWidth:]
+	return centroid{
+		mean:   math.Float64frombits(binaryEncoding.Uint64(cb)),
+		weight: math.Float64frombits(binaryEncoding.Uint64(cb[width64bits:])),
+	}
+}
+
+func quantileBufferedAt(b []byte, i int) float64 {
+	return math.Float64frombits(binaryEncoding.Uint64(b[quantileBufferOffset+2+i*width64bits:]))
+}
+
+func (e *quantile) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
+	dX, xWasSet := e.load(x)
+	dY, yWasSet := e.load(y)
+	switch {
+	case xWasSet && yWasSet:
+		dX.merge(dY)
+		e.save(b, dX)
+	case xWasSet:
+		e.save(b, dX)
+	case yWasSet:
+		e.save(b, dY)
+	}
+	width := e.EncodedWidth()
+	return b[width:], x[width:], y[width:]
+}
+
+func (e *quantile) SubMergers(subs []Expr) []SubMerge {
+	result := make([]SubMerge, 0, len(subs))
+	for _, sub := range subs {
+		var sm SubMerge
+		if e.String() == sub.String() {
+			sm = e.subMerge
+		}
+		result = append(result, sm)
+	}
+	return result
+}
+
+func (e *quantile) subMerge(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+	e.Merge(data, data, other)
+}
+
+func (e *quantile) Get(b []byte) (float64, bool, []byte) {
+	return e.get(b, e.Percentile)
+}
+
+func (e *quantile) get(b []byte, percentileExpr Expr) (float64, bool, []byte) {
+	percentile, _, remain := percentileExpr.Get(b[e.EncodedWidth():])
+	if b[0] != 1 {
+		return 0, false, remain
+	}
+	return e.quantile(b, percentile/100), true, remain
+}
+
+// load loads the digest, including any buffered points as centroids.
+func (e *quantile) load(b []byte) (*digest, bool) {
+	d := &digest{min: math.Inf(1), max: math.Inf(-1)}
+	if b[0] != 1 {
+		return d, false
+	}
+	numCentroids := int(binaryEncoding.Uint16(b[1:]))
+	numBuffered := int(binaryEncoding.Uint16(b[quantileBufferOffset:]))
+	d.min = math.Float64frombits(binaryEncoding.Uint64(b[3:]))
+	d.max = math.Float64frombits(binaryEncoding.Uint64(b[3+width64bits:]))
+	d.centroids = make([]centroid, 0, numCentroids+numBuffered)
+	for i := 0; i < numCentroids; i++ {
+		d.centroids = append(d.centroids, quantileCentroidAt(b, i))
+	}
+	for i := 0; i < numBuffered; i++ {
+		d.centroids = append(d.centroids, centroid{quantileBufferedAt(b, i), 1})
+	}
+	return d, true
+}
+
+// save saves the digest with its centroids sorted and an empty buffer.
+func (e *quantile) save(b []byte, d *digest) {
+	if len(d.centroids) > quantileCapacity {
+		d.compress()
+	} else {
+		d.sort()
+	}
+	b[0] = 1
+	binaryEncoding.PutUint16(b[1:], uint16(len(d.centroids)))
+	binaryEncoding.PutUint64(b[3:], math.Float64bits(d.min))
+	binaryEncoding.PutUint64(b[3+width64bits:], math.Float64bits(d.max))
+	for i, c := range d.centroids {
+		cb := b[3+2*width64bits+i*quantileCentroidWidth:]
+		binaryEncoding.PutUint64(cb, math.Float64bits(c.mean))
+		binaryEncoding.PutUint64(cb[width64bits:], math.Float64bits(c.weight))
+	}
+	binaryEncoding.PutUint16(b[quantileBufferOffset:], 0)
+}
+
+func (e *quantile) IsConstant() bool {
+	return e.Value.IsConstant()
+}
+
+func (e *quantile) DeAggregate() Expr {
+	return e.Value.DeAggregate()
+}
+
+func (e *quantile) String() string {
+	return fmt.Sprintf("QUANTILE(%v, %v)", e.Value, e.Percentile)
+}
+
+func (d *digest) merge(other *digest) {
+	d.centroids = append(d.centroids, other.centroids...)
+	if other.min < d.min {
+		d.min = other.min
+	}
+	if other.max > d.max {
+		d.max = other.max
+	}
+	if len(d.centroids) > quantileCapacity {
+		d.compress()
+	}
+}
+
+// compress merges adjacent centroids as long as they stay within the size
+// limit given by the k1 scale function, which keeps centroids small near the
+// extremes.
+func (d *digest) compress() {
+	d.sort()
+	total := float64(0)
+	for _, c := range d.centroids {
+		total += c.weight
+	}
+
+	result := make([]centroid, 0, quantileCompression)
+	current := d.centroids[0]
+	weightSoFar := float64(0)
+	weightLimit := total * quantileKInverse(quantileK(0)+1)
+	for _, next := range d.centroids[1:] {
+		if weightSoFar+current.weight+next.weight <= weightLimit {
+			newWeight := current.weight + next.weight
+			current.mean += (next.mean - current.mean) * next.weight / newWeight
+			current.weight = newWeight
+			continue
+		}
+		weightSoFar += current.weight
+		result = append(result, current)
+		weightLimit = total * quantileKInverse(quantileK(weightSoFar/total)+1)
+		current = next
+	}
+	d.centroids = append(result, current)
+}
+
+func quantileK(q float64) float64 {
+	return quantileCompression / (2 * math.Pi) * math.Asin(2*q-1)
+}
+
+func quantileKInverse(k float64) float64 {
+	if k >= quantileCompression/4 {
+		return 1
+	}
+	return (math.Sin(k*2*math.Pi/quantileCompression) + 1) / 2
+}
+
+func (d *digest) sort() {
+	sort.Slice(d.centroids, func(i, j int) bool {
+		return d.centroids[i].mean < d.centroids[j].mean
+	})
+}
+
+// quantileOf estimates the value at quantile q (0-1) by interpolating between
+// the means of n centroids with the given total weight, which nextCentroid
+// returns in ascending order of mean.
+func quantileOf(q float64, min float64, max float64, total float64, n int, nextCentroid func() centroid) float64 {
+	if n == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return min
+	}
+	if q >= 1 {
+		return max
+	}
+	first := nextCentroid()
+	if n == 1 {
+		return first.mean
+	}
+
+	target := q * total
+	if target < first.weight/2 {
+		return min + (first.mean-min)*target/(first.weight/2)
+	}
+	cumulative := float64(0)
+	c := first
+	for i := 0; i < n-1; i++ {
+		next := nextCentroid()
+		left := cumulative + c.weight/2
+		right := cumulative + c.weight + next.weight/2
+		if target <= right {
+			return c.mean + (next.mean-c.mean)*(target-left)/(right-left)
+		}
+		cumulative += c.weight
+		c = next
+	}
+	remaining := total - target
+	if remaining <= 0 {
+		return max
+	}
+	return max - (max-c.mean)*remaining/(c.weight/2)
+}
@@ -0,0 +1,58 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/getlantern/goexpr"
+	"github.com/getlantern/msgpack"
+)
+
+// QUANTILEOPT returns an optimized QUANTILE that wraps an existing QUANTILE.
+//
+// WARNING - QUANTILEs that wrap existing QUANTILEs are not stored and as such
+// are only suitable for use in querying but not in tables or views unless
+// those explicitly include the original QUANTILE as well.
+func QUANTILEOPT(wrapped interface{}, percentile interface{}) Expr {
+	var expr *quantile
+	switch t := wrapped.(type) {
+	case *quantileOptimized:
+		expr = &t.quantile
+	default:
+		expr = wrapped.(*quantile)
+	}
+	return &quantileOptimized{Wrapped: expr, quantile: *expr, Percentile: exprFor(percentile)}
+}
+
+type quantileOptimized struct {
+	quantile
+	Wrapped    Expr
+	Percentile Expr
+}
+
+func (e *quantileOptimized) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	remain, _, updated := e.quantile.Update(b, params, metadata)
+	value, _, _ := e.get(b, e.Percentile)
+	return remain, value, updated
+}
+
+func (e *quantileOptimized) Get(b []byte) (float64, bool, []byte) {
+	return e.get(b, e.Percentile)
+}
+
+func (e *quantileOptimized) String() string {
+	return fmt.Sprintf("QUANTILE(%v, %v)", e.Wrapped.String(), e.Percentile)
+}
+
+func (e *quantileOptimized) DecodeMsgpack(dec *msgpack.Decoder) error {
+	m := make(map[string]interface{})
+	err := dec.Decode(&m)
+	if err != nil {
+		return err
+	}
+	wrapped := m["Wrapped"].(*quantile)
+	percentile := m["Percentile"].(Expr)
+	e.Wrapped = wrapped
+	e.quantile = *wrapped
+	e.Percentile = percentile
+	return nil
+}
@@ -0,0 +1,143 @@
+package expr
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/getlantern/goexpr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeAggregateQuantile(t *testing.T) {
+	e := msgpacked(t, QUANTILE(SUM("p"), 99))
+	assert.Equal(t, FIELD("p").String(), e.DeAggregate().String())
+}
+
+func TestQuantile(t *testing.T) {
+	e := msgpacked(t, QUANTILE(SUM("a"), 99))
+	expected := float64(9.9)
+
+	eo := msgpacked(t, QUANTILEOPT(e, 50))
+	expectedO := float64(5.05)
+
+	eo2 := msgpacked(t, QUANTILEOPT(eo, 10))
+	expectedO2 := float64(1.05)
+
+	if !assert.True(t, IsQuantile(e)) {
+		return
+	}
+	if !assert.IsType(t, &quantile{}, e) {
+		return
+	}
+	if !assert.IsType(t, &quantileOptimized{}, eo) {
+		return
+	}
+	if !assert.IsType(t, &quantileOptimized{}, eo2) {
+		return
+	}
+	assert.Equal(t, "QUANTILE(QUANTILE(a, 99.000000), 50.000000)", eo.String())
+	assert.Equal(t, "QUANTILE(QUANTILE(a, 99.000000), 10.000000)", eo2.String())
+
+	checkValue := func(e Expr, b []byte, expected float64) {
+		val, wasSet, _ := e.Get(b)
+		if assert.True(t, wasSet) {
+			AssertFloatWithin(t, 0.03, expected, val, "Incorrect quantile")
+		}
+	}
+
+	md := goexpr.MapParams{}
+
+	merged := make([]byte, e.EncodedWidth())
+	for i := 0; i < 2; i++ {
+		b := make([]byte, e.EncodedWidth())
+		for j := 0; j < 50; j++ {
+			// Do some direct updates
+			for k := float64(1); k <= 50; k++ {
+				e.Update(b, Map{"a": k / 10}, md)
+			}
+
+			// Do some point merges
+			for k := float64(51); k <= 100; k++ {
+				b2 := make([]byte, e.EncodedWidth())
+				e.Update(b2, Map{"a": k / 10}, md)
+				e.Merge(b, b, b2)
+			}
+		}
+		checkValue(e, b, expected)
+		checkValue(eo, b, expectedO)
+		checkValue(eo2, b, expectedO2)
+		e.Merge(merged, merged, b)
+	}
+
+	checkValue(e, merged, expected)
+	checkValue(eo, merged, expectedO)
+	checkValue(eo2, merged, expectedO2)
+}
+
+func TestQuantileNegative(t *testing.T) {
+	e := msgpacked(t, QUANTILE("a", 50))
+	b := make([]byte, e.EncodedWidth())
+	md := goexpr.MapParams{}
+
+	_, wasSet, _ := e.Get(b)
+	assert.False(t, wasSet)
+
+	for i := 0; i < 10; i++ {
+		for k := float64(-1000); k <= 1000; k++ {
+			e.Update(b, Map{"a": k - 500}, md)
+		}
+	}
+	val, wasSet, _ := e.Get(b)
+	if assert.True(t, wasSet) {
+		AssertFloatWithin(t, 0.01, -500, val, "Incorrect median")
+	}
+	val, _, _ = QUANTILEOPT(e, 0).Get(b)
+	assert.EqualValues(t, -1500, val, "0th percentile should be min")
+	val, _, _ = QUANTILEOPT(e, 100).Get(b)
+	assert.EqualValues(t, 500, val, "100th percentile should be max")
+}
+
+func TestQuantileBuffer(t *testing.T) {
+	e := QUANTILE("a", 50).(*quantile)
+	b := make([]byte, e.EncodedWidth())
+	md := goexpr.MapParams{}
+	numCentroids := func() int {
+		return int(binaryEncoding.Uint16(b[1:]))
+	}
+	numBuffered := func() int {
+		return int(binaryEncoding.Uint16(b[quantileBufferOffset:]))
+	}
+
+	for i := 0; i < quantileBufferSize-1; i++ {
+		_, val, updated := e.Update(b, Map{"a": float64(quantileBufferSize - i)}, md)
+		assert.True(t, updated)
+		expected, _, _ := e.Get(b)
+		assert.Equal(t, expected, val)
+	}
+	assert.Equal(t, 0, numCentroids(), "Points should only have been buffered")
+	assert.Equal(t, quantileBufferSize-1, numBuffered())
+	val, _, _ := QUANTILEOPT(e, 0).Get(b)
+	assert.EqualValues(t, 2, val, "0th percentile should be min")
+	val, _, _ = QUANTILEOPT(e, 100).Get(b)
+	assert.EqualValues(t, quantileBufferSize, val, "100th percentile should be max")
+	val, _, _ = e.Get(b)
+	assert.EqualValues(t, 17, val, "Median of buffered points should be exact")
+
+	e.Update(b, Map{"a": 1}, md)
+	assert.Equal(t, quantileBufferSize, numCentroids(), "Full buffer should have been merged into centroids")
+	assert.Equal(t, 0, numBuffered())
+
+	for i := 0; i < 10000; i++ {
+		e.Update(b, Map{"a": float64(i % 1000)}, md)
+	}
+	d, _ := e.load(b)
+	d.sort()
+	for _, q := range []float64{0, 0.01, 0.5, 0.99, 1} {
+		i := 0
+		expected := quantileOf(q, d.min, d.max, float64(10000+quantileBufferSize), len(d.centroids), func() centroid {
+			i++
+			return d.centroids[i-1]
+		})
+		AssertFloatWithin(t, 0.000001, expected, e.quantile(b, q), fmt.Sprintf("Quantile %v from encoded digest should match decoded digest", q))
+	}
+}
@@ -0,0 +1,232 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/getlantern/goexpr"
+)
+
+const (
+	rateKind  = "RATE"
+	irateKind = "IRATE"
+	deltaKind = "DELTA"
+
+	// flag, increase, seconds, instant increase, instant seconds and instant
+	// position
+	rateWidth = 1 + width64bits*5
+)
+
+// RATE creates an Expr that obtains its value as the per-second rate of
+// increase of the given monotonic counter. The increase is calculated between
+// each pair of adjacent periods of the underlying sequence (similar to how
+// SHIFT reads other periods). If the counter decreases, it is assumed to have
+// been reset and the increase is taken to be its new value. When re-resolving
+// to a coarser resolution, the rate is averaged over all pairs of periods that
+// fall within each period. When a group contains several series, the result
+// is their average rate.
+//
+// RATE, IRATE and DELTA are calculated from stored data, so they may only be
+// used in queries and should wrap a single stored field (e.g. RATE(requests)).
+// The oldest period in a query has no previous period, so it has no value.
+func RATE(wrapped interface{}) Expr {
+	return &rate{Wrapped: exprFor(wrapped), Kind: rateKind}
+}
+
+// IRATE is like RATE but only considers the most recent pair of periods
+// within each period, making it more responsive to changes.
+func IRATE(wrapped interface{}) Expr {
+	return &rate{Wrapped: exprFor(wrapped), Kind: irateKind}
+}
+
+// DELTA is like RATE but obtains its value as the total increase rather than
+// the per-second rate. DELTAs of several series in a group are summed.
+func DELTA(wrapped interface{}) Expr {
+	return &rate{Wrapped: exprFor(wrapped), Kind: deltaKind}
+}
+
+type rate struct {
+	Wrapped Expr
+	Kind    string
+}
+
+type rateState struct {
+	increase         float64
+	seconds          float64
+	instantIncrease  float64
+	instantSeconds   float64
+	instantRemaining float64
+}
+
+func (e *rate) Validate() error {
+	return e.Wrapped.Validate()
+}
+
+func (e *rate) EncodedWidth() int {
+	return rateWidth
+}
+
+func (e *rate) Shift(resolution time.Duration) time.Duration {
+	return e.Wrapped.Shift(resolution)
+}
+
+func (e *rate) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	// Rates can't be calculated from individual points
+	state, _, remain := e.load(b)
+	return remain, e.calc(state), false
+}
+
+func (e *rate) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
+	stateX, xWasSet, remainX := e.load(x)
+	stateY, yWasSet, remainY := e.load(y)
+	if !xWasSet {
+		if yWasSet {
+			// Use valueY
+			b = e.save(b, stateY)
+		} else {
+			// Nothing to save, just advance
+			b = b[rateWidth:]
+		}
+	} else {
+		if yWasSet {
+			stateX.merge(stateY)
+		}
+		b = e.save(b, stateX)
+	}
+	return b, remainX, remainY
+}
+
+// merge adds other to s. Instant values are kept from whichever state saw the
+// most recent period, where periods are ordered by how much of their sequence
+// remains (more remaining means more recent since sequences are ordered from
+// newest to oldest).
+func (s *rateState) merge(other rateState) {
+	s.increase += other.increase
+	s.seconds += other.seconds
+	switch {
+	case other.instantRemaining > s.instantRemaining:
+		s.instantIncrease = other.instantIncrease
+		s.instantSeconds = other.instantSeconds
+		s.instantRemaining = other.instantRemaining
+	case other.instantRemaining == s.instantRemaining:
+		s.instantIncrease += other.instantIncrease
+		s.instantSeconds += other.instantSeconds
+	}
+}
+
+func (e *rate) SubMergers(subs []Expr) []SubMerge {
+	result := make([]SubMerge, 0, len(subs))
+	for _, sub := range subs {
+		var sm SubMerge
+		if e.String() == sub.String() {
+			sm = e.subMerge
+		} else if e.Wrapped.String() == sub.String() {
+			sm = e.rateSubMerger(sub)
+		}
+		result = append(result, sm)
+	}
+	return result
+}
+
+func (e *rate) subMerge(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+	e.Merge(data, data, other)
+}
+
+// rateSubMerger returns a SubMerge that calculates the increase between the
+// period at the start of other and the period before it.
+func (e *rate) rateSubMerger(sub Expr) SubMerge {
+	subWidth := sub.EncodedWidth()
+	return func(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+		if len(other) < subWidth*2 {
+			// No previous period
+			return
+		}
+		current, currentWasSet, _ := sub.Get(other)
+		previous, previousWasSet, _ := sub.Get(other[subWidth:])
+		if !currentWasSet || !previousWasSet {
+			return
+		}
+		increase := current - previous
+		if increase < 0 {
+			// Counter was reset
+			increase = current
+		}
+		seconds := otherRes.Seconds()
+		state, wasSet, _ := e.load(data)
+		next := rateState{
+			increase:         increase,
+			seconds:          seconds,
+			instantIncrease:  increase,
+			instantSeconds:   seconds,
+			instantRemaining: float64(len(other)),
+		}
+		if wasSet {
+			state.merge(next)
+		} else {
+			state = next
+		}
+		e.save(data, state)
+	}
+}
+
+func (e *rate) Get(b []byte) (float64, bool, []byte) {
+	state, wasSet, remain := e.load(b)
+	if !wasSet {
+		return 0, wasSet, remain
+	}
+	return e.calc(state), wasSet, remain
+}
+
+func (e *rate) calc(state rateState) float64 {
+	switch e.Kind {
+	case deltaKind:
+		return state.increase
+	case irateKind:
+		if state.instantSeconds == 0 {
+			return 0
+		}
+		return state.instantIncrease / state.instantSeconds
+	default:
+		if state.seconds == 0 {
+			return 0
+		}
+		return state.increase / state.seconds
+	}
+}
+
+func (e *rate) load(b []byte) (rateState, bool, []byte) {
+	remain := b[rateWidth:]
+	wasSet := b[0] == 1
+	var state rateState
+	if wasSet {
+		state.increase = math.Float64frombits(binaryEncoding.Uint64(b[1:]))
+		state.seconds = math.Float64frombits(binaryEncoding.Uint64(b[1+width64bits:]))
+		state.instantIncrease = math.Float64frombits(binaryEncoding.Uint64(b[1+width64bits*2:]))
+		state.instantSeconds = math.Float64frombits(binaryEncoding.Uint64(b[1+width64bits*3:]))
+		state.instantRemaining = math.Float64frombits(binaryEncoding.Uint64(b[1+width64bits*4:]))
+	}
+	return state, wasSet, remain
+}
+
+func (e *rate) save(b []byte, state rateState) []byte {
+	b[0] = 1
+	binaryEncoding.PutUint64(b[1:], math.Float64bits(state.increase))
+	binaryEncoding.PutUint64(b[1+width64bits:], math.Float64bits(state.seconds))
+	binaryEncoding.PutUint64(b[1+width64bits*2:], math.Float64bits(state.instantIncrease))
+	binaryEncoding.PutUint64(b[1+width64bits*3:], math.Float64bits(state.instantSeconds))
+	binaryEncoding.PutUint64(b[1+width64bits*4:], math.Float64bits(state.instantRemaining))
+	return b[rateWidth:]
+}
+
+func (e *rate) IsConstant() bool {
+	return e.Wrapped.IsConstant()
+}
+
+func (e *rate) DeAggregate() Expr {
+	return &rate{Wrapped: e.Wrapped.DeAggregate(), Kind: e.Kind}
+}
+
+func (e *rate) String() string {
+	return fmt.Sprintf("%v(%v)", e.Kind, e.Wrapped)
+}
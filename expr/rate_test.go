@@ -0,0 +1,77 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRate(t *testing.T) {
+	res := 1 * time.Hour
+	// newest first, counter was reset between the 4th and 3rd periods
+	counter := []float64{7300, 3700, 100, 7200, 3600, 0}
+	periods := len(counter)
+
+	fa := msgpacked(t, SUM(FIELD("a")))
+	a := make([]byte, fa.EncodedWidth()*periods)
+	for i, val := range counter {
+		fa.Update(a[i*fa.EncodedWidth():], Map{"a": val}, nil)
+	}
+
+	check := func(e Expr, scale int, expected []float64) {
+		e = msgpacked(t, e)
+		s := make([]byte, e.EncodedWidth()*len(expected))
+		subs := e.SubMergers([]Expr{fa})
+		if !assert.NotNil(t, subs[0], "%v should be able to sub merge from %v", e, fa) {
+			return
+		}
+		for po := 0; po < periods; po++ {
+			p := po / scale
+			subs[0](s[p*e.EncodedWidth():], a[po*fa.EncodedWidth():], res, nil)
+		}
+		for p, exp := range expected {
+			actual, wasSet, _ := e.Get(s[p*e.EncodedWidth():])
+			if exp < 0 {
+				assert.False(t, wasSet, "%v at position %d should not have been set", e, p)
+				continue
+			}
+			if assert.True(t, wasSet, "%v at position %d should have been set", e, p) {
+				AssertFloatWithin(t, 0.0001, exp, actual, e.String())
+			}
+		}
+	}
+
+	check(RATE(SUM("a")), 1, []float64{1, 1, 100.0 / 3600, 1, 1, -1})
+	check(IRATE(SUM("a")), 1, []float64{1, 1, 100.0 / 3600, 1, 1, -1})
+	check(DELTA(SUM("a")), 1, []float64{3600, 3600, 100, 3600, 3600, -1})
+
+	check(RATE(SUM("a")), 2, []float64{1, 3700.0 / 7200, 1})
+	check(IRATE(SUM("a")), 2, []float64{1, 100.0 / 3600, 1})
+	check(DELTA(SUM("a")), 2, []float64{7200, 3700, 3600})
+}
+
+func TestRateMerge(t *testing.T) {
+	e := msgpacked(t, DELTA(SUM("a")))
+	fa := SUM(FIELD("a"))
+	a := make([]byte, fa.EncodedWidth()*2)
+	fa.Update(a, Map{"a": 10}, nil)
+	fa.Update(a[fa.EncodedWidth():], Map{"a": 4}, nil)
+
+	sm := e.SubMergers([]Expr{fa})[0]
+	x := make([]byte, e.EncodedWidth())
+	y := make([]byte, e.EncodedWidth())
+	b := make([]byte, e.EncodedWidth())
+	sm(x, a, time.Minute, nil)
+	e.Merge(b, x, y)
+	val, _, _ := e.Get(b)
+	assert.EqualValues(t, 6, val)
+	sm(y, a, time.Minute, nil)
+	e.Merge(b, x, y)
+	val, _, _ = e.Get(b)
+	assert.EqualValues(t, 12, val)
+
+	_, val, updated := e.Update(b, Map{"a": 5}, nil)
+	assert.False(t, updated, "Rates shouldn't be updated from points")
+	assert.EqualValues(t, 12, val)
+}
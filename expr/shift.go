@@ -26,8 +26,8 @@ func (e *shift) EncodedWidth() int {
 	return e.Width
 }
 
-func (e *shift) Shift() time.Duration {
-	return e.Offset + e.Wrapped.Shift()
+func (e *shift) Shift(resolution time.Duration) time.Duration {
+	return e.Offset + e.Wrapped.Shift(resolution)
 }
 
 func (e *shift) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
@@ -31,7 +31,7 @@ func TestShiftSubMerge(t *testing.T) {
 
 	fa := msgpacked(t, SUM(FIELD("a")))
 	fs := msgpacked(t, SUB(SHIFT(SHIFT(SUM(FIELD("a")), -2*res), -1*res), SUM(FIELD("a"))))
-	assert.EqualValues(t, -3*res, fs.Shift())
+	assert.EqualValues(t, -3*res, fs.Shift(res))
 
 	a := make([]byte, fa.EncodedWidth()*periods)
 	s := make([]byte, fs.EncodedWidth()*periods)
@@ -0,0 +1,399 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/getlantern/goexpr"
+)
+
+const (
+	// topkKeyWidth is the number of bytes used to store each dimension value
+	// (including a 1 byte length prefix). Longer values are truncated.
+	topkKeyWidth = 64
+	// topkEntryWidth is the width of a key plus its count and error
+	topkEntryWidth = topkKeyWidth + 2*width64bits
+	// topkCapacityFactor determines how many more entries than K we track in
+	// order to improve accuracy
+	topkCapacityFactor = 3
+	// MaxTopK is the largest supported K for TOPK
+	MaxTopK = 100
+)
+
+// Unnester is implemented by expressions whose state holds multiple values,
+// each keyed by a dimension value (e.g. TOPK). When flattening query results,
+// a row containing an Unnester is turned into one row per key, with the key
+// added as a dimension named after the field.
+type Unnester interface {
+	Expr
+
+	// Unnest returns the keys and values stored in b, in descending order of
+	// value.
+	Unnest(b []byte) (keys []string, vals []float64)
+}
+
+// TOPK tracks the (approximately) K values of the given dimension that have the
+// largest total of the given value, using the Space-Saving algorithm. Like
+// HLL, dim may be the name of a dimension, a goexpr.Expr or a FIELD. As with
+// PERCENTILE, aggregates are stripped from value.
+//
+// The value of a TOPK expression is the total value of its top K entries. When
+// flattening query results, rows are unnested into one row per top value (see
+// Unnester).
+//
+// Dimension values are truncated to 63 bytes. Counts are upper bounds that
+// overestimate by at most the smallest tracked count.
+//
+// WARNING - TOPK is large (about 240 bytes per K per period), so it is best to
+// keep tables that use it relatively low cardinality.
+func TOPK(dim interface{}, value interface{}, k int) Expr {
+	var dimExpr goexpr.Expr
+	switch d := dim.(type) {
+	case goexpr.Expr:
+		dimExpr = d
+	case string:
+		dimExpr = goexpr.Param(d)
+	case *field:
+		dimExpr = goexpr.Param(d.Name)
+	default:
+		panic(fmt.Sprintf("Got a %v, please specify a dimension name or goexpr.Expr", reflect.TypeOf(dim)))
+	}
+	return &topk{
+		Dim:   dimExpr,
+		Value: exprFor(value).DeAggregate(),
+		K:     k,
+	}
+}
+
+type topk struct {
+	Dim   goexpr.Expr
+	Value Expr
+	K     int
+}
+
+type topkEntry struct {
+	key   string
+	count float64
+	err   float64
+}
+
+func (e *topk) Validate() error {
+	if e.Dim == nil {
+		return fmt.Errorf("TOPK requires a dimension")
+	}
+	if e.K < 1 || e.K > MaxTopK {
+		return fmt.Errorf("TOPK requires K between 1 and %d, not %d", MaxTopK, e.K)
+	}
+	return validateWrappedInAggregate(e.Value)
+}
+
+func (e *topk) capacity() int {
+	return e.K * topkCapacityFactor
+}
+
+func (e *topk) sketchWidth() int {
+	// wasSet flag, number of entries and entries
+	return 1 + 2 + e.capacity()*topkEntryWidth
+}
+
+func (e *topk) EncodedWidth() int {
+	return e.sketchWidth() + e.Value.EncodedWidth()
+}
+
+func (e *topk) Shift(resolution time.Duration) time.Duration {
+	return e.Value.Shift(resolution)
+}
+
+// Update updates the encoded table in place. Update keeps entries in
+// descending order of count, so the entry with the smallest count is always the
+// last one and the total of the top K is the total of the first K entries.
+func (e *topk) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	remain, value, updated := e.Value.Update(b[e.sketchWidth():], params, metadata)
+	// constant values (e.g. TOPK(dim, 1, 10)) count every point
+	updated = updated || e.Value.IsConstant()
+	if !updated || metadata == nil {
+		return remain, e.topTotal(b), false
+	}
+	dimValue := e.Dim.Eval(metadata)
+	if dimValue == nil {
+		return remain, e.topTotal(b), false
+	}
+	key := topkKey(dimValue)
+
+	numEntries := 0
+	if b[0] == 1 {
+		numEntries = int(binaryEncoding.Uint16(b[1:]))
+	}
+	i := topkFind(b, numEntries, key)
+	switch {
+	case i >= 0:
+		eb := topkEntryAt(b, i)
+		binaryEncoding.PutUint64(eb[topkKeyWidth:], math.Float64bits(topkCount(eb)+value))
+	case numEntries < e.capacity():
+		i = numEntries
+		numEntries++
+		b[0] = 1
+		binaryEncoding.PutUint16(b[1:], uint16(numEntries))
+		topkPut(topkEntryAt(b, i), topkEntry{key: key, count: value})
+	default:
+		// Replace the entry with the smallest count
+		i = numEntries - 1
+		eb := topkEntryAt(b, i)
+		min := topkCount(eb)
+		topkPut(eb, topkEntry{key: key, count: min + value, err: min})
+	}
+	topkReposition(b, numEntries, i)
+	return remain, e.topTotal(b), true
+}
+
+// topTotal calculates the total count of the top K entries of a table that's
+// in descending order of count.
+func (e *topk) topTotal(b []byte) float64 {
+	if b[0] != 1 {
+		return 0
+	}
+	numEntries := int(binaryEncoding.Uint16(b[1:]))
+	if numEntries > e.K {
+		numEntries = e.K
+	}
+	total := float64(0)
+	for i := 0; i < numEntries; i++ {
+		total += topkCount(topkEntryAt(b, i))
+	}
+	return total
+}
+
+func topkEntryAt(b []byte, i int) []byte {
+	return b[3+i*topkEntryWidth : 3+(i+1)*topkEntryWidth]
+}
+
+func topkCount(eb []byte) float64 {
+	return math.Float64frombits(binaryEncoding.Uint64(eb[topkKeyWidth:]))
+}
+
+// topkFind returns the index of the entry with the given key, or -1 if there is
+// none.
+func topkFind(b []byte, numEntries int, key string) int {
+	for i := 0; i < numEntries; i++ {
+		eb := topkEntryAt(b, i)
+		if int(eb[0]) == len(key) && string(eb[1:1+len(key)]) == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// topkReposition moves the entry at i so that entries remain in descending
+// order of count.
+func topkReposition(b []byte, numEntries int, i int) {
+	count := topkCount(topkEntryAt(b, i))
+	j := i
+	for j > 0 && topkCount(topkEntryAt(b, j-1)) < count {
+		j--
+	}
+	for j < numEntries-1 && topkCount(topkEntryAt(b, j+1)) > count {
+		j++
+	}
+	if j == i {
+		return
+	}
+	var entry [topkEntryWidth]byte
+	copy(entry[:], topkEntryAt(b, i))
+	if j < i {
+		copy(b[3+(j+1)*topkEntryWidth:3+(i+1)*topkEntryWidth], b[3+j*topkEntryWidth:3+i*topkEntryWidth])
+	} else {
+		copy(b[3+i*topkEntryWidth:3+j*topkEntryWidth], b[3+(i+1)*topkEntryWidth:3+(j+1)*topkEntryWidth])
+	}
+	copy(topkEntryAt(b, j), entry[:])
+}
+
+func topkKey(val interface{}) string {
+	var key string
+	switch v := val.(type) {
+	case string:
+		key = v
+	default:
+		key = fmt.Sprint(v)
+	}
+	if len(key) > topkKeyWidth-1 {
+		key = key[:topkKeyWidth-1]
+	}
+	return key
+}
+
+func (e *topk) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
+	entriesX, xWasSet := e.load(x)
+	entriesY, yWasSet := e.load(y)
+	width := e.EncodedWidth()
+	switch {
+	case xWasSet && yWasSet:
+		e.save(b, e.merge(entriesX, entriesY))
+	case xWasSet:
+		e.save(b, entriesX)
+	case yWasSet:
+		e.save(b, entriesY)
+	}
+	return b[width:], x[width:], y[width:]
+}
+
+// merge merges two Space-Saving summaries. Keys missing from a full summary
+// may have been evicted from it, so they're assumed to have that summary's
+// minimum count.
+func (e *topk) merge(x []topkEntry, y []topkEntry) []topkEntry {
+	minOf := func(entries []topkEntry) float64 {
+		if len(entries) < e.capacity() {
+			// summary isn't full, so nothing was evicted
+			return 0
+		}
+		min := math.MaxFloat64
+		for _, entry := range entries {
+			if entry.count < min {
+				min = entry.count
+			}
+		}
+		return min
+	}
+	minX, minY := minOf(x), minOf(y)
+
+	byKey := make(map[string]*topkEntry, len(x)+len(y))
+	for _, entry := range x {
+		byKey[entry.key] = &topkEntry{entry.key, entry.count + minY, entry.err + minY}
+	}
+	for _, entry := range y {
+		existing := byKey[entry.key]
+		if existing == nil {
+			byKey[entry.key] = &topkEntry{entry.key, entry.count + minX, entry.err + minX}
+		} else {
+			// remove minY that we assumed above
+			existing.count += entry.count - minY
+			existing.err += entry.err - minY
+		}
+	}
+
+	result := make([]topkEntry, 0, len(byKey))
+	for _, entry := range byKey {
+		result = append(result, *entry)
+	}
+	sortTopK(result)
+	if len(result) > e.capacity() {
+		result = result[:e.capacity()]
+	}
+	return result
+}
+
+func sortTopK(entries []topkEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count == entries[j].count {
+			return entries[i].key < entries[j].key
+		}
+		return entries[i].count > entries[j].count
+	})
+}
+
+func (e *topk) SubMergers(subs []Expr) []SubMerge {
+	result := make([]SubMerge, 0, len(subs))
+	for _, sub := range subs {
+		var sm SubMerge
+		if e.String() == sub.String() {
+			sm = e.subMerge
+		}
+		result = append(result, sm)
+	}
+	return result
+}
+
+func (e *topk) subMerge(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+	e.Merge(data, data, other)
+}
+
+func (e *topk) Get(b []byte) (float64, bool, []byte) {
+	entries, wasSet := e.load(b)
+	remain := b[e.EncodedWidth():]
+	if !wasSet {
+		return 0, false, remain
+	}
+	return e.calc(entries), true, remain
+}
+
+// calc calculates the total count of the top K entries.
+func (e *topk) calc(entries []topkEntry) float64 {
+	top := e.top(entries)
+	total := float64(0)
+	for _, entry := range top {
+		total += entry.count
+	}
+	return total
+}
+
+func (e *topk) top(entries []topkEntry) []topkEntry {
+	sorted := make([]topkEntry, len(entries))
+	copy(sorted, entries)
+	sortTopK(sorted)
+	if len(sorted) > e.K {
+		sorted = sorted[:e.K]
+	}
+	return sorted
+}
+
+func (e *topk) Unnest(b []byte) ([]string, []float64) {
+	entries, wasSet := e.load(b)
+	if !wasSet {
+		return nil, nil
+	}
+	top := e.top(entries)
+	keys := make([]string, 0, len(top))
+	vals := make([]float64, 0, len(top))
+	for _, entry := range top {
+		keys = append(keys, entry.key)
+		vals = append(vals, entry.count)
+	}
+	return keys, vals
+}
+
+func (e *topk) load(b []byte) ([]topkEntry, bool) {
+	if b[0] != 1 {
+		return nil, false
+	}
+	numEntries := int(binaryEncoding.Uint16(b[1:]))
+	entries := make([]topkEntry, 0, numEntries)
+	for i := 0; i < numEntries; i++ {
+		eb := topkEntryAt(b, i)
+		keyLen := int(eb[0])
+		entries = append(entries, topkEntry{
+			key:   string(eb[1 : 1+keyLen]),
+			count: topkCount(eb),
+			err:   math.Float64frombits(binaryEncoding.Uint64(eb[topkKeyWidth+width64bits:])),
+		})
+	}
+	return entries, true
+}
+
+func (e *topk) save(b []byte, entries []topkEntry) {
+	b[0] = 1
+	binaryEncoding.PutUint16(b[1:], uint16(len(entries)))
+	for i, entry := range entries {
+		topkPut(topkEntryAt(b, i), entry)
+	}
+}
+
+func topkPut(eb []byte, entry topkEntry) {
+	eb[0] = byte(len(entry.key))
+	copy(eb[1:topkKeyWidth], entry.key)
+	binaryEncoding.PutUint64(eb[topkKeyWidth:], math.Float64bits(entry.count))
+	binaryEncoding.PutUint64(eb[topkKeyWidth+width64bits:], math.Float64bits(entry.err))
+}
+
+func (e *topk) IsConstant() bool {
+	return false
+}
+
+func (e *topk) DeAggregate() Expr {
+	return e.Value.DeAggregate()
+}
+
+func (e *topk) String() string {
+	return fmt.Sprintf("TOPK(%v, %v, %d)", e.Dim, e.Value, e.K)
+}
@@ -0,0 +1,108 @@
+package expr
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/getlantern/goexpr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopK(t *testing.T) {
+	e := msgpacked(t, TOPK("host", SUM("bytes"), 2))
+	assert.Equal(t, "TOPK(host, bytes, 2)", e.String())
+	assert.NoError(t, e.Validate())
+	assert.Error(t, TOPK("host", "bytes", 0).Validate())
+
+	update := func(b []byte, host string, bytes float64) {
+		e.Update(b, Map{"bytes": bytes}, goexpr.MapParams{"host": host})
+	}
+
+	x := make([]byte, e.EncodedWidth())
+	_, wasSet, _ := e.Get(x)
+	assert.False(t, wasSet)
+	_, _, updated := e.Update(x, Map{"bytes": 1}, goexpr.MapParams{"other": "a"})
+	assert.False(t, updated, "Missing dimension should not update")
+
+	update(x, "a", 10)
+	update(x, "b", 30)
+	update(x, "c", 5)
+	update(x, "a", 15)
+	keys, vals := e.(Unnester).Unnest(x)
+	assert.Equal(t, []string{"b", "a"}, keys)
+	assert.Equal(t, []float64{30, 25}, vals)
+	val, _, _ := e.Get(x)
+	assert.EqualValues(t, 55, val)
+
+	// Fill up y with lots of light hitters and one heavy one
+	y := make([]byte, e.EncodedWidth())
+	for i := 0; i < 100; i++ {
+		update(y, fmt.Sprintf("light%d", i), 1)
+	}
+	update(y, "c", 100)
+
+	merged := make([]byte, e.EncodedWidth())
+	e.Merge(merged, x, y)
+	keys, vals = e.(Unnester).Unnest(merged)
+	assert.Equal(t, []string{"c", "b"}, keys)
+	if assert.Len(t, vals, 2) {
+		assert.True(t, vals[0] >= 105, "Counts should never be underestimated")
+		assert.True(t, vals[1] >= 30, "Counts should never be underestimated")
+	}
+
+	sms := e.SubMergers([]Expr{TOPK("host", "bytes", 2), TOPK("host", "bytes", 3)})
+	assert.NotNil(t, sms[0])
+	assert.Nil(t, sms[1])
+}
+
+func TestTopKUpdateInPlace(t *testing.T) {
+	e := TOPK("host", SUM("bytes"), 3).(*topk)
+	b := make([]byte, e.EncodedWidth())
+
+	// Reference implementation of Space-Saving
+	var expected []topkEntry
+	for i := 0; i < 1000; i++ {
+		host := fmt.Sprintf("host%d", rand.Intn(30))
+		bytes := rand.Float64() * float64(1+rand.Intn(10))
+		_, val, updated := e.Update(b, Map{"bytes": bytes}, goexpr.MapParams{"host": host})
+		if !assert.True(t, updated) {
+			return
+		}
+
+		found := false
+		for j := range expected {
+			if expected[j].key == host {
+				expected[j].count += bytes
+				found = true
+				break
+			}
+		}
+		if !found {
+			if len(expected) < e.capacity() {
+				expected = append(expected, topkEntry{key: host, count: bytes})
+			} else {
+				min := 0
+				for j := range expected {
+					if expected[j].count < expected[min].count {
+						min = j
+					}
+				}
+				expected[min] = topkEntry{key: host, count: expected[min].count + bytes, err: expected[min].count}
+			}
+		}
+
+		expectedVal, _, _ := e.Get(b)
+		if !assert.InDelta(t, e.calc(expected), val, 0.000001, "Wrong value after %d updates", i+1) || !assert.Equal(t, expectedVal, val) {
+			return
+		}
+	}
+
+	entries, _ := e.load(b)
+	assert.True(t, sort.SliceIsSorted(entries, func(i, j int) bool {
+		return entries[i].count > entries[j].count
+	}), "Entries should be in descending order of count")
+	sortTopK(expected)
+	assert.Equal(t, expected, entries)
+}
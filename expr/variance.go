@@ -0,0 +1,189 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/getlantern/goexpr"
+)
+
+const varianceWidth = width64bits*3 + 1
+
+// VARIANCE creates an Expr that obtains its value as the population variance
+// of the given value.
+func VARIANCE(val interface{}) Expr {
+	return &variance{Value: exprFor(val), Weight: CONST(1)}
+}
+
+// WVARIANCE creates an Expr that obtains its value as the weighted population
+// variance of the given value weighted by the given weight.
+func WVARIANCE(val interface{}, weight interface{}) Expr {
+	return &variance{Value: exprFor(val), Weight: exprFor(weight), Weighted: true}
+}
+
+// STDDEV creates an Expr that obtains its value as the population standard
+// deviation of the given value.
+func STDDEV(val interface{}) Expr {
+	return &variance{Value: exprFor(val), Weight: CONST(1), StdDev: true}
+}
+
+// WSTDDEV creates an Expr that obtains its value as the weighted population
+// standard deviation of the given value weighted by the given weight.
+func WSTDDEV(val interface{}, weight interface{}) Expr {
+	return &variance{Value: exprFor(val), Weight: exprFor(weight), Weighted: true, StdDev: true}
+}
+
+// variance tracks the total weight, mean and sum of squared differences from
+// the mean (M2) using Welford's algorithm, which is numerically stable and
+// merges exactly (see Chan et al).
+type variance struct {
+	Value    Expr
+	Weight   Expr
+	Weighted bool
+	StdDev   bool
+}
+
+func (e *variance) Validate() error {
+	err := validateWrappedInAggregate(e.Value)
+	if err != nil {
+		return err
+	}
+	if e.Weight.EncodedWidth() > 0 {
+		return fmt.Errorf("Weight expression %v must be a constant or directly derived from a field", e.Weight)
+	}
+	return nil
+}
+
+func (e *variance) EncodedWidth() int {
+	return varianceWidth + e.Value.EncodedWidth()
+}
+
+func (e *variance) Shift(resolution time.Duration) time.Duration {
+	a := e.Value.Shift(resolution)
+	b := e.Weight.Shift(resolution)
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (e *variance) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	count, mean, m2, _, remain := e.load(b)
+	remain, value, updated := e.Value.Update(remain, params, metadata)
+	remain, weight, _ := e.Weight.Update(remain, params, metadata)
+	if updated {
+		count, mean, m2 = combineVariance(count, mean, m2, weight, value, 0)
+		e.save(b, count, mean, m2)
+	}
+	return remain, e.calc(count, m2), updated
+}
+
+func (e *variance) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
+	countX, meanX, m2X, xWasSet, remainX := e.load(x)
+	countY, meanY, m2Y, yWasSet, remainY := e.load(y)
+	if !xWasSet {
+		if yWasSet {
+			// Use valueY
+			b = e.save(b, countY, meanY, m2Y)
+		} else {
+			// Nothing to save, just advance
+			b = b[varianceWidth:]
+		}
+	} else {
+		if yWasSet {
+			countX, meanX, m2X = combineVariance(countX, meanX, m2X, countY, meanY, m2Y)
+		}
+		b = e.save(b, countX, meanX, m2X)
+	}
+	return b, remainX, remainY
+}
+
+// combineVariance combines two sets of count, mean and M2. A single value is
+// just a set with an M2 of 0.
+func combineVariance(countA, meanA, m2A, countB, meanB, m2B float64) (float64, float64, float64) {
+	count := countA + countB
+	if count == 0 {
+		return countA, meanA, m2A
+	}
+	delta := meanB - meanA
+	mean := meanA + delta*countB/count
+	m2 := m2A + m2B + delta*delta*countA*countB/count
+	return count, mean, m2
+}
+
+func (e *variance) SubMergers(subs []Expr) []SubMerge {
+	result := make([]SubMerge, 0, len(subs))
+	for _, sub := range subs {
+		var sm SubMerge
+		if e.String() == sub.String() {
+			sm = e.subMerge
+		}
+		result = append(result, sm)
+	}
+	return result
+}
+
+func (e *variance) subMerge(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+	e.Merge(data, data, other)
+}
+
+func (e *variance) Get(b []byte) (float64, bool, []byte) {
+	count, _, m2, wasSet, remain := e.load(b)
+	if !wasSet {
+		return 0, wasSet, remain
+	}
+	return e.calc(count, m2), wasSet, remain
+}
+
+func (e *variance) calc(count float64, m2 float64) float64 {
+	if count == 0 || m2 <= 0 {
+		return 0
+	}
+	result := m2 / count
+	if e.StdDev {
+		result = math.Sqrt(result)
+	}
+	return result
+}
+
+func (e *variance) load(b []byte) (float64, float64, float64, bool, []byte) {
+	remain := b[varianceWidth:]
+	wasSet := b[0] == 1
+	count := float64(0)
+	mean := float64(0)
+	m2 := float64(0)
+	if wasSet {
+		count = math.Float64frombits(binaryEncoding.Uint64(b[1:]))
+		mean = math.Float64frombits(binaryEncoding.Uint64(b[width64bits+1:]))
+		m2 = math.Float64frombits(binaryEncoding.Uint64(b[width64bits*2+1:]))
+	}
+	return count, mean, m2, wasSet, remain
+}
+
+func (e *variance) save(b []byte, count float64, mean float64, m2 float64) []byte {
+	b[0] = 1
+	binaryEncoding.PutUint64(b[1:], math.Float64bits(count))
+	binaryEncoding.PutUint64(b[width64bits+1:], math.Float64bits(mean))
+	binaryEncoding.PutUint64(b[width64bits*2+1:], math.Float64bits(m2))
+	return b[varianceWidth:]
+}
+
+func (e *variance) IsConstant() bool {
+	return e.Value.IsConstant()
+}
+
+func (e *variance) DeAggregate() Expr {
+	return e.Value.DeAggregate()
+}
+
+func (e *variance) String() string {
+	name := "VARIANCE"
+	if e.StdDev {
+		name = "STDDEV"
+	}
+	if e.Weighted {
+		return fmt.Sprintf("W%v(%v, %v)", name, e.Value, e.Weight)
+	}
+	return fmt.Sprintf("%v(%v)", name, e.Value)
+}
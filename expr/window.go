@@ -0,0 +1,256 @@
+package expr
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/getlantern/goexpr"
+)
+
+const (
+	movingAvgKind = "MOVING_AVG"
+	movingSumKind = "MOVING_SUM"
+	ewmaKind      = "EWMA"
+
+	// flag, total, weight and position
+	windowWidth = 1 + width64bits*3
+
+	// ewmaMinWeight is the weight below which older periods are ignored by EWMA
+	ewmaMinWeight = 0.0001
+)
+
+// MOVING_AVG creates an Expr that obtains its value as the average of the
+// given value over a sliding window of the given size ending at each period.
+// Periods without a value are ignored.
+//
+// Like SHIFT, window functions read other periods of the underlying sequence,
+// so they may only be used in queries and should wrap a single stored field
+// (e.g. MOVING_AVG(load_avg, '1h')). The window is measured in periods of the
+// underlying data. When re-resolving to a coarser resolution, each period gets
+// the window ending at the most recent underlying period that it contains.
+// When a group contains several series, MOVING_SUM sums their windows whereas
+// MOVING_AVG and EWMA average them.
+func MOVING_AVG(wrapped interface{}, size time.Duration) Expr {
+	return &window{Wrapped: exprFor(wrapped), Kind: movingAvgKind, Window: size}
+}
+
+// MOVING_SUM is like MOVING_AVG but obtains its value as the sum over the
+// window.
+func MOVING_SUM(wrapped interface{}, size time.Duration) Expr {
+	return &window{Wrapped: exprFor(wrapped), Kind: movingSumKind, Window: size}
+}
+
+// EWMA creates an Expr that obtains its value as the exponentially weighted
+// moving average of the given value, where alpha (between 0 and 1) is the
+// weight given to the most recent period. Larger alphas discount older periods
+// faster. See MOVING_AVG for restrictions.
+func EWMA(wrapped interface{}, alpha float64) Expr {
+	return &window{Wrapped: exprFor(wrapped), Kind: ewmaKind, Alpha: alpha}
+}
+
+type window struct {
+	Wrapped Expr
+	Kind    string
+	Window  time.Duration
+	Alpha   float64
+}
+
+type windowState struct {
+	total     float64
+	weight    float64
+	remaining float64
+}
+
+func (e *window) Validate() error {
+	if e.Kind == ewmaKind {
+		if e.Alpha <= 0 || e.Alpha > 1 {
+			return fmt.Errorf("EWMA requires an alpha greater than 0 and at most 1, not %v", e.Alpha)
+		}
+	} else if e.Window <= 0 {
+		return fmt.Errorf("%v requires a positive window, not %v", e.Kind, e.Window)
+	}
+	return e.Wrapped.Validate()
+}
+
+func (e *window) EncodedWidth() int {
+	return windowWidth
+}
+
+func (e *window) Shift(resolution time.Duration) time.Duration {
+	// Look back far enough to fill the window for the oldest period
+	if e.Kind == ewmaKind {
+		return e.Wrapped.Shift(resolution) - time.Duration(e.maxPeriods())*resolution
+	}
+	return e.Wrapped.Shift(resolution) - e.Window
+}
+
+// maxPeriods returns the number of periods that EWMA looks at before the
+// weight of older periods drops below ewmaMinWeight.
+func (e *window) maxPeriods() int {
+	if e.Alpha >= 1 {
+		return 1
+	}
+	return int(math.Log(ewmaMinWeight)/math.Log(1-e.Alpha)) + 1
+}
+
+func (e *window) Update(b []byte, params Params, metadata goexpr.Params) ([]byte, float64, bool) {
+	// Windows can't be calculated from individual points
+	state, _, remain := e.load(b)
+	return remain, e.calc(state), false
+}
+
+func (e *window) Merge(b []byte, x []byte, y []byte) ([]byte, []byte, []byte) {
+	stateX, xWasSet, remainX := e.load(x)
+	stateY, yWasSet, remainY := e.load(y)
+	if !xWasSet {
+		if yWasSet {
+			// Use valueY
+			b = e.save(b, stateY)
+		} else {
+			// Nothing to save, just advance
+			b = b[windowWidth:]
+		}
+	} else {
+		if yWasSet {
+			stateX.merge(stateY)
+		}
+		b = e.save(b, stateX)
+	}
+	return b, remainX, remainY
+}
+
+// merge keeps whichever state has the window ending at the most recent period,
+// combining states whose windows end at the same position (see rateState).
+func (s *windowState) merge(other windowState) {
+	switch {
+	case other.remaining > s.remaining:
+		*s = other
+	case other.remaining == s.remaining:
+		s.total += other.total
+		s.weight += other.weight
+	}
+}
+
+func (e *window) SubMergers(subs []Expr) []SubMerge {
+	result := make([]SubMerge, 0, len(subs))
+	for _, sub := range subs {
+		var sm SubMerge
+		if e.String() == sub.String() {
+			sm = e.subMerge
+		} else if e.Wrapped.String() == sub.String() {
+			sm = e.windowSubMerger(sub)
+		}
+		result = append(result, sm)
+	}
+	return result
+}
+
+func (e *window) subMerge(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+	e.Merge(data, data, other)
+}
+
+// windowSubMerger returns a SubMerge that calculates the window ending at the
+// period at the start of other.
+func (e *window) windowSubMerger(sub Expr) SubMerge {
+	subWidth := sub.EncodedWidth()
+	return func(data []byte, other []byte, otherRes time.Duration, metadata goexpr.Params) {
+		numPeriods := len(other) / subWidth
+		periodWeight := func(i int) float64 {
+			return 1
+		}
+		if e.Kind == ewmaKind {
+			maxPeriods := e.maxPeriods()
+			if maxPeriods < numPeriods {
+				numPeriods = maxPeriods
+			}
+			periodWeight = func(i int) float64 {
+				return e.Alpha * math.Pow(1-e.Alpha, float64(i))
+			}
+		} else {
+			windowPeriods := int(e.Window / otherRes)
+			if windowPeriods < 1 {
+				windowPeriods = 1
+			}
+			if windowPeriods < numPeriods {
+				numPeriods = windowPeriods
+			}
+		}
+
+		next := windowState{remaining: float64(len(other))}
+		found := false
+		for i := 0; i < numPeriods; i++ {
+			val, wasSet, _ := sub.Get(other[i*subWidth:])
+			if wasSet {
+				w := periodWeight(i)
+				next.total += val * w
+				next.weight += w
+				found = true
+			}
+		}
+		if !found {
+			return
+		}
+
+		state, wasSet, _ := e.load(data)
+		if wasSet {
+			state.merge(next)
+		} else {
+			state = next
+		}
+		e.save(data, state)
+	}
+}
+
+func (e *window) Get(b []byte) (float64, bool, []byte) {
+	state, wasSet, remain := e.load(b)
+	if !wasSet {
+		return 0, wasSet, remain
+	}
+	return e.calc(state), wasSet, remain
+}
+
+func (e *window) calc(state windowState) float64 {
+	if e.Kind == movingSumKind {
+		return state.total
+	}
+	if state.weight == 0 {
+		return 0
+	}
+	return state.total / state.weight
+}
+
+func (e *window) load(b []byte) (windowState, bool, []byte) {
+	remain := b[windowWidth:]
+	wasSet := b[0] == 1
+	var state windowState
+	if wasSet {
+		state.total = math.Float64frombits(binaryEncoding.Uint64(b[1:]))
+		state.weight = math.Float64frombits(binaryEncoding.Uint64(b[1+width64bits:]))
+		state.remaining = math.Float64frombits(binaryEncoding.Uint64(b[1+width64bits*2:]))
+	}
+	return state, wasSet, remain
+}
+
+func (e *window) save(b []byte, state windowState) []byte {
+	b[0] = 1
+	binaryEncoding.PutUint64(b[1:], math.Float64bits(state.total))
+	binaryEncoding.PutUint64(b[1+width64bits:], math.Float64bits(state.weight))
+	binaryEncoding.PutUint64(b[1+width64bits*2:], math.Float64bits(state.remaining))
+	return b[windowWidth:]
+}
+
+func (e *window) IsConstant() bool {
+	return e.Wrapped.IsConstant()
+}
+
+func (e *window) DeAggregate() Expr {
+	return &window{Wrapped: e.Wrapped.DeAggregate(), Kind: e.Kind, Window: e.Window, Alpha: e.Alpha}
+}
+
+func (e *window) String() string {
+	if e.Kind == ewmaKind {
+		return fmt.Sprintf("EWMA(%v, %v)", e.Wrapped, e.Alpha)
+	}
+	return fmt.Sprintf("%v(%v, %v)", e.Kind, e.Wrapped, e.Window)
+}
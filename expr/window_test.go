@@ -0,0 +1,68 @@
+package expr
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWindow(t *testing.T) {
+	res := 1 * time.Hour
+	// newest first, the 4th period has no value
+	vals := []float64{6, 5, 4, -1, 2, 1}
+	periods := len(vals)
+
+	fa := msgpacked(t, SUM(FIELD("a")))
+	a := make([]byte, fa.EncodedWidth()*periods)
+	for i, val := range vals {
+		if val >= 0 {
+			fa.Update(a[i*fa.EncodedWidth():], Map{"a": val}, nil)
+		}
+	}
+
+	check := func(e Expr, scale int, expected []float64) {
+		e = msgpacked(t, e)
+		s := make([]byte, e.EncodedWidth()*len(expected))
+		subs := e.SubMergers([]Expr{fa})
+		if !assert.NotNil(t, subs[0], "%v should be able to sub merge from %v", e, fa) {
+			return
+		}
+		for po := 0; po < periods; po++ {
+			p := po / scale
+			subs[0](s[p*e.EncodedWidth():], a[po*fa.EncodedWidth():], res, nil)
+		}
+		for p, exp := range expected {
+			actual, wasSet, _ := e.Get(s[p*e.EncodedWidth():])
+			if exp < 0 {
+				assert.False(t, wasSet, "%v at position %d should not have been set", e, p)
+				continue
+			}
+			if assert.True(t, wasSet, "%v at position %d should have been set", e, p) {
+				AssertFloatWithin(t, 0.0001, exp, actual, e.String())
+			}
+		}
+	}
+
+	check(MOVING_SUM(SUM("a"), 2*res), 1, []float64{11, 9, 4, 2, 3, 1})
+	check(MOVING_AVG(SUM("a"), 2*res), 1, []float64{5.5, 4.5, 4, 2, 1.5, 1})
+	check(MOVING_AVG(SUM("a"), 3*res), 2, []float64{5, 3, 1.5})
+	check(EWMA(SUM("a"), 1), 1, []float64{6, 5, 4, -1, 2, 1})
+	// weights are 0.5, 0.25, 0.125 ...
+	check(EWMA(SUM("a"), 0.5), 1, []float64{
+		(6*0.5 + 5*0.25 + 4*0.125 + 2*0.03125 + 1*0.015625) / (0.5 + 0.25 + 0.125 + 0.03125 + 0.015625),
+		(5*0.5 + 4*0.25 + 2*0.0625 + 1*0.03125) / (0.5 + 0.25 + 0.0625 + 0.03125),
+		(4*0.5 + 2*0.125 + 1*0.0625) / (0.5 + 0.125 + 0.0625),
+		(2*0.25 + 1*0.125) / (0.25 + 0.125),
+		(2*0.5 + 1*0.25) / (0.5 + 0.25),
+		1,
+	})
+
+	assert.Error(t, EWMA(SUM("a"), 0).Validate())
+	assert.Error(t, MOVING_AVG(SUM("a"), 0).Validate())
+	assert.EqualValues(t, -2*res, MOVING_SUM(SUM("a"), 2*res).Shift(res))
+	// EWMA looks back as many periods as it gives meaningful weight to
+	assert.EqualValues(t, -14*res, EWMA(SUM("a"), 0.5).Shift(res))
+	assert.EqualValues(t, -14*time.Minute, EWMA(SUM("a"), 0.5).Shift(time.Minute))
+	assert.EqualValues(t, -1*res, EWMA(SUM("a"), 1).Shift(res))
+}
@@ -54,4 +54,5 @@ require (
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/net v0.0.0-20210610132358-84b48f89b13b
 	google.golang.org/grpc v1.22.1
+	google.golang.org/protobuf v1.26.0
 )
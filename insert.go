@@ -5,6 +5,7 @@ import (
 	"hash"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/dustin/go-humanize"
@@ -18,10 +19,22 @@ func (db *DB) Insert(stream string, ts time.Time, dims map[string]interface{}, v
 	return db.InsertRaw(stream, ts, bytemap.New(dims), bytemap.New(vals))
 }
 
+// HasStream indicates whether points can be inserted into the given stream,
+// i.e. whether any table reads from it.
+func (db *DB) HasStream(stream string) bool {
+	stream = strings.TrimSpace(strings.ToLower(stream))
+	db.tablesMutex.Lock()
+	defer db.tablesMutex.Unlock()
+	return db.streams[stream] != nil
+}
+
 func (db *DB) InsertRaw(stream string, ts time.Time, dims bytemap.ByteMap, vals bytemap.ByteMap) error {
 	if db.opts.Follow != nil {
 		return errors.New("Declining to insert data directly to follower")
 	}
+	if !db.opts.RecoverTo.IsZero() {
+		return errors.New("Declining to insert data while recovering to %v", db.opts.RecoverTo.In(time.UTC))
+	}
 
 	stream = strings.TrimSpace(strings.ToLower(stream))
 	db.tablesMutex.Lock()
@@ -66,16 +79,39 @@ type walRead struct {
 
 func (t *table) processWALInserts() {
 	in := make(chan *walRead)
-	t.db.Go(func(stop <-chan interface{}) {
+	t.Go(func(stop <-chan interface{}) {
 		t.processInserts(in, stop)
 	})
 
 	for {
+		// Read blocks once we've caught up with the WAL, so remember since when
+		// we've been waiting for data (see DB.WaitForWALReplay)
+		atomic.StoreInt64(&t.walIdleSince, time.Now().UnixNano())
 		data, err := t.wal.Read()
+		atomic.StoreInt64(&t.walIdleSince, 0)
 		if err != nil {
+			if t.isDropped() || t.db.isClosing() {
+				// Reader was closed because the table was dropped, or the WAL went
+				// away after the database was closed
+				return
+			}
 			t.db.Panic(fmt.Errorf("Unable to read from WAL: %v", err))
 		}
-		in <- &walRead{data, t.wal.Offset(), 0}
+		offset := t.wal.Offset()
+		if recoverTo := t.db.opts.RecoverTo; !recoverTo.IsZero() && offset.TS().After(recoverTo) {
+			// Stop replaying at the point in time that we're recovering to, without
+			// advancing our offsets past it
+			t.log.Debugf("Reached WAL offset %v after %v, stopping replay", offset, recoverTo.In(time.UTC))
+			t.db.walBuffers.Put(data)
+			atomic.StoreInt64(&t.walIdleSince, time.Now().UnixNano())
+			<-t.dropped
+			return
+		}
+		select {
+		case in <- &walRead{data, offset, 0}:
+		case <-t.dropped:
+			return
+		}
 	}
 }
 
@@ -0,0 +1,229 @@
+// Package lineprotocol parses InfluxDB line protocol into points suitable for
+// inserting into zenodb. The measurement becomes the stream, tags become dims
+// and fields become vals. See
+// https://docs.influxdata.com/influxdb/v1.8/write_protocols/line_protocol_reference/.
+package lineprotocol
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getlantern/bytemap"
+)
+
+// Point is a single point parsed from line protocol.
+type Point struct {
+	Stream string
+	Ts     time.Time
+	Dims   bytemap.ByteMap
+	Vals   bytemap.ByteMap
+}
+
+// Inserter is something that can have Points inserted into it (e.g. a
+// zenodb.DB).
+type Inserter interface {
+	InsertRaw(stream string, ts time.Time, dims bytemap.ByteMap, vals bytemap.ByteMap) error
+}
+
+// ParsePrecision parses the precision parameter used by InfluxDB ("n", "ns",
+// "u", "us", "ms", "s", "m" or "h"). An empty string means nanoseconds.
+func ParsePrecision(precision string) (time.Duration, error) {
+	switch precision {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us", "µ":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("Unknown precision %v", precision)
+	}
+}
+
+// Parse parses all lines in data, returning the successfully parsed points
+// along with errors for any lines that failed to parse. Timestamps are
+// interpreted using the given precision and points without a timestamp get
+// the time now.
+func Parse(data []byte, precision time.Duration, now time.Time) ([]*Point, []error) {
+	var points []*Point
+	var errs []error
+	for i, line := range bytes.Split(data, []byte("\n")) {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		point, err := ParseLine(line, precision, now)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Line %d: %v", i+1, err))
+			continue
+		}
+		points = append(points, point)
+	}
+	return points, errs
+}
+
+// ParseLine parses a single line of line protocol.
+func ParseLine(line []byte, precision time.Duration, now time.Time) (*Point, error) {
+	sections := splitUnescaped(string(line), ' ', true)
+	if len(sections) < 2 {
+		return nil, fmt.Errorf("Missing fields")
+	}
+	if len(sections) > 3 {
+		return nil, fmt.Errorf("Too many sections")
+	}
+
+	key := splitUnescaped(sections[0], ',', false)
+	stream := unescape(key[0])
+	if stream == "" {
+		return nil, fmt.Errorf("Missing measurement")
+	}
+	dims := make(map[string]interface{}, len(key)-1)
+	for _, tag := range key[1:] {
+		name, value, err := splitKeyValue(tag)
+		if err != nil {
+			return nil, err
+		}
+		dims[name] = unescape(value)
+	}
+	if len(dims) == 0 {
+		return nil, fmt.Errorf("Need at least one tag")
+	}
+
+	vals := make(map[string]float64)
+	for _, field := range splitUnescaped(sections[1], ',', true) {
+		name, value, err := splitKeyValue(field)
+		if err != nil {
+			return nil, err
+		}
+		val, numeric, err := parseFieldValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid value for field %v: %v", name, err)
+		}
+		if numeric {
+			vals[name] = val
+		}
+	}
+	if len(vals) == 0 {
+		return nil, fmt.Errorf("Need at least one numeric field")
+	}
+
+	ts := now
+	if len(sections) == 3 {
+		tsInt, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid timestamp %v: %v", sections[2], err)
+		}
+		if tsInt > math.MaxInt64/int64(precision) || tsInt < math.MinInt64/int64(precision) {
+			return nil, fmt.Errorf("Timestamp %v is out of range for precision %v", sections[2], precision)
+		}
+		ts = time.Unix(0, tsInt*int64(precision))
+	}
+
+	return &Point{
+		Stream: stream,
+		Ts:     ts,
+		Dims:   bytemap.New(dims),
+		Vals:   bytemap.NewFloat(vals),
+	}, nil
+}
+
+// Insert inserts the given points into the Inserter, returning the number of
+// points that were successfully inserted and the first error encountered.
+func Insert(inserter Inserter, points []*Point) (int, error) {
+	inserted := 0
+	var firstErr error
+	for _, point := range points {
+		err := inserter.InsertRaw(point.Stream, point.Ts, point.Dims, point.Vals)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		inserted++
+	}
+	return inserted, firstErr
+}
+
+// parseFieldValue parses a field value. String values are not supported by
+// zenodb and are ignored (numeric will be false). Booleans are treated as 1 or
+// 0.
+func parseFieldValue(value string) (val float64, numeric bool, err error) {
+	if len(value) == 0 {
+		return 0, false, fmt.Errorf("Missing value")
+	}
+	if value[0] == '"' {
+		if len(value) < 2 || value[len(value)-1] != '"' {
+			return 0, false, fmt.Errorf("Unterminated string %v", value)
+		}
+		return 0, false, nil
+	}
+	switch value {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+	last := value[len(value)-1]
+	if last == 'i' || last == 'u' {
+		value = value[:len(value)-1]
+	}
+	val, err = strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	return val, true, nil
+}
+
+func splitKeyValue(s string) (string, string, error) {
+	parts := splitUnescaped(s, '=', false)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("Invalid key/value pair %v", s)
+	}
+	key := unescape(parts[0])
+	if key == "" {
+		return "", "", fmt.Errorf("Missing key in %v", s)
+	}
+	return key, parts[1], nil
+}
+
+// splitUnescaped splits s on sep, ignoring separators that are escaped with a
+// backslash and, if quotes is true, separators inside of double quotes.
+// Escape sequences are retained in the result.
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var result []string
+	start := 0
+	escaped := false
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quotes && c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			result = append(result, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(result, s[start:])
+}
+
+var unescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\\`, `\`)
+
+func unescape(s string) string {
+	return unescaper.Replace(s)
+}
@@ -0,0 +1,66 @@
+package lineprotocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	now := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	data := []byte(`# comment
+cpu,host=server\ 1,region=us-west load=0.64,cores=4i,up=t,desc="a b,c" 1577836800
+
+bad line
+mem,host=server1 used="text"
+disk,path=/a\,b free=10u
+`)
+	points, errs := Parse(data, time.Second, now)
+	assert.Len(t, errs, 2)
+	if !assert.Len(t, points, 2) {
+		return
+	}
+
+	cpu := points[0]
+	assert.Equal(t, "cpu", cpu.Stream)
+	assert.Equal(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), cpu.Ts.Unix(), "Timestamp should be parsed from the line, not defaulted to now")
+	assert.Equal(t, map[string]interface{}{"host": "server 1", "region": "us-west"}, cpu.Dims.AsMap())
+	assert.Equal(t, map[string]interface{}{"load": 0.64, "cores": float64(4), "up": float64(1)}, cpu.Vals.AsMap())
+
+	disk := points[1]
+	assert.Equal(t, "disk", disk.Stream)
+	assert.Equal(t, now, disk.Ts)
+	assert.Equal(t, map[string]interface{}{"path": "/a,b"}, disk.Dims.AsMap())
+	assert.Equal(t, map[string]interface{}{"free": float64(10)}, disk.Vals.AsMap())
+}
+
+func TestParsePrecision(t *testing.T) {
+	for precision, expected := range map[string]time.Duration{
+		"":   time.Nanosecond,
+		"ns": time.Nanosecond,
+		"u":  time.Microsecond,
+		"ms": time.Millisecond,
+		"s":  time.Second,
+		"h":  time.Hour,
+	} {
+		actual, err := ParsePrecision(precision)
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, actual)
+		}
+	}
+	_, err := ParsePrecision("d")
+	assert.Error(t, err)
+}
+
+func TestParseTimestampOverflow(t *testing.T) {
+	now := time.Now()
+	_, err := ParseLine([]byte("cpu,host=a load=1 9223372036854775807"), time.Second, now)
+	assert.Error(t, err, "Timestamp that overflows at the given precision should be rejected")
+	_, err = ParseLine([]byte("cpu,host=a load=1 -9223372036854775807"), time.Millisecond, now)
+	assert.Error(t, err, "Negative timestamp that overflows at the given precision should be rejected")
+	point, err := ParseLine([]byte("cpu,host=a load=1 9223372036854775807"), time.Nanosecond, now)
+	if assert.NoError(t, err) {
+		assert.EqualValues(t, int64(9223372036854775807), point.Ts.UnixNano())
+	}
+}
@@ -8,7 +8,6 @@ import (
 
 	"github.com/golang/snappy"
 
-	"github.com/getlantern/bytemap"
 	"github.com/getlantern/errors"
 	"github.com/getlantern/goexpr"
 	"github.com/getlantern/golog"
@@ -16,7 +15,6 @@ import (
 
 	"github.com/getlantern/zenodb/common"
 	"github.com/getlantern/zenodb/core"
-	"github.com/getlantern/zenodb/encoding"
 	"github.com/getlantern/zenodb/sql"
 )
 
@@ -40,17 +38,17 @@ func (db *DB) FilterAndMerge(table string, whereClause string, shouldSort bool,
 
 // FileInfo returns information about the given data file
 func FileInfo(inFile string) (offsetsBySource common.OffsetsBySource, fieldsString string, fields core.Fields, err error) {
-	fs := &fileStore{
+	df := &dataFile{
 		filename: inFile,
 	}
-	file, err := os.OpenFile(fs.filename, os.O_RDONLY, 0)
+	file, err := os.OpenFile(df.filename, os.O_RDONLY, 0)
 	if err != nil {
-		err = errors.New("Unable to open filestore at %v: %v", fs.filename, err)
+		err = errors.New("Unable to open filestore at %v: %v", df.filename, err)
 		return
 	}
 	defer file.Close()
 	r := snappy.NewReader(file)
-	return fs.info(r)
+	return df.info(r)
 }
 
 // Check checks all of the given inFiles for readability and returns errors
@@ -58,20 +56,20 @@ func FileInfo(inFile string) (offsetsBySource common.OffsetsBySource, fieldsStri
 func Check(inFiles ...string) map[string]error {
 	errors := make(map[string]error)
 	for _, inFile := range inFiles {
-		fs := &fileStore{
+		df := &dataFile{
 			filename: inFile,
 			t: &table{
 				log: golog.LoggerFor("check"),
 			},
 		}
-		file, err := os.OpenFile(fs.filename, os.O_RDONLY, 0)
+		file, err := os.OpenFile(df.filename, os.O_RDONLY, 0)
 		if err != nil {
-			errors[inFile] = fmt.Errorf("Unable to open filestore at %v: %v", fs.filename, err)
+			errors[inFile] = fmt.Errorf("Unable to open filestore at %v: %v", df.filename, err)
 			continue
 		}
 		defer file.Close()
 		r := snappy.NewReader(file)
-		_, _, _, err = fs.info(r)
+		_, _, _, err = df.info(r)
 		if err != nil {
 			errors[inFile] = err
 			continue
@@ -91,18 +89,26 @@ func (db *DB) CheckTable(table string, filename string) error {
 	if t == nil {
 		return errors.New("Table %v not found", table)
 	}
-	fs := &fileStore{
+	df := &dataFile{
 		t:        t,
 		fields:   t.fields,
 		filename: filename,
 	}
+	rr, err := df.open()
+	if err != nil {
+		return err
+	}
+	defer rr.Close()
 	numRows := 0
-	_, err := fs.iterate(t.fields, nil, true, false, func(key bytemap.ByteMap, columns []encoding.Sequence, raw []byte) (bool, error) {
+	for {
+		_, _, err := rr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.New("Encountered error after reading %d rows: %v", numRows, err)
+		}
 		numRows++
-		return true, nil
-	})
-	if err != nil {
-		return errors.New("Encountered error after reading %d rows: %v", numRows, err)
 	}
 	fmt.Printf("Read %d rows\n", numRows)
 	return nil
@@ -13,6 +13,7 @@ var (
 	leaderStats    *LeaderStats
 	followerStats  map[common.FollowerID]*FollowerStats
 	partitionStats map[int]*PartitionStats
+	walStats       map[string]*WALStats
 
 	mx sync.RWMutex
 )
@@ -25,6 +26,7 @@ func reset() {
 	leaderStats = &LeaderStats{}
 	followerStats = make(map[common.FollowerID]*FollowerStats, 0)
 	partitionStats = make(map[int]*PartitionStats, 0)
+	walStats = make(map[string]*WALStats, 0)
 }
 
 // Stats are the overall stats
@@ -32,6 +34,7 @@ type Stats struct {
 	Leader     *LeaderStats
 	Followers  sortedFollowerStats
 	Partitions sortedPartitionStats
+	WAL        sortedWALStats
 }
 
 // LeaderStats provides stats for the cluster leader
@@ -54,6 +57,19 @@ type PartitionStats struct {
 	NumFollowers int
 }
 
+// WALStats provides stats about how much of the WAL for a given stream is being
+// retained and why
+type WALStats struct {
+	Stream string
+	// PinnedBy identifies what is requiring the oldest retained WAL data (e.g. a
+	// table or follower)
+	PinnedBy string
+	// PinnedSince is the timestamp of the oldest WAL data that must be retained
+	PinnedSince string
+	// PinnedFor is how far back from the current time WAL data is retained
+	PinnedFor string
+}
+
 type sortedFollowerStats []*FollowerStats
 
 func (s sortedFollowerStats) Len() int      { return len(s) }
@@ -70,6 +86,14 @@ func (s sortedPartitionStats) Less(i, j int) bool {
 	return s[i].Partition < s[j].Partition
 }
 
+type sortedWALStats []*WALStats
+
+func (s sortedWALStats) Len() int      { return len(s) }
+func (s sortedWALStats) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s sortedWALStats) Less(i, j int) bool {
+	return s[i].Stream < s[j].Stream
+}
+
 // SetNumPartitions sets the number of partitions in the cluster
 func SetNumPartitions(numPartitions int) {
 	mx.Lock()
@@ -122,6 +146,20 @@ func QueuedForFollower(followerID common.FollowerID, queued int) {
 	}
 }
 
+// WALPinned records that the WAL for the given stream has to be retained back
+// to the given offset because pinnedBy still needs it.
+func WALPinned(stream string, pinnedBy string, offset wal.Offset, now time.Time) {
+	ts := offset.TS()
+	mx.Lock()
+	walStats[stream] = &WALStats{
+		Stream:      stream,
+		PinnedBy:    pinnedBy,
+		PinnedSince: ts.Format(time.RFC3339),
+		PinnedFor:   now.Sub(ts).Round(time.Second).String(),
+	}
+	mx.Unlock()
+}
+
 func getFollowerStats(followerID common.FollowerID) *FollowerStats {
 	fs, found := followerStats[followerID]
 	if !found {
@@ -140,6 +178,7 @@ func GetStats() *Stats {
 		Leader:     leaderStats,
 		Followers:  make(sortedFollowerStats, 0, len(followerStats)),
 		Partitions: make(sortedPartitionStats, 0, len(partitionStats)),
+		WAL:        make(sortedWALStats, 0, len(walStats)),
 	}
 
 	for _, fs := range followerStats {
@@ -148,10 +187,14 @@ func GetStats() *Stats {
 	for _, ps := range partitionStats {
 		s.Partitions = append(s.Partitions, ps)
 	}
+	for _, ws := range walStats {
+		s.WAL = append(s.WAL, ws)
+	}
 	mx.RUnlock()
 
 	sort.Sort(s.Followers)
 	sort.Sort(s.Partitions)
+	sort.Sort(s.WAL)
 	s.Leader.ConnectedPartitions = len(partitionStats)
 	s.Leader.ConnectedFollowers = len(followerStats)
 	return s
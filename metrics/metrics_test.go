@@ -75,4 +75,16 @@ func TestMetrics(t *testing.T) {
 	s = GetStats()
 	assert.Equal(t, 2, s.Leader.ConnectedFollowers)
 	assert.Equal(t, 1, s.Leader.ConnectedPartitions)
+
+	WALPinned("b", "follower 1", wal.NewOffsetForTS(ts), ts.Add(1*time.Hour))
+	WALPinned("a", "table x", wal.NewOffsetForTS(ts), ts.Add(2*time.Hour))
+	s = GetStats()
+	if assert.Len(t, s.WAL, 2) {
+		assert.Equal(t, "a", s.WAL[0].Stream)
+		assert.Equal(t, "table x", s.WAL[0].PinnedBy)
+		assert.Equal(t, ts.Format(time.RFC3339), s.WAL[0].PinnedSince)
+		assert.Equal(t, "2h0m0s", s.WAL[0].PinnedFor)
+		assert.Equal(t, "b", s.WAL[1].Stream)
+		assert.Equal(t, "follower 1", s.WAL[1].PinnedBy)
+	}
 }
@@ -15,6 +15,9 @@ func addHaving(flat core.FlatRowSource, query *sql.Query) core.FlatRowSource {
 		if include == 1 {
 			// Removing having field
 			row.Values = row.Values[:havingIdx]
+			if row.Set != nil {
+				row.Set = row.Set[:havingIdx]
+			}
 			return row, nil
 		}
 		return nil, nil
@@ -16,6 +16,7 @@ func planLocal(query *sql.Query, opts *Opts) (core.FlatRowSource, error) {
 	fixupSubQuery(query, opts)
 
 	var source core.RowSource
+	var lookback time.Duration
 	var err error
 	if query.FromSubQuery != nil {
 		source, err = sourceForSubQuery(query, opts)
@@ -23,7 +24,7 @@ func planLocal(query *sql.Query, opts *Opts) (core.FlatRowSource, error) {
 			return nil, err
 		}
 	} else {
-		source, err = sourceForTable(query, opts)
+		source, lookback, err = sourceForTable(query, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -41,6 +42,12 @@ func planLocal(query *sql.Query, opts *Opts) (core.FlatRowSource, error) {
 		return nil, err
 	}
 
+	if ranged, ok := source.(RangedTable); ok {
+		// Leave room for one extra period, which functions like RATE need in
+		// order to calculate the first period.
+		ranged.ReadRange(asOf.Add(-1*(lookback+resolution)), until)
+	}
+
 	if query.Where != nil {
 		source, err = applySubQueryFilters(query, opts, source)
 		if err != nil {
@@ -72,10 +79,17 @@ func sourceForSubQuery(query *sql.Query, opts *Opts) (core.RowSource, error) {
 	return core.Unflatten(subSource, query.FieldsNoHaving), nil
 }
 
-func sourceForTable(query *sql.Query, opts *Opts) (core.RowSource, error) {
-	return opts.GetTable(query.From, func(tableFields core.Fields) (core.Fields, error) {
+// sourceForTable returns the source for the table queried by the given query
+// along with the lookback, i.e. how far before the query's asOf the query needs
+// to read data.
+func sourceForTable(query *sql.Query, opts *Opts) (core.RowSource, time.Duration, error) {
+	var queryFields core.Fields
+	source, err := opts.GetTable(query.From, func(tableFields core.Fields) (core.Fields, error) {
 		if query.HasSelectAll {
 			// For SELECT *, include all table fields
+			if fields, err := query.Fields.Get(tableFields); err == nil {
+				queryFields = fields
+			}
 			return tableFields, nil
 		}
 
@@ -87,6 +101,7 @@ func sourceForTable(query *sql.Query, opts *Opts) (core.RowSource, error) {
 		if err != nil {
 			return nil, err
 		}
+		queryFields = fields
 		for _, field := range fields {
 			sms := field.Expr.SubMergers(tableExprs)
 			for i, sm := range sms {
@@ -105,6 +120,24 @@ func sourceForTable(query *sql.Query, opts *Opts) (core.RowSource, error) {
 
 		return result, nil
 	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return source, lookbackFor(queryFields, source.GetResolution()), nil
+}
+
+// lookbackFor determines how far back from the query's asOf we need to read
+// data at the given resolution in order to calculate the given fields, based on
+// how far back they're shifted (e.g. by SHIFT or windowed functions like
+// MOVING_AVG).
+func lookbackFor(fields core.Fields, resolution time.Duration) time.Duration {
+	lookback := time.Duration(0)
+	for _, field := range fields {
+		if shift := -1 * field.Expr.Shift(resolution); shift > lookback {
+			lookback = shift
+		}
+	}
+	return lookback
 }
 
 func asOfUntilFor(query *sql.Query, opts *Opts, source core.RowSource, now time.Time) (time.Time, bool, time.Time, bool) {
@@ -18,6 +18,15 @@ type Table interface {
 	GetPartitionBy() []string
 }
 
+// RangedTable is a Table that can avoid reading data outside of a time range.
+type RangedTable interface {
+	Table
+
+	// ReadRange tells the table that the query only needs data between asOf
+	// and until.
+	ReadRange(asOf time.Time, until time.Time)
+}
+
 type Opts struct {
 	GetTable        func(table string, includedFields func(tableFields core.Fields) (core.Fields, error)) (Table, error)
 	Now             func(table string) time.Time
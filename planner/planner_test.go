@@ -544,6 +544,15 @@ func verifyNoHaving(t *testing.T, fields Fields, plan Source, sqlString string)
 	}
 }
 
+func TestLookbackFor(t *testing.T) {
+	assert.EqualValues(t, 0, lookbackFor(defaultFields, resolution))
+	assert.EqualValues(t, 3*time.Minute, lookbackFor(Fields{fieldA, NewField("s", SHIFT(eB, -3*time.Minute))}, resolution))
+	assert.EqualValues(t, time.Hour, lookbackFor(Fields{NewField("m", MOVING_AVG(eA, time.Hour))}, resolution))
+	// EWMA looks back a number of periods that depends on alpha
+	assert.EqualValues(t, 14*resolution, lookbackFor(Fields{NewField("e", EWMA(eA, 0.5))}, resolution))
+	assert.EqualValues(t, 14*time.Hour, lookbackFor(Fields{NewField("e", EWMA(eA, 0.5))}, time.Hour))
+}
+
 func TestPlanExecution(t *testing.T) {
 	sqlString := `
 SELECT AVG(a)+AVG(b) AS avg_total
@@ -588,6 +597,73 @@ LIMIT 1
 	verify(plan)
 }
 
+func TestSubQueryNulls(t *testing.T) {
+	sqlString := `
+SELECT AVG(nothing) AS avg_nothing, AVG(a) AS avg_a
+FROM (SELECT _points, a, b, a / 0 AS nothing FROM tablea GROUP BY x, period(10s))
+GROUP BY x, period(10s)
+`
+
+	verify := func(plan FlatRowSource) {
+		var rows []*FlatRow
+		plan.Iterate(context.Background(), FieldsIgnored, func(row *FlatRow) (bool, error) {
+			rows = append(rows, row)
+			return true, nil
+		})
+
+		if assert.Len(t, rows, 1, "Rows without any a should have been omitted rather than treated as 0") {
+			row := rows[0]
+			assert.Equal(t, 1, row.Key.Get("x"))
+			assert.Nil(t, row.Get("avg_nothing"), "Average of NULLs should be NULL")
+			assert.EqualValues(t, 220, row.Get("avg_a"))
+		}
+	}
+
+	opts := defaultOpts()
+	plan, err := Plan(sqlString, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	verify(plan)
+
+	opts.QueryCluster = queryCluster
+	plan, err = Plan(sqlString, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+	verify(plan)
+}
+
+func TestSubQueryUnnestedTopK(t *testing.T) {
+	sqlString := `
+SELECT AVG(a) AS avg_a
+FROM (SELECT _points, a, top FROM tablea GROUP BY x, period(10s))
+GROUP BY x, period(10s)
+`
+
+	opts := defaultOpts()
+	opts.GetTable = func(table string, includedFields func(tableFields Fields) (Fields, error)) (Table, error) {
+		included, err := includedFields(topkFields)
+		if err != nil {
+			return nil, err
+		}
+		return &topkTable{testTable{table, included}}, nil
+	}
+	plan, err := Plan(sqlString, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var rows []*FlatRow
+	_, err = plan.Iterate(context.Background(), FieldsIgnored, func(row *FlatRow) (bool, error) {
+		rows = append(rows, row)
+		return true, nil
+	})
+	if assert.NoError(t, err) && assert.Len(t, rows, 1) {
+		assert.EqualValues(t, 70, rows[0].Get("avg_a"), "Only the first unnested row should contribute to the average")
+	}
+}
+
 func defaultOpts() *Opts {
 	return &Opts{
 		GetTable: func(table string, includedFields func(tableFields Fields) (Fields, error)) (Table, error) {
@@ -749,3 +825,28 @@ func (tes textExprSource) Get(known Fields) (Expr, error) {
 func (tes textExprSource) String() string {
 	return string(tes)
 }
+
+var (
+	eTop       = TOPK("y", "a", 2)
+	topkFields = Fields{PointsField, fieldA, NewField("top", eTop)}
+)
+
+// type topkTable emulates a table that tracks the top y values by a
+type topkTable struct {
+	testTable
+}
+
+func (t *topkTable) Iterate(ctx context.Context, onFields OnFields, onRow OnRow) (interface{}, error) {
+	onFields(t.fields)
+
+	for i, a := range []float64{10, 20, 40} {
+		y := i*2 + 3
+		key := bytemap.New(map[string]interface{}{"x": 1, "y": y})
+		onRow(key, []encoding.Sequence{
+			encoding.NewFloatValue(PointsField.Expr, epoch, 1),
+			encoding.NewFloatValue(eA, epoch, a),
+			encoding.NewValue(eTop, epoch, Map{"a": a}, goexpr.MapParams{"y": y}),
+		})
+	}
+	return nil, nil
+}
@@ -20,6 +20,10 @@ var (
 )
 
 func (db *DB) Query(sqlString string, isSubQuery bool, subQueryResults [][]interface{}, includeMemStore bool) (core.FlatRowSource, error) {
+	if sql.IsShowQueries(sqlString) {
+		return &showQueries{db, db.clock.Now()}, nil
+	}
+
 	q, err := sql.Parse(sqlString)
 	if err != nil {
 		return nil, err
@@ -32,13 +36,16 @@ func (db *DB) Query(sqlString string, isSubQuery bool, subQueryResults [][]inter
 
 	opts := &planner.Opts{
 		GetTable: func(table string, outFields func(tableFields core.Fields) (core.Fields, error)) (planner.Table, error) {
+			if st := db.getSystemTable(table); st != nil {
+				return st, nil
+			}
 			return db.getQueryable(table, outFields, includeMemStore)
 		},
 		Now:             db.now,
 		IsSubQuery:      isSubQuery,
 		SubQueryResults: subQueryResults,
 	}
-	if db.opts.Passthrough {
+	if db.opts.Passthrough && !isCatalogQuery(q) {
 		opts.QueryCluster = func(ctx context.Context, sqlString string, isSubQuery bool, subQueryResults [][]interface{}, unflat bool, onFields core.OnFields, onRow core.OnRow, onFlatRow core.OnFlatRow) (interface{}, error) {
 			return db.queryCluster(ctx, sqlString, isSubQuery, subQueryResults, includeMemStore, unflat, onFields, onRow, onFlatRow)
 		}
@@ -60,7 +67,7 @@ func (db *DB) getQueryable(table string, outFields func(tableFields core.Fields)
 		return nil, fmt.Errorf("Table %v is virtual and cannot be queried", table)
 	}
 	until := encoding.RoundTimeUp(db.clock.Now(), t.Resolution)
-	asOf := encoding.RoundTimeUp(until.Add(-1*t.RetentionPeriod), t.Resolution)
+	asOf := encoding.RoundTimeUp(until.Add(-1*t.getRetentionPeriod()), t.Resolution)
 	fields := t.getFields()
 	out, err := outFields(fields)
 	if err != nil {
@@ -69,7 +76,7 @@ func (db *DB) getQueryable(table string, outFields func(tableFields core.Fields)
 	if out == nil {
 		out = t.getFields()
 	}
-	return &queryable{db, t, out, asOf, until, includeMemStore}, nil
+	return &queryable{db: db, t: t, fields: out, asOf: asOf, until: until, includeMemStore: includeMemStore}, nil
 }
 
 func MetaDataFor(source core.FlatRowSource, fields core.Fields) *common.QueryMetaData {
@@ -88,6 +95,8 @@ type queryable struct {
 	fields          core.Fields
 	asOf            time.Time
 	until           time.Time
+	readAsOf        time.Time
+	readUntil       time.Time
 	includeMemStore bool
 }
 
@@ -107,6 +116,13 @@ func (q *queryable) GetUntil() time.Time {
 	return q.until
 }
 
+// ReadRange implements the interface planner.RangedTable, allowing us to skip
+// data on disk that's outside of the range needed by the query.
+func (q *queryable) ReadRange(asOf time.Time, until time.Time) {
+	q.readAsOf = asOf
+	q.readUntil = until
+}
+
 func (q *queryable) GetPartitionBy() []string {
 	return q.t.PartitionBy
 }
@@ -126,10 +142,14 @@ func (q *queryable) Iterate(ctx context.Context, onFields core.OnFields, onRow c
 		return nil, errors.New("No fields found!")
 	}
 
+	rq := runningQueryFor(ctx)
 	i := 1
 	// When iterating, as an optimization, we read only the needed fields (not
 	// all table fields).
-	highWaterMarks, err := q.t.iterate(ctx, q.fields, q.includeMemStore, func(key bytemap.ByteMap, vals []encoding.Sequence) (bool, error) {
+	highWaterMarks, err := q.t.iterate(ctx, q.fields, q.includeMemStore, q.readAsOf, q.readUntil, func(key bytemap.ByteMap, vals []encoding.Sequence) (bool, error) {
+		if rq != nil {
+			rq.scanned(key, vals)
+		}
 		if i%1000 == 0 {
 			// every 1000 rows, check and cap memory size
 			if !q.db.capMemorySize(false) {
@@ -0,0 +1,84 @@
+package zenodb
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/errors"
+)
+
+// restore initializes the database directory from the snapshot at
+// db.opts.RestoreFrom, unless the directory already exists. Table data is hard
+// linked where possible since segments are immutable, but WAL files are copied
+// because the restored database appends to them.
+func (db *DB) restore() error {
+	snapshotDir := db.opts.RestoreFrom
+	_, err := os.Stat(db.opts.Dir)
+	if err == nil {
+		db.log.Debugf("%v already exists, not restoring from snapshot at %v", db.opts.Dir, snapshotDir)
+		return nil
+	}
+	if !os.IsNotExist(err) {
+		return errors.New("Unable to check db dir at %v: %v", db.opts.Dir, err)
+	}
+	_, err = os.Stat(filepath.Join(snapshotDir, SnapshotInfoFilename))
+	if err != nil {
+		return errors.New("%v does not appear to be a snapshot: %v", snapshotDir, err)
+	}
+
+	db.log.Debugf("Restoring %v from snapshot at %v", db.opts.Dir, snapshotDir)
+	walDir := filepath.Join(snapshotDir, "_wal") + string(filepath.Separator)
+	err = filepath.Walk(snapshotDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(snapshotDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(db.opts.Dir, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if rel == SnapshotInfoFilename {
+			return nil
+		}
+		if strings.HasPrefix(path, walDir) {
+			return copyFile(path, target)
+		}
+		return linkOrCopy(path, target)
+	})
+	if err != nil {
+		os.RemoveAll(db.opts.Dir)
+		return errors.New("Unable to restore from snapshot at %v: %v", snapshotDir, err)
+	}
+	return nil
+}
+
+// WaitForWALReplay waits until every table that reads from the WAL has caught
+// up with it, meaning that its reader has been waiting for new data for at
+// least the given idle time. This is useful for finishing point-in-time
+// recovery (see DBOpts.RecoverTo) before flushing and closing the database.
+func (db *DB) WaitForWALReplay(idle time.Duration) {
+	for {
+		caughtUp := true
+		now := time.Now().UnixNano()
+		for _, t := range db.catalogTables() {
+			if t.wal == nil {
+				continue
+			}
+			idleSince := atomic.LoadInt64(&t.walIdleSince)
+			if idleSince == 0 || time.Duration(now-idleSince) < idle {
+				caughtUp = false
+				break
+			}
+		}
+		if caughtUp {
+			return
+		}
+		time.Sleep(idle / 10)
+	}
+}
@@ -0,0 +1,108 @@
+package zenodb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/zenodb/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "zenodbrestoretest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dataDir := filepath.Join(tmpDir, "data")
+	schema := Schema{
+		"table_a": &TableOpts{Name: "table_a", RetentionPeriod: time.Hour, SQL: "SELECT SUM(x) AS x FROM inbound GROUP BY a, period(1m)"},
+	}
+	open := func() *DB {
+		db, err := NewDB(&DBOpts{
+			Dir:         dataDir,
+			VirtualTime: true,
+		})
+		if !assert.NoError(t, err) || !assert.NoError(t, db.ApplySchema(schema)) {
+			return nil
+		}
+		return db
+	}
+
+	db := open()
+	if db == nil {
+		return
+	}
+
+	now := time.Now()
+	insert := func(a string, ts time.Time) bool {
+		return assert.NoError(t, db.Insert("inbound", ts, map[string]interface{}{"a": a}, map[string]interface{}{"x": 1}))
+	}
+
+	// a is flushed to the table before the snapshot, b and c are only in the WAL
+	if !insert("a", now) {
+		return
+	}
+	time.Sleep(500 * time.Millisecond)
+	db.FlushAll()
+	if !insert("b", now.Add(1*time.Minute)) {
+		return
+	}
+	time.Sleep(500 * time.Millisecond)
+	db.Close()
+
+	// Reopening starts a new WAL segment that's positioned after recoverTo
+	recoverTo := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	db = open()
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	// c is timestamped before b but written to the WAL after recoverTo
+	if !insert("c", now.Add(-1*time.Minute)) {
+		return
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	snapshotDir := filepath.Join(tmpDir, "snapshot")
+	_, err = db.Snapshot(snapshotDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	restoredDir := filepath.Join(tmpDir, "restored")
+	restored, err := NewDB(&DBOpts{
+		Dir:         restoredDir,
+		RestoreFrom: snapshotDir,
+		RecoverTo:   recoverTo,
+		VirtualTime: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer restored.Close()
+	_, err = os.Stat(filepath.Join(restoredDir, SnapshotInfoFilename))
+	assert.True(t, os.IsNotExist(err), "Snapshot info should not be restored")
+	assert.Error(t, restored.Insert("inbound", now, map[string]interface{}{"a": "d"}, map[string]interface{}{"x": 1}), "Inserts should be declined while recovering")
+
+	restored.WaitForWALReplay(500 * time.Millisecond)
+	restoredTable := restored.getTable("table_a")
+	if !assert.NotNil(t, restoredTable, "Schema should have been restored from snapshot") {
+		return
+	}
+	var keys []string
+	_, err = restoredTable.rowStore.iterate(context.Background(), restoredTable.getFields(), true, time.Time{}, time.Time{}, func(key bytemap.ByteMap, columns []encoding.Sequence) (bool, error) {
+		keys = append(keys, key.Get("a").(string))
+		return true, nil
+	})
+	if assert.NoError(t, err) {
+		assert.ElementsMatch(t, []string{"a", "b"}, keys, "Data after RecoverTo should not have been replayed")
+	}
+}
@@ -0,0 +1,101 @@
+package zenodb
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/getlantern/errors"
+)
+
+const (
+	retentionSweepInterval = 1 * time.Minute
+)
+
+// sweepRetention periodically drops segments whose data lies entirely before
+// the table's retention period. Segments that are only partially expired are
+// left alone, their expired data is skipped when reading and dropped when the
+// segment is compacted.
+func (rs *rowStore) sweepRetention(stop <-chan interface{}) {
+	ticker := time.NewTicker(retentionSweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			rs.t.log.Debug("Stop sweeping expired segments")
+			return
+		case <-ticker.C:
+			err := rs.dropExpiredSegments()
+			if err != nil {
+				rs.t.log.Errorf("Unable to drop expired segments: %v", err)
+			}
+		}
+	}
+}
+
+// dropExpiredSegments removes all expired segments from the manifest. The
+// files themselves are deleted by removeOldFiles once nothing is reading them
+// anymore.
+func (rs *rowStore) dropExpiredSegments() error {
+	rs.mx.RLock()
+	fs := rs.fileStore
+	rs.mx.RUnlock()
+
+	truncateBefore := rs.t.truncateBefore()
+	expired := make(map[string]bool)
+	freed := int64(0)
+	for _, seg := range fs.segments {
+		if seg.expired(truncateBefore) {
+			expired[seg.Name] = true
+			freed += seg.Size
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	err := rs.commit(nil, expired, nil, nil, nil)
+	if err != nil {
+		return errors.New("Unable to commit removal of %d expired segments: %v", len(expired), err)
+	}
+	rs.t.log.Debugf("Dropped %d segments that expired before %v, freeing %v", len(expired), truncateBefore.In(time.UTC), humanize.Bytes(uint64(freed)))
+
+	rs.t.statsMutex.Lock()
+	rs.t.stats.ExpiredSegments += int64(len(expired))
+	rs.t.stats.ExpiredBytes += freed
+	rs.t.statsMutex.Unlock()
+	return nil
+}
+
+// expired indicates whether all of the segment's data is from before
+// truncateBefore.
+func (seg *segment) expired(truncateBefore time.Time) bool {
+	return !seg.End.After(truncateBefore)
+}
+
+// getRetentionPeriod returns the table's current retention period, which can
+// change when the table is altered.
+func (t *table) getRetentionPeriod() time.Duration {
+	return time.Duration(atomic.LoadInt64(&t.retentionPeriod))
+}
+
+// applyRetentionPeriod changes the table's retention period. Shortening it
+// takes effect on the next retention sweep, lengthening it only affects data
+// that hasn't expired yet.
+func (t *table) applyRetentionPeriod(retentionPeriod time.Duration) error {
+	if t.Virtual {
+		return nil
+	}
+	if retentionPeriod <= 0 {
+		return errors.New("Please specify a positive RetentionPeriod")
+	}
+	if retentionPeriod < t.Resolution {
+		return errors.New("Please specify a RetentionPeriod greater than the resolution")
+	}
+	old := time.Duration(atomic.SwapInt64(&t.retentionPeriod, int64(retentionPeriod)))
+	if old != retentionPeriod {
+		t.log.Debugf("Updated retention period from %v to %v", old, retentionPeriod)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package zenodb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/getlantern/golog"
+	"github.com/getlantern/vtime"
+	"github.com/getlantern/zenodb/sql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropExpiredSegments(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "retention")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Date(2017, time.January, 2, 0, 0, 0, 0, time.UTC)
+	db := &DB{
+		log:   golog.LoggerFor("retention_test"),
+		clock: vtime.NewVirtualClock(now),
+	}
+	tbl := &table{
+		TableOpts:       &TableOpts{Name: "mytable"},
+		Query:           sql.Query{Resolution: time.Minute},
+		db:              db,
+		log:             db.log,
+		retentionPeriod: int64(12 * time.Hour),
+	}
+	segmentAt := func(name string, hoursAgo int) *segment {
+		end := now.Add(-1 * time.Duration(hoursAgo) * time.Hour)
+		return &segment{Name: name, Start: end.Add(-6 * time.Hour), End: end, Size: 100}
+	}
+	rs := &rowStore{t: tbl, opts: &rowStoreOptions{dir: tmpDir}}
+	rs.fileStore = &fileStore{t: tbl, rs: rs, segments: []*segment{
+		segmentAt("a", 18),
+		segmentAt("b", 12),
+		segmentAt("c", 6),
+		segmentAt("d", 0),
+	}}
+	tbl.rowStore = rs
+
+	segmentNames := func() []string {
+		var names []string
+		for _, seg := range rs.fileStore.segments {
+			names = append(names, seg.Name)
+		}
+		return names
+	}
+
+	if !assert.NoError(t, rs.dropExpiredSegments()) {
+		return
+	}
+	assert.Equal(t, []string{"c", "d"}, segmentNames(), "Partially expired segment should be kept")
+	assert.EqualValues(t, 2, tbl.stats.ExpiredSegments)
+	assert.EqualValues(t, 200, tbl.stats.ExpiredBytes)
+	m, err := readManifest(tmpDir)
+	if assert.NoError(t, err) && assert.NotNil(t, m) {
+		assert.Len(t, m.Segments, 2, "Manifest should have been updated")
+	}
+
+	assert.Error(t, tbl.applyRetentionPeriod(0))
+	assert.Error(t, tbl.applyRetentionPeriod(time.Second), "Retention period shorter than resolution should be rejected")
+	if !assert.NoError(t, tbl.applyRetentionPeriod(3*time.Hour)) {
+		return
+	}
+	assert.Equal(t, 3*time.Hour, tbl.getRetentionPeriod())
+	if !assert.NoError(t, rs.dropExpiredSegments()) {
+		return
+	}
+	assert.Equal(t, []string{"d"}, segmentNames(), "Shortening retention period should expire more segments")
+	assert.EqualValues(t, 3, tbl.stats.ExpiredSegments)
+	assert.EqualValues(t, 300, tbl.stats.ExpiredBytes)
+}
@@ -2,10 +2,9 @@ package zenodb
 
 import (
 	"bytes"
+	"container/heap"
 	"context"
-	"crypto/sha256"
 	"encoding/binary"
-	"encoding/hex"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,17 +16,16 @@ import (
 	"time"
 
 	"github.com/golang/snappy"
-	"github.com/oxtoacart/emsort"
 
 	"github.com/dustin/go-humanize"
 	"github.com/getlantern/bytemap"
 	"github.com/getlantern/errors"
-	"github.com/getlantern/goexpr"
 	"github.com/getlantern/wal"
 	"github.com/getlantern/zenodb/bytetree"
 	"github.com/getlantern/zenodb/common"
 	"github.com/getlantern/zenodb/core"
 	"github.com/getlantern/zenodb/encoding"
+	"github.com/getlantern/zenodb/tiered"
 )
 
 const (
@@ -50,6 +48,8 @@ type rowStoreOptions struct {
 	dir             string
 	minFlushLatency time.Duration
 	maxFlushLatency time.Duration
+	segmentDuration time.Duration
+	coldStore       tiered.Store
 }
 
 type insert struct {
@@ -70,8 +70,14 @@ type rowStore struct {
 	inserts              chan *insert
 	forceFlushes         chan bool
 	forceFlushCompletes  chan bool
-	flushCount           int
 	iterationsInProgress map[string]int
+	obsoleteColdSegments []*segment
+	segmentSeq           int64
+	manifestMx           sync.Mutex
+	durableOffsets       common.OffsetsBySource
+	storedStats          *StoredStats
+	statsInsertedPoints  int64
+	statsInsertedSince   time.Time
 	mx                   sync.RWMutex
 }
 
@@ -100,63 +106,13 @@ func (t *table) openRowStore(opts *rowStoreOptions) (*rowStore, common.OffsetsBy
 	if err != nil && !os.IsExist(err) {
 		return nil, nil, errors.New("Unable to create folder for row store: %v", err)
 	}
-
-	existingFileName := ""
-	files, err := listRegularFiles(opts.dir)
-	if err != nil {
-		return nil, nil, errors.New("Unable to read contents of directory: %v", err)
+	if opts.segmentDuration <= 0 {
+		opts.segmentDuration = DefaultSegmentDuration
 	}
-	offsetsBySource := make(common.OffsetsBySource)
-	if len(files) > 0 {
-		for _, file := range files {
-			t.log.Debug(file.Name())
-		}
-		// files are sorted by name, in our case timestamp, so the last file in the
-		// list is the most recent. That's the one that we want.
-		for i := len(files) - 1; i >= 0; i-- {
-			filename := files[i].Name()
-			existingFileName = filepath.Join(opts.dir, files[i].Name())
-			if filename == offsetFilename {
-				// This is an offset file, just read the offset
-				o, err := ioutil.ReadFile(existingFileName)
-				if err != nil {
-					t.log.Errorf("Unable to read offset: %v", err)
-				} else if len(o) < wal.OffsetSize {
-					t.log.Errorf("Offset file contents of wrong length: %v %d", existingFileName, len(o))
-				} else {
-					fileVersion := FileVersion_4
-					if len(o) > wal.OffsetSize {
-						// Before Version 5, we only stored a single offset. Since we have more than that,
-						// assume that this is at least Version 5.
-						fileVersion = FileVersion_5
-					}
-					offsetsBySource, _ = t.readOffsets(fileVersion, o)
-					t.log.Debugf("Read highWaterMarks from offset file: %v", offsetsBySource.TSString())
-				}
-				// clear existingFileName so we don't use it for filestore
-				existingFileName = ""
-				continue
-			}
 
-			// Get WAL offset
-			newOffsetsBySource, opened, err := t.readWALOffsets(existingFileName)
-			if err != nil {
-				if !opened {
-					return nil, nil, err
-				} else {
-					t.log.Errorf("Unable to read offset from existing file %v, assuming corrupted and will remove: %v", existingFileName, err)
-					rmErr := os.Remove(existingFileName)
-					if rmErr != nil {
-						return nil, nil, errors.New("Unable to remove corrupted file %v: %v", existingFileName, err)
-					}
-					continue
-				}
-			}
-
-			offsetsBySource = newOffsetsBySource.Advance(offsetsBySource)
-			t.log.Debugf("Initializing row store from %v", existingFileName)
-			break
-		}
+	storedStats, err := readStoredStats(opts.dir)
+	if err != nil {
+		t.log.Errorf("Unable to read stored stats, will recompute on next flush: %v", err)
 	}
 
 	fields := t.getFields()
@@ -169,18 +125,58 @@ func (t *table) openRowStore(opts *rowStoreOptions) (*rowStore, common.OffsetsBy
 		forceFlushes:         make(chan bool),
 		forceFlushCompletes:  make(chan bool),
 		iterationsInProgress: make(map[string]int),
+		segmentSeq:           time.Now().UnixNano(),
+		storedStats:          storedStats,
 		fileStore: &fileStore{
-			t:        t,
-			fields:   fields,
-			filename: existingFileName,
+			t:      t,
+			fields: fields,
 		},
 	}
 	rs.fileStore.rs = rs
 
-	t.db.Go(func(stop <-chan interface{}) {
+	m, err := readManifest(opts.dir)
+	if err != nil {
+		return nil, nil, errors.New("Unable to read manifest: %v", err)
+	}
+	if m != nil {
+		t.log.Debugf("Initializing row store from %d segments", len(m.Segments))
+		rs.fileStore.segments = m.Segments
+		rs.fileStore.offsetsBySource = m.OffsetsBySource
+		rs.obsoleteColdSegments = m.ObsoleteColdSegments
+		if opts.coldStore == nil {
+			for _, seg := range m.Segments {
+				if seg.Cold {
+					t.log.Errorf("Table has segments in cold storage but no ColdStore is configured, queries that need them will fail")
+					break
+				}
+			}
+		}
+	} else {
+		err = rs.migrateLegacyFiles()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	offsetsBySource := make(common.OffsetsBySource)
+	for source, offset := range rs.fileStore.offsetsBySource {
+		offsetsBySource[source] = offset
+	}
+	t.log.Debugf("Read highWaterMarks from manifest: %v", offsetsBySource.TSString())
+	rs.recordDurableOffsets(offsetsBySource)
+
+	t.Go(func(stop <-chan interface{}) {
 		rs.processInserts(offsetsBySource, stop)
 	})
-	t.db.Go(rs.removeOldFiles)
+	t.Go(rs.removeOldFiles)
+	t.Go(rs.compactSegments)
+	t.Go(rs.sweepRetention)
+	if opts.coldStore != nil {
+		// ColdAfter may be set by altering the table later
+		t.Go(rs.offloadColdSegments)
+	} else if coldAfter := t.getColdAfter(); coldAfter > 0 {
+		t.log.Errorf("ColdAfter is set to %v but no ColdStore is configured, not offloading segments", coldAfter)
+	}
 
 	return rs, offsetsBySource, nil
 }
@@ -226,12 +222,20 @@ func (rs *rowStore) memStoreSize() int {
 }
 
 func (rs *rowStore) insert(insert *insert) {
-	rs.inserts <- insert
+	select {
+	case rs.inserts <- insert:
+	case <-rs.t.dropped:
+		// table was dropped, discard insert
+	}
 }
 
 func (rs *rowStore) forceFlush() {
-	rs.forceFlushes <- true
-	<-rs.forceFlushCompletes
+	select {
+	case rs.forceFlushes <- true:
+		<-rs.forceFlushCompletes
+	case <-rs.t.dropped:
+		// table was dropped, nothing to flush
+	}
 }
 
 func (rs *rowStore) newMemStore(offsetsBySource common.OffsetsBySource) *memstore {
@@ -250,15 +254,17 @@ func (rs *rowStore) processInserts(offsetsBySource common.OffsetsBySource, stop
 	flushTimer := time.NewTimer(flushInterval)
 	rs.t.log.Debugf("Will flush after %v", flushInterval)
 
-	flush := func(allowSort bool) *memstore {
+	flush := func() *memstore {
 		if ms.tree.Length() == 0 {
 			rs.t.log.Trace("No data to flush")
 
 			if ms.offsetChanged {
 				rs.t.log.Debug("No new data, but we've advanced through the WAL, record the change")
-				err := rs.writeOffsets(ms.offsetsBySource)
+				err := rs.commit(nil, nil, ms.offsetsBySource, nil, nil)
 				if err != nil {
 					rs.t.log.Errorf("Unable to write updated offset: %v", err)
+				} else {
+					rs.recordDurableOffsets(ms.offsetsBySource)
 				}
 				ms.offsetChanged = false
 			}
@@ -270,7 +276,7 @@ func (rs *rowStore) processInserts(offsetsBySource common.OffsetsBySource, stop
 		if rs.t.log.IsTraceEnabled() {
 			rs.t.log.Tracef("Requesting flush at memstore size: %v", humanize.Bytes(uint64(ms.tree.Bytes())))
 		}
-		newMS, flushDuration := rs.processFlush(ms, allowSort)
+		newMS, flushDuration := rs.processFlush(ms)
 		ms = newMS
 		flushInterval = flushDuration * 10
 		if flushInterval > rs.opts.maxFlushLatency {
@@ -295,14 +301,18 @@ func (rs *rowStore) processInserts(offsetsBySource common.OffsetsBySource, stop
 			rs.mx.Unlock()
 		case <-flushTimer.C:
 			rs.t.log.Trace("Requesting flush due to flush interval")
-			flush(false)
+			flush()
 		case <-rs.forceFlushes:
 			rs.t.log.Debug("Forcing flush")
-			flush(true)
+			flush()
 			rs.forceFlushCompletes <- true
 		case <-stop:
+			if rs.t.isDropped() {
+				rs.t.log.Debug("Table dropped, discarding unflushed data")
+				return
+			}
 			rs.t.log.Debug("Forcing flush due to database stopped")
-			flush(true)
+			flush()
 			rs.t.log.Debug("Done forcing flush due to database stopped")
 			return
 		case fields := <-rs.fieldUpdates:
@@ -312,7 +322,7 @@ func (rs *rowStore) processInserts(offsetsBySource common.OffsetsBySource, stop
 
 			// force flush before processing any more inserts
 			offsetsBySource = ms.offsetsBySource
-			ms = flush(false)
+			ms = flush()
 
 			if ms == nil {
 				// nothing flushed, create a new memstore to pick up new fields
@@ -325,193 +335,130 @@ func (rs *rowStore) processInserts(offsetsBySource common.OffsetsBySource, stop
 	}
 }
 
-func (rs *rowStore) iterate(ctx context.Context, outFields core.Fields, includeMemStore bool, onValue func(bytemap.ByteMap, []encoding.Sequence) (more bool, err error)) (common.OffsetsBySource, error) {
+// iterate iterates over the table's data, only reading segments that overlap
+// the time range from asOf to until (zero asOf or until mean unbounded).
+func (rs *rowStore) iterate(ctx context.Context, outFields core.Fields, includeMemStore bool, asOf time.Time, until time.Time, onValue func(bytemap.ByteMap, []encoding.Sequence) (more bool, err error)) (common.OffsetsBySource, error) {
 	guard := core.Guard(ctx)
 
-	rs.mx.RLock()
+	rs.mx.Lock()
+	if rs.t.isDropped() {
+		rs.mx.Unlock()
+		return nil, errors.New("Table %v has been dropped", rs.t.Name)
+	}
 	fs := rs.fileStore
 	var ms *memstore
 	if includeMemStore {
 		ms = rs.memStore.copy()
 	}
-	rs.mx.RUnlock()
-	rs.mx.Lock()
-	rs.iterationsInProgress[fs.filename]++
+	// Note - we protect all of the segments from removal at the same time that
+	// we grab the fileStore so that none of them can get removed in between.
+	rs.pinSegments(fs.segments)
 	rs.mx.Unlock()
-	defer func() {
-		rs.mx.Lock()
-		rs.iterationsInProgress[fs.filename]--
-		rs.mx.Unlock()
-	}()
-	return fs.iterate(outFields, ms, false, false, func(key bytemap.ByteMap, columns []encoding.Sequence, raw []byte) (bool, error) {
+	defer rs.unpinSegments(fs.segments)
+	return fs.iterate(outFields, ms, asOf, until, func(key bytemap.ByteMap, columns []encoding.Sequence) (bool, error) {
 		return guard.ProceedAfter(onValue(key, columns))
 	})
 }
 
-func (rs *rowStore) processFlush(ms *memstore, allowSort bool) (*memstore, time.Duration) {
-	attempts := 3
-	for i := 0; i < attempts; i++ {
-		// Try a few times just in case we encounter a random error reading the file
-		last := i == attempts-1
-		result, duration := rs.doProcessFlush(ms, allowSort, !last)
-		if result != nil {
-			return result, duration
-		}
+// pinSegments protects the given segments from removal until unpinSegments is
+// called. It must be called with rs.mx locked.
+func (rs *rowStore) pinSegments(segments []*segment) {
+	for _, seg := range segments {
+		rs.iterationsInProgress[seg.Name]++
 	}
-	rs.t.db.Panic("processFlush loop terminated without result, should never happen")
-	return nil, 0
 }
 
-func (rs *rowStore) doProcessFlush(ms *memstore, allowSort, allowFailure bool) (*memstore, time.Duration) {
-	shouldSort := allowSort && rs.t.shouldSort()
-	willSort := "not sorted"
-	if shouldSort {
-		defer rs.t.stopSorting()
-		willSort = "sorted"
+func (rs *rowStore) unpinSegments(segments []*segment) {
+	rs.mx.Lock()
+	for _, seg := range segments {
+		rs.iterationsInProgress[seg.Name]--
+		if rs.iterationsInProgress[seg.Name] <= 0 {
+			delete(rs.iterationsInProgress, seg.Name)
+		}
 	}
+	rs.mx.Unlock()
+}
 
-	rs.mx.RLock()
-	fs := rs.fileStore
-	rs.mx.RUnlock()
-	// We allow raw most of the time for efficiency purposes, but every 10 flushes
-	// we don't so that we have an opportunity to truncate old data.
-	disallowRaw := rs.flushCount%10 == 9
-	rs.flushCount++
-	if disallowRaw {
-		rs.t.log.Debug("Disallowing raw on flush to force truncation")
+// waitForIterations waits for all in-progress iterations to finish. It must
+// only be called once the table has been dropped, so that no new iterations
+// can start.
+func (rs *rowStore) waitForIterations() {
+	for {
+		rs.mx.RLock()
+		inProgress := 0
+		for _, count := range rs.iterationsInProgress {
+			inProgress += count
+		}
+		rs.mx.RUnlock()
+		if inProgress == 0 {
+			return
+		}
+		rs.t.log.Debugf("Waiting for %d iterations to finish", inProgress)
+		time.Sleep(250 * time.Millisecond)
 	}
+}
 
-	fs.t.log.Debugf("Starting flush, %v", willSort)
+// processFlush writes the contents of the given memstore to new segments
+// (leaving existing segments untouched) and commits them along with the
+// memstore's offsets, replacing the memstore with a new, empty one.
+func (rs *rowStore) processFlush(ms *memstore) (*memstore, time.Duration) {
+	rs.t.log.Debug("Starting flush")
 	start := time.Now()
 
-	out, err := ioutil.TempFile("", "nextrowstore")
+	segments, err := rs.writeSegments(ms)
 	if err != nil {
-		rs.t.db.Panic(err)
+		rs.t.db.Panic(fmt.Errorf("Unable to write segments: %v", err))
 	}
-	defer out.Close()
-
-	highWaterMark, rowCount, flushErr := fs.flush(out, rs.fields, nil, ms.offsetsBySource, ms, shouldSort, disallowRaw)
-	if flushErr != nil {
-		shasum, err := calcShaSum(fs.filename)
-		if err != nil {
-			rs.t.log.Errorf("Unable to calculate sha256 sum for %v: %v", fs.filename, err)
-		} else {
-			rs.t.log.Debugf("sha256sum for %v was %v after failing to iterate", fs.filename, shasum)
-		}
-		if allowFailure {
-			rs.t.log.Errorf("Unable to flush using %v, failed after reading %d rows, will try again: %v", fs.filename, rowCount, flushErr)
-			return nil, 0
-		}
-		rs.t.log.Errorf("Unable to flush using %v, failed after reading %d rows, marking file as corrupted and panicking: %v", fs.filename, rowCount, flushErr)
-		fs.markCorrupted()
-		rs.t.db.Panic(flushErr)
-	}
-
-	if syncErr := out.Sync(); syncErr != nil {
-		rs.t.db.Panic(syncErr)
-	}
-	fi, err := out.Stat()
+	newMS := rs.newMemStore(ms.offsetsBySource)
+	err = rs.commit(segments, nil, ms.offsetsBySource, rs.fields, newMS)
 	if err != nil {
-		fs.t.log.Errorf("Unable to stat output file to get size: %v", err)
-	}
-	if closeErr := out.Close(); closeErr != nil {
-		rs.t.db.Panic(closeErr)
+		discardSegments(segments)
+		rs.t.db.Panic(fmt.Errorf("Unable to commit segments: %v", err))
 	}
 
-	// Note - we left-pad the unix nano value to the widest possible length to
-	// ensure lexicographical sort matches time-based sort (e.g. on directory
-	// listing).
-	newFileStoreName := filepath.Join(rs.opts.dir, fmt.Sprintf("filestore_%020d_%d.dat", time.Now().UnixNano(), CurrentFileVersion))
-	if renameErr := os.Rename(out.Name(), newFileStoreName); renameErr != nil {
-		rs.t.db.Panic(renameErr)
-	}
-	defer func() {
-		shasum, err := calcShaSum(newFileStoreName)
-		if err != nil {
-			rs.t.log.Errorf("Unable to calculate sha256 sum for %v: %v", newFileStoreName, err)
-		} else {
-			rs.t.log.Debugf("sha256sum for %v was %v immediately after writing", newFileStoreName, shasum)
+	rowCount := 0
+	size := int64(0)
+	highWaterMark := int64(0)
+	for _, seg := range segments {
+		rowCount += seg.Rows
+		size += seg.Size
+		if seg.HighWaterMark > highWaterMark {
+			highWaterMark = seg.HighWaterMark
 		}
-	}()
-
-	fs = &fileStore{rs.t, rs, rs.fields, newFileStoreName}
-	ms = rs.newMemStore(ms.offsetsBySource)
-	rs.mx.Lock()
-	rs.fileStore = fs
-	rs.memStore = ms
-	rs.mx.Unlock()
-
-	flushDuration := time.Now().Sub(start)
-	if fi != nil {
-		rs.t.log.Debugf("Flushed %d rows to %v in %v, compressed size on disk %d. %v.", rowCount, newFileStoreName, flushDuration, fi.Size(), willSort)
-	} else {
-		rs.t.log.Debugf("Flushed %d rows to %v in %v. %v.", rowCount, newFileStoreName, flushDuration, willSort)
 	}
+	flushDuration := time.Now().Sub(start)
+	rs.t.log.Debugf("Flushed %d rows to %d segments in %v, compressed size on disk %d", rowCount, len(segments), flushDuration, size)
 
 	rs.t.updateHighWaterMarkDisk(highWaterMark)
-	return ms, flushDuration
+	rs.recordDurableOffsets(newMS.offsetsBySource)
+	rs.updateStoredStatsFromSegments()
+	return newMS, flushDuration
 }
 
-func (fs *fileStore) flush(out *os.File, fields core.Fields, filter goexpr.Expr, offsetsBySource common.OffsetsBySource, ms *memstore, shouldSort bool, disallowRaw bool) (int64, int, error) {
-	cout, err := fs.createOutWriter(out, fields, offsetsBySource, shouldSort)
-	if err != nil {
-		fs.t.db.Panic(fmt.Errorf("Unable to create out writer: %v", err))
-	}
-
-	highWaterMark := int64(0)
-	truncateBefore := fs.t.truncateBefore()
-	rowCount := 0
-	write := func(key bytemap.ByteMap, columns []encoding.Sequence, raw []byte) (bool, error) {
-		nextHighWaterMark, err := fs.doWrite(cout, fields, filter, truncateBefore, shouldSort, key, columns, raw)
-		if err != nil {
-			fs.t.db.Panic(fmt.Errorf("Unable to write row out: %v", err))
-		}
-		if nextHighWaterMark > highWaterMark {
-			highWaterMark = nextHighWaterMark
-		}
-		rowCount++
-		return true, nil
-	}
-
-	iterate := func() (err error) {
-		defer func() {
-			if r := recover(); r != nil {
-				err = fmt.Errorf("Recovered from panic on iterating for flush: %v", r)
-			}
-		}()
-
-		_, err = fs.iterate(fields, ms, !shouldSort, !disallowRaw, write)
-		return
-	}
-
-	if iterateErr := iterate(); iterateErr != nil {
-		// this is the only case in which we return an error to signify that we can self-heal by deleting this filestore
-		return highWaterMark, rowCount, iterateErr
-	}
-
-	// manually flush to the underlying snappy writer, since snappy's own Close() function doesn't check the return value of flush
-	f, ok := cout.(flushable)
-	if ok {
-		err = f.Flush()
-		if err != nil {
-			cout.Close()
-			fs.t.db.Panic(fmt.Errorf("Unable to flush flushable writer: %v", err))
-		}
-	}
-
-	err = cout.Close()
-	if err != nil {
-		fs.t.db.Panic(fmt.Errorf("Unable to close out writer: %v", err))
+// recordDurableOffsets records the offsets up to which data has been persisted
+// to disk. On restart, the table will resume reading the WAL from these
+// offsets, so WAL data after these offsets needs to be retained.
+func (rs *rowStore) recordDurableOffsets(offsetsBySource common.OffsetsBySource) {
+	rs.mx.Lock()
+	copyOfOffsets := make(common.OffsetsBySource, len(offsetsBySource))
+	for source, offset := range offsetsBySource {
+		copyOfOffsets[source] = offset
 	}
-
-	return highWaterMark, rowCount, nil
+	rs.durableOffsets = copyOfOffsets
+	rs.mx.Unlock()
 }
 
-type flushable interface {
-	Flush() error
+// getDurableOffsets returns the offsets up to which data has been persisted to
+// disk.
+func (rs *rowStore) getDurableOffsets() common.OffsetsBySource {
+	rs.mx.RLock()
+	defer rs.mx.RUnlock()
+	return rs.durableOffsets
 }
 
-func (fs *fileStore) createOutWriter(out *os.File, fields core.Fields, offsetsBySource common.OffsetsBySource, shouldSort bool) (io.WriteCloser, error) {
+// createOutWriter creates a writer for a new data file with a header
+// containing the given fields and offsets.
+func (t *table) createOutWriter(out *os.File, fields core.Fields, offsetsBySource common.OffsetsBySource) (*snappy.Writer, error) {
 	sout := snappy.NewBufferedWriter(out)
 
 	fieldStrings := make([]string, 0, len(fields))
@@ -524,7 +471,7 @@ func (fs *fileStore) createOutWriter(out *os.File, fields core.Fields, offsetsBy
 	if err != nil {
 		return nil, errors.New("Unable to write header length: %v", err)
 	}
-	err = fs.t.writeOffsets(sout, offsetsBySource)
+	err = t.writeOffsets(sout, offsetsBySource)
 	if err != nil {
 		return nil, errors.New("Unable to write header: %v", err)
 	}
@@ -533,149 +480,28 @@ func (fs *fileStore) createOutWriter(out *os.File, fields core.Fields, offsetsBy
 		return nil, errors.New("Unable to write header: %v", err)
 	}
 
-	if !shouldSort {
-		return sout, nil
-	}
-	chunk := func(r io.Reader) ([]byte, error) {
-		rowLength := uint64(0)
-		readErr := binary.Read(r, encoding.Binary, &rowLength)
-		if readErr != nil {
-			return nil, readErr
-		}
-		_row := make([]byte, rowLength)
-		row := _row
-		encoding.Binary.PutUint64(row, rowLength)
-		row = row[encoding.Width64bits:]
-		_, err = io.ReadFull(r, row)
-		return _row, err
-	}
-
-	less := func(a []byte, b []byte) bool {
-		return bytes.Compare(a, b) < 0
-	}
-
-	cout, sortErr := emsort.New(sout, chunk, less, int(fs.t.db.maxMemoryBytes())/10)
-	if sortErr != nil {
-		fs.t.db.Panic(sortErr)
-	}
-
-	return cout, nil
+	return sout, nil
 }
 
-func (fs *fileStore) doWrite(cout io.WriteCloser, fields core.Fields, filter goexpr.Expr, truncateBefore time.Time, shouldSort bool, key bytemap.ByteMap, columns []encoding.Sequence, raw []byte) (int64, error) {
-	highWaterMark := int64(0)
-
-	if !shouldSort && raw != nil {
-		// This is an optimization that allows us to skip other processing by just
-		// passing through the raw data
-		_, writeErr := cout.Write(raw)
-		return highWaterMark, writeErr
-	}
-
-	if filter != nil && !filter.Eval(key).(bool) {
-		// Didn't meet filter criteria, remove key
-		return highWaterMark, nil
-	}
-
-	hasActiveSequence := false
-	for i, seq := range columns {
-		seq = seq.Truncate(fields[i].Expr.EncodedWidth(), fs.t.Resolution, truncateBefore, time.Time{})
-		columns[i] = seq
-		if seq != nil {
-			hasActiveSequence = true
-		}
-	}
-
-	if !hasActiveSequence {
-		// all encoding.Sequences expired, remove key
-		return highWaterMark, nil
-	}
-
+// encodeRow encodes a row in the format described on dataFile.
+func encodeRow(key bytemap.ByteMap, columns []encoding.Sequence) []byte {
 	rowLength := encoding.Width64bits + encoding.Width16bits + len(key) + encoding.Width16bits
 	for _, seq := range columns {
 		rowLength += encoding.Width64bits + len(seq)
-		ts := seq.UntilInt()
-		if ts > highWaterMark {
-			highWaterMark = ts
-		}
-	}
-
-	var o io.Writer = cout
-	var buf *bytes.Buffer
-	if shouldSort {
-		// When sorting, we need to write the entire row as a single byte array,
-		// so use a ByteBuffer. We don't do this otherwise because we're already
-		// using a buffered writer, so we can avoid the copying
-		b := make([]byte, 0, rowLength)
-		buf = bytes.NewBuffer(b)
-		o = buf
 	}
 
-	err := binary.Write(o, encoding.Binary, uint64(rowLength))
-	if err != nil {
-		return highWaterMark, errors.Wrap(err)
-	}
-
-	err = binary.Write(o, encoding.Binary, uint16(len(key)))
-	if err != nil {
-		return highWaterMark, errors.Wrap(err)
-	}
-	_, err = o.Write(key)
-	if err != nil {
-		return highWaterMark, errors.Wrap(err)
-	}
-
-	err = binary.Write(o, encoding.Binary, uint16(len(columns)))
-	if err != nil {
-		return highWaterMark, errors.Wrap(err)
-	}
+	row := make([]byte, rowLength)
+	b := encoding.WriteInt64(row, rowLength)
+	b = encoding.WriteInt16(b, len(key))
+	b = encoding.Write(b, key)
+	b = encoding.WriteInt16(b, len(columns))
 	for _, seq := range columns {
-		err = binary.Write(o, encoding.Binary, uint64(len(seq)))
-		if err != nil {
-			return highWaterMark, errors.Wrap(err)
-		}
+		b = encoding.WriteInt64(b, len(seq))
 	}
 	for _, seq := range columns {
-		_, err = o.Write(seq)
-		if err != nil {
-			return highWaterMark, errors.Wrap(err)
-		}
-	}
-
-	if shouldSort {
-		// flush buffer
-		_b := buf.Bytes()
-		_, writeErr := cout.Write(_b)
-		if writeErr != nil {
-			return highWaterMark, errors.Wrap(err)
-		}
-	}
-
-	return highWaterMark, nil
-}
-
-func (rs *rowStore) writeOffsets(offsetsBySource common.OffsetsBySource) error {
-	out, err := ioutil.TempFile("", "nextoffset")
-	if err != nil {
-		rs.t.db.Panic(err)
+		b = encoding.Write(b, seq)
 	}
-	defer out.Close()
-
-	err = rs.t.writeOffsets(out, offsetsBySource)
-	if err != nil {
-		return errors.New("Unable to write offsets: %v", err)
-	}
-
-	err = out.Sync()
-	if err != nil {
-		return errors.New("Unable to sync offset file: %v", err)
-	}
-	err = out.Close()
-	if err != nil {
-		return errors.New("Unable to close offset file: %v", err)
-	}
-
-	return os.Rename(out.Name(), filepath.Join(rs.opts.dir, offsetFilename))
+	return row
 }
 
 func (rs *rowStore) removeOldFiles(stop <-chan interface{}) {
@@ -688,24 +514,7 @@ func (rs *rowStore) removeOldFiles(stop <-chan interface{}) {
 			rs.t.log.Debug("Stop removing old files")
 			return
 		case <-ticker.C:
-			files, err := listRegularFiles(rs.opts.dir)
-			if err != nil {
-				rs.t.log.Errorf("Unable to list data files in %v: %v", rs.opts.dir, err)
-			}
-			// Note - the list of files is sorted by name, which in our case is the
-			// timestamp, so that means they're sorted chronologically. We don't want
-			// to delete the last file in the list because that's the current one.
-			foundLatest := false
-			for i := len(files) - 3; i >= 0; i-- {
-				filename := files[i].Name()
-				if filename == offsetFilename {
-					// Ignore offset file
-					continue
-				}
-				if !foundLatest {
-					foundLatest = true
-					continue
-				}
+			for _, filename := range rs.obsoleteFiles() {
 				rs.t.db.waitForBackupToFinish(stop)
 				rs.mx.RLock()
 				okayToRemove := rs.iterationsInProgress[filename] == 0 // don't remove file if we're iterating on it
@@ -716,37 +525,67 @@ func (rs *rowStore) removeOldFiles(stop <-chan interface{}) {
 					rs.t.log.Debugf("Removing old file %v", name)
 					err := os.Remove(name)
 					if err != nil {
-						rs.t.log.Errorf("Unable to delete old file store %v, still consuming disk space unnecessarily: %v", name, err)
+						rs.t.log.Errorf("Unable to delete old file %v, still consuming disk space unnecessarily: %v", name, err)
 					}
 				}
 			}
+			rs.removeObsoleteColdSegments()
 		}
 	}
 }
 
-// fileStore stores rows on disk, encoding them as:
-//   rowLength|keylength|key|numcolumns|col1len|col2len|...|lastcollen|col1|col2|...|lastcol
-//
-// rowLength is 64 bits and includes itself
-// keylength is 16 bits and does not include itself
-// key can be up to 64KB
-// numcolumns is 16 bits (i.e. 65,536 columns allowed)
-// col*len is 64 bits
+// obsoleteFiles lists the data files in the table's directory that aren't
+// part of the manifest (anymore), like segments that have been compacted and
+// files from before the table was migrated to segments.
+func (rs *rowStore) obsoleteFiles() []string {
+	// Hold the manifestMx so that we don't see segments that are in the middle
+	// of being committed.
+	rs.manifestMx.Lock()
+	defer rs.manifestMx.Unlock()
+
+	files, err := listRegularFiles(rs.opts.dir)
+	if err != nil {
+		rs.t.log.Errorf("Unable to list data files in %v: %v", rs.opts.dir, err)
+		return nil
+	}
+
+	rs.mx.RLock()
+	live := make(map[string]bool, len(rs.fileStore.segments))
+	for _, seg := range rs.fileStore.segments {
+		// the local files of cold segments have been offloaded
+		live[seg.Name] = !seg.Cold
+	}
+	rs.mx.RUnlock()
+
+	var obsolete []string
+	for _, file := range files {
+		filename := file.Name()
+		isDataFile := strings.HasPrefix(filename, segmentFilePrefix) || strings.HasPrefix(filename, legacyFilePrefix) || filename == offsetFilename
+		if isDataFile && !live[filename] {
+			obsolete = append(obsolete, filename)
+		}
+	}
+	return obsolete
+}
+
+// fileStore is an immutable view of the segments that make up a table's data
+// on disk at a given point in time.
 type fileStore struct {
-	t        *table
-	rs       *rowStore
-	fields   core.Fields
-	filename string
+	t               *table
+	rs              *rowStore
+	fields          core.Fields
+	segments        []*segment
+	offsetsBySource common.OffsetsBySource
 }
 
-func (fs *fileStore) iterate(outFields []core.Field, ms *memstore, okayToReuseBuffer bool, rawOkay bool, onRow func(bytemap.ByteMap, []encoding.Sequence, []byte) (more bool, err error)) (common.OffsetsBySource, error) {
-	fs.t.log.Debugf("Iterating over %v", fs.filename)
+// iterate iterates over the rows of all segments that overlap the time range
+// from asOf to until (zero asOf or until mean unbounded), merging the data for
+// keys that appear in multiple segments as well as data from the given
+// memstore (if any). Since every segment is sorted by key, this is a streaming
+// k-way merge that only holds one row per segment in memory.
+func (fs *fileStore) iterate(outFields core.Fields, ms *memstore, asOf time.Time, until time.Time, onRow func(bytemap.ByteMap, []encoding.Sequence) (more bool, err error)) (common.OffsetsBySource, error) {
 	ctx := time.Now().UnixNano()
-	var offsetsBySource common.OffsetsBySource
-
-	if fs.t.log.IsTraceEnabled() {
-		fs.t.log.Tracef("Iterating with memstore ? %v from file %v", ms != nil, fs.filename)
-	}
+	offsetsBySource := fs.offsetsBySource
 
 	truncateBefore := fs.t.truncateBefore()
 	if len(outFields) == 0 {
@@ -761,141 +600,91 @@ func (fs *fileStore) iterate(outFields []core.Field, ms *memstore, okayToReuseBu
 		memToOut = rowMerger(outFields, ms.fields, fs.t.Resolution, truncateBefore)
 	}
 
-	file, err := os.OpenFile(fs.filename, os.O_RDONLY, 0)
-	if os.IsNotExist(err) {
-		fs.t.log.Debugf("No filestore available at %v, (yet), try reading the offset file", fs.filename)
-		offsetFile := filepath.Join(fs.rs.opts.dir, offsetFilename)
-		o, err := ioutil.ReadFile(offsetFile)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				fs.t.log.Errorf("Error reading offset file %v: %v", offsetFile, err)
-			}
-		} else if len(o) < wal.OffsetSize {
-			fs.t.log.Errorf("Offset file contents of wrong length: %v %d", offsetFile, len(o))
-		} else {
-			fileVersion := FileVersion_4
-			if len(o) > wal.OffsetSize {
-				// Before Version 5, we only stored a single offset. Since we have more than that,
-				// assume that this is at least Version 5.
-				fileVersion = FileVersion_5
-			}
-			offsetsBySource, _ = fs.t.readOffsets(fileVersion, o)
-			fs.t.log.Debugf("Set highWaterMarks from offset file: %v", offsetsBySource.TSString())
+	var readers []*rowReader
+	defer func() {
+		for _, rr := range readers {
+			rr.Close()
 		}
-	} else {
+	}()
+	heads := make(segmentHeads, 0, len(fs.segments))
+	for _, seg := range fs.segments {
+		if !seg.overlaps(asOf, until) {
+			continue
+		}
+		if fs.t.log.IsTraceEnabled() {
+			fs.t.log.Tracef("Iterating with memstore ? %v over %v (cold: %v)", ms != nil, seg.Name, seg.Cold)
+		}
+		rr, err := fs.rs.openSegment(fs.fields, seg)
 		if err != nil {
-			return offsetsBySource, fs.t.log.Errorf("Unable to open file %v: %v", fs.filename, err)
+			return offsetsBySource, fs.t.log.Errorf("Unable to open segment: %v", err)
 		}
-		fs.t.log.Debugf("Found filestore at %v", fs.filename)
-		r := snappy.NewReader(file)
-
-		var fileFields core.Fields
-		offsetsBySource, _, fileFields, err = fs.info(r)
+		readers = append(readers, rr)
+		head := &segmentHead{
+			rowReader: rr,
+			// this function will map fields from the segment into the right positions
+			// on the outbound row, merging with data from other segments
+			toOut: rowMerger(outFields, rr.fileFields, fs.t.Resolution, truncateBefore),
+		}
+		hasRow, err := head.advance()
 		if err != nil {
-			return offsetsBySource, err
+			return offsetsBySource, fs.t.log.Error(err)
 		}
-		fs.t.log.Debugf("Set highWaterMark from data file: %v", offsetsBySource.TSString())
-
-		// raw is only okay if the file fields match the out fields
-		rawOkay = rawOkay && fileFields.Equals(outFields)
-
-		// this function will map fields from the file into the right positions on
-		// the outbound row
-		fileToOut := rowMapper(outFields, fileFields)
-
-		var rowBuffer []byte
-		var row []byte
-
-		// Read from file
-		for {
-			rowLength := uint64(0)
-			err := binary.Read(r, encoding.Binary, &rowLength)
-			if err == io.EOF {
-				break
-			}
-			if err != nil {
-				return offsetsBySource, fs.t.log.Errorf("Unexpected error reading row length from %v: %v", fs.filename, err)
-			}
-
-			useBuffer := okayToReuseBuffer && int(rowLength) <= cap(rowBuffer)
-			if useBuffer {
-				// Reslice
-				row = rowBuffer[:rowLength]
-			} else {
-				row = make([]byte, rowLength)
-			}
-			rowBuffer = row
-			raw := row
-			encoding.Binary.PutUint64(row, rowLength)
-			row = row[encoding.Width64bits:]
-			_, err = io.ReadFull(r, row)
-			if err != nil {
-				return offsetsBySource, fs.t.log.Errorf("Unexpected error while reading row from %v: %v", fs.filename, err)
-			}
-
-			keyLength, row := encoding.ReadInt16(row)
-			key, row := encoding.ReadByteMap(row, keyLength)
+		if hasRow {
+			heads = append(heads, head)
+		}
+	}
+	heap.Init(&heads)
 
-			var msColumns []encoding.Sequence
-			if ms != nil {
-				msColumns = ms.tree.Remove(ctx, key)
-			}
-			if msColumns == nil && rawOkay {
-				// There's nothing to merge in, just pass through the raw data
-				more, err := onRow(key, nil, raw)
-				if !more || err != nil {
-					fs.t.log.Errorf("Error processing row: %v", err)
-					return offsetsBySource, err
-				}
-				continue
-			}
-			// At this point, we should never pass the raw data
-			raw = nil
-
-			numColumns, row := encoding.ReadInt16(row)
-			colLengths := make([]int, 0, numColumns)
-			for i := 0; i < numColumns; i++ {
-				if len(row) < 8 {
-					return offsetsBySource, fs.t.log.Errorf("Not enough data left to decode column %d length on row of length %d from %v!", i, rowLength, fs.filename)
-				}
-				var colLength int
-				colLength, row = encoding.ReadInt64(row)
-				colLengths = append(colLengths, int(colLength))
-			}
+	matching := make([]*segmentHead, 0, len(heads))
+	for heads.Len() > 0 {
+		// Collect all segments that contain the next key
+		matching = matching[:0]
+		key := heads[0].key
+		for heads.Len() > 0 && bytes.Equal(heads[0].key, key) {
+			matching = append(matching, heap.Pop(&heads).(*segmentHead))
+		}
 
-			includesAtLeastOneColumn := false
-			columns := make([]encoding.Sequence, len(outFields))
-			for i, colLength := range colLengths {
-				var seq encoding.Sequence
-				if colLength > len(row) {
-					return offsetsBySource, fs.t.log.Errorf("Not enough data left to decode column from %v, wanted %d have %d", fs.filename, colLength, len(row))
-				}
-				seq, row = encoding.ReadSequence(row, colLength)
-				if seq != nil && fileToOut(columns, i, seq) {
+		includesAtLeastOneColumn := false
+		columns := make([]encoding.Sequence, len(outFields))
+		for _, head := range matching {
+			for i, seq := range head.columns {
+				if len(seq) > 0 && head.toOut(columns, i, seq) {
 					includesAtLeastOneColumn = true
 				}
 				if fs.t.log.IsTraceEnabled() {
-					fs.t.log.Tracef("File Read: %v", seq.String(fileFields[i].Expr, fs.t.Resolution))
+					fs.t.log.Tracef("File Read: %v", seq.String(head.fileFields[i].Expr, fs.t.Resolution))
 				}
 			}
+		}
 
-			// Merge memStore columns into fileStore columns
-			for i, msColumn := range msColumns {
+		// Merge memStore columns into fileStore columns
+		if ms != nil {
+			for i, msColumn := range ms.tree.Remove(ctx, key) {
 				if memToOut(columns, i, msColumn) {
 					includesAtLeastOneColumn = true
 				}
 			}
+		}
 
-			var more bool
-			if includesAtLeastOneColumn {
-				more, err = onRow(key, columns, raw)
-				if err != nil {
-					fs.t.log.Errorf("Error processing row from %v: %v", fs.filename, err)
-				}
+		more := true
+		var err error
+		if includesAtLeastOneColumn {
+			more, err = onRow(key, columns)
+			if err != nil {
+				fs.t.log.Errorf("Error processing row: %v", err)
 			}
+		}
+		if !more || err != nil {
+			return offsetsBySource, err
+		}
 
-			if !more || err != nil {
-				return offsetsBySource, err
+		for _, head := range matching {
+			hasRow, err := head.advance()
+			if err != nil {
+				return offsetsBySource, fs.t.log.Error(err)
+			}
+			if hasRow {
+				heap.Push(&heads, head)
 			}
 		}
 	}
@@ -908,7 +697,7 @@ func (fs *fileStore) iterate(outFields []core.Field, ms *memstore, okayToReuseBu
 			for i, msColumn := range msColumns {
 				memToOut(columns, i, msColumn)
 			}
-			more, err := onRow(bytemap.ByteMap(key), columns, nil)
+			more, err := onRow(bytemap.ByteMap(key), columns)
 			return more, false, err
 		})
 	}
@@ -916,28 +705,174 @@ func (fs *fileStore) iterate(outFields []core.Field, ms *memstore, okayToReuseBu
 	return offsetsBySource, nil
 }
 
-func (fs *fileStore) info(r io.Reader) (common.OffsetsBySource, string, core.Fields, error) {
+// segmentHead is the current row of a segment that's being iterated over
+type segmentHead struct {
+	*rowReader
+	key     bytemap.ByteMap
+	columns []encoding.Sequence
+	toOut   func(out []encoding.Sequence, i int, seq encoding.Sequence) bool
+}
+
+// advance reads the next row, returning false if there are no more rows.
+func (head *segmentHead) advance() (bool, error) {
+	key, columns, err := head.next()
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	head.key = key
+	head.columns = columns
+	return true, nil
+}
+
+// segmentHeads is a min-heap of segmentHeads ordered by key
+type segmentHeads []*segmentHead
+
+func (h segmentHeads) Len() int           { return len(h) }
+func (h segmentHeads) Less(i, j int) bool { return bytes.Compare(h[i].key, h[j].key) < 0 }
+func (h segmentHeads) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *segmentHeads) Push(x interface{}) {
+	*h = append(*h, x.(*segmentHead))
+}
+
+func (h *segmentHeads) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// dataFile is a single file containing rows, either a segment or a legacy
+// filestore file. It starts with a header containing the WAL offsets and
+// fields of the data, followed by rows encoded as:
+//   rowLength|keylength|key|numcolumns|col1len|col2len|...|lastcollen|col1|col2|...|lastcol
+//
+// rowLength is 64 bits and includes itself
+// keylength is 16 bits and does not include itself
+// key can be up to 64KB
+// numcolumns is 16 bits (i.e. 65,536 columns allowed)
+// col*len is 64 bits
+type dataFile struct {
+	t        *table
+	fields   core.Fields
+	filename string
+}
+
+// rowReader reads the rows of a dataFile in order
+type rowReader struct {
+	*dataFile
+	file            io.ReadCloser
+	r               io.Reader
+	fileFields      core.Fields
+	offsetsBySource common.OffsetsBySource
+}
+
+func (df *dataFile) open() (*rowReader, error) {
+	file, err := os.OpenFile(df.filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, errors.New("Unable to open file %v: %v", df.filename, err)
+	}
+	return df.read(file)
+}
+
+// read reads the dataFile from the given file, which is closed when the
+// resulting rowReader is closed.
+func (df *dataFile) read(file io.ReadCloser) (*rowReader, error) {
+	r := snappy.NewReader(file)
+	offsetsBySource, _, fileFields, err := df.info(r)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &rowReader{
+		dataFile:        df,
+		file:            file,
+		r:               r,
+		fileFields:      fileFields,
+		offsetsBySource: offsetsBySource,
+	}, nil
+}
+
+// next reads the next row, returning its key and its columns in the order of
+// the file's fields. At the end of the file, next returns io.EOF.
+func (rr *rowReader) next() (bytemap.ByteMap, []encoding.Sequence, error) {
+	rowLength := uint64(0)
+	err := binary.Read(rr.r, encoding.Binary, &rowLength)
+	if err == io.EOF {
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, errors.New("Unexpected error reading row length from %v: %v", rr.filename, err)
+	}
+	if rowLength < encoding.Width64bits {
+		return nil, nil, errors.New("Invalid row length %d in %v", rowLength, rr.filename)
+	}
+
+	row := make([]byte, rowLength)
+	encoding.Binary.PutUint64(row, rowLength)
+	row = row[encoding.Width64bits:]
+	_, err = io.ReadFull(rr.r, row)
+	if err != nil {
+		return nil, nil, errors.New("Unexpected error while reading row from %v: %v", rr.filename, err)
+	}
+
+	keyLength, row := encoding.ReadInt16(row)
+	key, row := encoding.ReadByteMap(row, keyLength)
+
+	numColumns, row := encoding.ReadInt16(row)
+	colLengths := make([]int, 0, numColumns)
+	for i := 0; i < numColumns; i++ {
+		if len(row) < 8 {
+			return nil, nil, errors.New("Not enough data left to decode column %d length on row of length %d from %v!", i, rowLength, rr.filename)
+		}
+		var colLength int
+		colLength, row = encoding.ReadInt64(row)
+		colLengths = append(colLengths, int(colLength))
+	}
+
+	columns := make([]encoding.Sequence, 0, numColumns)
+	for _, colLength := range colLengths {
+		var seq encoding.Sequence
+		if colLength > len(row) {
+			return nil, nil, errors.New("Not enough data left to decode column from %v, wanted %d have %d", rr.filename, colLength, len(row))
+		}
+		seq, row = encoding.ReadSequence(row, colLength)
+		columns = append(columns, seq)
+	}
+
+	return key, columns, nil
+}
+
+func (rr *rowReader) Close() error {
+	return rr.file.Close()
+}
+
+func (df *dataFile) info(r io.Reader) (common.OffsetsBySource, string, core.Fields, error) {
 	var offsetsBySource common.OffsetsBySource
-	fileVersion := fs.t.versionFor(fs.filename)
+	fileVersion := df.t.versionFor(df.filename)
 	// File contains header with field info, use it
 	headerLength := uint32(0)
 	lengthErr := binary.Read(r, encoding.Binary, &headerLength)
 	if lengthErr != nil {
-		return offsetsBySource, "", nil, fs.t.log.Errorf("Unexpected error reading header length from %v: %v", fs.filename, lengthErr)
+		return offsetsBySource, "", nil, errors.New("Unexpected error reading header length from %v: %v", df.filename, lengthErr)
 	}
 	fieldsBytes := make([]byte, headerLength)
 	_, readErr := io.ReadFull(r, fieldsBytes)
 	if readErr != nil {
-		return offsetsBySource, "", nil, fs.t.log.Errorf("Unable to read fields from %v: %v", fs.filename, readErr)
+		return offsetsBySource, "", nil, errors.New("Unable to read fields from %v: %v", df.filename, readErr)
 	}
-	offsetsBySource, fieldsBytes = fs.t.readOffsets(fileVersion, fieldsBytes)
+	offsetsBySource, fieldsBytes = df.t.readOffsets(fileVersion, fieldsBytes)
 	delim := fieldsDelims[fileVersion]
 	fieldsString := string(fieldsBytes)
 	fieldStrings := strings.Split(fieldsString, delim)
 	fileFields := make(core.Fields, 0, len(fieldStrings))
 	for _, fieldString := range fieldStrings {
 		foundField := false
-		for _, field := range fs.fields {
+		for _, field := range df.fields {
 			if fieldString == field.String() {
 				fileFields = append(fileFields, field)
 				foundField = true
@@ -952,8 +887,8 @@ func (fs *fileStore) info(r io.Reader) (common.OffsetsBySource, string, core.Fie
 	return offsetsBySource, fieldsString, fileFields, nil
 }
 
-func (fs *fileStore) markCorrupted() error {
-	dir, file := filepath.Split(fs.filename)
+func (df *dataFile) markCorrupted() error {
+	dir, file := filepath.Split(df.filename)
 	corruptedDir := filepath.Join(dir, "corrupted")
 	corruptedFile := filepath.Join(corruptedDir, file)
 
@@ -962,9 +897,9 @@ func (fs *fileStore) markCorrupted() error {
 		return errors.New("Unable to make corrupted subdirectory %v: %v", corruptedDir, err)
 	}
 
-	err = os.Rename(fs.filename, corruptedFile)
+	err = os.Rename(df.filename, corruptedFile)
 	if err != nil {
-		return errors.New("Unable to move corrupted filestore %v to %v: %v", fs.filename, corruptedFile, err)
+		return errors.New("Unable to move corrupted filestore %v to %v: %v", df.filename, corruptedFile, err)
 	}
 
 	return nil
@@ -972,9 +907,11 @@ func (fs *fileStore) markCorrupted() error {
 
 func (t *table) versionFor(filename string) int {
 	fileVersion := 0
+	// The version is the last part of the name, e.g. filestore_<ts>_5.dat or
+	// segment_<start>_<seq>_5.dat
 	parts := strings.Split(filepath.Base(filename), "_")
-	if len(parts) == 3 {
-		versionString := strings.Split(parts[2], ".")[0]
+	if len(parts) >= 3 {
+		versionString := strings.Split(parts[len(parts)-1], ".")[0]
 		var versionErr error
 		fileVersion, versionErr = strconv.Atoi(versionString)
 		if versionErr != nil {
@@ -1093,17 +1030,3 @@ func listRegularFiles(dir string) ([]os.FileInfo, error) {
 	}
 	return regularFiles, nil
 }
-
-func calcShaSum(filename string) (string, error) {
-	f, err := os.OpenFile(filename, os.O_RDONLY, 0)
-	if err != nil {
-		return "", errors.New("Unable to open %v to calculate sha256: %v", filename, err)
-	}
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return "", errors.New("Unable to calculate sha256 for %v: %v", filename, err)
-	}
-
-	return hex.EncodeToString(h.Sum(nil)), nil
-}
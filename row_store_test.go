@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/getlantern/golog"
+	"github.com/getlantern/vtime"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -16,9 +17,16 @@ func TestStorage(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
+	// The row store runs background tasks, so stop them when we're done
+	db := &DB{
+		log:     golog.LoggerFor("storagetest"),
+		clock:   vtime.RealClock,
+		closing: make(chan interface{}),
+	}
+	defer db.Close()
 	tb := &table{
 		log: golog.LoggerFor("storagetest"),
-		db:  &DB{},
+		db:  db,
 	}
 	cs, _, err := tb.openRowStore(&rowStoreOptions{
 		dir: tmpDir,
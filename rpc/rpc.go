@@ -38,6 +38,7 @@ type InsertReport struct {
 }
 
 type Query struct {
+	ID              string
 	SQLString       string
 	IsSubQuery      bool
 	SubQueryResults [][]interface{}
@@ -47,6 +48,31 @@ type Query struct {
 	HasDeadline     bool
 }
 
+type CancelQuery struct {
+	ID string
+}
+
+type CancelQueryResult struct {
+	Found bool
+}
+
+type ExecuteDDL struct {
+	SQL string
+}
+
+type ExecuteDDLResult struct {
+	Error string
+}
+
+type Snapshot struct {
+	Name string
+}
+
+type SnapshotResult struct {
+	Info  *common.SnapshotInfo
+	Error string
+}
+
 type Point struct {
 	Data   []byte
 	Offset wal.Offset
@@ -79,6 +105,19 @@ type Client interface {
 
 	ProcessRemoteQuery(ctx context.Context, partition int, query planner.QueryClusterFN, timeout time.Duration, opts ...grpc.CallOption) error
 
+	// CancelQuery cancels the running query with the given id, returning false
+	// if no such query was found.
+	CancelQuery(ctx context.Context, id string, opts ...grpc.CallOption) (bool, error)
+
+	// ExecuteDDL executes a CREATE, ALTER or DROP statement to change the
+	// server's schema.
+	ExecuteDDL(ctx context.Context, sqlString string, opts ...grpc.CallOption) error
+
+	// Snapshot creates a point-in-time snapshot of the server's database with
+	// the given name, which is a directory relative to the server's snapshot
+	// root.
+	Snapshot(ctx context.Context, name string, opts ...grpc.CallOption) (*common.SnapshotInfo, error)
+
 	Close() error
 }
 
@@ -90,6 +129,12 @@ type Server interface {
 	Follow(*common.Follow, grpc.ServerStream) error
 
 	HandleRemoteQueries(r *RegisterQueryHandler, stream grpc.ServerStream) error
+
+	CancelQuery(*CancelQuery, grpc.ServerStream) error
+
+	ExecuteDDL(*ExecuteDDL, grpc.ServerStream) error
+
+	Snapshot(*Snapshot, grpc.ServerStream) error
 }
 
 var ServiceDesc = grpc.ServiceDesc{
@@ -118,6 +163,21 @@ var ServiceDesc = grpc.ServiceDesc{
 			Handler:       insertHandler,
 			ClientStreams: true,
 		},
+		{
+			StreamName:    "cancelQuery",
+			Handler:       cancelQueryHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "executeDDL",
+			Handler:       executeDDLHandler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "snapshot",
+			Handler:       snapshotHandler,
+			ServerStreams: true,
+		},
 	},
 }
 
@@ -148,3 +208,27 @@ func remoteQueryHandler(srv interface{}, stream grpc.ServerStream) error {
 	}
 	return srv.(Server).HandleRemoteQueries(r, stream)
 }
+
+func cancelQueryHandler(srv interface{}, stream grpc.ServerStream) error {
+	c := new(CancelQuery)
+	if err := stream.RecvMsg(c); err != nil {
+		return err
+	}
+	return srv.(Server).CancelQuery(c, stream)
+}
+
+func executeDDLHandler(srv interface{}, stream grpc.ServerStream) error {
+	d := new(ExecuteDDL)
+	if err := stream.RecvMsg(d); err != nil {
+		return err
+	}
+	return srv.(Server).ExecuteDDL(d, stream)
+}
+
+func snapshotHandler(srv interface{}, stream grpc.ServerStream) error {
+	s := new(Snapshot)
+	if err := stream.RecvMsg(s); err != nil {
+		return err
+	}
+	return srv.(Server).Snapshot(s, stream)
+}
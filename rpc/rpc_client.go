@@ -114,7 +114,7 @@ func (c *client) Query(ctx context.Context, sqlString string, includeMemStore bo
 	if err != nil {
 		return nil, nil, err
 	}
-	if err = stream.SendMsg(&Query{SQLString: sqlString, IncludeMemStore: includeMemStore}); err != nil {
+	if err = stream.SendMsg(&Query{ID: common.QueryID(ctx), SQLString: sqlString, IncludeMemStore: includeMemStore}); err != nil {
 		return nil, nil, err
 	}
 	if err = stream.CloseSend(); err != nil {
@@ -246,6 +246,7 @@ func (c *client) ProcessRemoteQuery(ctx context.Context, partition int, query pl
 		defer cancel()
 	}
 	streamCtx = common.WithIncludeMemStore(streamCtx, q.IncludeMemStore)
+	streamCtx = common.WithQueryID(streamCtx, q.ID)
 
 	_stats, queryErr := query(streamCtx, q.SQLString, q.IsSubQuery, q.SubQueryResults, q.Unflat, onFields, onRow, onFlatRow)
 	var stats *common.QueryStats
@@ -262,6 +263,69 @@ func (c *client) ProcessRemoteQuery(ctx context.Context, partition int, query pl
 	return nil
 }
 
+func (c *client) CancelQuery(ctx context.Context, id string, opts ...grpc.CallOption) (bool, error) {
+	stream, err := grpc.NewClientStream(c.authenticated(ctx), &ServiceDesc.Streams[4], c.cc, "/zenodb/cancelQuery", opts...)
+	if err != nil {
+		return false, err
+	}
+	if err = stream.SendMsg(&CancelQuery{ID: id}); err != nil {
+		return false, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return false, err
+	}
+
+	result := &CancelQueryResult{}
+	if err = stream.RecvMsg(result); err != nil {
+		return false, err
+	}
+	return result.Found, nil
+}
+
+func (c *client) ExecuteDDL(ctx context.Context, sqlString string, opts ...grpc.CallOption) error {
+	stream, err := grpc.NewClientStream(c.authenticated(ctx), &ServiceDesc.Streams[5], c.cc, "/zenodb/executeDDL", opts...)
+	if err != nil {
+		return err
+	}
+	if err = stream.SendMsg(&ExecuteDDL{SQL: sqlString}); err != nil {
+		return err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return err
+	}
+
+	result := &ExecuteDDLResult{}
+	if err = stream.RecvMsg(result); err != nil {
+		return err
+	}
+	if result.Error != "" {
+		return errors.New("%v", result.Error)
+	}
+	return nil
+}
+
+func (c *client) Snapshot(ctx context.Context, name string, opts ...grpc.CallOption) (*common.SnapshotInfo, error) {
+	stream, err := grpc.NewClientStream(c.authenticated(ctx), &ServiceDesc.Streams[6], c.cc, "/zenodb/snapshot", opts...)
+	if err != nil {
+		return nil, err
+	}
+	if err = stream.SendMsg(&Snapshot{Name: name}); err != nil {
+		return nil, err
+	}
+	if err = stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	result := &SnapshotResult{}
+	if err = stream.RecvMsg(result); err != nil {
+		return nil, err
+	}
+	if result.Error != "" {
+		return nil, errors.New("%v", result.Error)
+	}
+	return result.Info, nil
+}
+
 func (c *client) Close() error {
 	return c.cc.Close()
 }
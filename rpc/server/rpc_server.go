@@ -38,6 +38,14 @@ type DB interface {
 	Follow(f *common.Follow, cb func([]byte, wal.Offset) error)
 
 	RegisterQueryHandler(partition int, query planner.QueryClusterFN)
+
+	RegisterQuery(ctx context.Context, id string, origin string, sqlString string) (context.Context, func())
+
+	CancelQuery(id string) bool
+
+	ExecuteDDL(sqlString string) error
+
+	SnapshotNamed(name string) (*common.SnapshotInfo, error)
 }
 
 func PrepareServer(db DB, l net.Listener, opts *Opts) (func() error, func()) {
@@ -121,8 +129,11 @@ func (s *server) Query(q *rpc.Query, stream grpc.ServerStream) error {
 		return err
 	}
 
+	ctx, finished := s.db.RegisterQuery(stream.Context(), q.ID, zenodb.QueryOriginRPC, q.SQLString)
+	defer finished()
+
 	rr := &rpc.RemoteQueryResult{}
-	stats, err := source.Iterate(stream.Context(), func(fields core.Fields) error {
+	stats, err := source.Iterate(ctx, func(fields core.Fields) error {
 		// Send query metadata
 		md := zenodb.MetaDataFor(source, fields)
 		return stream.SendMsg(md)
@@ -177,6 +188,7 @@ func (s *server) HandleRemoteQueries(r *rpc.RegisterQueryHandler, stream grpc.Se
 
 	s.db.RegisterQueryHandler(r.Partition, func(ctx context.Context, sqlString string, isSubQuery bool, subQueryResults [][]interface{}, unflat bool, onFields core.OnFields, onRow core.OnRow, onFlatRow core.OnFlatRow) (interface{}, error) {
 		q := &rpc.Query{
+			ID:              common.QueryID(ctx),
 			SQLString:       sqlString,
 			IsSubQuery:      isSubQuery,
 			SubQueryResults: subQueryResults,
@@ -186,6 +198,19 @@ func (s *server) HandleRemoteQueries(r *rpc.RegisterQueryHandler, stream grpc.Se
 		q.Deadline, q.HasDeadline = ctx.Deadline()
 		sendErr := stream.SendMsg(q)
 
+		// If the query is canceled, finish so that the stream is closed, which
+		// stops the query on the remote end.
+		queryDone := make(chan interface{})
+		defer close(queryDone)
+		go func() {
+			select {
+			case <-ctx.Done():
+				finish(core.Guard(ctx).Err())
+			case <-queryDone:
+				// query finished normally
+			}
+		}()
+
 		m, recvErr := <-initialResultCh, <-initialErrCh
 
 		// Check send error after reading initial result to avoid blocking
@@ -259,6 +284,42 @@ func (s *server) HandleRemoteQueries(r *rpc.RegisterQueryHandler, stream grpc.Se
 	return err
 }
 
+func (s *server) CancelQuery(c *rpc.CancelQuery, stream grpc.ServerStream) error {
+	if authorizeErr := s.authorize(stream); authorizeErr != nil {
+		return authorizeErr
+	}
+
+	return stream.SendMsg(&rpc.CancelQueryResult{Found: s.db.CancelQuery(c.ID)})
+}
+
+func (s *server) ExecuteDDL(d *rpc.ExecuteDDL, stream grpc.ServerStream) error {
+	if authorizeErr := s.authorize(stream); authorizeErr != nil {
+		return authorizeErr
+	}
+
+	result := &rpc.ExecuteDDLResult{}
+	if err := s.db.ExecuteDDL(d.SQL); err != nil {
+		s.log.Errorf("Unable to execute %v: %v", d.SQL, err)
+		result.Error = err.Error()
+	}
+	return stream.SendMsg(result)
+}
+
+func (s *server) Snapshot(sn *rpc.Snapshot, stream grpc.ServerStream) error {
+	if authorizeErr := s.authorize(stream); authorizeErr != nil {
+		return authorizeErr
+	}
+
+	result := &rpc.SnapshotResult{}
+	info, err := s.db.SnapshotNamed(sn.Name)
+	if err != nil {
+		s.log.Errorf("Unable to snapshot to %v: %v", sn.Name, err)
+		result.Error = err.Error()
+	}
+	result.Info = info
+	return stream.SendMsg(result)
+}
+
 func (s *server) authorize(stream grpc.ServerStream) error {
 	if s.password == "" {
 		s.log.Debug("No password specified, allowing access to world")
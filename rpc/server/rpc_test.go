@@ -2,6 +2,7 @@ package rpcserver
 
 import (
 	"context"
+	"errors"
 	"net"
 	"sync/atomic"
 	"testing"
@@ -78,6 +79,132 @@ func TestInsert(t *testing.T) {
 	}
 }
 
+func TestCancelQuery(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	db := &mockDB{}
+	start, _ := PrepareServer(db, l, &Opts{
+		Password: "password",
+	})
+	go start()
+	time.Sleep(1 * time.Second)
+
+	client, err := rpc.Dial(l.Addr().String(), &rpc.ClientOpts{
+		Password: "password",
+		Dialer: func(addr string, timeout time.Duration) (net.Conn, error) {
+			return net.DialTimeout("tcp", addr, timeout)
+		},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+
+	found, err := client.CancelQuery(context.Background(), "running")
+	if assert.NoError(t, err) {
+		assert.True(t, found)
+	}
+	found, err = client.CancelQuery(context.Background(), "unknown")
+	if assert.NoError(t, err) {
+		assert.False(t, found)
+	}
+}
+
+func TestExecuteDDL(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	db := &mockDB{}
+	start, _ := PrepareServer(db, l, &Opts{
+		Password: "password",
+	})
+	go start()
+	time.Sleep(1 * time.Second)
+
+	dial := func(password string) (rpc.Client, error) {
+		return rpc.Dial(l.Addr().String(), &rpc.ClientOpts{
+			Password: password,
+			Dialer: func(addr string, timeout time.Duration) (net.Conn, error) {
+				return net.DialTimeout("tcp", addr, timeout)
+			},
+		})
+	}
+
+	client, err := dial("password")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+
+	assert.NoError(t, client.ExecuteDDL(context.Background(), "DROP TABLE good"))
+	err = client.ExecuteDDL(context.Background(), "DROP TABLE bad")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bad table")
+	}
+
+	unauthorized, err := dial("wrong")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer unauthorized.Close()
+	assert.Error(t, unauthorized.ExecuteDDL(context.Background(), "DROP TABLE good"), "Wrong password should not be allowed to change schema")
+}
+
+func TestSnapshot(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer l.Close()
+
+	db := &mockDB{}
+	start, _ := PrepareServer(db, l, &Opts{
+		Password: "password",
+	})
+	go start()
+	time.Sleep(1 * time.Second)
+
+	dial := func(password string) (rpc.Client, error) {
+		return rpc.Dial(l.Addr().String(), &rpc.ClientOpts{
+			Password: password,
+			Dialer: func(addr string, timeout time.Duration) (net.Conn, error) {
+				return net.DialTimeout("tcp", addr, timeout)
+			},
+		})
+	}
+
+	client, err := dial("password")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+
+	info, err := client.Snapshot(context.Background(), "good")
+	if assert.NoError(t, err) && assert.NotNil(t, info) {
+		assert.Equal(t, "/snapshots/good", info.Dir)
+		assert.Equal(t, 2, info.Tables)
+	}
+	_, err = client.Snapshot(context.Background(), "bad")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bad name")
+	}
+
+	unauthorized, err := dial("wrong")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer unauthorized.Close()
+	_, err = unauthorized.Snapshot(context.Background(), "good")
+	assert.Error(t, err, "Wrong password should not be allowed to snapshot")
+}
+
 type mockDB struct {
 	numInserts int64
 }
@@ -101,3 +228,25 @@ func (db *mockDB) Follow(f *common.Follow, cb func([]byte, wal.Offset) error) {
 func (db *mockDB) RegisterQueryHandler(partition int, query planner.QueryClusterFN) {
 
 }
+
+func (db *mockDB) RegisterQuery(ctx context.Context, id string, origin string, sqlString string) (context.Context, func()) {
+	return ctx, func() {}
+}
+
+func (db *mockDB) CancelQuery(id string) bool {
+	return id == "running"
+}
+
+func (db *mockDB) ExecuteDDL(sqlString string) error {
+	if sqlString != "DROP TABLE good" {
+		return errors.New("bad table")
+	}
+	return nil
+}
+
+func (db *mockDB) SnapshotNamed(name string) (*common.SnapshotInfo, error) {
+	if name != "good" {
+		return nil, errors.New("bad name")
+	}
+	return &common.SnapshotInfo{Dir: "/snapshots/" + name, Tables: 2}, nil
+}
@@ -0,0 +1,346 @@
+package zenodb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/uuid"
+	"github.com/getlantern/zenodb/common"
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/encoding"
+	"github.com/getlantern/zenodb/expr"
+)
+
+const (
+	// QueryOriginWeb identifies queries that came in through the web API
+	QueryOriginWeb = "web"
+	// QueryOriginRPC identifies queries that came in through gRPC (e.g. zeno-cli)
+	QueryOriginRPC = "rpc"
+	// QueryOriginCluster identifies queries that a leader sent to this follower
+	QueryOriginCluster = "cluster"
+
+	keyRunningQuery = "zenodb.runningQuery"
+
+	showQueriesSQL = "SHOW QUERIES"
+)
+
+// QueryInfo describes a query that's currently running.
+type QueryInfo struct {
+	ID     string
+	SQL    string
+	Origin string
+	// Node identifies the node on which the query is running (e.g. leader.1 or
+	// follower.0.1).
+	Node  string
+	Start time.Time
+	// RowsScanned counts rows read from local tables plus rows received from
+	// cluster partitions.
+	RowsScanned int64
+	// MemoryEstimate estimates the number of bytes of row data that the query
+	// has read so far.
+	MemoryEstimate int64
+	// NumPartitions is the number of partitions queried (0 for local queries).
+	NumPartitions int
+	// FinishedPartitions is the number of partitions that have finished.
+	FinishedPartitions int
+	// RowsByPartition counts the rows received from each partition.
+	RowsByPartition map[int]int64
+}
+
+type runningQuery struct {
+	id                 string
+	sql                string
+	origin             string
+	start              time.Time
+	cancel             context.CancelFunc
+	rowsScanned        int64
+	bytesScanned       int64
+	numPartitions      int
+	rowsByPartition    map[int]*int64
+	finishedPartitions map[int]bool
+	partitionsMx       sync.Mutex
+}
+
+// RegisterQuery registers a running query under the given id so that it shows
+// up in RunningQueries and can be canceled using CancelQuery. If id is blank,
+// a new id is generated. The returned Context is done once either ctx is done
+// or the query has been canceled. Callers must call the returned finished
+// function once the query is done.
+func (db *DB) RegisterQuery(ctx context.Context, id string, origin string, sqlString string) (queryCtx context.Context, finished func()) {
+	if id == "" {
+		id = uuid.New().String()
+	}
+	queryCtx, cancel := context.WithCancel(ctx)
+	rq := &runningQuery{
+		id:     id,
+		sql:    sqlString,
+		origin: origin,
+		start:  time.Now(),
+		cancel: cancel,
+	}
+	queryCtx = common.WithQueryID(queryCtx, id)
+	queryCtx = context.WithValue(queryCtx, keyRunningQuery, rq)
+
+	db.runningQueriesMx.Lock()
+	db.runningQueries[id] = rq
+	db.runningQueriesMx.Unlock()
+
+	return queryCtx, func() {
+		db.runningQueriesMx.Lock()
+		if db.runningQueries[id] == rq {
+			delete(db.runningQueries, id)
+		}
+		db.runningQueriesMx.Unlock()
+		cancel()
+	}
+}
+
+// CancelQuery cancels the running query with the given id. It returns false if
+// no such query is running.
+func (db *DB) CancelQuery(id string) bool {
+	db.runningQueriesMx.Lock()
+	rq, found := db.runningQueries[id]
+	delete(db.runningQueries, id)
+	db.runningQueriesMx.Unlock()
+
+	if !found {
+		return false
+	}
+	db.log.Debugf("Canceling query %v", id)
+	rq.cancel()
+	return true
+}
+
+// RunningQueries lists the queries that are currently running on this node,
+// oldest first.
+func (db *DB) RunningQueries() []*QueryInfo {
+	db.runningQueriesMx.Lock()
+	rqs := make([]*runningQuery, 0, len(db.runningQueries))
+	for _, rq := range db.runningQueries {
+		rqs = append(rqs, rq)
+	}
+	db.runningQueriesMx.Unlock()
+
+	node := db.opts.logSuffix()
+	infos := make([]*QueryInfo, 0, len(rqs))
+	for _, rq := range rqs {
+		info := rq.info()
+		info.Node = node
+		infos = append(infos, info)
+	}
+	sortQueryInfos(infos)
+	return infos
+}
+
+// ClusterRunningQueries lists the queries that are currently running on this
+// node and, if this node is a leader, on the followers that answer its queries
+// (one per partition), oldest first. For leaders, the returned QueryStats
+// report which partitions failed to answer. For other nodes, they're nil.
+func (db *DB) ClusterRunningQueries(ctx context.Context) ([]*QueryInfo, *common.QueryStats, error) {
+	infos := db.RunningQueries()
+	if !db.opts.Passthrough {
+		return infos, nil, nil
+	}
+
+	stats, err := db.queryCluster(ctx, showQueriesSQL, false, nil, false, false, func(fields core.Fields) error {
+		return nil
+	}, nil, func(row *core.FlatRow) (bool, error) {
+		info := queryInfoFor(row, time.Now())
+		if info != nil {
+			infos = append(infos, info)
+		}
+		return true, nil
+	})
+	sortQueryInfos(infos)
+	qs, _ := stats.(*common.QueryStats)
+	return infos, qs, err
+}
+
+// queryInfoFor reconstructs a QueryInfo from a row returned by SHOW QUERIES on
+// a follower. Start is derived from the elapsed time so that it's relative to
+// this node's clock.
+func queryInfoFor(row *core.FlatRow, now time.Time) *QueryInfo {
+	if len(row.Values) < len(showQueriesFields) {
+		return nil
+	}
+	dim := func(name string) string {
+		val, _ := row.Key.Get(name).(string)
+		return val
+	}
+	return &QueryInfo{
+		ID:                 dim("id"),
+		SQL:                dim("sql"),
+		Origin:             dim("origin"),
+		Node:               dim("node"),
+		Start:              now.Add(-1 * time.Duration(row.Values[0]*float64(time.Second))),
+		RowsScanned:        int64(row.Values[1]),
+		MemoryEstimate:     int64(row.Values[2]),
+		NumPartitions:      int(row.Values[3]),
+		FinishedPartitions: int(row.Values[4]),
+	}
+}
+
+func sortQueryInfos(infos []*QueryInfo) {
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Start.Before(infos[j].Start)
+	})
+}
+
+func runningQueryFor(ctx context.Context) *runningQuery {
+	rq := ctx.Value(keyRunningQuery)
+	if rq == nil {
+		return nil
+	}
+	return rq.(*runningQuery)
+}
+
+func (rq *runningQuery) scanned(key bytemap.ByteMap, vals []encoding.Sequence) {
+	size := len(key)
+	for _, val := range vals {
+		size += len(val)
+	}
+	atomic.AddInt64(&rq.rowsScanned, 1)
+	atomic.AddInt64(&rq.bytesScanned, int64(size))
+}
+
+func (rq *runningQuery) received(size int) {
+	atomic.AddInt64(&rq.bytesScanned, int64(size))
+}
+
+func (rq *runningQuery) startPartitions(numPartitions int) {
+	rq.partitionsMx.Lock()
+	rq.numPartitions = numPartitions
+	rq.rowsByPartition = make(map[int]*int64, numPartitions)
+	rq.finishedPartitions = make(map[int]bool, numPartitions)
+	rq.partitionsMx.Unlock()
+}
+
+func (rq *runningQuery) trackPartition(partition int, rows *int64) {
+	rq.partitionsMx.Lock()
+	rq.rowsByPartition[partition] = rows
+	rq.partitionsMx.Unlock()
+}
+
+func (rq *runningQuery) finishPartition(partition int) {
+	rq.partitionsMx.Lock()
+	rq.finishedPartitions[partition] = true
+	rq.partitionsMx.Unlock()
+}
+
+func (rq *runningQuery) info() *QueryInfo {
+	info := &QueryInfo{
+		ID:             rq.id,
+		SQL:            rq.sql,
+		Origin:         rq.origin,
+		Start:          rq.start,
+		RowsScanned:    atomic.LoadInt64(&rq.rowsScanned),
+		MemoryEstimate: atomic.LoadInt64(&rq.bytesScanned),
+	}
+
+	rq.partitionsMx.Lock()
+	info.NumPartitions = rq.numPartitions
+	info.FinishedPartitions = len(rq.finishedPartitions)
+	if len(rq.rowsByPartition) > 0 {
+		info.RowsByPartition = make(map[int]int64, len(rq.rowsByPartition))
+		for partition, rows := range rq.rowsByPartition {
+			partitionRows := atomic.LoadInt64(rows)
+			info.RowsByPartition[partition] = partitionRows
+			info.RowsScanned += partitionRows
+		}
+	}
+	rq.partitionsMx.Unlock()
+
+	return info
+}
+
+var showQueriesFields = core.Fields{
+	core.NewField("elapsed_seconds", expr.FIELD("elapsed_seconds")),
+	core.NewField("rows_scanned", expr.FIELD("rows_scanned")),
+	core.NewField("memory_estimate", expr.FIELD("memory_estimate")),
+	core.NewField("num_partitions", expr.FIELD("num_partitions")),
+	core.NewField("finished_partitions", expr.FIELD("finished_partitions")),
+}
+
+// showQueries is a core.FlatRowSource that lists the running queries in
+// response to SHOW QUERIES. On a leader, this includes the queries running on
+// its followers.
+type showQueries struct {
+	db  *DB
+	now time.Time
+}
+
+func (sq *showQueries) Iterate(ctx context.Context, onFields core.OnFields, onRow core.OnFlatRow) (interface{}, error) {
+	err := onFields(showQueriesFields)
+	if err != nil {
+		return nil, err
+	}
+
+	infos, stats, err := sq.db.ClusterRunningQueries(ctx)
+	if err != nil {
+		return stats, err
+	}
+
+	// Measure elapsed time after hearing back from the followers so that it's
+	// consistent with the start times derived from their results
+	now := time.Now()
+	ts := sq.now.UnixNano()
+	for _, info := range infos {
+		row := &core.FlatRow{
+			TS: ts,
+			Key: bytemap.New(map[string]interface{}{
+				"id":     info.ID,
+				"sql":    info.SQL,
+				"origin": info.Origin,
+				"node":   info.Node,
+				"start":  info.Start.In(time.UTC).Format(time.RFC3339),
+			}),
+			Values: []float64{
+				now.Sub(info.Start).Seconds(),
+				float64(info.RowsScanned),
+				float64(info.MemoryEstimate),
+				float64(info.NumPartitions),
+				float64(info.FinishedPartitions),
+			},
+		}
+		row.SetFields(showQueriesFields)
+		more, err := onRow(row)
+		if !more || err != nil {
+			return nil, err
+		}
+	}
+
+	if stats != nil {
+		return stats, nil
+	}
+	nowMillis := common.TimeToMillis(sq.now)
+	return &common.QueryStats{
+		NumPartitions:           1,
+		NumSuccessfulPartitions: 1,
+		LowestHighWaterMark:     nowMillis,
+		HighestHighWaterMark:    nowMillis,
+	}, nil
+}
+
+func (sq *showQueries) GetGroupBy() []core.GroupBy {
+	return nil
+}
+
+func (sq *showQueries) GetResolution() time.Duration {
+	return 0
+}
+
+func (sq *showQueries) GetAsOf() time.Time {
+	return sq.now
+}
+
+func (sq *showQueries) GetUntil() time.Time {
+	return sq.now
+}
+
+func (sq *showQueries) String() string {
+	return "show queries"
+}
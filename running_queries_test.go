@@ -0,0 +1,96 @@
+package zenodb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/golog"
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunningQueries(t *testing.T) {
+	db := &DB{
+		opts:           &DBOpts{ID: 1},
+		log:            golog.LoggerFor("running_queries_test"),
+		runningQueries: make(map[string]*runningQuery),
+	}
+
+	ctx, finished := db.RegisterQuery(context.Background(), "a", QueryOriginWeb, "SELECT * FROM a")
+	defer finished()
+	rq := runningQueryFor(ctx)
+	if !assert.NotNil(t, rq) {
+		return
+	}
+	rq.scanned(bytemap.New(map[string]interface{}{"dim": "x"}), []encoding.Sequence{make(encoding.Sequence, 10)})
+	rq.startPartitions(2)
+	partitionRows := int64(5)
+	rq.trackPartition(1, &partitionRows)
+	rq.finishPartition(1)
+
+	ctx2, finished2 := db.RegisterQuery(context.Background(), "", QueryOriginRPC, "SELECT * FROM b")
+
+	queries := db.RunningQueries()
+	if assert.Len(t, queries, 2) {
+		info := queries[0]
+		assert.Equal(t, "a", info.ID)
+		assert.Equal(t, QueryOriginWeb, info.Origin)
+		assert.Equal(t, "SELECT * FROM a", info.SQL)
+		assert.Equal(t, "standalone.1", info.Node)
+		assert.EqualValues(t, 6, info.RowsScanned)
+		assert.True(t, info.MemoryEstimate > 10)
+		assert.Equal(t, 2, info.NumPartitions)
+		assert.Equal(t, 1, info.FinishedPartitions)
+		assert.EqualValues(t, map[int]int64{1: 5}, info.RowsByPartition)
+		assert.NotEmpty(t, queries[1].ID, "Blank id should have been generated")
+	}
+
+	var rows []*core.FlatRow
+	_, err := (&showQueries{db, time.Now()}).Iterate(context.Background(), core.FieldsIgnored, func(row *core.FlatRow) (bool, error) {
+		rows = append(rows, row)
+		return true, nil
+	})
+	if assert.NoError(t, err) && assert.Len(t, rows, 2) {
+		assert.Equal(t, "a", rows[0].Key.Get("id"))
+		assert.Equal(t, "standalone.1", rows[0].Key.Get("node"))
+		assert.EqualValues(t, 6, rows[0].Values[1])
+	}
+
+	finished2()
+	assert.Error(t, ctx2.Err(), "Finishing should cancel context")
+	assert.Len(t, db.RunningQueries(), 1)
+
+	assert.False(t, db.CancelQuery("unknown"))
+	assert.True(t, db.CancelQuery("a"))
+	assert.Equal(t, context.Canceled, ctx.Err())
+	assert.Empty(t, db.RunningQueries())
+}
+
+func TestQueryInfoFor(t *testing.T) {
+	now := time.Now()
+	row := &core.FlatRow{
+		Key: bytemap.New(map[string]interface{}{
+			"id":     "a",
+			"sql":    "SELECT * FROM a",
+			"origin": QueryOriginCluster,
+			"node":   "follower.1.2",
+		}),
+		Values: []float64{2.5, 10, 100, 0, 0},
+	}
+	info := queryInfoFor(row, now)
+	if assert.NotNil(t, info) {
+		assert.Equal(t, "a", info.ID)
+		assert.Equal(t, "SELECT * FROM a", info.SQL)
+		assert.Equal(t, QueryOriginCluster, info.Origin)
+		assert.Equal(t, "follower.1.2", info.Node)
+		assert.Equal(t, now.Add(-2500*time.Millisecond), info.Start)
+		assert.EqualValues(t, 10, info.RowsScanned)
+		assert.EqualValues(t, 100, info.MemoryEstimate)
+	}
+
+	row.Values = row.Values[:2]
+	assert.Nil(t, queryInfoFor(row, now), "Row with missing values should be ignored")
+}
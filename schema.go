@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/getlantern/errors"
 	"github.com/getlantern/yaml"
 	"github.com/getlantern/zenodb/sql"
 )
@@ -69,7 +72,26 @@ func (db *DB) ApplySchemaFromFile(filename string) error {
 	return db.ApplySchema(schema)
 }
 
+// ApplySchema creates or alters tables to match the given schema and drops
+// tables that were in the previously applied schema but are no longer in the
+// given one. As a safeguard against accidentally truncated schema files, it
+// refuses to apply an empty schema or one that would drop every table, see
+// ForceApplySchema.
 func (db *DB) ApplySchema(_schema Schema) error {
+	db.schemaMx.Lock()
+	defer db.schemaMx.Unlock()
+	return db.applySchema(_schema, false)
+}
+
+// ForceApplySchema is like ApplySchema but also applies a schema that's empty
+// or would drop every table.
+func (db *DB) ForceApplySchema(_schema Schema) error {
+	db.schemaMx.Lock()
+	defer db.schemaMx.Unlock()
+	return db.applySchema(_schema, true)
+}
+
+func (db *DB) applySchema(_schema Schema, force bool) error {
 	schema := make(Schema, len(_schema))
 	// Convert all names in schema to lowercase
 	for name, opts := range _schema {
@@ -79,19 +101,64 @@ func (db *DB) ApplySchema(_schema Schema) error {
 
 	// Identify dependencies
 	var tables []*TableOpts
+	var views []*TableOpts
 	for name, opts := range schema {
 		if !opts.View {
 			tables = append(tables, opts)
 		} else {
+			views = append(views, opts)
 			dependsOn, err := sql.TableFor(opts.SQL)
 			if err != nil {
 				return fmt.Errorf("Unable to determine underlying table for view %v: %v", name, err)
 			}
-			table, found := schema[dependsOn]
-			if !found {
-				return fmt.Errorf("Table %v needed by view %v not found", name, dependsOn)
+			if schema[dependsOn] == nil {
+				if db.getTable(dependsOn) == nil || db.getTable(name) == nil {
+					return fmt.Errorf("Table %v needed by view %v not found", dependsOn, name)
+				}
+				// The view's table is being dropped, detach (drop) the view too
+				db.log.Debugf("Dropping view %v because its table %v is being dropped", name, dependsOn)
+				delete(schema, name)
 			}
-			table.dependencyOf = append(table.dependencyOf, opts)
+		}
+	}
+	for _, opts := range views {
+		if schema[opts.Name] == nil {
+			continue
+		}
+		dependsOn, _ := sql.TableFor(opts.SQL)
+		table := schema[dependsOn]
+		if table == nil {
+			return fmt.Errorf("Table %v needed by view %v not found", dependsOn, opts.Name)
+		}
+		table.dependencyOf = append(table.dependencyOf, opts)
+	}
+	// Remember the schema as specified, before applying it modifies the opts
+	applied := schema.copy()
+
+	// Drop tables that were in the previous schema but are no longer in this
+	// one, starting with views. Tables that weren't created from a schema (e.g.
+	// with CreateTable) are left alone.
+	existing := db.catalogTables()
+	var toDrop []*table
+	for i := len(existing) - 1; i >= 0; i-- {
+		t := existing[i]
+		if schema[t.Name] == nil && db.schema[t.Name] != nil {
+			toDrop = append(toDrop, t)
+		}
+	}
+	if !force && len(db.schema) > 0 {
+		if len(schema) == 0 {
+			return fmt.Errorf("Refusing to apply empty schema, which would drop %d tables", len(toDrop))
+		}
+		if len(toDrop) == len(existing) {
+			return fmt.Errorf("Refusing to apply schema that would drop every table")
+		}
+	}
+	for _, t := range toDrop {
+		db.log.Debugf("Dropping %v", t.Name)
+		err := db.dropTable(t)
+		if err != nil {
+			return fmt.Errorf("Error dropping table %v: %v", t.Name, err)
 		}
 	}
 	// Apply tables in order of dependencies
@@ -124,9 +191,176 @@ func (db *DB) ApplySchema(_schema Schema) error {
 		}
 	}
 
+	db.schema = applied
 	return nil
 }
 
+// Schema returns a copy of the most recently applied schema.
+func (db *DB) Schema() Schema {
+	db.schemaMx.Lock()
+	defer db.schemaMx.Unlock()
+	return db.schema.copy()
+}
+
+// ExecuteDDL executes a CREATE, ALTER or DROP statement (see sql.DDL) against
+// the current schema. If the database was configured with a SchemaFile, the
+// resulting schema is saved back to that file so that it's used on restart.
+//
+// Note that in a cluster, schema changes only apply to the node on which
+// they're executed.
+func (db *DB) ExecuteDDL(statement string) error {
+	if db.opts.ReadOnly {
+		return errors.New("Unable to change schema of read-only database")
+	}
+
+	ddl, err := sql.ParseDDL(statement)
+	if err != nil {
+		return err
+	}
+
+	db.schemaMx.Lock()
+	defer db.schemaMx.Unlock()
+
+	schema := db.schema.copy()
+	existing := schema[ddl.Name]
+	tableType := "Table"
+	if ddl.View {
+		tableType = "View"
+	}
+	switch ddl.Op {
+	case sql.DDLCreate:
+		if existing != nil {
+			return fmt.Errorf("%v %v already exists", tableType, ddl.Name)
+		}
+		opts, err := tableOptsFor(ddl, &TableOpts{View: ddl.View})
+		if err != nil {
+			return err
+		}
+		schema[ddl.Name] = opts
+	case sql.DDLAlter:
+		if existing == nil || existing.View != ddl.View {
+			return fmt.Errorf("%v %v not found", tableType, ddl.Name)
+		}
+		opts, err := tableOptsFor(ddl, existing)
+		if err != nil {
+			return err
+		}
+		schema[ddl.Name] = opts
+	case sql.DDLDrop:
+		if existing == nil || existing.View != ddl.View {
+			return fmt.Errorf("%v %v not found", tableType, ddl.Name)
+		}
+		delete(schema, ddl.Name)
+	}
+
+	db.log.Debugf("Executing %v", statement)
+	// An explicit DROP is allowed to drop the last table
+	err = db.applySchema(schema.copy(), ddl.Op == sql.DDLDrop)
+	if err != nil {
+		return err
+	}
+
+	if db.opts.SchemaFile == "" {
+		db.log.Debug("No SchemaFile configured, not saving schema")
+		return nil
+	}
+	return db.saveSchema(db.opts.SchemaFile, db.schema)
+}
+
+// tableOptsFor builds TableOpts from the given DDL statement, using the given
+// opts as a starting point.
+func tableOptsFor(ddl *sql.DDL, opts *TableOpts) (*TableOpts, error) {
+	result := opts.copy()
+	result.Name = ddl.Name
+	result.SQL = ddl.SQL
+	for key, value := range ddl.Options {
+		var err error
+		switch key {
+		case "retentionperiod":
+			result.RetentionPeriod, err = sql.ParseDuration(value)
+		case "backfill":
+			result.Backfill, err = sql.ParseDuration(value)
+		case "segmentduration":
+			result.SegmentDuration, err = sql.ParseDuration(value)
+		case "coldafter":
+			result.ColdAfter, err = sql.ParseDuration(value)
+		case "minflushlatency":
+			result.MinFlushLatency, err = sql.ParseDuration(value)
+		case "maxflushlatency":
+			result.MaxFlushLatency, err = sql.ParseDuration(value)
+		case "partitionby":
+			result.PartitionBy = nil
+			for _, dim := range strings.Split(value, ",") {
+				dim = strings.TrimSpace(dim)
+				if dim != "" {
+					result.PartitionBy = append(result.PartitionBy, dim)
+				}
+			}
+		case "virtual":
+			result.Virtual, err = strconv.ParseBool(value)
+		default:
+			err = fmt.Errorf("unknown option")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Invalid option %v = %v for %v: %v", key, value, ddl.Name, err)
+		}
+	}
+	return result, nil
+}
+
+// saveSchema atomically replaces the given schema file with the given schema.
+func (db *DB) saveSchema(filename string, schema Schema) error {
+	b, err := yaml.Marshal(schema)
+	if err != nil {
+		return fmt.Errorf("Unable to marshal schema: %v", err)
+	}
+
+	// Write to a temp file in the same directory so that we can rename it
+	out, err := ioutil.TempFile(filepath.Dir(filename), ".zenodbschema")
+	if err != nil {
+		return fmt.Errorf("Unable to create temp file for schema: %v", err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	_, err = out.Write(b)
+	if err != nil {
+		return fmt.Errorf("Unable to write schema: %v", err)
+	}
+	err = out.Sync()
+	if err != nil {
+		return fmt.Errorf("Unable to sync schema: %v", err)
+	}
+	err = out.Close()
+	if err != nil {
+		return fmt.Errorf("Unable to close schema: %v", err)
+	}
+	err = os.Rename(out.Name(), filename)
+	if err != nil {
+		return fmt.Errorf("Unable to replace schema file: %v", err)
+	}
+	db.log.Debugf("Saved schema to %v", filename)
+	return nil
+}
+
+func (schema Schema) copy() Schema {
+	result := make(Schema, len(schema))
+	for name, opts := range schema {
+		result[name] = opts.copy()
+	}
+	return result
+}
+
+func (opts *TableOpts) copy() *TableOpts {
+	result := *opts
+	if opts.PartitionBy != nil {
+		result.PartitionBy = make([]string, len(opts.PartitionBy))
+		copy(result.PartitionBy, opts.PartitionBy)
+	}
+	result.dependencyOf = nil
+	return &result
+}
+
 type byDependency struct {
 	opts  []*TableOpts
 	names []string
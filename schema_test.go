@@ -0,0 +1,166 @@
+package zenodb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/getlantern/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecuteDDL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "zenodbschematest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	schemaFile := filepath.Join(tmpDir, "schema.yaml")
+	err = ioutil.WriteFile(schemaFile, []byte(`
+table_a:
+  retentionperiod: 1h
+  sql: SELECT SUM(x) AS x FROM inbound GROUP BY a, period(1m)
+`), 0644)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	db, err := NewDB(&DBOpts{
+		Dir:         filepath.Join(tmpDir, "data"),
+		SchemaFile:  schemaFile,
+		VirtualTime: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	assert.Error(t, db.ExecuteDDL("CREATE TABLE table_a WITH (retentionperiod = 1h) AS SELECT x FROM inbound GROUP BY a, period(1m)"), "Creating existing table should fail")
+	assert.Error(t, db.ExecuteDDL("ALTER VIEW table_a AS SELECT x FROM table_a"), "Altering table as view should fail")
+	assert.Error(t, db.ExecuteDDL("CREATE TABLE table_b WITH (bogus = 1) AS SELECT x FROM inbound GROUP BY a, period(1m)"), "Unknown option should fail")
+	assert.Error(t, db.ExecuteDDL("DROP TABLE table_c"), "Dropping unknown table should fail")
+
+	if !assert.NoError(t, db.ExecuteDDL("CREATE TABLE table_b WITH (retentionperiod = 2h, partitionby = 'a') AS SELECT SUM(y) AS y FROM inbound GROUP BY a, period(1m)")) {
+		return
+	}
+	if !assert.NoError(t, db.ExecuteDDL("ALTER TABLE table_a AS SELECT SUM(x) AS x, SUM(z) AS z FROM inbound GROUP BY a, period(1m)")) {
+		return
+	}
+	if !assert.NoError(t, db.ExecuteDDL("CREATE VIEW view_b AS SELECT * FROM table_b")) {
+		return
+	}
+	assert.NotNil(t, db.getTable("table_b"))
+	assert.NotNil(t, db.getTable("view_b"))
+	assert.Len(t, db.getTable("table_a").getFields(), 3, "Altered table should have new field (plus _points)")
+	assert.NoError(t, db.ExecuteDDL("DROP VIEW view_b"))
+
+	b, err := ioutil.ReadFile(schemaFile)
+	if !assert.NoError(t, err) {
+		return
+	}
+	var saved Schema
+	if !assert.NoError(t, yaml.Unmarshal(b, &saved)) {
+		return
+	}
+	assert.Len(t, saved, 2)
+	if assert.NotNil(t, saved["table_b"]) {
+		assert.Equal(t, 2*time.Hour, saved["table_b"].RetentionPeriod)
+		assert.Equal(t, []string{"a"}, saved["table_b"].PartitionBy)
+	}
+	if assert.NotNil(t, saved["table_a"]) {
+		assert.Equal(t, time.Hour, saved["table_a"].RetentionPeriod)
+		assert.Contains(t, saved["table_a"].SQL, "SUM(z)")
+	}
+	assert.Equal(t, saved["table_b"].SQL, db.Schema()["table_b"].SQL)
+}
+
+func TestDropTable(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "zenodbdroptest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	dataDir := filepath.Join(tmpDir, "data")
+	db, err := NewDB(&DBOpts{
+		Dir:         dataDir,
+		VirtualTime: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	tableOpts := func(name string, view bool, sql string) *TableOpts {
+		return &TableOpts{Name: name, View: view, RetentionPeriod: time.Hour, SQL: sql}
+	}
+	if !assert.NoError(t, db.ApplySchema(Schema{
+		"table_a": tableOpts("table_a", false, "SELECT SUM(x) AS x FROM inbound GROUP BY a, period(1m)"),
+		"view_a":  tableOpts("view_a", true, "SELECT * FROM table_a GROUP BY period(5m)"),
+		"table_b": tableOpts("table_b", false, "SELECT SUM(y) AS y FROM inbound GROUP BY b, period(1m)"),
+	})) {
+		return
+	}
+	tableA := db.getTable("table_a")
+	if !assert.NotNil(t, tableA) {
+		return
+	}
+	assert.NotNil(t, db.getTable("view_a"))
+
+	assert.Error(t, db.ApplySchema(Schema{
+		"view_c": tableOpts("view_c", true, "SELECT * FROM table_c"),
+	}), "New view on unknown table should fail")
+	assert.NotNil(t, db.getTable("table_a"), "Failed schema should not drop anything")
+
+	assert.Error(t, db.ApplySchema(Schema{}), "Empty schema should be refused")
+	assert.Error(t, db.ApplySchema(Schema{
+		"table_c": tableOpts("table_c", false, "SELECT SUM(z) AS z FROM inbound GROUP BY c, period(1m)"),
+	}), "Schema that drops every table should be refused")
+	assert.NotNil(t, db.getTable("table_a"), "Refused schema should not drop anything")
+	assert.NotNil(t, db.getTable("table_b"), "Refused schema should not drop anything")
+
+	// Tables that weren't created from the schema aren't dropped by it
+	if !assert.NoError(t, db.CreateTable(tableOpts("table_d", false, "SELECT SUM(d) AS d FROM inbound GROUP BY d, period(1m)"))) {
+		return
+	}
+
+	// Removing table_a from the schema drops it along with its view
+	if !assert.NoError(t, db.ApplySchema(Schema{
+		"view_a":  tableOpts("view_a", true, "SELECT * FROM table_a GROUP BY period(5m)"),
+		"table_b": tableOpts("table_b", false, "SELECT SUM(y) AS y FROM inbound GROUP BY b, period(1m)"),
+	})) {
+		return
+	}
+	assert.Nil(t, db.getTable("table_a"))
+	assert.Nil(t, db.getTable("view_a"))
+	assert.NotNil(t, db.getTable("table_b"))
+	assert.NotNil(t, db.getTable("table_d"), "Table not in previous schema should not have been dropped")
+	assert.Len(t, db.Schema(), 1)
+	assert.True(t, tableA.isDropped())
+	_, err = tableA.rowStore.iterate(context.Background(), tableA.getFields(), true, time.Time{}, time.Time{}, nil)
+	assert.Error(t, err, "Iterating dropped table should fail")
+
+	_, err = os.Stat(filepath.Join(dataDir, "table_a"))
+	assert.True(t, os.IsNotExist(err), "Data for dropped table should have been moved")
+	archived, err := ioutil.ReadDir(filepath.Join(dataDir, droppedDir))
+	if assert.NoError(t, err) {
+		assert.Len(t, archived, 2, "table_a and view_a should have been archived")
+	}
+
+	// Dropped tables can be recreated from scratch
+	assert.NoError(t, db.ApplySchema(Schema{
+		"table_a": tableOpts("table_a", false, "SELECT SUM(x) AS x FROM inbound GROUP BY a, period(1m)"),
+		"table_b": tableOpts("table_b", false, "SELECT SUM(y) AS y FROM inbound GROUP BY b, period(1m)"),
+	}))
+	assert.NotNil(t, db.getTable("table_a"))
+
+	// Forcing allows dropping everything in the schema
+	assert.NoError(t, db.ForceApplySchema(Schema{}))
+	assert.Nil(t, db.getTable("table_a"))
+	assert.Nil(t, db.getTable("table_b"))
+	assert.NotNil(t, db.getTable("table_d"))
+}
@@ -0,0 +1,812 @@
+package zenodb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/oxtoacart/emsort"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/errors"
+	"github.com/getlantern/wal"
+	"github.com/getlantern/zenodb/common"
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/encoding"
+)
+
+const (
+	// DefaultSegmentDuration is the default time range covered by each segment
+	// of a table.
+	DefaultSegmentDuration = 24 * time.Hour
+
+	manifestFilename  = "manifest.json"
+	segmentFilePrefix = "segment_"
+	legacyFilePrefix  = "filestore_"
+
+	// compactionThreshold is the number of segments of similar size in a
+	// partition at which they get compacted even if the partition is still
+	// receiving new data.
+	compactionThreshold = 4
+	compactionInterval  = 1 * time.Minute
+
+	// compactionSizeRatio is how much larger than the smallest segment of a
+	// size tier other segments in that tier may be.
+	compactionSizeRatio = 2
+
+	// compactionMinTierSize is the size below which all segments are considered
+	// to be in the same size tier.
+	compactionMinTierSize = 1024 * 1024
+
+	// defaultSortMemory is the memory used for sorting legacy files during
+	// migration if the database doesn't have a memory limit.
+	defaultSortMemory = 100 * 1024 * 1024
+)
+
+// segment is an immutable data file that holds the rows of a single time
+// partition, sorted by key. Every flush writes new segments for the partitions
+// that received data, and compaction merges the segments of a partition into
+// one.
+type segment struct {
+	// Name is the name of the segment's file in the table's directory
+	Name string
+	// Start and End delimit the time partition covered by this segment. The
+	// segment only contains periods from Start (inclusive) to End (exclusive).
+	Start time.Time
+	End   time.Time
+	// Size is the size of the segment's file in bytes
+	Size int64
+	// Rows is the number of rows (unique keys) in the segment
+	Rows int
+	// HighWaterMark is the timestamp of the most recent data in the segment
+	HighWaterMark int64
+	// Distinct holds serialized estimates of the number of distinct values of
+	// each dimension in the segment
+	Distinct map[string][]byte
+	// Cold indicates that the segment has been offloaded to the database's
+	// ColdStore and no longer lives in the table's directory
+	Cold bool
+	// ColdKey is the key under which a cold segment is kept in the ColdStore
+	ColdKey string `json:",omitempty"`
+	// tmpName is where the segment's file lives before it's committed
+	tmpName string
+}
+
+// overlaps determines whether this segment holds data between asOf and until.
+// A zero asOf or until means that the range is unbounded on that side.
+func (seg *segment) overlaps(asOf time.Time, until time.Time) bool {
+	return (asOf.IsZero() || seg.End.After(asOf)) && (until.IsZero() || !seg.Start.After(until))
+}
+
+// manifest lists the segments that make up a table's data on disk along with
+// the WAL offsets up to which that data is durable. Since the manifest is
+// replaced atomically, flushes and compactions take effect atomically too.
+type manifest struct {
+	Segments        []*segment
+	OffsetsBySource common.OffsetsBySource
+	// ObsoleteColdSegments are cold segments that have been removed from the
+	// table but not yet deleted from the ColdStore, so that they get deleted
+	// even if the database is restarted in the meantime.
+	ObsoleteColdSegments []*segment `json:",omitempty"`
+}
+
+func readManifest(dir string) (*manifest, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	m := &manifest{}
+	err = json.Unmarshal(b, m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func writeManifest(dir string, m *manifest) error {
+	out, err := ioutil.TempFile("", "nextmanifest")
+	if err != nil {
+		return errors.New("Unable to create manifest file: %v", err)
+	}
+	defer out.Close()
+
+	err = json.NewEncoder(out).Encode(m)
+	if err != nil {
+		return errors.New("Unable to write manifest: %v", err)
+	}
+	err = out.Sync()
+	if err != nil {
+		return errors.New("Unable to sync manifest file: %v", err)
+	}
+	err = out.Close()
+	if err != nil {
+		return errors.New("Unable to close manifest file: %v", err)
+	}
+
+	return os.Rename(out.Name(), filepath.Join(dir, manifestFilename))
+}
+
+// commit atomically updates the manifest by adding the given (newly written)
+// segments and removing the named ones. If offsetsBySource is nil, the
+// manifest keeps its current offsets. Once the manifest has been written, the
+// resulting fileStore becomes visible to iterations along with the given
+// memstore, if any. Files of removed segments (including cold ones) are deleted
+// by removeOldFiles once they're no longer being iterated over.
+func (rs *rowStore) commit(add []*segment, remove map[string]bool, offsetsBySource common.OffsetsBySource, fields core.Fields, ms *memstore) error {
+	rs.manifestMx.Lock()
+	defer rs.manifestMx.Unlock()
+
+	rs.mx.RLock()
+	fs := rs.fileStore
+	obsolete := append([]*segment{}, rs.obsoleteColdSegments...)
+	rs.mx.RUnlock()
+
+	segments := make([]*segment, 0, len(fs.segments)+len(add))
+	for _, seg := range fs.segments {
+		if !remove[seg.Name] {
+			segments = append(segments, seg)
+		} else if seg.Cold {
+			obsolete = append(obsolete, seg)
+		}
+	}
+	for _, seg := range add {
+		err := os.Rename(seg.tmpName, filepath.Join(rs.opts.dir, seg.Name))
+		if err != nil {
+			return errors.New("Unable to move segment %v into place: %v", seg.Name, err)
+		}
+		segments = append(segments, seg)
+	}
+	// segments sort by partition and then by the order in which they were
+	// written
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].Name < segments[j].Name
+	})
+
+	if offsetsBySource == nil {
+		offsetsBySource = fs.offsetsBySource
+	}
+	copyOfOffsets := make(common.OffsetsBySource, len(offsetsBySource))
+	for source, offset := range offsetsBySource {
+		copyOfOffsets[source] = offset
+	}
+	err := writeManifest(rs.opts.dir, &manifest{Segments: segments, OffsetsBySource: copyOfOffsets, ObsoleteColdSegments: obsolete})
+	if err != nil {
+		return err
+	}
+
+	if fields == nil {
+		fields = fs.fields
+	}
+	rs.mx.Lock()
+	rs.fileStore = &fileStore{t: rs.t, rs: rs, fields: fields, segments: segments, offsetsBySource: copyOfOffsets}
+	if ms != nil {
+		rs.memStore = ms
+	}
+	rs.obsoleteColdSegments = obsolete
+	rs.mx.Unlock()
+	return nil
+}
+
+// partitionStart returns the start of the partition of the given duration that
+// contains the given timestamp (in nanoseconds since the epoch).
+func partitionStart(ts int64, duration time.Duration) int64 {
+	d := int64(duration)
+	start := ts - ts%d
+	if ts < 0 && ts%d != 0 {
+		start -= d
+	}
+	return start
+}
+
+// splitRow splits the columns of a row (holding data for the given fields)
+// into one row per partition, keyed to the start of the partition. Data from
+// before truncateBefore is dropped. The columns of the resulting rows don't
+// share memory with the original columns unless the original data falls
+// within a single partition.
+func (rs *rowStore) splitRow(fields core.Fields, columns []encoding.Sequence, truncateBefore time.Time) map[int64][]encoding.Sequence {
+	result := make(map[int64][]encoding.Sequence, 1)
+	resolution := int64(rs.t.Resolution)
+	for i, seq := range columns {
+		if i >= len(fields) {
+			break
+		}
+		width := fields[i].Expr.EncodedWidth()
+		seq = seq.Truncate(width, rs.t.Resolution, truncateBefore, time.Time{})
+		numPeriods := seq.NumPeriods(width)
+		if numPeriods == 0 {
+			continue
+		}
+		until := seq.UntilInt()
+		data := seq[encoding.Width64bits:]
+		for p := 0; p < numPeriods; {
+			ts := until - int64(p)*resolution
+			start := partitionStart(ts, rs.opts.segmentDuration)
+			// all periods from ts back to the start of the partition belong to the
+			// same partition
+			n := int((ts-start)/resolution) + 1
+			if p+n > numPeriods {
+				n = numPeriods - p
+			}
+			var part encoding.Sequence
+			if p == 0 && n == numPeriods {
+				// everything is in one partition, no need to copy
+				part = seq
+			} else {
+				part = make(encoding.Sequence, encoding.Width64bits+n*width)
+				part.SetUntil(encoding.TimeFromInt(ts))
+				copy(part[encoding.Width64bits:], data[p*width:(p+n)*width])
+			}
+			row := result[start]
+			if row == nil {
+				row = make([]encoding.Sequence, len(fields))
+				result[start] = row
+			}
+			row[i] = part
+			p += n
+		}
+	}
+	return result
+}
+
+// segmentWriter writes rows to a new segment. Unless the segmentWriter sorts
+// its output, rows must be written in order of their keys.
+type segmentWriter struct {
+	rs             *rowStore
+	fields         core.Fields
+	seg            *segment
+	out            *os.File
+	sout           *snappy.Writer
+	cout           io.WriteCloser
+	sorted         bool
+	truncateBefore time.Time
+	distinct       distinctCounter
+}
+
+// newSegmentWriter creates a segmentWriter for the partition from start to
+// end. If sortMemory is greater than 0, the segmentWriter sorts rows by key
+// using an external merge sort that uses up to sortMemory bytes of memory.
+func (rs *rowStore) newSegmentWriter(fields core.Fields, start time.Time, end time.Time, offsetsBySource common.OffsetsBySource, sortMemory int) (*segmentWriter, error) {
+	out, err := ioutil.TempFile("", "nextsegment")
+	if err != nil {
+		return nil, errors.New("Unable to create temp file for segment: %v", err)
+	}
+	sout, err := rs.t.createOutWriter(out, fields, offsetsBySource)
+	if err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, errors.New("Unable to create out writer: %v", err)
+	}
+
+	sw := &segmentWriter{
+		rs:     rs,
+		fields: fields,
+		seg: &segment{
+			// Note - we left-pad the unix nano values to the widest possible length
+			// to ensure lexicographical sort matches time-based sort (e.g. on
+			// directory listing).
+			Name:    fmt.Sprintf("%v%020d_%020d_%d.dat", segmentFilePrefix, start.UnixNano(), atomic.AddInt64(&rs.segmentSeq, 1), CurrentFileVersion),
+			Start:   start,
+			End:     end,
+			tmpName: out.Name(),
+		},
+		out:            out,
+		sout:           sout,
+		cout:           sout,
+		truncateBefore: rs.t.truncateBefore(),
+		distinct:       make(distinctCounter),
+	}
+
+	if sortMemory > 0 {
+		cout, sortErr := emsort.New(sout, readRow, lessByKey, sortMemory)
+		if sortErr != nil {
+			sw.discard()
+			return nil, errors.New("Unable to create sorted writer: %v", sortErr)
+		}
+		sw.cout = cout
+		sw.sorted = true
+	}
+
+	return sw, nil
+}
+
+// readRow reads a single row from the given reader, for use with emsort
+func readRow(r io.Reader) ([]byte, error) {
+	rowLength := uint64(0)
+	readErr := binary.Read(r, encoding.Binary, &rowLength)
+	if readErr != nil {
+		return nil, readErr
+	}
+	row := make([]byte, rowLength)
+	encoding.Binary.PutUint64(row, rowLength)
+	_, err := io.ReadFull(r, row[encoding.Width64bits:])
+	return row, err
+}
+
+// lessByKey orders encoded rows by their keys
+func lessByKey(a []byte, b []byte) bool {
+	return bytes.Compare(keyOf(a), keyOf(b)) < 0
+}
+
+func keyOf(row []byte) []byte {
+	row = row[encoding.Width64bits:]
+	keyLength, row := encoding.ReadInt16(row)
+	return row[:keyLength]
+}
+
+// write writes a row to the segment, truncating data from before the table's
+// retention period. Rows without any remaining data are skipped.
+func (sw *segmentWriter) write(key bytemap.ByteMap, columns []encoding.Sequence) error {
+	hasActiveSequence := false
+	for i, seq := range columns {
+		seq = seq.Truncate(sw.fields[i].Expr.EncodedWidth(), sw.rs.t.Resolution, sw.truncateBefore, time.Time{})
+		columns[i] = seq
+		if len(seq) > 0 {
+			hasActiveSequence = true
+			if ts := seq.UntilInt(); ts > sw.seg.HighWaterMark {
+				sw.seg.HighWaterMark = ts
+			}
+		}
+	}
+
+	if !hasActiveSequence {
+		// all encoding.Sequences expired, skip key
+		return nil
+	}
+
+	_, err := sw.cout.Write(encodeRow(key, columns))
+	if err != nil {
+		return errors.New("Unable to write row to segment: %v", err)
+	}
+	sw.distinct.add(key)
+	sw.seg.Rows++
+	return nil
+}
+
+// finish finishes writing the segment and returns it, ready to be committed.
+// If no rows were written, the segment is discarded and finish returns nil.
+func (sw *segmentWriter) finish() (*segment, error) {
+	if sw.sorted {
+		// closing the sorted writer also flushes and closes the snappy writer
+		err := sw.cout.Close()
+		if err != nil {
+			sw.discard()
+			return nil, errors.New("Unable to sort segment: %v", err)
+		}
+	} else {
+		// manually flush to the underlying snappy writer, since snappy's own Close() function doesn't check the return value of flush
+		err := sw.sout.Flush()
+		if err != nil {
+			sw.discard()
+			return nil, errors.New("Unable to flush segment: %v", err)
+		}
+		err = sw.sout.Close()
+		if err != nil {
+			sw.discard()
+			return nil, errors.New("Unable to close out writer: %v", err)
+		}
+	}
+
+	if sw.seg.Rows == 0 {
+		sw.discard()
+		return nil, nil
+	}
+
+	err := sw.out.Sync()
+	if err != nil {
+		sw.discard()
+		return nil, errors.New("Unable to sync segment: %v", err)
+	}
+	fi, err := sw.out.Stat()
+	if err != nil {
+		sw.rs.t.log.Errorf("Unable to stat segment to get size: %v", err)
+	} else {
+		sw.seg.Size = fi.Size()
+	}
+	err = sw.out.Close()
+	if err != nil {
+		sw.discard()
+		return nil, errors.New("Unable to close segment: %v", err)
+	}
+
+	sw.seg.Distinct = sw.distinct.marshal()
+	return sw.seg, nil
+}
+
+// discard abandons the segment and removes its file
+func (sw *segmentWriter) discard() {
+	sw.out.Close()
+	os.Remove(sw.out.Name())
+}
+
+func discardSegments(segments []*segment) {
+	for _, seg := range segments {
+		os.Remove(seg.tmpName)
+	}
+}
+
+type partitionRow struct {
+	key     bytemap.ByteMap
+	columns []encoding.Sequence
+}
+
+// writeSegments writes the contents of the given memstore to new segments, one
+// per partition that has data. The segments still need to be committed.
+func (rs *rowStore) writeSegments(ms *memstore) ([]*segment, error) {
+	truncateBefore := rs.t.truncateBefore()
+	rowsByPartition := make(map[int64][]*partitionRow)
+	ms.tree.Walk(time.Now().UnixNano(), func(key []byte, columns []encoding.Sequence) (bool, bool, error) {
+		for start, partColumns := range rs.splitRow(ms.fields, columns, truncateBefore) {
+			rowsByPartition[start] = append(rowsByPartition[start], &partitionRow{bytemap.ByteMap(key), partColumns})
+		}
+		return true, true, nil
+	})
+
+	starts := make([]int64, 0, len(rowsByPartition))
+	for start := range rowsByPartition {
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i] < starts[j]
+	})
+
+	segments := make([]*segment, 0, len(starts))
+	for _, _start := range starts {
+		rows := rowsByPartition[_start]
+		sort.Slice(rows, func(i, j int) bool {
+			return bytes.Compare(rows[i].key, rows[j].key) < 0
+		})
+		start := encoding.TimeFromInt(_start)
+		sw, err := rs.newSegmentWriter(ms.fields, start, start.Add(rs.opts.segmentDuration), ms.offsetsBySource, 0)
+		if err != nil {
+			discardSegments(segments)
+			return nil, err
+		}
+		for _, row := range rows {
+			err = sw.write(row.key, row.columns)
+			if err != nil {
+				sw.discard()
+				discardSegments(segments)
+				return nil, err
+			}
+		}
+		seg, err := sw.finish()
+		if err != nil {
+			discardSegments(segments)
+			return nil, err
+		}
+		if seg != nil {
+			segments = append(segments, seg)
+		}
+	}
+
+	return segments, nil
+}
+
+// segmentsByPartition groups the segments of this fileStore by partition
+func (fs *fileStore) segmentsByPartition() [][]*segment {
+	var result [][]*segment
+	var current []*segment
+	for _, seg := range fs.segments {
+		if len(current) > 0 && (!seg.Start.Equal(current[0].Start) || !seg.End.Equal(current[0].End)) {
+			result = append(result, current)
+			current = nil
+		}
+		current = append(current, seg)
+	}
+	if len(current) > 0 {
+		result = append(result, current)
+	}
+	return result
+}
+
+// compactSegments periodically compacts the table's segments
+func (rs *rowStore) compactSegments(stop <-chan interface{}) {
+	ticker := time.NewTicker(compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			rs.t.log.Debug("Stop compacting segments")
+			return
+		case <-ticker.C:
+			rs.compact(stop)
+		}
+	}
+}
+
+// compact merges segments using size-tiered compaction. The segments of each
+// partition are grouped into tiers of similar size and every tier that has
+// accumulated compactionThreshold segments is merged into a single segment,
+// which usually lands in the next tier up. This way, the data in a partition is
+// rewritten a logarithmic rather than linear number of times as it grows, and
+// large compacted segments are left alone while new data keeps coming in.
+// Once a partition has ended, it won't receive much more data, so any tier
+// with more than one segment gets merged.
+func (rs *rowStore) compact(stop <-chan interface{}) {
+	rs.mx.RLock()
+	fs := rs.fileStore
+	rs.mx.RUnlock()
+
+	now := rs.t.db.clock.Now()
+	truncateBefore := rs.t.truncateBefore()
+	for _, segments := range fs.segmentsByPartition() {
+		if len(segments) < 2 {
+			continue
+		}
+		if segments[0].expired(truncateBefore) {
+			// will be dropped by sweepRetention, no point in compacting it
+			continue
+		}
+		threshold := compactionThreshold
+		if !segments[0].End.After(now) {
+			threshold = 2
+		}
+		for _, tier := range sizeTiers(segments) {
+			if len(tier) < threshold {
+				continue
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			err := rs.compactPartition(fs, tier)
+			if err != nil {
+				rs.t.log.Errorf("Unable to compact %d segments starting at %v: %v", len(tier), tier[0].Start, err)
+			}
+		}
+	}
+}
+
+// sizeTiers groups the given segments into tiers of segments with similar
+// sizes, from smallest to largest. A segment belongs to the same tier as the
+// smallest segment in that tier if it's at most compactionSizeRatio times as
+// large (segments smaller than compactionMinTierSize count as being of that
+// size). Within each tier, segments remain in their original order.
+func sizeTiers(segments []*segment) [][]*segment {
+	sorted := make([]*segment, len(segments))
+	copy(sorted, segments)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Size < sorted[j].Size
+	})
+
+	var tiers [][]*segment
+	var current []*segment
+	tierSize := int64(0)
+	for _, seg := range sorted {
+		if len(current) > 0 && seg.Size > tierSize*compactionSizeRatio {
+			tiers = append(tiers, current)
+			current = nil
+		}
+		if len(current) == 0 {
+			tierSize = seg.Size
+			if tierSize < compactionMinTierSize {
+				tierSize = compactionMinTierSize
+			}
+		}
+		current = append(current, seg)
+	}
+	if len(current) > 0 {
+		tiers = append(tiers, current)
+	}
+
+	for _, tier := range tiers {
+		sort.Slice(tier, func(i, j int) bool {
+			return tier[i].Name < tier[j].Name
+		})
+	}
+	return tiers
+}
+
+// compactPartition merges the given segments (which all belong to the same
+// partition) into a single new segment. Other segments of the partition are
+// left untouched.
+func (rs *rowStore) compactPartition(fs *fileStore, segments []*segment) error {
+	start := time.Now()
+	sw, err := rs.newSegmentWriter(fs.fields, segments[0].Start, segments[0].End, fs.offsetsBySource, 0)
+	if err != nil {
+		return err
+	}
+
+	toCompact := &fileStore{t: rs.t, rs: rs, fields: fs.fields, segments: segments}
+	_, err = toCompact.iterate(fs.fields, nil, time.Time{}, time.Time{}, func(key bytemap.ByteMap, columns []encoding.Sequence) (bool, error) {
+		return true, sw.write(key, columns)
+	})
+	if err != nil {
+		sw.discard()
+		return err
+	}
+	seg, err := sw.finish()
+	if err != nil {
+		return err
+	}
+
+	var add []*segment
+	if seg != nil {
+		add = append(add, seg)
+	}
+	remove := make(map[string]bool, len(segments))
+	for _, seg := range segments {
+		remove[seg.Name] = true
+	}
+	err = rs.commit(add, remove, nil, nil, nil)
+	if err != nil {
+		discardSegments(add)
+		return err
+	}
+
+	rows := 0
+	if seg != nil {
+		rows = seg.Rows
+	}
+	rs.t.log.Debugf("Compacted %d segments starting at %v into %d rows in %v", len(segments), segments[0].Start, rows, time.Now().Sub(start))
+	return nil
+}
+
+// migrateLegacyFiles creates the manifest for a table whose data is still
+// stored the way it was before segments were introduced, i.e. in a single
+// filestore file that's rewritten on every flush, plus an offset file. The
+// most recent readable filestore file is split into segments. For new tables,
+// this simply creates an empty manifest.
+func (rs *rowStore) migrateLegacyFiles() error {
+	t := rs.t
+	files, err := listRegularFiles(rs.opts.dir)
+	if err != nil {
+		return errors.New("Unable to read contents of directory: %v", err)
+	}
+
+	offsetsBySource := make(common.OffsetsBySource)
+	var segments []*segment
+	// files are sorted by name, in our case timestamp, so the last file in the
+	// list is the most recent. That's the one that we want.
+	for i := len(files) - 1; i >= 0; i-- {
+		filename := files[i].Name()
+		existingFileName := filepath.Join(rs.opts.dir, filename)
+		if filename == offsetFilename {
+			// This is an offset file, just read the offset
+			o, err := ioutil.ReadFile(existingFileName)
+			if err != nil {
+				t.log.Errorf("Unable to read offset: %v", err)
+			} else if len(o) < wal.OffsetSize {
+				t.log.Errorf("Offset file contents of wrong length: %v %d", existingFileName, len(o))
+			} else {
+				fileVersion := FileVersion_4
+				if len(o) > wal.OffsetSize {
+					// Before Version 5, we only stored a single offset. Since we have more than that,
+					// assume that this is at least Version 5.
+					fileVersion = FileVersion_5
+				}
+				offsetsBySource, _ = t.readOffsets(fileVersion, o)
+				t.log.Debugf("Read highWaterMarks from offset file: %v", offsetsBySource.TSString())
+			}
+			continue
+		}
+		if !strings.HasPrefix(filename, legacyFilePrefix) {
+			continue
+		}
+
+		// Get WAL offset
+		newOffsetsBySource, opened, err := t.readWALOffsets(existingFileName)
+		if err != nil {
+			if !opened {
+				return err
+			}
+			t.log.Errorf("Unable to read offset from existing file %v, assuming corrupted and will remove: %v", existingFileName, err)
+			rmErr := os.Remove(existingFileName)
+			if rmErr != nil {
+				return errors.New("Unable to remove corrupted file %v: %v", existingFileName, err)
+			}
+			continue
+		}
+
+		t.log.Debugf("Migrating %v to segments", existingFileName)
+		segments, err = rs.migrateLegacyFile(existingFileName, newOffsetsBySource)
+		if err != nil {
+			t.log.Errorf("Unable to migrate %v, marking as corrupted: %v", existingFileName, err)
+			df := &dataFile{t: t, fields: rs.fields, filename: existingFileName}
+			if markErr := df.markCorrupted(); markErr != nil {
+				return markErr
+			}
+			continue
+		}
+
+		offsetsBySource = newOffsetsBySource.Advance(offsetsBySource)
+		break
+	}
+
+	// commit leaves the legacy files in place, removeOldFiles takes care of them
+	return rs.commit(segments, nil, offsetsBySource, rs.fields, nil)
+}
+
+// migrateLegacyFile splits the given legacy filestore file into sorted segments.
+func (rs *rowStore) migrateLegacyFile(filename string, offsetsBySource common.OffsetsBySource) ([]*segment, error) {
+	start := time.Now()
+	df := &dataFile{t: rs.t, fields: rs.fields, filename: filename}
+	rr, err := df.open()
+	if err != nil {
+		return nil, err
+	}
+	defer rr.Close()
+
+	// Legacy files aren't sorted, so we sort each segment using a share of the
+	// available memory based on how many partitions we expect to find.
+	sortMemory := int(rs.t.db.maxMemoryBytes()) / 10
+	if sortMemory <= 0 {
+		sortMemory = defaultSortMemory
+	}
+	sortMemory /= int(rs.t.getRetentionPeriod()/rs.opts.segmentDuration) + 1
+
+	writers := make(map[int64]*segmentWriter)
+	discardWriters := func() {
+		for _, sw := range writers {
+			sw.discard()
+		}
+	}
+
+	truncateBefore := rs.t.truncateBefore()
+	fileToOut := rowMapper(rs.fields, rr.fileFields)
+	for {
+		key, fileColumns, err := rr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			discardWriters()
+			return nil, err
+		}
+		columns := make([]encoding.Sequence, len(rs.fields))
+		for i, seq := range fileColumns {
+			if len(seq) > 0 {
+				fileToOut(columns, i, seq)
+			}
+		}
+		for _start, partColumns := range rs.splitRow(rs.fields, columns, truncateBefore) {
+			sw := writers[_start]
+			if sw == nil {
+				partStart := encoding.TimeFromInt(_start)
+				sw, err = rs.newSegmentWriter(rs.fields, partStart, partStart.Add(rs.opts.segmentDuration), offsetsBySource, sortMemory)
+				if err != nil {
+					discardWriters()
+					return nil, err
+				}
+				writers[_start] = sw
+			}
+			err = sw.write(key, partColumns)
+			if err != nil {
+				discardWriters()
+				return nil, err
+			}
+		}
+	}
+
+	segments := make([]*segment, 0, len(writers))
+	for _start, sw := range writers {
+		delete(writers, _start)
+		seg, err := sw.finish()
+		if err != nil {
+			discardWriters()
+			discardSegments(segments)
+			return nil, err
+		}
+		if seg != nil {
+			segments = append(segments, seg)
+		}
+	}
+
+	rs.t.log.Debugf("Migrated %v to %d segments in %v", filename, len(segments), time.Now().Sub(start))
+	return segments, nil
+}
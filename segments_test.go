@@ -0,0 +1,401 @@
+package zenodb
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/golog"
+	"github.com/getlantern/vtime"
+	"github.com/getlantern/wal"
+	"github.com/getlantern/zenodb/common"
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/encoding"
+	"github.com/getlantern/zenodb/expr"
+	"github.com/getlantern/zenodb/sql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionStart(t *testing.T) {
+	assert.EqualValues(t, 4, partitionStart(5, 4))
+	assert.EqualValues(t, 4, partitionStart(4, 4))
+	assert.EqualValues(t, -4, partitionStart(-1, 4))
+	assert.EqualValues(t, -4, partitionStart(-4, 4))
+}
+
+func TestSplitRow(t *testing.T) {
+	res := time.Hour
+	rs := &rowStore{
+		t:    &table{TableOpts: &TableOpts{}, Query: sql.Query{Resolution: res}},
+		opts: &rowStoreOptions{segmentDuration: 4 * res},
+	}
+	e := expr.SUM("a")
+	fields := core.Fields{core.NewField("a", e)}
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var seq encoding.Sequence
+	for i := 0; i < 10; i++ {
+		ts := base.Add(time.Duration(i) * res)
+		seq = seq.Update(encoding.NewTSParams(ts, bytemap.NewFloat(map[string]float64{"a": float64(i + 1)})), nil, e, res, time.Time{})
+	}
+
+	parts := rs.splitRow(fields, []encoding.Sequence{seq}, time.Time{})
+	if !assert.Len(t, parts, 3) {
+		return
+	}
+	var merged encoding.Sequence
+	for start, columns := range parts {
+		part := columns[0]
+		partStart := encoding.TimeFromInt(start)
+		assert.False(t, part.AsOf(e.EncodedWidth(), res).Before(partStart.Add(-res)), "Data should not start before partition")
+		assert.True(t, part.Until().Before(partStart.Add(4*res)), "Data should not end after partition")
+		merged = merged.Merge(part, e, res, time.Time{})
+	}
+	for i := 0; i < 10; i++ {
+		val, found := merged.ValueAtTime(base.Add(time.Duration(i)*res), e, res)
+		assert.True(t, found)
+		assert.EqualValues(t, i+1, val, "Merged partitions should contain original data")
+	}
+
+	parts = rs.splitRow(fields, []encoding.Sequence{seq}, base.Add(7*res+time.Minute))
+	assert.Len(t, parts, 1, "Truncated data should fall within a single partition")
+}
+
+func TestSizeTiers(t *testing.T) {
+	mb := int64(1024 * 1024)
+	segments := []*segment{
+		{Name: "a", Size: 10},
+		{Name: "b", Size: 5 * mb},
+		{Name: "c", Size: 100},
+		{Name: "d", Size: 9 * mb},
+		{Name: "e", Size: 30 * mb},
+		{Name: "f", Size: 4 * mb},
+	}
+	var tiers [][]string
+	for _, tier := range sizeTiers(segments) {
+		var names []string
+		for _, seg := range tier {
+			names = append(names, seg.Name)
+		}
+		tiers = append(tiers, names)
+	}
+	assert.Equal(t, [][]string{{"a", "c"}, {"b", "f"}, {"d"}, {"e"}}, tiers)
+}
+
+// testRowStore is a rowStore for a table with a single SUM field "a" whose
+// background processes aren't running.
+type testRowStore struct {
+	*rowStore
+	test   *testing.T
+	e      expr.Expr
+	fields core.Fields
+	res    time.Duration
+}
+
+func newTestRowStore(t *testing.T, dir string, now time.Time) *testRowStore {
+	res := time.Hour
+	db := &DB{
+		opts:  &DBOpts{},
+		log:   golog.LoggerFor("segments_test"),
+		clock: vtime.NewVirtualClock(now),
+	}
+	tbl := &table{
+		TableOpts:       &TableOpts{Name: "mytable"},
+		Query:           sql.Query{Resolution: res},
+		db:              db,
+		log:             db.log,
+		retentionPeriod: int64(48 * time.Hour),
+	}
+	e := expr.SUM("a")
+	fields := core.Fields{core.NewField("a", e)}
+	rs := &rowStore{
+		t:                    tbl,
+		fields:               fields,
+		opts:                 &rowStoreOptions{dir: dir, segmentDuration: 6 * time.Hour},
+		iterationsInProgress: make(map[string]int),
+	}
+	rs.fileStore = &fileStore{t: tbl, rs: rs, fields: fields}
+	tbl.rowStore = rs
+	return &testRowStore{rowStore: rs, test: t, e: e, fields: fields, res: res}
+}
+
+// seq builds a sequence with the given values of a at the given times
+func (rs *testRowStore) seq(valsByTime map[time.Time]float64) encoding.Sequence {
+	var seq encoding.Sequence
+	for ts, val := range valsByTime {
+		seq = seq.Update(encoding.NewTSParams(ts, bytemap.NewFloat(map[string]float64{"a": val})), nil, rs.e, rs.res, time.Time{})
+	}
+	return seq
+}
+
+// writeSegment writes and commits a segment in the partition containing ts
+// with a single row for the given dim. If size is positive, the segment's size
+// is recorded as size.
+func (rs *testRowStore) writeSegment(dim string, ts time.Time, val float64, size int64) *segment {
+	start := ts.Truncate(rs.opts.segmentDuration)
+	sw, err := rs.newSegmentWriter(rs.fields, start, start.Add(rs.opts.segmentDuration), nil, 0)
+	if !assert.NoError(rs.test, err) {
+		return nil
+	}
+	if !assert.NoError(rs.test, sw.write(bytemap.New(map[string]interface{}{"d": dim}), []encoding.Sequence{rs.seq(map[time.Time]float64{ts: val})})) {
+		return nil
+	}
+	seg, err := sw.finish()
+	if !assert.NoError(rs.test, err) {
+		return nil
+	}
+	if size > 0 {
+		seg.Size = size
+	}
+	if !assert.NoError(rs.test, rs.commit([]*segment{seg}, nil, nil, rs.fields, nil)) {
+		return nil
+	}
+	return seg
+}
+
+// values iterates over the fileStore (merged with the given memstore) from
+// asOf and returns the values of a at the given times by dim.
+func (rs *testRowStore) values(ms *memstore, asOf time.Time, times ...time.Time) (map[string][]float64, error) {
+	result := make(map[string][]float64)
+	_, err := rs.fileStore.iterate(rs.fields, ms, asOf, time.Time{}, func(key bytemap.ByteMap, columns []encoding.Sequence) (bool, error) {
+		vals := make([]float64, 0, len(times))
+		for _, ts := range times {
+			val, _ := columns[0].ValueAtTime(ts, rs.e, rs.res)
+			vals = append(vals, val)
+		}
+		result[key.Get("d").(string)] = vals
+		return true, nil
+	})
+	return result, err
+}
+
+func segmentNames(segments []*segment) []string {
+	names := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		names = append(names, seg.Name)
+	}
+	return names
+}
+
+func TestCompact(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "segmentstest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Date(2017, time.January, 3, 0, 0, 0, 0, time.UTC)
+	rs := newTestRowStore(t, tmpDir, now)
+
+	// active partition with an already compacted large segment and 3 small ones
+	large := rs.writeSegment("x", now.Add(2*time.Hour), 10, 100*1024*1024)
+	for i := 0; i < 3; i++ {
+		rs.writeSegment("x", now.Add(time.Hour), 1, 0)
+	}
+	// ended partition with 2 small segments
+	rs.writeSegment("x", now.Add(-10*time.Hour), 5, 0)
+	rs.writeSegment("y", now.Add(-10*time.Hour), 6, 0)
+	if t.Failed() {
+		return
+	}
+
+	rs.compact(nil)
+	partitions := rs.fileStore.segmentsByPartition()
+	if !assert.Len(t, partitions, 2) {
+		return
+	}
+	assert.Len(t, partitions[0], 1, "Ended partition should have been compacted")
+	assert.Len(t, partitions[1], 4, "Active partition shouldn't have been compacted before reaching threshold")
+
+	rs.writeSegment("x", now.Add(time.Hour), 1, 0)
+	rs.compact(nil)
+	partitions = rs.fileStore.segmentsByPartition()
+	if !assert.Len(t, partitions, 2) {
+		return
+	}
+	assert.Len(t, partitions[0], 1)
+	if assert.Len(t, partitions[1], 2, "Small segments should have been compacted into one") {
+		assert.Contains(t, segmentNames(partitions[1]), large.Name, "Large segment should have been left alone")
+	}
+	assert.NotContains(t, rs.obsoleteFiles(), large.Name, "Large segment should not have been rewritten")
+
+	vals, err := rs.values(nil, time.Time{}, now.Add(-10*time.Hour), now.Add(time.Hour), now.Add(2*time.Hour))
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string][]float64{
+			"x": {5, 4, 10},
+			"y": {6, 0, 0},
+		}, vals, "Compaction should retain all data")
+	}
+	m, err := readManifest(tmpDir)
+	if assert.NoError(t, err) && assert.NotNil(t, m) {
+		assert.Equal(t, segmentNames(rs.fileStore.segments), segmentNames(m.Segments), "Manifest should reflect compaction")
+	}
+}
+
+func TestIterateSegments(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "segmentstest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Date(2017, time.January, 3, 0, 0, 0, 0, time.UTC)
+	rs := newTestRowStore(t, tmpDir, now)
+
+	old := rs.writeSegment("x", now.Add(-10*time.Hour), 1, 0)
+	rs.writeSegment("x", now.Add(-3*time.Hour), 2, 0)
+	rs.writeSegment("x", now.Add(-3*time.Hour), 3, 0)
+	rs.writeSegment("y", now.Add(-2*time.Hour), 4, 0)
+	if t.Failed() {
+		return
+	}
+	// remove the old segment's file so that iterating over it fails
+	if !assert.NoError(t, os.Remove(filepath.Join(tmpDir, old.Name))) {
+		return
+	}
+
+	ms := rs.newMemStore(nil)
+	ms.tree.Update(bytemap.New(map[string]interface{}{"d": "x"}), nil, encoding.NewTSParams(now.Add(-1*time.Hour), bytemap.NewFloat(map[string]float64{"a": 5})), nil)
+	ms.tree.Update(bytemap.New(map[string]interface{}{"d": "z"}), nil, encoding.NewTSParams(now.Add(-1*time.Hour), bytemap.NewFloat(map[string]float64{"a": 6})), nil)
+
+	vals, err := rs.values(ms, now.Add(-5*time.Hour), now.Add(-3*time.Hour), now.Add(-2*time.Hour), now.Add(-1*time.Hour))
+	if assert.NoError(t, err, "Segment outside of range should have been skipped") {
+		assert.Equal(t, map[string][]float64{
+			"x": {5, 0, 5},
+			"y": {0, 4, 0},
+			"z": {0, 0, 6},
+		}, vals, "Key should have been merged across segments and memstore")
+	}
+
+	_, err = rs.values(nil, time.Time{})
+	assert.Error(t, err, "Segment inside of range should have been read")
+}
+
+func TestMigrateLegacyFiles(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "segmentstest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Date(2017, time.January, 3, 0, 0, 0, 0, time.UTC)
+	rs := newTestRowStore(t, tmpDir, now)
+	offsets := common.OffsetsBySource{0: wal.NewOffsetForTS(now)}
+
+	// write a legacy filestore file with unsorted rows, one of which spans two
+	// partitions
+	out, err := os.Create(filepath.Join(tmpDir, fmt.Sprintf("%v%020d_%d.dat", legacyFilePrefix, now.UnixNano(), CurrentFileVersion)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	sout, err := rs.t.createOutWriter(out, rs.fields, offsets)
+	if !assert.NoError(t, err) {
+		return
+	}
+	rows := []struct {
+		dim string
+		seq encoding.Sequence
+	}{
+		{"y", rs.seq(map[time.Time]float64{now.Add(-3 * time.Hour): 3})},
+		{"x", rs.seq(map[time.Time]float64{now.Add(-10 * time.Hour): 1, now.Add(-3 * time.Hour): 2})},
+	}
+	for _, row := range rows {
+		_, err = sout.Write(encodeRow(bytemap.New(map[string]interface{}{"d": row.dim}), []encoding.Sequence{row.seq}))
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+	if !assert.NoError(t, sout.Close()) || !assert.NoError(t, out.Close()) {
+		return
+	}
+
+	if !assert.NoError(t, rs.migrateLegacyFiles()) {
+		return
+	}
+	assert.Len(t, rs.fileStore.segmentsByPartition(), 2, "Legacy file should have been split by partition")
+	assert.Equal(t, offsets.TSString(), rs.fileStore.offsetsBySource.TSString())
+	m, err := readManifest(tmpDir)
+	if assert.NoError(t, err) && assert.NotNil(t, m) {
+		assert.Equal(t, segmentNames(rs.fileStore.segments), segmentNames(m.Segments))
+		assert.Equal(t, offsets.TSString(), m.OffsetsBySource.TSString())
+	}
+
+	vals, err := rs.values(nil, time.Time{}, now.Add(-10*time.Hour), now.Add(-3*time.Hour))
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string][]float64{
+			"x": {1, 2},
+			"y": {0, 3},
+		}, vals, "Migration should retain all data")
+	}
+}
+
+func TestReopenFromManifest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "segmentstest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	schema := Schema{
+		"table_a": &TableOpts{Name: "table_a", RetentionPeriod: time.Hour, SQL: "SELECT SUM(x) AS x FROM inbound GROUP BY a, period(1m)"},
+	}
+	open := func() *DB {
+		db, err := NewDB(&DBOpts{
+			Dir:         tmpDir,
+			VirtualTime: true,
+		})
+		if !assert.NoError(t, err) {
+			return nil
+		}
+		if !assert.NoError(t, db.ApplySchema(schema)) {
+			db.Close()
+			return nil
+		}
+		return db
+	}
+	keys := func(db *DB) []string {
+		tbl := db.getTable("table_a")
+		var result []string
+		_, err := tbl.rowStore.iterate(context.Background(), tbl.getFields(), false, time.Time{}, time.Time{}, func(key bytemap.ByteMap, columns []encoding.Sequence) (bool, error) {
+			result = append(result, key.Get("a").(string))
+			return true, nil
+		})
+		assert.NoError(t, err)
+		return result
+	}
+
+	db := open()
+	if db == nil {
+		return
+	}
+	now := time.Now()
+	for _, a := range []string{"a", "b"} {
+		if !assert.NoError(t, db.Insert("inbound", now, map[string]interface{}{"a": a}, map[string]interface{}{"x": 1})) {
+			db.Close()
+			return
+		}
+	}
+	time.Sleep(500 * time.Millisecond)
+	db.FlushAll()
+	assert.ElementsMatch(t, []string{"a", "b"}, keys(db))
+	db.Close()
+	m, err := readManifest(filepath.Join(tmpDir, "table_a"))
+	if !assert.NoError(t, err) || !assert.NotNil(t, m) || !assert.NotEmpty(t, m.Segments) {
+		return
+	}
+
+	db = open()
+	if db == nil {
+		return
+	}
+	defer db.Close()
+	fs := db.getTable("table_a").rowStore.fileStore
+	assert.Equal(t, segmentNames(m.Segments), segmentNames(fs.segments), "Segments should have been read from manifest")
+	assert.Equal(t, m.OffsetsBySource.TSString(), fs.offsetsBySource.TSString(), "Offsets should have been read from manifest")
+	assert.ElementsMatch(t, []string{"a", "b"}, keys(db))
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/getlantern/zenodb/lineprotocol"
+)
+
+const (
+	// maxUDPPacketSize is the largest UDP payload we accept
+	maxUDPPacketSize = 64 * 1024
+)
+
+// serveInfluxUDP reads InfluxDB line protocol datagrams from conn and inserts
+// the contained points, one batch per datagram, until conn is closed.
+func (s *Server) serveInfluxUDP(conn net.PacketConn) {
+	precision, err := lineprotocol.ParsePrecision(s.InfluxUDPPrecision)
+	if err != nil {
+		s.log.Errorf("Invalid precision for InfluxDB UDP listener, defaulting to nanoseconds: %v", err)
+		precision = time.Nanosecond
+	}
+
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			s.log.Debugf("Stopped reading InfluxDB line protocol over UDP: %v", err)
+			return
+		}
+		points, parseErrs := lineprotocol.Parse(buf[:n], precision, time.Now())
+		if len(parseErrs) > 0 {
+			s.log.Errorf("Unable to parse %d lines received over UDP, first error: %v", len(parseErrs), parseErrs[0])
+		}
+		if _, insertErr := lineprotocol.Insert(s.db, points); insertErr != nil {
+			s.log.Errorf("Error inserting points received over UDP: %v", insertErr)
+		}
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/getlantern/zenodb/planner"
 	"github.com/getlantern/zenodb/rpc"
 	rpcserver "github.com/getlantern/zenodb/rpc/server"
+	"github.com/getlantern/zenodb/tiered"
 	"github.com/getlantern/zenodb/web"
 	"github.com/gorilla/mux"
 	"golang.org/x/crypto/acme/autocert"
@@ -91,14 +92,28 @@ type Server struct {
 	WebQueryTimeout           time.Duration
 	WebQueryConcurrencyLimit  int
 	WebMaxResponseBytes       int
+	PrometheusStreamLabel     string
+	PrometheusDefaultStream   string
+	InfluxUDPAddr             string
+	InfluxUDPPrecision        string
 	ListenTimeout             time.Duration
 	MaxReconnectWaitTime      time.Duration
 	Panic                     func(err interface{})
 
-	Schema         string
-	AliasesFile    string
-	EnableGeo      bool
-	RedisCacheSize int
+	Schema              string
+	SnapshotRoot        string
+	DeleteDroppedTables bool
+	AliasesFile         string
+	EnableGeo           bool
+	RedisCacheSize      int
+
+	ColdStoreDir               string
+	ColdStoreS3Endpoint        string
+	ColdStoreS3Bucket          string
+	ColdStoreS3Region          string
+	ColdStoreS3Prefix          string
+	ColdStoreS3AccessKeyID     string
+	ColdStoreS3SecretAccessKey string
 
 	log     golog.Logger
 	db      *zenodb.DB
@@ -164,9 +179,17 @@ func (s *Server) Prepare() (db *zenodb.DB, run func() error, finalErr error) {
 		fmt.Printf("Whitelisted dimensions: %v\n", whitelistedDimensions)
 	}
 
+	coldStore, coldStoreErr := s.coldStore()
+	if coldStoreErr != nil {
+		finalErr = coldStoreErr
+		return
+	}
+
 	dbOpts := &zenodb.DBOpts{
 		Dir:                       s.DBDir,
 		SchemaFile:                s.Schema,
+		SnapshotRoot:              s.SnapshotRoot,
+		DeleteDroppedTables:       s.DeleteDroppedTables,
 		EnableGeo:                 s.EnableGeo,
 		ISPProvider:               cmd.ISPProvider(),
 		AliasesFile:               s.AliasesFile,
@@ -188,6 +211,7 @@ func (s *Server) Prepare() (db *zenodb.DB, run func() error, finalErr error) {
 		MaxFollowQueue:            s.MaxFollowQueue,
 		Panic:                     s.Panic,
 		WhitelistedDimensions:     whitelistedDimensions,
+		ColdStore:                 coldStore,
 	}
 
 	s.log = dbOpts.BuildLogger()
@@ -360,6 +384,25 @@ func (s *Server) Prepare() (db *zenodb.DB, run func() error, finalErr error) {
 			s.log.Debugf("Listening for HTTPS connections at %v\n", s.HTTPSListener.Addr())
 		}
 
+		if s.InfluxUDPAddr != "" {
+			var udpConn net.PacketConn
+			err = s.listen(func() error {
+				udpConn, err = net.ListenPacket("udp", s.InfluxUDPAddr)
+				return err
+			})
+			if err != nil {
+				return s.log.Errorf("Unable to listen for InfluxDB line protocol over UDP at %v: %v", s.InfluxUDPAddr, err)
+			}
+			s.log.Debugf("Listening for InfluxDB line protocol over UDP at %v\n", udpConn.LocalAddr())
+			s.db.Go(func(stop <-chan interface{}) {
+				go func() {
+					<-stop
+					udpConn.Close()
+				}()
+				s.serveInfluxUDP(udpConn)
+			})
+		}
+
 		serveHTTP, err := s.serveHTTP()
 		if err != nil {
 			return s.log.Errorf("Unable to serve HTTP: %v", err)
@@ -490,18 +533,20 @@ func (s *Server) serveHTTP() (func() error, error) {
 		s.Router = mux.NewRouter()
 	}
 	stop, err := web.Configure(s.db, s.Router, &web.Opts{
-		OAuthClientID:         s.OauthClientID,
-		OAuthClientSecret:     s.OauthClientSecret,
-		GitHubOrg:             s.GitHubOrg,
-		HashKey:               s.CookieHashKey,
-		BlockKey:              s.CookieBlockKey,
-		Password:              s.Password,
-		AssetsDir:             s.WebAssetsDir,
-		CacheDir:              filepath.Join(s.DBDir, "_webcache"),
-		CacheTTL:              s.WebQueryCacheTTL,
-		QueryTimeout:          s.WebQueryTimeout,
-		QueryConcurrencyLimit: s.WebQueryConcurrencyLimit,
-		MaxResponseBytes:      s.WebMaxResponseBytes,
+		OAuthClientID:           s.OauthClientID,
+		OAuthClientSecret:       s.OauthClientSecret,
+		GitHubOrg:               s.GitHubOrg,
+		HashKey:                 s.CookieHashKey,
+		BlockKey:                s.CookieBlockKey,
+		Password:                s.Password,
+		AssetsDir:               s.WebAssetsDir,
+		CacheDir:                filepath.Join(s.DBDir, "_webcache"),
+		CacheTTL:                s.WebQueryCacheTTL,
+		QueryTimeout:            s.WebQueryTimeout,
+		QueryConcurrencyLimit:   s.WebQueryConcurrencyLimit,
+		MaxResponseBytes:        s.WebMaxResponseBytes,
+		PrometheusStreamLabel:   s.PrometheusStreamLabel,
+		PrometheusDefaultStream: s.PrometheusDefaultStream,
 	})
 	if err != nil {
 		return nil, err
@@ -554,6 +599,28 @@ func (s *Server) GetSessionTicketKey() [32]byte {
 	return key
 }
 
+// coldStore builds the tiered.Store configured by the -coldstore* flags, if
+// any.
+func (s *Server) coldStore() (tiered.Store, error) {
+	if s.ColdStoreDir != "" && s.ColdStoreS3Endpoint != "" {
+		return nil, errors.New("Please specify only one of -coldstoredir and -coldstores3endpoint")
+	}
+	if s.ColdStoreDir != "" {
+		return tiered.NewDirStore(s.ColdStoreDir)
+	}
+	if s.ColdStoreS3Endpoint != "" {
+		return tiered.NewS3Store(&tiered.S3Opts{
+			Endpoint:        s.ColdStoreS3Endpoint,
+			Bucket:          s.ColdStoreS3Bucket,
+			Region:          s.ColdStoreS3Region,
+			Prefix:          s.ColdStoreS3Prefix,
+			AccessKeyID:     s.ColdStoreS3AccessKeyID,
+			SecretAccessKey: s.ColdStoreS3SecretAccessKey,
+		})
+	}
+	return nil, nil
+}
+
 func (s *Server) clientsFor(serversString string, serverOverridesString string, clientSessionCache tls.ClientSessionCache) (map[int]rpc.Client, error) {
 	servers := strings.Split(serversString, ",")
 	serverOverrides := strings.Split(serverOverridesString, ",")
@@ -629,6 +696,8 @@ func (s *Server) Close() {
 func (s *Server) ConfigureFlags() {
 	flag.StringVar(&s.DBDir, "dbdir", "zenodata", "The directory in which to store the database files, defaults to ./zenodata")
 	flag.StringVar(&s.WebAssetsDir, "webassetsdir", "", "optionally specify a directoryy for web assets (in lieu of embedded web assets)")
+	flag.StringVar(&s.SnapshotRoot, "snapshotroot", "", "if specified, clients can take snapshots (with SNAPSHOT TO '<name>' or POST /snapshot?name=<name>) into subdirectories of this directory")
+	flag.BoolVar(&s.DeleteDroppedTables, "deletedroppedtables", false, "Set this flag to delete the data of dropped tables instead of moving it into <dbdir>/_dropped.")
 	flag.BoolVar(&s.Vtime, "vtime", false, "Set this flag to use virtual instead of real time. When using virtual time, the advancement of time will be governed by the timestamps received via inserts.")
 	flag.DurationVar(&s.WALSync, "walsync", 5*time.Second, "How frequently to sync the WAL to disk. Set to 0 to sync after every write. Defaults to 5 seconds.")
 	flag.IntVar(&s.MaxWALSize, "maxwalsize", 1024*1024*1024, "Maximum size of WAL segments on disk. Defaults to 1 GB.")
@@ -668,4 +737,15 @@ func (s *Server) ConfigureFlags() {
 	flag.DurationVar(&s.WebQueryTimeout, "webquerytimeout", 30*time.Minute, "time out web queries after this duration")
 	flag.IntVar(&s.WebQueryConcurrencyLimit, "webqueryconcurrency", 2, "limit concurrent web queries to this (subsequent queries will be queued)")
 	flag.IntVar(&s.WebMaxResponseBytes, "webquerymaxresponsebytes", 25*1024*1024, "limit the size of query results returned through the web API")
+	flag.StringVar(&s.PrometheusStreamLabel, "promstreamlabel", web.DefaultPrometheusStreamLabel, "label that determines which stream Prometheus remote-write samples are inserted into")
+	flag.StringVar(&s.PrometheusDefaultStream, "promdefaultstream", "", "stream into which to insert Prometheus remote-write samples that don't have the -promstreamlabel label")
+	flag.StringVar(&s.InfluxUDPAddr, "influxudpaddr", "", "if specified, listen for InfluxDB line protocol over UDP at this address")
+	flag.StringVar(&s.InfluxUDPPrecision, "influxudpprecision", "ns", "the precision of timestamps received via -influxudpaddr (ns, u, ms, s, m or h)")
+	flag.StringVar(&s.ColdStoreDir, "coldstoredir", "", "if specified, tables with a coldafter option offload old segments to this directory (e.g. on a slower disk)")
+	flag.StringVar(&s.ColdStoreS3Endpoint, "coldstores3endpoint", "", "if specified, tables with a coldafter option offload old segments to the S3-compatible object store at this URL, e.g. https://s3.us-east-1.amazonaws.com")
+	flag.StringVar(&s.ColdStoreS3Bucket, "coldstores3bucket", "", "the bucket to use with -coldstores3endpoint")
+	flag.StringVar(&s.ColdStoreS3Region, "coldstores3region", tiered.DefaultS3Region, "the region to use with -coldstores3endpoint")
+	flag.StringVar(&s.ColdStoreS3Prefix, "coldstores3prefix", "", "optional prefix for the keys of objects stored via -coldstores3endpoint")
+	flag.StringVar(&s.ColdStoreS3AccessKeyID, "coldstores3accesskeyid", "", "the access key id to use with -coldstores3endpoint")
+	flag.StringVar(&s.ColdStoreS3SecretAccessKey, "coldstores3secretaccesskey", "", "the secret access key to use with -coldstores3endpoint")
 }
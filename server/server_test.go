@@ -567,6 +567,47 @@ test_ab:
 			}
 			return true
 		}},
+		test{"running queries are listed for the leader and its followers", 10 * time.Second, func() bool {
+			if len(followersByPartition) == 0 {
+				return true
+			}
+
+			for i, client := range clients {
+				var nodes map[string]int
+				for attempt := 0; attempt < 20; attempt++ {
+					if attempt > 0 {
+						time.Sleep(250 * time.Millisecond)
+					}
+					_, rows, err := query(client, "SHOW QUERIES", false)
+					if err != nil {
+						continue
+					}
+					nodes = make(map[string]int)
+					for _, row := range rows {
+						nodes[fmt.Sprint(row.Key.Get("node"))]++
+					}
+					if len(nodes) == len(followersByPartition)+1 {
+						break
+					}
+				}
+				if !assert.Equal(t, 1, nodes[fmt.Sprintf("leader.%d", leaders[i].ID)], "Leader should list its own query") {
+					return false
+				}
+				for partition := range followersByPartition {
+					numForPartition := 0
+					for node, count := range nodes {
+						if strings.HasPrefix(node, fmt.Sprintf("follower.%d.", partition)) {
+							numForPartition += count
+						}
+					}
+					if !assert.Equal(t, 1, numForPartition, "Leader should list query running on follower for partition %d", partition) {
+						return false
+					}
+				}
+			}
+
+			return true
+		}},
 		test{"remaining followers return correct results after killing redundant followers", 10 * time.Second, func() bool {
 			for _, followersForPartition := range followersByPartition {
 				if len(followersForPartition) > 1 {
@@ -0,0 +1,320 @@
+package zenodb
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getlantern/errors"
+	"github.com/getlantern/wal"
+	"github.com/getlantern/zenodb/common"
+)
+
+const (
+	// SnapshotInfoFilename is the name of the file in a snapshot directory that
+	// describes the snapshot.
+	SnapshotInfoFilename = "snapshot.json"
+
+	snapshotSchemaFilename = "schema.yaml"
+)
+
+var (
+	errTableDropped = errors.New("Table has been dropped")
+)
+
+// SnapshotNamed creates a snapshot (see Snapshot) with the given name inside of
+// DBOpts.SnapshotRoot. The name is a relative path that may not point outside
+// of the snapshot root, which makes this safe to expose to remote clients.
+func (db *DB) SnapshotNamed(name string) (*common.SnapshotInfo, error) {
+	dir, err := db.snapshotDirFor(name)
+	if err != nil {
+		return nil, err
+	}
+	return db.Snapshot(dir)
+}
+
+func (db *DB) snapshotDirFor(name string) (string, error) {
+	if db.opts.SnapshotRoot == "" {
+		return "", errors.New("No snapshot root configured, unable to snapshot by name")
+	}
+	if name == "" {
+		return "", errors.New("Please specify a snapshot name")
+	}
+	if filepath.IsAbs(name) {
+		return "", errors.New("Snapshot name %v must be a relative path", name)
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == ".." {
+			return "", errors.New("Snapshot name %v may not contain ..", name)
+		}
+	}
+	dir := filepath.Join(db.opts.SnapshotRoot, name)
+	if dir == filepath.Clean(db.opts.SnapshotRoot) {
+		return "", errors.New("Snapshot name %v doesn't name a directory inside of the snapshot root", name)
+	}
+	return dir, nil
+}
+
+// Snapshot creates a consistent point-in-time snapshot of the database in the
+// given directory, which must not exist yet. The snapshot has the same layout
+// as the database directory. It contains the current segments and manifest of
+// every table, the WAL files needed to bring the tables up to date and the
+// current schema (as schema.yaml). Files are hard linked where possible, so
+// taking a snapshot is cheap and doesn't stop tables from flushing.
+func (db *DB) Snapshot(dir string) (*common.SnapshotInfo, error) {
+	if db.opts.ReadOnly {
+		return nil, errors.New("Unable to snapshot read-only database")
+	}
+	_, err := os.Stat(dir)
+	if err == nil {
+		return nil, errors.New("Snapshot directory %v already exists", dir)
+	}
+	if !os.IsNotExist(err) {
+		return nil, errors.New("Unable to check snapshot directory %v: %v", dir, err)
+	}
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.New("Unable to create snapshot directory %v: %v", dir, err)
+	}
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	// Keep WAL retention from removing WAL files until we've linked the ones we
+	// need.
+	db.snapshotMx.Lock()
+	defer db.snapshotMx.Unlock()
+
+	start := time.Now()
+	info := &common.SnapshotInfo{Dir: dir, Created: start}
+	walOffsets := make(map[string]wal.Offset)
+	for _, t := range db.catalogTables() {
+		if t.rowStore == nil {
+			continue
+		}
+		offsetsBySource, numSegments, err := t.rowStore.snapshot(filepath.Join(dir, t.Name))
+		if err == errTableDropped {
+			continue
+		}
+		if err != nil {
+			return nil, errors.New("Unable to snapshot table %v: %v", t.Name, err)
+		}
+		info.Tables++
+		info.Segments += numSegments
+
+		// The table resumes reading from its offset when opened from the
+		// snapshot, but doesn't need anything older than its retention period.
+		offset := offsetsBySource[0]
+		offsetByRetentionPeriod := wal.NewOffsetForTS(t.truncateBefore())
+		if offsetByRetentionPeriod.After(offset) {
+			offset = offsetByRetentionPeriod
+		}
+		existing, found := walOffsets[t.From]
+		if !found || existing.After(offset) {
+			walOffsets[t.From] = offset
+		}
+	}
+
+	walDir := filepath.Join(db.opts.Dir, "_wal")
+	streamDirs, err := ioutil.ReadDir(walDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.New("Unable to list WAL directory %v: %v", walDir, err)
+	}
+	for _, streamDir := range streamDirs {
+		if !streamDir.IsDir() {
+			continue
+		}
+		stream := streamDir.Name()
+		offset, found := walOffsets[stream]
+		if !found {
+			// No tables read from this stream (e.g. on a passthrough node), keep
+			// whatever WAL retention would keep.
+			pin := db.walPinFor(stream)
+			if pin != nil {
+				offset = pin.offset
+			}
+		}
+		numFiles, err := snapshotWAL(filepath.Join(walDir, stream), filepath.Join(dir, "_wal", stream), offset)
+		if err != nil {
+			return nil, errors.New("Unable to snapshot WAL for %v: %v", stream, err)
+		}
+		info.WALFiles += numFiles
+	}
+
+	schema := db.Schema()
+	if len(schema) > 0 {
+		err = db.saveSchema(filepath.Join(dir, snapshotSchemaFilename), schema)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, errors.New("Unable to marshal snapshot info: %v", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, SnapshotInfoFilename), b, 0644)
+	if err != nil {
+		return nil, errors.New("Unable to write snapshot info: %v", err)
+	}
+
+	succeeded = true
+	db.log.Debugf("Snapshotted %d tables with %d segments and %d WAL files to %v in %v", info.Tables, info.Segments, info.WALFiles, dir, time.Now().Sub(start))
+	return info, nil
+}
+
+// snapshot links the table's current segments into dir, along with a manifest
+// listing them, and returns the manifest's offsets and the number of segments.
+// The segments are protected from removal while they're being linked. Cold
+// segments are downloaded from the ColdStore into the snapshot, since the
+// database may delete them from there at any time, so the snapshot doesn't
+// depend on the ColdStore.
+func (rs *rowStore) snapshot(dir string) (common.OffsetsBySource, int, error) {
+	rs.mx.Lock()
+	if rs.t.isDropped() {
+		rs.mx.Unlock()
+		return nil, 0, errTableDropped
+	}
+	fs := rs.fileStore
+	rs.pinSegments(fs.segments)
+	rs.mx.Unlock()
+	defer rs.unpinSegments(fs.segments)
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, 0, errors.New("Unable to create directory %v: %v", dir, err)
+	}
+	segments := make([]*segment, 0, len(fs.segments))
+	for _, seg := range fs.segments {
+		if seg.Cold {
+			err = rs.downloadColdSegment(seg, filepath.Join(dir, seg.Name))
+			if err != nil {
+				return nil, 0, err
+			}
+			local := *seg
+			local.Cold = false
+			local.ColdKey = ""
+			seg = &local
+		} else {
+			err = linkOrCopy(filepath.Join(rs.opts.dir, seg.Name), filepath.Join(dir, seg.Name))
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		segments = append(segments, seg)
+	}
+	err = writeManifest(dir, &manifest{Segments: segments, OffsetsBySource: fs.offsetsBySource})
+	if err != nil {
+		return nil, 0, err
+	}
+	err = linkOrCopy(filepath.Join(rs.opts.dir, statsFilename), filepath.Join(dir, statsFilename))
+	if err != nil && !os.IsNotExist(err) {
+		// stats are recomputed on the next flush, so this isn't fatal
+		rs.t.log.Errorf("Unable to snapshot stored stats: %v", err)
+	}
+	return fs.offsetsBySource, len(fs.segments), nil
+}
+
+type walFile struct {
+	name string
+	seq  int64
+}
+
+// snapshotWAL links the files in walDir that contain data at or after the
+// given offset into dir and returns the number of files in the snapshot. WAL
+// files are named after their file sequence, so the file containing the offset
+// is the last one whose sequence isn't later than the offset's. The newest
+// file is still being appended to, so it's copied rather than linked.
+func snapshotWAL(walDir string, dir string, offset wal.Offset) (int, error) {
+	files, err := listRegularFiles(walDir)
+	if err != nil {
+		return 0, errors.New("Unable to list WAL files in %v: %v", walDir, err)
+	}
+	var walFiles []*walFile
+	for _, file := range files {
+		name := file.Name()
+		seq, parseErr := strconv.ParseInt(strings.Split(name, ".")[0], 10, 64)
+		if parseErr != nil {
+			// not a WAL segment
+			continue
+		}
+		walFiles = append(walFiles, &walFile{name, seq})
+	}
+	sort.Slice(walFiles, func(i, j int) bool {
+		return walFiles[i].seq < walFiles[j].seq
+	})
+
+	first := 0
+	if len(offset) > 0 {
+		for i, file := range walFiles {
+			if file.seq <= offset.FileSequence() {
+				first = i
+			}
+		}
+	}
+	walFiles = walFiles[first:]
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, errors.New("Unable to create directory %v: %v", dir, err)
+	}
+	for i, file := range walFiles {
+		src := filepath.Join(walDir, file.name)
+		dst := filepath.Join(dir, file.name)
+		if i == len(walFiles)-1 {
+			err = copyFile(src, dst)
+		} else {
+			err = linkOrCopy(src, dst)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(walFiles), nil
+}
+
+// linkOrCopy hard links src to dst, falling back to copying if src and dst are
+// on different file systems.
+func linkOrCopy(src string, dst string) error {
+	err := os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+	if os.IsNotExist(err) {
+		return err
+	}
+	return copyFile(src, dst)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.New("Unable to create %v: %v", dst, err)
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return errors.New("Unable to copy %v to %v: %v", src, dst, err)
+	}
+	err = out.Sync()
+	if err != nil {
+		return errors.New("Unable to sync %v: %v", dst, err)
+	}
+	return out.Close()
+}
@@ -0,0 +1,119 @@
+package zenodb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/zenodb/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "zenodbsnapshottest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := NewDB(&DBOpts{
+		Dir:         filepath.Join(tmpDir, "data"),
+		VirtualTime: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer db.Close()
+
+	if !assert.NoError(t, db.ApplySchema(Schema{
+		"table_a": &TableOpts{Name: "table_a", RetentionPeriod: time.Hour, SQL: "SELECT SUM(x) AS x FROM inbound GROUP BY a, period(1m)"},
+	})) {
+		return
+	}
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if !assert.NoError(t, db.Insert("inbound", now, map[string]interface{}{"a": i}, map[string]interface{}{"x": 1})) {
+			return
+		}
+	}
+	tbl := db.getTable("table_a")
+	flushed := false
+	for i := 0; i < 50; i++ {
+		time.Sleep(100 * time.Millisecond)
+		db.FlushAll()
+		tbl.rowStore.mx.RLock()
+		flushed = len(tbl.rowStore.fileStore.segments) > 0
+		tbl.rowStore.mx.RUnlock()
+		if flushed {
+			break
+		}
+	}
+	if !assert.True(t, flushed, "Table should have been flushed within 5 seconds") {
+		return
+	}
+
+	snapshotDir := filepath.Join(tmpDir, "snapshot")
+	info, err := db.Snapshot(snapshotDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, snapshotDir, info.Dir)
+	assert.Equal(t, 1, info.Tables)
+	assert.True(t, info.Segments > 0)
+	assert.True(t, info.WALFiles > 0)
+	for _, name := range []string{SnapshotInfoFilename, snapshotSchemaFilename, filepath.Join("table_a", manifestFilename)} {
+		_, err = os.Stat(filepath.Join(snapshotDir, name))
+		assert.NoError(t, err, name)
+	}
+
+	_, err = db.Snapshot(snapshotDir)
+	assert.Error(t, err, "Snapshotting into existing directory should fail")
+
+	// Data inserted after the snapshot shouldn't show up in the snapshot
+	assert.NoError(t, db.Insert("inbound", now, map[string]interface{}{"a": 10}, map[string]interface{}{"x": 1}))
+	db.FlushAll()
+
+	restored, err := NewDB(&DBOpts{
+		Dir:         snapshotDir,
+		SchemaFile:  filepath.Join(snapshotDir, snapshotSchemaFilename),
+		VirtualTime: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer restored.Close()
+
+	restoredTable := restored.getTable("table_a")
+	if !assert.NotNil(t, restoredTable) {
+		return
+	}
+	rows := 0
+	_, err = restoredTable.rowStore.iterate(context.Background(), restoredTable.getFields(), false, time.Time{}, time.Time{}, func(key bytemap.ByteMap, columns []encoding.Sequence) (bool, error) {
+		rows++
+		return true, nil
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, rows, "Restored table should contain data up to the snapshot")
+	}
+}
+
+func TestSnapshotDirFor(t *testing.T) {
+	db := &DB{opts: &DBOpts{SnapshotRoot: "/snapshots"}}
+	dir, err := db.snapshotDirFor("daily/2017-06-01")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "/snapshots/daily/2017-06-01", dir)
+	}
+	for _, name := range []string{"", ".", "/etc", "..", "../etc", "daily/../../etc", "daily/.."} {
+		_, err = db.snapshotDirFor(name)
+		assert.Error(t, err, "Snapshot name %v should be rejected", name)
+	}
+
+	db = &DB{opts: &DBOpts{}}
+	_, err = db.snapshotDirFor("daily")
+	assert.Error(t, err, "Snapshots by name should require a snapshot root")
+}
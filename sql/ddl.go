@@ -0,0 +1,95 @@
+package sql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DDLCreate creates a new table or view
+	DDLCreate = "CREATE"
+	// DDLAlter changes the definition of an existing table or view
+	DDLAlter = "ALTER"
+	// DDLDrop removes a table or view
+	DDLDrop = "DROP"
+)
+
+var (
+	ddlRegex       = regexp.MustCompile(`(?is)^\s*(CREATE|ALTER|DROP)\s+(TABLE|VIEW)\s+([A-Za-z_][A-Za-z0-9_]*)\s*(.*?)\s*;?\s*$`)
+	ddlWithRegex   = regexp.MustCompile(`(?is)^WITH\s*\((.*?)\)\s*(.*)$`)
+	ddlAsRegex     = regexp.MustCompile(`(?is)^AS\s+(SELECT\s.*)$`)
+	ddlOptionRegex = regexp.MustCompile(`(?s)^\s*([A-Za-z_]+)\s*=\s*('[^']*'|"[^"]*"|[^,]*?)\s*(?:,|$)`)
+)
+
+// DDL represents a parsed schema statement, which looks like one of:
+//
+//	CREATE TABLE|VIEW name [WITH (option = value, ...)] AS SELECT ...
+//	ALTER TABLE|VIEW name [WITH (option = value, ...)] AS SELECT ...
+//	DROP TABLE|VIEW name
+//
+// Option names match the keys used in the YAML schema file (e.g.
+// retentionperiod, maxflushlatency, partitionby).
+type DDL struct {
+	// Op is one of DDLCreate, DDLAlter or DDLDrop
+	Op   string
+	View bool
+	// Name is the name of the table or view, in lowercase
+	Name string
+	// Options are the options from the WITH clause, keyed by lowercase name
+	Options map[string]string
+	// SQL is the SELECT query that defines the table or view
+	SQL string
+}
+
+// IsDDL indicates whether the given sql is a schema statement (CREATE, ALTER
+// or DROP).
+func IsDDL(sql string) bool {
+	return ddlRegex.MatchString(sql)
+}
+
+// ParseDDL parses a schema statement.
+func ParseDDL(sql string) (*DDL, error) {
+	match := ddlRegex.FindStringSubmatch(sql)
+	if match == nil {
+		return nil, fmt.Errorf("Not a CREATE, ALTER or DROP statement: %v", sql)
+	}
+	ddl := &DDL{
+		Op:      strings.ToUpper(match[1]),
+		View:    strings.ToUpper(match[2]) == "VIEW",
+		Name:    strings.ToLower(match[3]),
+		Options: make(map[string]string),
+	}
+	rest := match[4]
+
+	if ddl.Op == DDLDrop {
+		if rest != "" {
+			return nil, fmt.Errorf("Unexpected text after DROP %v: %v", ddl.Name, rest)
+		}
+		return ddl, nil
+	}
+
+	withMatch := ddlWithRegex.FindStringSubmatch(rest)
+	if withMatch != nil {
+		options := withMatch[1]
+		for strings.TrimSpace(options) != "" {
+			optionMatch := ddlOptionRegex.FindStringSubmatch(options)
+			if optionMatch == nil {
+				return nil, fmt.Errorf("Unable to parse options for %v: %v", ddl.Name, withMatch[1])
+			}
+			ddl.Options[strings.ToLower(optionMatch[1])] = strings.Trim(optionMatch[2], `'"`)
+			options = options[len(optionMatch[0]):]
+		}
+		rest = withMatch[2]
+	}
+
+	asMatch := ddlAsRegex.FindStringSubmatch(rest)
+	if asMatch == nil {
+		return nil, fmt.Errorf("%v %v requires AS SELECT ...", ddl.Op, ddl.Name)
+	}
+	ddl.SQL = asMatch[1]
+	if _, err := Parse(ddl.SQL); err != nil {
+		return nil, fmt.Errorf("Unable to parse SELECT for %v: %v", ddl.Name, err)
+	}
+	return ddl, nil
+}
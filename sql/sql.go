@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -19,11 +20,14 @@ import (
 	"github.com/getlantern/golog"
 	"github.com/getlantern/sqlparser"
 	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/dimfn"
 	"github.com/getlantern/zenodb/expr"
 )
 
 var (
 	log = golog.LoggerFor("zenodb.sql")
+
+	showQueriesRegex = regexp.MustCompile(`(?i)^\s*SHOW\s+(QUERIES|PROCESSLIST)\s*;?\s*$`)
 )
 
 var (
@@ -32,6 +36,11 @@ var (
 	ErrBoundedArity                  = errors.New("BOUNDED requires three parameters, like BOUNDED(b, 0, 100)")
 	ErrPercentileArity               = errors.New("PERCENTILE requires either two or five parameters, like PERCENTILE(b, 99.9, 0, 1000, 3)")
 	ErrPercentileOptWrap             = errors.New("PERCENTILE with two parameters may only wrap an existing PERCENTILE expression")
+	ErrTopKArity                     = errors.New("TOPK requires three parameters, like TOPK(dim, b, 10)")
+	ErrRateArity                     = errors.New("RATE, IRATE and DELTA require one parameter, like RATE(requests)")
+	ErrQuantileArity                 = errors.New("QUANTILE requires two parameters, like QUANTILE(b, 99.9)")
+	ErrMovingArity                   = errors.New("MOVING_AVG and MOVING_SUM require two parameters, like MOVING_AVG(b, '1h')")
+	ErrEWMAArity                     = errors.New("EWMA requires two parameters, like EWMA(b, 0.3)")
 	ErrShiftArity                    = errors.New("SHIFT requires two parameters, like SHIFT(SUM(b), '-1h')")
 	ErrCrosshiftArity                = errors.New("CROSSHIFT requires three parameters, like CROSSHIFT(SUM(b), '1h', '-1d')")
 	ErrCrosshiftZeroCutoffOrInterval = errors.New("CROSSHIFT cutoff and interval must be non-zero")
@@ -45,15 +54,34 @@ var (
 )
 
 var aggregateFuncs = map[string]func(interface{}) expr.Expr{
-	"SUM":   expr.SUM,
-	"MIN":   expr.MIN,
-	"MAX":   expr.MAX,
-	"COUNT": expr.COUNT,
-	"AVG":   expr.AVG,
+	"SUM":            expr.SUM,
+	"MIN":            expr.MIN,
+	"MAX":            expr.MAX,
+	"COUNT":          expr.COUNT,
+	"AVG":            expr.AVG,
+	"VARIANCE":       expr.VARIANCE,
+	"STDDEV":         expr.STDDEV,
+	"FIRST":          expr.FIRST,
+	"LAST":           expr.LAST,
+	"HLL":            expr.HLL,
+	"COUNT_DISTINCT": expr.HLL,
 }
 
 var binaryAggregateFuncs = map[string]func(interface{}, interface{}) expr.Expr{
-	"WAVG": expr.WAVG,
+	"WAVG":      expr.WAVG,
+	"WVARIANCE": expr.WVARIANCE,
+	"WSTDDEV":   expr.WSTDDEV,
+}
+
+var rateFuncs = map[string]func(interface{}) expr.Expr{
+	"RATE":  expr.RATE,
+	"IRATE": expr.IRATE,
+	"DELTA": expr.DELTA,
+}
+
+var movingFuncs = map[string]func(interface{}, time.Duration) expr.Expr{
+	"MOVING_AVG": expr.MOVING_AVG,
+	"MOVING_SUM": expr.MOVING_SUM,
 }
 
 var operators = map[string]func(interface{}, interface{}) expr.Expr{
@@ -87,11 +115,19 @@ var unaryGoExpr = map[string]func(goexpr.Expr) goexpr.Expr{
 	"ASN":          isp.ASN,
 	"ASNAME":       isp.ASName,
 	"LEN":          goexpr.Len,
+	"LOWER":        dimfn.LOWER,
+	"UPPER":        dimfn.UPPER,
+	"URL_HOST":     dimfn.URL_HOST,
+	"URL_PATH":     dimfn.URL_PATH,
 }
 
 var binaryGoExpr = map[string]func(goexpr.Expr, goexpr.Expr) goexpr.Expr{
-	"HGET":      redis.HGet,
-	"SISMEMBER": redis.SIsMember,
+	"HGET":           redis.HGet,
+	"SISMEMBER":      redis.SIsMember,
+	"REGEXP_EXTRACT": dimfn.REGEXP_EXTRACT,
+	"REGEXP_MATCH":   dimfn.REGEXP_MATCH,
+	"CIDR_MATCH":     dimfn.CIDR_MATCH,
+	"HASH_BUCKET":    dimfn.HASH_BUCKET,
 }
 
 var ternaryGoExpr = map[string]func(goexpr.Expr, goexpr.Expr, goexpr.Expr) goexpr.Expr{
@@ -204,6 +240,12 @@ func TableFor(sql string) (string, error) {
 	return strings.ToLower(nodeToString(stmt.From[0])), nil
 }
 
+// IsShowQueries indicates whether the given sql is a SHOW QUERIES (or SHOW
+// PROCESSLIST) statement, which lists the currently running queries.
+func IsShowQueries(sql string) bool {
+	return showQueriesRegex.MatchString(sql)
+}
+
 // Parse parses a SQL statement and returns a corresponding *Query object.
 func Parse(sql string) (*Query, error) {
 	parsed, err := sqlparser.Parse(sql)
@@ -477,6 +519,10 @@ func (q *Query) applyFrom(stmt *sqlparser.Select) error {
 			return nil
 		case *sqlparser.TableName:
 			q.From = strings.ToLower(string(e.Name))
+			if len(e.Qualifier) > 0 {
+				// Qualified table names like zeno.tables refer to system tables
+				q.From = strings.ToLower(string(e.Qualifier)) + "." + q.From
+			}
 			return nil
 		}
 	}
@@ -678,9 +724,27 @@ func (f *fielded) exprFor(_e sqlparser.Expr, defaultToSum bool) (interface{}, er
 		if fname == "PERCENTILE" {
 			return f.percentileExprFor(e, fname, defaultToSum)
 		}
+		if fname == "QUANTILE" {
+			return f.quantileExprFor(e, fname, defaultToSum)
+		}
 		if fname == "SHIFT" {
 			return f.shiftExprFor(e, fname, defaultToSum)
 		}
+		if fname == "TOPK" {
+			return f.topkExprFor(e, fname, defaultToSum)
+		}
+		if fn, found := rateFuncs[fname]; found {
+			return f.rateExprFor(e, fn)
+		}
+		if fn, found := movingFuncs[fname]; found {
+			return f.movingExprFor(e, fn)
+		}
+		if fname == "EWMA" {
+			return f.ewmaExprFor(e, fname, defaultToSum)
+		}
+		if expr.IsFunc(fname) {
+			return f.funcExprFor(e, fname)
+		}
 		switch len(e.Exprs) {
 		case 1:
 			return f.unaryFuncExprFor(e, fname, defaultToSum)
@@ -690,6 +754,10 @@ func (f *fielded) exprFor(_e sqlparser.Expr, defaultToSum bool) (interface{}, er
 			return nil, ErrAggregateArity
 		}
 
+	case *sqlparser.CaseExpr:
+		return f.caseExprFor(e)
+	case *sqlparser.NullCheck:
+		return f.nullCheckExprFor(e)
 	case *sqlparser.ComparisonExpr:
 		return f.comparisonExprFor(e, defaultToSum)
 	case *sqlparser.BinaryExpr:
@@ -852,6 +920,67 @@ func (f *fielded) percentileExprFor(e *sqlparser.FuncExpr, fname string, default
 	return expr.PERCENTILE(valueEx, percentileEx, min, max, int(precision)), nil
 }
 
+func (f *fielded) quantileExprFor(e *sqlparser.FuncExpr, fname string, defaultToSum bool) (interface{}, error) {
+	if len(e.Exprs) != 2 {
+		return nil, ErrQuantileArity
+	}
+
+	_valueEx, ok := e.Exprs[0].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, ErrWildcardNotAllowed
+	}
+	var valueField core.Field
+	switch t := _valueEx.Expr.(type) {
+	case *sqlparser.ColName:
+		valueField = f.fieldsMap[strings.ToLower(string(t.Name))]
+	}
+	_percentileEx, ok := e.Exprs[1].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, ErrWildcardNotAllowed
+	}
+	percentileEx, err := f.exprFor(_percentileEx.Expr, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if expr.IsQuantile(valueField.Expr) {
+		// existing field is a quantile, just wrap it
+		return expr.QUANTILEOPT(valueField.Expr, percentileEx), nil
+	}
+	valueEx, err := f.exprFor(_valueEx.Expr, false)
+	if err != nil {
+		return nil, err
+	}
+	return expr.QUANTILE(valueEx, percentileEx), nil
+}
+
+func (f *fielded) topkExprFor(e *sqlparser.FuncExpr, fname string, defaultToSum bool) (interface{}, error) {
+	if len(e.Exprs) != 3 {
+		return nil, ErrTopKArity
+	}
+	_dimEx, ok := e.Exprs[0].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, ErrWildcardNotAllowed
+	}
+	dimEx, err := goExprFor(_dimEx.Expr)
+	if err != nil {
+		return nil, err
+	}
+	_valueEx, ok := e.Exprs[1].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, ErrWildcardNotAllowed
+	}
+	valueEx, err := f.exprFor(_valueEx.Expr, false)
+	if err != nil {
+		return nil, err
+	}
+	k, err := nodeToInt(e.Exprs[2])
+	if err != nil {
+		return nil, err
+	}
+	return expr.TOPK(dimEx, valueEx, int(k)), nil
+}
+
 func (f *fielded) shiftExprFor(e *sqlparser.FuncExpr, fname string, defaultToSum bool) (interface{}, error) {
 	if len(e.Exprs) != 2 {
 		return nil, ErrShiftArity
@@ -871,6 +1000,59 @@ func (f *fielded) shiftExprFor(e *sqlparser.FuncExpr, fname string, defaultToSum
 	return expr.SHIFT(valueEx, offset), nil
 }
 
+func (f *fielded) rateExprFor(e *sqlparser.FuncExpr, fn func(interface{}) expr.Expr) (interface{}, error) {
+	if len(e.Exprs) != 1 {
+		return nil, ErrRateArity
+	}
+	_valueEx, ok := e.Exprs[0].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, ErrWildcardNotAllowed
+	}
+	valueEx, err := f.exprFor(_valueEx.Expr, true)
+	if err != nil {
+		return nil, err
+	}
+	return fn(valueEx), nil
+}
+
+func (f *fielded) movingExprFor(e *sqlparser.FuncExpr, fn func(interface{}, time.Duration) expr.Expr) (interface{}, error) {
+	if len(e.Exprs) != 2 {
+		return nil, ErrMovingArity
+	}
+	_valueEx, ok := e.Exprs[0].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, ErrWildcardNotAllowed
+	}
+	valueEx, err := f.exprFor(_valueEx.Expr, true)
+	if err != nil {
+		return nil, err
+	}
+	size, err := nodeToDuration(e.Exprs[1])
+	if err != nil {
+		return nil, err
+	}
+	return fn(valueEx, size), nil
+}
+
+func (f *fielded) ewmaExprFor(e *sqlparser.FuncExpr, fname string, defaultToSum bool) (interface{}, error) {
+	if len(e.Exprs) != 2 {
+		return nil, ErrEWMAArity
+	}
+	_valueEx, ok := e.Exprs[0].(*sqlparser.NonStarExpr)
+	if !ok {
+		return nil, ErrWildcardNotAllowed
+	}
+	valueEx, err := f.exprFor(_valueEx.Expr, true)
+	if err != nil {
+		return nil, err
+	}
+	alpha, err := nodeToFloat(e.Exprs[1])
+	if err != nil {
+		return nil, err
+	}
+	return expr.EWMA(valueEx, alpha), nil
+}
+
 func (f *fielded) unaryFuncExprFor(e *sqlparser.FuncExpr, fname string, defaultToSum bool) (interface{}, error) {
 	var fn func(interface{}) (expr.Expr, error)
 	_fn, ok := aggregateFuncs[fname]
@@ -922,6 +1104,60 @@ func (f *fielded) binaryFuncExprFor(e *sqlparser.FuncExpr, fname string, default
 	return fn(se1, se2), nil
 }
 
+func (f *fielded) funcExprFor(e *sqlparser.FuncExpr, fname string) (interface{}, error) {
+	args := make([]interface{}, 0, len(e.Exprs))
+	for _, _arg := range e.Exprs {
+		arg, ok := _arg.(*sqlparser.NonStarExpr)
+		if !ok {
+			return nil, ErrWildcardNotAllowed
+		}
+		argEx, err := f.exprFor(arg.Expr, true)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, argEx)
+	}
+	return expr.Func(fname, args...)
+}
+
+func (f *fielded) caseExprFor(e *sqlparser.CaseExpr) (interface{}, error) {
+	if e.Expr != nil {
+		// The parser only accepts conditions after WHEN, so CASE value WHEN ... can't compare values
+		return nil, fmt.Errorf("CASE only supports the CASE WHEN condition THEN ... form, not %v", nodeToString(e))
+	}
+	args := make([]interface{}, 0, len(e.Whens)*2+1)
+	for _, when := range e.Whens {
+		cond, err := f.exprFor(when.Cond, true)
+		if err != nil {
+			return nil, err
+		}
+		val, err := f.exprFor(when.Val, true)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, cond, val)
+	}
+	if e.Else != nil {
+		elseVal, err := f.exprFor(e.Else, true)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, elseVal)
+	}
+	return expr.Func("CASE", args...)
+}
+
+func (f *fielded) nullCheckExprFor(e *sqlparser.NullCheck) (interface{}, error) {
+	wrapped, err := f.exprFor(e.Expr, true)
+	if err != nil {
+		return nil, err
+	}
+	if "is not null" == e.Operator {
+		return expr.ISNOTNULL(wrapped), nil
+	}
+	return expr.ISNULL(wrapped), nil
+}
+
 func (f *fielded) comparisonExprFor(e *sqlparser.ComparisonExpr, defaultToSum bool) (interface{}, error) {
 	_op := string(e.Operator)
 	if log.IsTraceEnabled() {
@@ -1153,7 +1389,11 @@ func goFnExprFor(e *sqlparser.FuncExpr, fname string) (goexpr.Expr, error) {
 		if err != nil {
 			return nil, err
 		}
-		return bfn(p0, p1), nil
+		result := bfn(p0, p1)
+		if err := dimfn.Validate(result); err != nil {
+			return nil, fmt.Errorf("Invalid %v: %v", fname, err)
+		}
+		return result, nil
 	}
 	tfn, found := ternaryGoExpr[fname]
 	if found {
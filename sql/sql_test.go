@@ -10,6 +10,7 @@ import (
 	"github.com/getlantern/goexpr/isp"
 	"github.com/getlantern/goexpr/redis"
 	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/dimfn"
 	. "github.com/getlantern/zenodb/expr"
 	"github.com/kylelemons/godebug/pretty"
 	"github.com/stretchr/testify/assert"
@@ -425,11 +426,305 @@ FROM Table_A
 	assert.True(t, q.GroupByAll)
 }
 
+func TestVariance(t *testing.T) {
+	q, err := Parse(`SELECT STDDEV(a) AS sd, VARIANCE(a) AS v, WSTDDEV(a, b) AS wsd, WVARIANCE(a, b) AS wv FROM Table_A`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, fields, 4) {
+		assert.Equal(t, core.NewField("sd", STDDEV("a")).String(), fields[0].String())
+		assert.Equal(t, core.NewField("v", VARIANCE("a")).String(), fields[1].String())
+		assert.Equal(t, core.NewField("wsd", WSTDDEV("a", "b")).String(), fields[2].String())
+		assert.Equal(t, core.NewField("wv", WVARIANCE("a", "b")).String(), fields[3].String())
+	}
+}
+
+func TestFirstLast(t *testing.T) {
+	q, err := Parse(`SELECT FIRST(queue_depth) AS opening, LAST(queue_depth) AS closing FROM Table_A`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, fields, 2) {
+		assert.Equal(t, core.NewField("opening", FIRST("queue_depth")).String(), fields[0].String())
+		assert.Equal(t, core.NewField("closing", LAST("queue_depth")).String(), fields[1].String())
+	}
+}
+
+func TestRate(t *testing.T) {
+	q, err := Parse(`SELECT RATE(requests) AS rps, IRATE(LAST(requests)) AS irps, DELTA(requests) AS increase FROM Table_A HAVING RATE(requests) > 10`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, fields, 4) {
+		assert.Equal(t, core.NewField("rps", RATE(SUM("requests"))).String(), fields[0].String())
+		assert.Equal(t, core.NewField("irps", IRATE(LAST("requests"))).String(), fields[1].String())
+		assert.Equal(t, core.NewField("increase", DELTA(SUM("requests"))).String(), fields[2].String())
+		assert.Equal(t, core.NewField("_having", GT(RATE(SUM("requests")), 10)).String(), fields[3].String())
+	}
+
+	q, err = Parse(`SELECT RATE(requests, 1) AS rps FROM Table_A`)
+	if assert.NoError(t, err) {
+		_, err = q.Fields.Get(nil)
+		assert.Equal(t, ErrRateArity, err)
+	}
+}
+
+func TestMovingWindows(t *testing.T) {
+	q, err := Parse(`SELECT MOVING_AVG(load_avg, '1h') AS smoothed, MOVING_SUM(requests, '30m') AS recent, EWMA(AVG(load_avg), 0.3) AS ewma FROM Table_A HAVING MOVING_SUM(requests, '30m') > 100`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, fields, 4) {
+		assert.Equal(t, core.NewField("smoothed", MOVING_AVG(SUM("load_avg"), time.Hour)).String(), fields[0].String())
+		assert.Equal(t, core.NewField("recent", MOVING_SUM(SUM("requests"), 30*time.Minute)).String(), fields[1].String())
+		assert.Equal(t, core.NewField("ewma", EWMA(AVG("load_avg"), 0.3)).String(), fields[2].String())
+		assert.Equal(t, core.NewField("_having", GT(MOVING_SUM(SUM("requests"), 30*time.Minute), 100)).String(), fields[3].String())
+	}
+
+	q, err = Parse(`SELECT MOVING_AVG(load_avg) AS smoothed FROM Table_A`)
+	if assert.NoError(t, err) {
+		_, err = q.Fields.Get(nil)
+		assert.Equal(t, ErrMovingArity, err)
+	}
+}
+
+func TestMathAndCase(t *testing.T) {
+	q, err := Parse(`
+SELECT
+	ABS(a) AS abs_a,
+	POW(a, 2) AS squared,
+	GREATEST(a, b, 10) AS most,
+	COALESCE(a, b) AS either,
+	CASE WHEN a > 10 THEN b WHEN a > 5 THEN b * 2 ELSE 0 END AS cased,
+	CASE WHEN a = 1 THEN b END AS unmatched
+FROM Table_A`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	absA, _ := UnaryMath("ABS", SUM("a"))
+	expected := []core.Field{
+		core.NewField("abs_a", absA),
+		core.NewField("squared", POW(SUM("a"), CONST(2))),
+		core.NewField("most", GREATEST(SUM("a"), SUM("b"), CONST(10))),
+		core.NewField("either", COALESCE(SUM("a"), SUM("b"))),
+		core.NewField("cased", CASE(GT(SUM("a"), CONST(10)), SUM("b"), GT(SUM("a"), CONST(5)), MULT(SUM("b"), CONST(2)), CONST(0))),
+		core.NewField("unmatched", CASE(EQ(SUM("a"), CONST(1)), SUM("b"))),
+	}
+	if assert.Len(t, fields, len(expected)) {
+		for i, field := range expected {
+			assert.Equal(t, field.String(), fields[i].String())
+		}
+	}
+
+	q, err = Parse(`SELECT POW(a) AS p FROM Table_A`)
+	if assert.NoError(t, err) {
+		_, err = q.Fields.Get(nil)
+		assert.Error(t, err)
+	}
+
+	q, err = Parse(`SELECT CASE a WHEN b > 1 THEN b END AS c FROM Table_A`)
+	if assert.NoError(t, err) {
+		_, err = q.Fields.Get(nil)
+		assert.Error(t, err, "CASE value WHEN ... should be rejected")
+	}
+}
+
+func TestNulls(t *testing.T) {
+	q, err := Parse(`SELECT errors / NULLIF(requests, 0) AS error_rate FROM Table_A HAVING errors / requests IS NULL OR errors / requests IS NOT NULL`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	errorRate := DIV(SUM("errors"), SUM("requests"))
+	if assert.Len(t, fields, 2) {
+		assert.Equal(t, core.NewField("error_rate", DIV(SUM("errors"), NULLIF(SUM("requests"), CONST(0)))).String(), fields[0].String())
+		assert.Equal(t, core.NewField("_having", OR(ISNULL(errorRate), ISNOTNULL(errorRate))).String(), fields[1].String())
+	}
+}
+
+func TestDimFunctions(t *testing.T) {
+	q, err := Parse(`
+SELECT requests
+FROM Table_A
+WHERE REGEXP_MATCH(path, '^/api/') = true AND CIDR_MATCH(ip, '10.0.0.0/8') = true
+GROUP BY
+	LOWER(dim_a) AS a_lower,
+	UPPER(dim_b) AS b_upper,
+	REGEXP_EXTRACT(path, '^/api/([a-z]+)') AS endpoint,
+	URL_HOST(url) AS host,
+	URL_PATH(url) AS path,
+	HASH_BUCKET(user_id, 10) AS bucket
+`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	params := goexpr.MapParams{
+		"dim_a":   "Hello",
+		"dim_b":   "World",
+		"path":    "/api/users",
+		"ip":      "10.1.2.3",
+		"url":     "https://example.com/a/b",
+		"user_id": "joe",
+	}
+	assert.Equal(t, true, q.Where.Eval(params))
+	assert.Equal(t, false, q.Where.Eval(goexpr.MapParams{"path": "/api/users", "ip": "192.168.1.1"}))
+	if assert.Len(t, q.GroupBy, 6) {
+		expected := map[string]interface{}{
+			"a_lower":  "hello",
+			"b_upper":  "WORLD",
+			"bucket":   dimfn.HASH_BUCKET(goexpr.Constant("joe"), goexpr.Constant(10)).Eval(nil),
+			"endpoint": "users",
+			"host":     "example.com",
+			"path":     "/a/b",
+		}
+		for _, groupBy := range q.GroupBy {
+			assert.Equal(t, expected[groupBy.Name], groupBy.Expr.Eval(params), groupBy.Name)
+		}
+	}
+
+	_, err = Parse(`SELECT requests FROM Table_A WHERE REGEXP_MATCH(path, '(') = true`)
+	assert.Error(t, err, "Invalid regular expression should fail")
+	_, err = Parse(`SELECT requests FROM Table_A GROUP BY REGEXP_EXTRACT(path, dim_a) AS endpoint`)
+	assert.Error(t, err, "Non-constant regular expression should fail")
+	_, err = Parse(`SELECT requests FROM Table_A WHERE CIDR_MATCH(ip, 'bad') = true`)
+	assert.Error(t, err, "Invalid CIDR should fail")
+}
+
+func TestCountDistinct(t *testing.T) {
+	q, err := Parse(`SELECT HLL(device_id) AS devices, COUNT_DISTINCT(Client_IP) AS ips FROM Table_A`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, fields, 2) {
+		assert.Equal(t, core.NewField("devices", HLL("device_id")).String(), fields[0].String())
+		assert.Equal(t, core.NewField("ips", HLL("client_ip")).String(), fields[1].String())
+	}
+}
+
+func TestTopK(t *testing.T) {
+	q, err := Parse(`SELECT TOPK(Host, SUM(bytes), 10) AS top_hosts, TOPK(host, 1, 5) AS top_counts FROM Table_A`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, fields, 2) {
+		assert.Equal(t, core.NewField("top_hosts", TOPK("host", "bytes", 10)).String(), fields[0].String())
+		assert.Equal(t, core.NewField("top_counts", TOPK("host", CONST(1), 5)).String(), fields[1].String())
+	}
+
+	q, err = Parse(`SELECT TOPK(host, bytes) AS top FROM Table_A`)
+	if assert.NoError(t, err) {
+		_, err = q.Fields.Get(nil)
+		assert.Equal(t, ErrTopKArity, err)
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	q, err := Parse(`SELECT QUANTILE(SUM(latency), 99.9) AS p99, QUANTILE(p99, 50) AS p50 FROM Table_A`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	fields, err := q.Fields.Get(nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if assert.Len(t, fields, 2) {
+		p99 := QUANTILE("latency", 99.9)
+		assert.Equal(t, core.NewField("p99", p99).String(), fields[0].String())
+		assert.Equal(t, core.NewField("p50", QUANTILEOPT(p99, 50)).String(), fields[1].String())
+	}
+
+	q, err = Parse(`SELECT QUANTILE(latency) AS p FROM Table_A`)
+	if assert.NoError(t, err) {
+		_, err = q.Fields.Get(nil)
+		assert.Equal(t, ErrQuantileArity, err)
+	}
+}
+
 func TestParseIt(t *testing.T) {
 	_, err := Parse(`select * from TableA  group by concat('_', ct1, concat('|', ct2)) as _crosstab`)
 	assert.NoError(t, err)
 }
 
+func TestQualifiedFrom(t *testing.T) {
+	q, err := Parse(`SELECT * FROM Zeno.Tables`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "zeno.tables", q.From)
+	}
+}
+
+func TestIsShowQueries(t *testing.T) {
+	assert.True(t, IsShowQueries("SHOW QUERIES"))
+	assert.True(t, IsShowQueries(" show   queries; "))
+	assert.True(t, IsShowQueries("SHOW PROCESSLIST"))
+	assert.False(t, IsShowQueries("SHOW TABLES"))
+	assert.False(t, IsShowQueries("SELECT * FROM queries"))
+}
+
+func TestParseDDL(t *testing.T) {
+	ddl, err := ParseDDL(`CREATE TABLE Table_A WITH (retentionperiod = 1h, PartitionBy = 'a, b', virtual=false) AS SELECT SUM(x) AS x FROM inbound GROUP BY a, b, period(1m);`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, DDLCreate, ddl.Op)
+		assert.False(t, ddl.View)
+		assert.Equal(t, "table_a", ddl.Name)
+		assert.Equal(t, map[string]string{"retentionperiod": "1h", "partitionby": "a, b", "virtual": "false"}, ddl.Options)
+		assert.Equal(t, "SELECT SUM(x) AS x FROM inbound GROUP BY a, b, period(1m)", ddl.SQL)
+	}
+
+	ddl, err = ParseDDL("alter view view_a as\nSELECT * FROM table_a")
+	if assert.NoError(t, err) {
+		assert.Equal(t, DDLAlter, ddl.Op)
+		assert.True(t, ddl.View)
+		assert.Empty(t, ddl.Options)
+		assert.Equal(t, "SELECT * FROM table_a", ddl.SQL)
+	}
+
+	ddl, err = ParseDDL("DROP TABLE table_a")
+	if assert.NoError(t, err) {
+		assert.Equal(t, DDLDrop, ddl.Op)
+		assert.Equal(t, "table_a", ddl.Name)
+	}
+
+	assert.True(t, IsDDL("drop view x"))
+	assert.False(t, IsDDL("SELECT * FROM x"))
+	_, err = ParseDDL("CREATE TABLE a")
+	assert.Error(t, err, "CREATE requires AS SELECT")
+	_, err = ParseDDL("CREATE TABLE a AS SELECT FROM WHERE")
+	assert.Error(t, err, "Invalid SELECT should fail")
+	_, err = ParseDDL("DROP TABLE a b")
+	assert.Error(t, err, "DROP should not allow extra text")
+}
+
 type testexpr struct {
 	val goexpr.Expr
 }
@@ -0,0 +1,297 @@
+package zenodb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/errors"
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/encoding"
+	"github.com/getlantern/zenodb/expr"
+	"github.com/retailnext/hllpp"
+)
+
+const (
+	// StatsTable is the name of the system table that exposes StoredStats
+	StatsTable = "_stats"
+
+	// statsFilename sorts before the filestore files so that it's easy to skip
+	// when looking for the latest filestore.
+	statsFilename = "_stats.json"
+)
+
+// StoredStats are statistics about a table that are updated every time the
+// table is flushed to disk and that are persisted alongside the table's data,
+// so unlike TableStats they survive restarts.
+type StoredStats struct {
+	// Updated is the time at which the stats were last updated
+	Updated time.Time
+	// FileSize is the total size of the table's segments on disk in bytes
+	FileSize int64
+	// Rows is the number of rows in the table's segments. Keys that have data
+	// in multiple segments are counted once per segment.
+	Rows int64
+	// DistinctValues estimates the number of distinct values of each dimension,
+	// merged across all of the table's segments
+	DistinctValues map[string]uint64
+	// InsertRate is the average number of points per second inserted into the
+	// table between the prior flush and this one
+	InsertRate float64
+	// HighWaterMark is the timestamp of the most recent data on disk
+	HighWaterMark time.Time
+}
+
+// StoredStats returns the StoredStats for the named table, or nil if the table
+// doesn't exist or hasn't been flushed yet.
+func (db *DB) StoredStats(table string) *StoredStats {
+	t := db.getTable(table)
+	if t == nil || t.rowStore == nil {
+		return nil
+	}
+	return t.rowStore.getStoredStats()
+}
+
+// AllStoredStats returns all available StoredStats, keyed to the table names.
+func (db *DB) AllStoredStats() map[string]*StoredStats {
+	tables := make(map[string]*table)
+	db.tablesMutex.RLock()
+	for name, t := range db.tables {
+		tables[name] = t
+	}
+	db.tablesMutex.RUnlock()
+
+	m := make(map[string]*StoredStats, len(tables))
+	for name, t := range tables {
+		if t.rowStore == nil {
+			continue
+		}
+		stats := t.rowStore.getStoredStats()
+		if stats != nil {
+			m[name] = stats
+		}
+	}
+	return m
+}
+
+// distinctCounter estimates the number of distinct values of each dimension in
+// a set of keys.
+type distinctCounter map[string]*hllpp.HLLPP
+
+func (dc distinctCounter) add(key bytemap.ByteMap) {
+	key.Iterate(false, true, func(dim string, value interface{}, valueBytes []byte) bool {
+		hlp := dc[dim]
+		if hlp == nil {
+			hlp = hllpp.New()
+			dc[dim] = hlp
+		}
+		hlp.Add(valueBytes)
+		return true
+	})
+}
+
+// marshal serializes the estimates so that they can be stored with a segment.
+func (dc distinctCounter) marshal() map[string][]byte {
+	serialized := make(map[string][]byte, len(dc))
+	for dim, hlp := range dc {
+		serialized[dim] = hlp.Marshal()
+	}
+	return serialized
+}
+
+// merge merges serialized estimates into this distinctCounter.
+func (dc distinctCounter) merge(serialized map[string][]byte) error {
+	for dim, b := range serialized {
+		hlp, err := hllpp.Unmarshal(b)
+		if err != nil {
+			return errors.New("Unable to unmarshal distinct values for %v: %v", dim, err)
+		}
+		existing := dc[dim]
+		if existing == nil {
+			dc[dim] = hlp
+			continue
+		}
+		err = existing.Merge(hlp)
+		if err != nil {
+			return errors.New("Unable to merge distinct values for %v: %v", dim, err)
+		}
+	}
+	return nil
+}
+
+func (dc distinctCounter) counts() map[string]uint64 {
+	counts := make(map[string]uint64, len(dc))
+	for dim, hlp := range dc {
+		counts[dim] = hlp.Count()
+	}
+	return counts
+}
+
+// updateStoredStats updates and persists the stored stats after a flush. It
+// is only called from the processInserts goroutine.
+func (rs *rowStore) updateStoredStats(fileSize int64, rows int, distinct distinctCounter, highWaterMark int64) {
+	now := rs.t.db.clock.Now()
+	rs.t.statsMutex.RLock()
+	insertedPoints := rs.t.stats.InsertedPoints
+	rs.t.statsMutex.RUnlock()
+
+	stats := &StoredStats{
+		Updated:        now,
+		FileSize:       fileSize,
+		Rows:           int64(rows),
+		DistinctValues: distinct.counts(),
+		HighWaterMark:  encoding.TimeFromInt(highWaterMark),
+	}
+	if !rs.statsInsertedSince.IsZero() {
+		elapsed := now.Sub(rs.statsInsertedSince).Seconds()
+		if elapsed > 0 {
+			stats.InsertRate = float64(insertedPoints-rs.statsInsertedPoints) / elapsed
+		}
+	}
+	rs.statsInsertedPoints = insertedPoints
+	rs.statsInsertedSince = now
+
+	rs.mx.Lock()
+	rs.storedStats = stats
+	rs.mx.Unlock()
+
+	err := rs.writeStoredStats(stats)
+	if err != nil {
+		rs.t.log.Errorf("Unable to write stored stats: %v", err)
+	}
+}
+
+// updateStoredStatsFromSegments updates the stored stats based on the current
+// segments. It is only called from the processInserts goroutine.
+func (rs *rowStore) updateStoredStatsFromSegments() {
+	rs.mx.RLock()
+	fs := rs.fileStore
+	rs.mx.RUnlock()
+
+	fileSize := int64(0)
+	rows := 0
+	highWaterMark := int64(0)
+	distinct := make(distinctCounter)
+	for _, seg := range fs.segments {
+		fileSize += seg.Size
+		rows += seg.Rows
+		if seg.HighWaterMark > highWaterMark {
+			highWaterMark = seg.HighWaterMark
+		}
+		err := distinct.merge(seg.Distinct)
+		if err != nil {
+			rs.t.log.Errorf("Unable to count distinct values in %v: %v", seg.Name, err)
+		}
+	}
+	rs.updateStoredStats(fileSize, rows, distinct, highWaterMark)
+}
+
+func (rs *rowStore) getStoredStats() *StoredStats {
+	rs.mx.RLock()
+	defer rs.mx.RUnlock()
+	return rs.storedStats
+}
+
+func (rs *rowStore) writeStoredStats(stats *StoredStats) error {
+	out, err := ioutil.TempFile("", "nextstats")
+	if err != nil {
+		return errors.New("Unable to create stats file: %v", err)
+	}
+	defer out.Close()
+
+	err = json.NewEncoder(out).Encode(stats)
+	if err != nil {
+		return errors.New("Unable to write stats: %v", err)
+	}
+	err = out.Sync()
+	if err != nil {
+		return errors.New("Unable to sync stats file: %v", err)
+	}
+	err = out.Close()
+	if err != nil {
+		return errors.New("Unable to close stats file: %v", err)
+	}
+
+	return os.Rename(out.Name(), filepath.Join(rs.opts.dir, statsFilename))
+}
+
+func readStoredStats(dir string) (*StoredStats, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, statsFilename))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	stats := &StoredStats{}
+	err = json.Unmarshal(b, stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+var storedStatsTableFields = core.Fields{
+	core.NewField("size_bytes", expr.SUM("size_bytes")),
+	core.NewField("num_rows", expr.SUM("num_rows")),
+	core.NewField("insert_rate", expr.SUM("insert_rate")),
+	core.NewField("high_water_mark", expr.MAX("high_water_mark")),
+	core.NewField("updated", expr.MAX("updated")),
+	core.NewField("distinct_values", expr.SUM("distinct_values")),
+}
+
+// storedStatsTable exposes the StoredStats of all tables as the system table
+// _stats. Every table has a row keyed by table_name, and every dimension in
+// every table has a row keyed by table_name and dim_name that contains its
+// distinct_values. Times are reported as seconds since the epoch. Values are
+// aggregated with SUM and MAX so that querying _stats in a cluster combines
+// the stats from all partitions.
+//
+// Only the distinct value counts, not the underlying sketches, are available
+// to the query, so in a cluster distinct_values is the sum of every
+// partition's count. A value that appears in several partitions is counted
+// once per partition, making distinct_values an upper bound rather than an
+// estimate of the number of distinct values across the cluster.
+func (db *DB) storedStatsTable() *systemTable {
+	return db.newSystemTable(StatsTable, storedStatsTableFields, func() []*systemRow {
+		all := db.AllStoredStats()
+		tableNames := make([]string, 0, len(all))
+		for name := range all {
+			tableNames = append(tableNames, name)
+		}
+		sort.Strings(tableNames)
+
+		var rows []*systemRow
+		for _, name := range tableNames {
+			stats := all[name]
+			rows = append(rows, &systemRow{
+				key: map[string]interface{}{"table_name": name},
+				vals: map[string]float64{
+					"size_bytes":      float64(stats.FileSize),
+					"num_rows":        float64(stats.Rows),
+					"insert_rate":     stats.InsertRate,
+					"high_water_mark": float64(stats.HighWaterMark.Unix()),
+					"updated":         float64(stats.Updated.Unix()),
+				},
+			})
+			dims := make([]string, 0, len(stats.DistinctValues))
+			for dim := range stats.DistinctValues {
+				dims = append(dims, dim)
+			}
+			sort.Strings(dims)
+			for _, dim := range dims {
+				rows = append(rows, &systemRow{
+					key: map[string]interface{}{"table_name": name, "dim_name": dim},
+					vals: map[string]float64{
+						"distinct_values": float64(stats.DistinctValues[dim]),
+					},
+				})
+			}
+		}
+		return rows
+	})
+}
@@ -0,0 +1,88 @@
+package zenodb
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/golog"
+	"github.com/getlantern/vtime"
+	"github.com/getlantern/zenodb/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStoredStats(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "storedstats")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	now := time.Date(2017, time.January, 1, 2, 3, 4, 0, time.UTC)
+	db := &DB{
+		log:    golog.LoggerFor("stored_stats_test"),
+		clock:  vtime.NewVirtualClock(now),
+		tables: make(map[string]*table),
+	}
+	tbl := &table{TableOpts: &TableOpts{Name: "mytable"}, db: db, log: db.log}
+	tbl.rowStore = &rowStore{t: tbl, opts: &rowStoreOptions{dir: tmpDir}}
+	db.tables["mytable"] = tbl
+
+	stats, err := readStoredStats(tmpDir)
+	if assert.NoError(t, err) {
+		assert.Nil(t, stats, "Missing stats file should yield no stats")
+	}
+
+	distinct := make(distinctCounter)
+	for _, key := range []map[string]interface{}{
+		{"a": 1, "b": "x"},
+		{"a": 2, "b": "x"},
+		{"a": 3},
+	} {
+		distinct.add(bytemap.New(key))
+	}
+	tbl.stats.InsertedPoints = 10
+	tbl.rowStore.updateStoredStats(1000, 3, distinct, now.UnixNano())
+	db.clock.Advance(now.Add(10 * time.Second))
+	tbl.stats.InsertedPoints = 60
+	tbl.rowStore.updateStoredStats(2000, 3, distinct, now.UnixNano())
+
+	stats, err = readStoredStats(tmpDir)
+	if !assert.NoError(t, err) || !assert.NotNil(t, stats) {
+		return
+	}
+	assert.EqualValues(t, 2000, stats.FileSize)
+	assert.EqualValues(t, 3, stats.Rows)
+	assert.Equal(t, map[string]uint64{"a": 3, "b": 1}, stats.DistinctValues)
+	assert.EqualValues(t, 5, stats.InsertRate)
+	assert.Equal(t, now.Unix(), stats.HighWaterMark.Unix())
+
+	st := db.getSystemTable(StatsTable)
+	if !assert.NotNil(t, st) {
+		return
+	}
+	var keys []bytemap.ByteMap
+	var vals []core.Vals
+	var fields core.Fields
+	_, err = st.Iterate(context.Background(), func(inFields core.Fields) error {
+		fields = inFields
+		return nil
+	}, func(key bytemap.ByteMap, rowVals core.Vals) (bool, error) {
+		keys = append(keys, key)
+		vals = append(vals, rowVals)
+		return true, nil
+	})
+	if !assert.NoError(t, err) || !assert.Len(t, keys, 3) {
+		return
+	}
+	assert.Equal(t, "mytable", keys[0].Get("table_name"))
+	assert.Nil(t, keys[0].Get("dim_name"))
+	sizeBytes, _ := vals[0][0].ValueAt(0, fields[0].Expr)
+	assert.EqualValues(t, 2000, sizeBytes)
+	assert.Equal(t, "a", keys[1].Get("dim_name"))
+	distinctValues, _ := vals[1][5].ValueAt(0, fields[5].Expr)
+	assert.EqualValues(t, 3, distinctValues)
+}
@@ -0,0 +1,113 @@
+package zenodb
+
+import (
+	"context"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/getlantern/zenodb/common"
+	"github.com/getlantern/zenodb/core"
+	"github.com/getlantern/zenodb/encoding"
+)
+
+const (
+	systemTableResolution = 1 * time.Minute
+)
+
+// systemRow is a single row in a systemTable.
+type systemRow struct {
+	key  map[string]interface{}
+	vals map[string]float64
+}
+
+// systemTable is a planner.Table whose rows are generated at query time from
+// the database's own state rather than read from disk. All values are reported
+// in the single period ending at until.
+type systemTable struct {
+	name   string
+	fields core.Fields
+	asOf   time.Time
+	until  time.Time
+	rows   func() []*systemRow
+}
+
+func (db *DB) newSystemTable(name string, fields core.Fields, rows func() []*systemRow) *systemTable {
+	until := encoding.RoundTimeUp(db.clock.Now(), systemTableResolution)
+	return &systemTable{
+		name:   name,
+		fields: fields,
+		asOf:   until.Add(-1 * systemTableResolution),
+		until:  until,
+		rows:   rows,
+	}
+}
+
+// getSystemTable returns the named system table, or nil if there is no system
+// table by that name.
+func (db *DB) getSystemTable(name string) *systemTable {
+	switch name {
+	case StatsTable:
+		return db.storedStatsTable()
+	case CatalogTables:
+		return db.catalogTablesTable()
+	case CatalogFields:
+		return db.catalogFieldsTable()
+	case CatalogStreams:
+		return db.catalogStreamsTable()
+	}
+	return nil
+}
+
+func (st *systemTable) Iterate(ctx context.Context, onFields core.OnFields, onRow core.OnRow) (interface{}, error) {
+	err := onFields(st.fields)
+	if err != nil {
+		return nil, err
+	}
+
+	guard := core.Guard(ctx)
+	for _, row := range st.rows() {
+		vals := make(core.Vals, len(st.fields))
+		for i, field := range st.fields {
+			val, found := row.vals[field.Name]
+			if found {
+				vals[i] = encoding.NewFloatValue(field.Expr, st.until, val)
+			}
+		}
+		more, err := guard.ProceedAfter(onRow(bytemap.New(row.key), vals))
+		if !more || err != nil {
+			return nil, err
+		}
+	}
+
+	untilMillis := common.TimeToMillis(st.until)
+	return &common.QueryStats{
+		NumPartitions:           1,
+		NumSuccessfulPartitions: 1,
+		LowestHighWaterMark:     untilMillis,
+		HighestHighWaterMark:    untilMillis,
+	}, nil
+}
+
+func (st *systemTable) GetGroupBy() []core.GroupBy {
+	return nil
+}
+
+func (st *systemTable) GetResolution() time.Duration {
+	return systemTableResolution
+}
+
+func (st *systemTable) GetAsOf() time.Time {
+	return st.asOf
+}
+
+func (st *systemTable) GetUntil() time.Time {
+	return st.until
+}
+
+func (st *systemTable) GetPartitionBy() []string {
+	return nil
+}
+
+func (st *systemTable) String() string {
+	return st.name
+}
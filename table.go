@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/getlantern/bytemap"
@@ -24,42 +25,57 @@ import (
 // TableStats presents statistics for a given table (currently only since the
 // last time the database process was started).
 type TableStats struct {
-	FilteredPoints int64
-	QueuedPoints   int64
-	InsertedPoints int64
-	DroppedPoints  int64
-	ExpiredValues  int64
+	FilteredPoints    int64
+	QueuedPoints      int64
+	InsertedPoints    int64
+	DroppedPoints     int64
+	ExpiredValues     int64
+	ExpiredSegments   int64
+	ExpiredBytes      int64
+	OffloadedSegments int64
+	OffloadedBytes    int64
 }
 
 // TableOpts configures a table.
 type TableOpts struct {
 	// Name is the name of the table.
-	Name string
+	Name string `yaml:"-"`
 	// View indicates if this table is a view on top of an existing table.
-	View bool
+	View bool `yaml:"view,omitempty"`
 	// MinFlushLatency sets a lower bound on how frequently the memstore is
 	// flushed to disk.
-	MinFlushLatency time.Duration
+	MinFlushLatency time.Duration `yaml:"minflushlatency,omitempty"`
 	// MaxFlushLatency sets an upper bound on how long to wait before flushing the
 	// memstore to disk.
-	MaxFlushLatency time.Duration
+	MaxFlushLatency time.Duration `yaml:"maxflushlatency,omitempty"`
 	// RetentionPeriod limits how long data is kept in the table (based on the
-	// timestamp of the data itself).
-	RetentionPeriod time.Duration
+	// timestamp of the data itself). Views default to the RetentionPeriod of
+	// their table.
+	RetentionPeriod time.Duration `yaml:"retentionperiod,omitempty"`
 	// Backfill limits how far back to grab data from the WAL when first creating
 	// a table. If 0, backfill is limited only by the RetentionPeriod.
-	Backfill time.Duration
+	Backfill time.Duration `yaml:"backfill,omitempty"`
+	// SegmentDuration sets the time range covered by each segment file on disk.
+	// Queries only read segments that overlap the time range they query.
+	// Defaults to DefaultSegmentDuration and is rounded up to a multiple of the
+	// table's resolution. It can't be changed once the table exists.
+	SegmentDuration time.Duration `yaml:"segmentduration,omitempty"`
+	// ColdAfter, if set, moves segments whose data is entirely older than this
+	// to the database's ColdStore. Queries still read cold segments, but only
+	// when the queried time range includes them. Altering it only affects
+	// segments that aren't cold yet.
+	ColdAfter time.Duration `yaml:"coldafter,omitempty"`
 	// PartitionBy can be used in clustered deployments to decide which
 	// dimensions to use in partitioning data. If unspecified, all dimensions are
 	// used for partitioning.
-	PartitionBy []string
+	PartitionBy []string `yaml:"partitionby,omitempty"`
 	// SQL is the SELECT query that determines the fields, filtering and input
 	// source for this table.
-	SQL string
+	SQL string `yaml:"sql"`
 	// Virtual, if true, means that the table's data isn't actually stored or
 	// queryable. Virtual tables are useful for defining a base set of fields
 	// from which other tables can select.
-	Virtual      bool
+	Virtual      bool `yaml:"virtual,omitempty"`
 	dependencyOf []*TableOpts
 }
 
@@ -76,17 +92,32 @@ type table struct {
 	statsMutex          sync.RWMutex
 	wal                 *wal.Reader
 	readOffset          wal.Offset
+	walIdleSince        int64
 	iterations          *iteration
 	highWaterMarkDisk   int64
 	highWaterMarkMemory int64
 	highWaterMarkMx     sync.RWMutex
+	retentionPeriod     int64
+	coldAfter           int64
+	dropped             chan interface{}
+	tasks               sync.WaitGroup
 }
 
+const (
+	iterationPending = iota
+	iterationStarted
+	iterationAbandoned
+)
+
 type iteration struct {
 	t               *table
 	ctx             context.Context
+	guard           core.TimeoutGuard
+	state           int32
 	outFields       core.Fields
 	includeMemStore bool
+	asOf            time.Time
+	until           time.Time
 	onValue         func(bytemap.ByteMap, []encoding.Sequence) (more bool, err error)
 	fieldMappings   map[int]int
 	offsetsCh       chan common.OffsetsBySource
@@ -123,11 +154,14 @@ func (db *DB) CreateTable(opts *TableOpts) error {
 	opts.Name = strings.ToLower(opts.Name)
 
 	t := &table{
-		TableOpts: opts,
-		Query:     *q,
-		fields:    fields,
-		db:        db,
-		log:       golog.LoggerFor(fmt.Sprintf("%v.%v", db.opts.logLabel(), opts.Name)),
+		TableOpts:       opts,
+		Query:           *q,
+		fields:          fields,
+		db:              db,
+		log:             golog.LoggerFor(fmt.Sprintf("%v.%v", db.opts.logLabel(), opts.Name)),
+		retentionPeriod: int64(opts.RetentionPeriod),
+		coldAfter:       int64(opts.ColdAfter),
+		dropped:         make(chan interface{}),
 	}
 
 	t.log.Debugf("Fields will be: %v", fields)
@@ -153,6 +187,8 @@ func (db *DB) CreateTable(opts *TableOpts) error {
 				dir:             filepath.Join(db.opts.Dir, t.Name),
 				minFlushLatency: t.MinFlushLatency,
 				maxFlushLatency: t.MaxFlushLatency,
+				segmentDuration: t.segmentDuration(),
+				coldStore:       db.opts.ColdStore,
 			})
 			if rsErr != nil {
 				return rsErr
@@ -168,7 +204,7 @@ func (db *DB) CreateTable(opts *TableOpts) error {
 
 			t.log.Debugf("Starting at WAL offsets %v", offsetsBySource)
 
-			t.db.Go(t.logHighWaterMark)
+			t.Go(t.logHighWaterMark)
 		}
 
 		if t.db.opts.Follow != nil {
@@ -186,6 +222,18 @@ func (t *table) Alter(opts *TableOpts) error {
 	if err != nil {
 		return err
 	}
+	if !t.Virtual && segmentDurationFor(opts.SegmentDuration, t.Resolution) != t.segmentDuration() {
+		// existing segments are partitioned by the old duration
+		return errors.New("Unable to change SegmentDuration of existing table %v, please create a new table instead", t.Name)
+	}
+	err = t.applyRetentionPeriod(opts.RetentionPeriod)
+	if err != nil {
+		return err
+	}
+	err = t.applyColdAfter(opts.ColdAfter)
+	if err != nil {
+		return err
+	}
 	t.applyWhere(q.Where)
 	t.applyFields(fields)
 	return nil
@@ -225,6 +273,10 @@ func (db *DB) queryAndFields(opts *TableOpts) (q *sql.Query, fields core.Fields,
 			opts.PartitionBy = t.PartitionBy
 		}
 
+		if opts.RetentionPeriod <= 0 {
+			opts.RetentionPeriod = t.getRetentionPeriod()
+		}
+
 		// Combine where clauses
 		if t.Where != nil {
 			if q.Where == nil {
@@ -276,7 +328,7 @@ func (t *table) startFollowing(offsetsBySource common.OffsetsBySource) {
 		t.db.newStreamSubscriber[t.From] = newSubscriber
 	}
 	t.db.newStreamSubscriberMx.Unlock()
-	newSubscriber <- &tableWithOffsets{t, offsetsBySource}
+	newSubscriber <- &tableWithOffsets{t: t, os: offsetsBySource}
 }
 
 func (t *table) startWALProcessing(walOffset wal.Offset) error {
@@ -293,7 +345,7 @@ func (t *table) startWALProcessing(walOffset wal.Offset) error {
 			return walErr
 		}
 		t.db.Go(func(stop <-chan interface{}) {
-			t.db.capWALAge(w, stop)
+			t.db.retainWAL(t.From, w, stop)
 		})
 		t.db.streams[t.From] = w
 	}
@@ -359,8 +411,24 @@ func (t *table) getWhere() goexpr.Expr {
 	return where
 }
 
+// segmentDuration returns the table's SegmentDuration, rounded up to a
+// multiple of its resolution.
+func (t *table) segmentDuration() time.Duration {
+	return segmentDurationFor(t.SegmentDuration, t.Resolution)
+}
+
+func segmentDurationFor(d time.Duration, resolution time.Duration) time.Duration {
+	if d <= 0 {
+		d = DefaultSegmentDuration
+	}
+	if remainder := d % resolution; remainder != 0 {
+		d += resolution - remainder
+	}
+	return d
+}
+
 func (t *table) truncateBefore() time.Time {
-	return t.db.clock.Now().Add(-1 * t.RetentionPeriod)
+	return t.db.clock.Now().Add(-1 * t.getRetentionPeriod())
 }
 
 func (t *table) backfillTo() time.Time {
@@ -370,7 +438,9 @@ func (t *table) backfillTo() time.Time {
 	return t.db.clock.Now().Add(-1 * t.Backfill)
 }
 
-func (t *table) iterate(ctx context.Context, outFields core.Fields, includeMemStore bool, onValue func(bytemap.ByteMap, []encoding.Sequence) (more bool, err error)) (common.OffsetsBySource, error) {
+// iterate iterates over the table's data. Data outside of the time range from
+// asOf to until may be skipped, zero asOf or until mean unbounded.
+func (t *table) iterate(ctx context.Context, outFields core.Fields, includeMemStore bool, asOf time.Time, until time.Time, onValue func(bytemap.ByteMap, []encoding.Sequence) (more bool, err error)) (common.OffsetsBySource, error) {
 	origOnValue := onValue
 	iterCount := 0
 	defer func() {
@@ -383,13 +453,33 @@ func (t *table) iterate(ctx context.Context, outFields core.Fields, includeMemSt
 	it := &iteration{
 		t:               t,
 		ctx:             ctx,
+		guard:           core.Guard(ctx),
 		outFields:       outFields,
 		includeMemStore: includeMemStore,
+		asOf:            asOf,
+		until:           until,
 		onValue:         onValue,
 		offsetsCh:       make(chan common.OffsetsBySource, 1),
 		errCh:           make(chan error, 1),
 	}
-	t.db.requestedIterations <- it
+	select {
+	case t.db.requestedIterations <- it:
+		// submitted
+	case <-ctx.Done():
+		return nil, it.guard.Err()
+	}
+
+	select {
+	case <-ctx.Done():
+		if it.abandon() {
+			// Iteration hadn't started yet, no need to wait for it
+			return nil, it.guard.Err()
+		}
+		// Iteration already started, wait for it to notice that it's been
+		// canceled.
+	case offsetsBySource := <-it.offsetsCh:
+		return offsetsBySource, <-it.errCh
+	}
 	return <-it.offsetsCh, <-it.errCh
 }
 
@@ -435,9 +525,23 @@ func (db *DB) processIterations() {
 	}
 }
 
-func (db *DB) doProcessIterations(iterations []*iteration) {
+func (db *DB) doProcessIterations(allIterations []*iteration) {
+	iterations := make([]*iteration, 0, len(allIterations))
+	for _, it := range allIterations {
+		if it.start() {
+			iterations = append(iterations, it)
+		}
+	}
+	if len(iterations) == 0 {
+		// All iterations were canceled before we got to them
+		return
+	}
+
 	var maxDeadline time.Time
 	includeMemStore := false
+	// read the union of the time ranges of all iterations
+	asOf := iterations[0].asOf
+	until := iterations[0].until
 	allOutFields := make(core.Fields, 0)
 	hasOutField := func(field core.Field) bool {
 		for _, existingField := range allOutFields {
@@ -450,6 +554,12 @@ func (db *DB) doProcessIterations(iterations []*iteration) {
 
 	for _, it := range iterations {
 		includeMemStore = includeMemStore || it.includeMemStore
+		if it.asOf.IsZero() || it.asOf.Before(asOf) {
+			asOf = it.asOf
+		}
+		if !until.IsZero() && (it.until.IsZero() || it.until.After(until)) {
+			until = it.until
+		}
 		deadline, hasDeadline := it.ctx.Deadline()
 		if hasDeadline && deadline.After(maxDeadline) {
 			maxDeadline = deadline
@@ -478,10 +588,17 @@ func (db *DB) doProcessIterations(iterations []*iteration) {
 	for i, it := range iterations {
 		remainingIterations[i] = it
 	}
+	iterationErrs := make(map[int]error, len(iterations))
 
 	combinedOnValue := func(dims bytemap.ByteMap, vals []encoding.Sequence) (bool, error) {
 		more := false
 		for i, it := range remainingIterations {
+			if guardErr := it.guard.Err(); guardErr != nil {
+				// This iteration has been canceled or timed out, stop feeding it
+				iterationErrs[i] = guardErr
+				delete(remainingIterations, i)
+				continue
+			}
 			itVals := make([]encoding.Sequence, len(it.outFields))
 			for i, val := range vals {
 				itI := it.fieldMappings[i]
@@ -510,16 +627,32 @@ func (db *DB) doProcessIterations(iterations []*iteration) {
 		newCtx, cancel = context.WithDeadline(newCtx, maxDeadline)
 		defer cancel()
 	}
-	offsetsBySource, err := iterations[0].t.rowStore.iterate(newCtx, allOutFields, includeMemStore, combinedOnValue)
+	offsetsBySource, err := iterations[0].t.rowStore.iterate(newCtx, allOutFields, includeMemStore, asOf, until, combinedOnValue)
 	if err != nil {
 		iterations[0].t.log.Errorf("Got error while iterating: %v", err)
 	}
-	for _, it := range iterations {
+	for i, it := range iterations {
 		it.offsetsCh <- offsetsBySource
-		it.errCh <- err
+		itErr := iterationErrs[i]
+		if itErr == nil {
+			itErr = err
+		}
+		it.errCh <- itErr
 	}
 }
 
+// start marks the iteration as started, returning false if it had already been
+// abandoned.
+func (it *iteration) start() bool {
+	return atomic.CompareAndSwapInt32(&it.state, iterationPending, iterationStarted)
+}
+
+// abandon marks the iteration as abandoned, returning false if it had already
+// been started.
+func (it *iteration) abandon() bool {
+	return atomic.CompareAndSwapInt32(&it.state, iterationPending, iterationAbandoned)
+}
+
 func (it *iteration) indexOfOutField(field core.Field) int {
 	for i, existingField := range it.outFields {
 		if existingField.String() == field.String() {
@@ -529,33 +662,6 @@ func (it *iteration) indexOfOutField(field core.Field) int {
 	return -1
 }
 
-// shouldSort determines whether or not a flush should be sorted. The flush will
-// sort if the table is the next table in line to be sorted, and no other sort
-// is currently happening. If shouldSort returns true, the flushing process
-// must call stopSorting when finished so that other tables have a chance to
-// sort.
-func (t *table) shouldSort() bool {
-	if t.db.opts.MaxMemoryRatio <= 0 {
-		return false
-	}
-
-	t.db.tablesMutex.RLock()
-	if t.db.nextTableToSort >= len(t.db.orderedTables) {
-		t.db.nextTableToSort = 0
-	}
-	nextTableToSort := t.db.orderedTables[t.db.nextTableToSort]
-	result := t.Name == nextTableToSort.Name && !t.db.isSorting
-	t.db.tablesMutex.RUnlock()
-	return result
-}
-
-func (t *table) stopSorting() {
-	t.db.tablesMutex.RLock()
-	t.db.isSorting = false
-	t.db.nextTableToSort++
-	t.db.tablesMutex.RUnlock()
-}
-
 func (t *table) memStoreSize() int {
 	return t.rowStore.memStoreSize()
 }
@@ -0,0 +1,268 @@
+package tiered
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultS3Region is the region used for signing requests if none is
+	// specified.
+	DefaultS3Region = "us-east-1"
+
+	// DefaultS3Timeout is the timeout for requests (including reading the
+	// response body) if no Client is specified.
+	DefaultS3Timeout = 10 * time.Minute
+
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3Opts configures a Store backed by an S3-compatible object store like AWS
+// S3 or MinIO.
+type S3Opts struct {
+	// Endpoint is the base URL of the object store, e.g.
+	// https://s3.us-east-1.amazonaws.com or http://localhost:9000.
+	Endpoint string
+	// Bucket is the bucket in which to store objects. Buckets are addressed
+	// path-style, i.e. <Endpoint>/<Bucket>/<key>.
+	Bucket string
+	// Region is the region used for signing requests, defaults to
+	// DefaultS3Region.
+	Region string
+	// AccessKeyID and SecretAccessKey are the credentials used for signing
+	// requests.
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every key
+	Prefix string
+	// Client is the http.Client used for requests, defaults to a client with a
+	// timeout of DefaultS3Timeout.
+	Client *http.Client
+}
+
+// NewS3Store creates a Store that keeps objects in an S3-compatible object
+// store. Requests are authenticated using AWS Signature Version 4.
+func NewS3Store(opts *S3Opts) (Store, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint %v: %v", opts.Endpoint, err)
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %v: please specify a scheme and host", opts.Endpoint)
+	}
+	if opts.Bucket == "" {
+		return nil, fmt.Errorf("please specify a bucket")
+	}
+	s := &s3Store{
+		opts:     *opts,
+		endpoint: endpoint,
+		client:   opts.Client,
+	}
+	if s.opts.Region == "" {
+		s.opts.Region = DefaultS3Region
+	}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: DefaultS3Timeout}
+	}
+	return s, nil
+}
+
+type s3Store struct {
+	opts     S3Opts
+	endpoint *url.URL
+	client   *http.Client
+}
+
+func (s *s3Store) Put(key string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open %v: %v", path, err)
+	}
+	defer file.Close()
+	fi, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %v: %v", path, err)
+	}
+
+	req, err := s.newRequest(http.MethodPut, key, file)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = fi.Size()
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) Open(key string) (io.ReadCloser, error) {
+	req, err := s.newRequest(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3Store) Delete(key string) error {
+	req, err := s.newRequest(http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Store) newRequest(method string, key string, body io.Reader) (*http.Request, error) {
+	u := *s.endpoint
+	u.Path = path.Join("/", u.Path, s.opts.Bucket, s.opts.Prefix+key)
+	u.RawPath = uriEncode(u.Path, false)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build %v request for %v: %v", method, key, err)
+	}
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+	return req, nil
+}
+
+// do signs and executes the given request. If the object store responds with
+// anything other than a 2xx status, do returns the response (with its body
+// closed) along with an error.
+func (s *s3Store) do(req *http.Request) (*http.Response, error) {
+	signV4(req, s.opts.AccessKeyID, s.opts.SecretAccessKey, s.opts.Region, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to %v %v: %v", req.Method, req.URL, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return resp, fmt.Errorf("unexpected response to %v %v: %v %v", req.Method, req.URL, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// signV4 signs the given request using AWS Signature Version 4 as described at
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-header-based-auth.html.
+// The request's x-amz-content-sha256 header has to be set to either the hex
+// encoded SHA256 of the payload or UNSIGNED-PAYLOAD. All other headers of the
+// request are signed too.
+func signV4(req *http.Request, accessKeyID string, secretAccessKey string, region string, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name)
+		canonicalHeaders.WriteByte(':')
+		canonicalHeaders.WriteString(headers[name])
+		canonicalHeaders.WriteByte('\n')
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	query := req.URL.Query()
+	queryKeys := make([]string, 0, len(query))
+	for key := range query {
+		queryKeys = append(queryKeys, key)
+	}
+	sort.Strings(queryKeys)
+	var canonicalQuery []string
+	for _, key := range queryKeys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			canonicalQuery = append(canonicalQuery, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		strings.Join(canonicalQuery, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v", accessKeyID, scope, signedHeaders, signature))
+}
+
+// uriEncode encodes s as required by AWS Signature Version 4, i.e. it escapes
+// everything except unreserved characters and, if encodeSlash is false, '/'.
+func uriEncode(s string, encodeSlash bool) string {
+	var result strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			result.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			result.WriteByte(c)
+		default:
+			fmt.Fprintf(&result, "%%%02X", c)
+		}
+	}
+	return result.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
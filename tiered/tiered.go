@@ -0,0 +1,94 @@
+// Package tiered provides secondary storage backends to which zenodb tables
+// can offload segments that are too old to be queried frequently. Stores are
+// simple object stores that hold immutable files under string keys.
+package tiered
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Store is a secondary storage backend for table segments.
+type Store interface {
+	// Put uploads the file at the given local path under key, replacing any
+	// existing object with the same key.
+	Put(key string, path string) error
+
+	// Open opens the object stored under key for reading.
+	Open(key string) (io.ReadCloser, error)
+
+	// Delete removes the object stored under key. Deleting an object that
+	// doesn't exist is not an error.
+	Delete(key string) error
+}
+
+// NewDirStore creates a Store that keeps objects as files in the given
+// directory, for example on a slower but larger local disk or on a network
+// mount.
+func NewDirStore(dir string) (Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create directory %v: %v", dir, err)
+	}
+	return &dirStore{dir}, nil
+}
+
+type dirStore struct {
+	dir string
+}
+
+func (s *dirStore) Put(key string, path string) error {
+	dst := s.pathFor(key)
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create directory for %v: %v", key, err)
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open %v: %v", path, err)
+	}
+	defer in.Close()
+
+	// Write to a temp file in the same directory and rename it into place so
+	// that readers never see a partially written object.
+	out, err := ioutil.TempFile(filepath.Dir(dst), ".put")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file for %v: %v", key, err)
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		return fmt.Errorf("unable to copy %v to %v: %v", path, key, err)
+	}
+	err = out.Sync()
+	if err != nil {
+		return fmt.Errorf("unable to sync %v: %v", key, err)
+	}
+	err = out.Close()
+	if err != nil {
+		return fmt.Errorf("unable to close %v: %v", key, err)
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+func (s *dirStore) Open(key string) (io.ReadCloser, error) {
+	return os.Open(s.pathFor(key))
+}
+
+func (s *dirStore) Delete(key string) error {
+	err := os.Remove(s.pathFor(key))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *dirStore) pathFor(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
@@ -0,0 +1,57 @@
+package tiered
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirStore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "tieredtest")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	store, err := NewDirStore(filepath.Join(tmpDir, "cold"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	testStore(t, store, tmpDir)
+}
+
+// testStore runs a round trip through the given store, using tmpDir for
+// scratch files.
+func testStore(t *testing.T, store Store, tmpDir string) {
+	src := filepath.Join(tmpDir, "src")
+	if !assert.NoError(t, ioutil.WriteFile(src, []byte("hello world"), 0644)) {
+		return
+	}
+
+	key := "table_a/segment_1"
+	_, err := store.Open(key)
+	assert.Error(t, err, "Opening missing object should fail")
+
+	if !assert.NoError(t, store.Put(key, src)) {
+		return
+	}
+	r, err := store.Open(key)
+	if !assert.NoError(t, err) {
+		return
+	}
+	b, err := ioutil.ReadAll(r)
+	r.Close()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hello world", string(b))
+	}
+
+	if !assert.NoError(t, store.Delete(key)) {
+		return
+	}
+	_, err = store.Open(key)
+	assert.Error(t, err, "Opening deleted object should fail")
+	assert.NoError(t, store.Delete(key), "Deleting missing object should succeed")
+}
@@ -0,0 +1,230 @@
+package zenodb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/getlantern/wal"
+	"github.com/getlantern/zenodb/common"
+	"github.com/getlantern/zenodb/metrics"
+)
+
+const (
+	walRetentionInterval = 1 * time.Minute
+
+	// followerPinInterval limits how often the offsets of connected followers
+	// are republished while sending entries to them.
+	followerPinInterval = 10 * time.Second
+
+	// defaultFollowerPinTTL limits how long the WAL is retained for a follower
+	// that has disconnected if MaxFollowAge isn't set.
+	defaultFollowerPinTTL = 24 * time.Hour
+)
+
+// walPin identifies the earliest offset in a WAL that's still needed by
+// something (a table, a follower or MaxFollowAge).
+type walPin struct {
+	by     string
+	offset wal.Offset
+}
+
+// retainWAL periodically truncates the given stream's WAL to remove data that
+// is no longer needed by any table or follower, and compresses older WAL
+// segments.
+func (db *DB) retainWAL(stream string, w *wal.WAL, stop <-chan interface{}) {
+	ticker := time.NewTicker(walRetentionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			db.waitForBackupToFinish(stop)
+			// don't remove WAL files while a snapshot is linking them
+			db.snapshotMx.RLock()
+			db.truncateWAL(stream, w)
+			db.snapshotMx.RUnlock()
+		}
+	}
+}
+
+func (db *DB) truncateWAL(stream string, w *wal.WAL) {
+	pin := db.walPinFor(stream)
+	if pin != nil {
+		metrics.WALPinned(stream, pin.by, pin.offset, db.clock.Now())
+		if len(pin.offset) > 0 {
+			db.log.Debugf("Truncating WAL for %v before %v, pinned by %v", stream, pin.offset.TS().In(time.UTC), pin.by)
+			err := w.TruncateBefore(pin.offset)
+			if err != nil {
+				db.log.Errorf("Error truncating WAL for %v before %v: %v", stream, pin.offset, err)
+			}
+		}
+	}
+
+	// MaxWALSize is a hard limit that applies even if data is still needed
+	err := w.TruncateToSize(int64(db.opts.MaxWALSize))
+	if err != nil {
+		db.log.Errorf("Error truncating WAL: %v", err)
+	}
+	err = w.CompressBeforeSize(int64(db.opts.WALCompressionSize))
+	if err != nil {
+		db.log.Errorf("Error compressing WAL: %v", err)
+	}
+}
+
+// walPinFor determines the lowest offset in the given stream's WAL that's still
+// needed by any table, follower or, on passthrough nodes, by MaxFollowAge. If
+// nothing needs the WAL, this returns nil, in which case the WAL is only
+// limited by MaxWALSize.
+//
+// Connected followers are pinned at the earliest offset that they've been
+// sent, see updateFollowerPins. Followers that have disconnected stay pinned at
+// their last offset, since that's roughly where they'll resume from, until
+// their pin expires. If MaxFollowAge is set, followers don't pin anything older
+// than that.
+func (db *DB) walPinFor(stream string) *walPin {
+	var pin *walPin
+	consider := func(by string, offset wal.Offset) {
+		if pin == nil || pin.offset.After(offset) {
+			pin = &walPin{by: by, offset: offset}
+		}
+	}
+
+	db.tablesMutex.RLock()
+	for _, t := range db.tables {
+		if t.From != stream || t.rowStore == nil {
+			continue
+		}
+		// The table resumes reading from its durable offset on restart, but
+		// doesn't need anything older than its retention period.
+		offset := t.rowStore.getDurableOffsets()[0]
+		offsetByRetentionPeriod := wal.NewOffsetForTS(t.truncateBefore())
+		if offsetByRetentionPeriod.After(offset) {
+			offset = offsetByRetentionPeriod
+		}
+		consider(fmt.Sprintf("table %v", t.Name), offset)
+	}
+	db.tablesMutex.RUnlock()
+
+	var earliestFollowerOffset wal.Offset
+	if db.opts.MaxFollowAge > 0 {
+		earliestFollowerOffset = wal.NewOffsetForTS(db.clock.Now().Add(-1 * db.opts.MaxFollowAge))
+	}
+	db.followerOffsetsMx.RLock()
+	for followerID, offset := range db.followerOffsets[stream] {
+		if earliestFollowerOffset != nil && earliestFollowerOffset.After(offset) {
+			offset = earliestFollowerOffset
+		}
+		consider(fmt.Sprintf("follower %v", followerID), offset)
+	}
+	db.followerOffsetsMx.RUnlock()
+
+	if db.opts.Passthrough && db.opts.MaxFollowAge > 0 {
+		// Followers that aren't currently connected may still come back for data
+		// up to MaxFollowAge old.
+		consider("maxfollowage", wal.NewOffsetForTS(db.clock.Now().Add(-1*db.opts.MaxFollowAge)))
+	}
+
+	return pin
+}
+
+// recordFollowerOffsets records the durable offset of each follower on each
+// stream so that we know what we need to retain in the WAL.
+func (db *DB) recordFollowerOffsets(followerOffsets map[string]map[common.FollowerID]wal.Offset) {
+	db.followerOffsetsMx.Lock()
+	db.followerOffsets = followerOffsets
+	db.followerOffsetsMx.Unlock()
+}
+
+// followerPins tracks, by stream, the earliest offset that each follower still
+// needs, along with when followers that have since failed departed.
+type followerPins struct {
+	offsets  map[string]map[common.FollowerID]wal.Offset
+	departed map[common.FollowerID]time.Time
+}
+
+func newFollowerPins() *followerPins {
+	return &followerPins{
+		offsets:  make(map[string]map[common.FollowerID]wal.Offset),
+		departed: make(map[common.FollowerID]time.Time),
+	}
+}
+
+func (pins *followerPins) has(followerID common.FollowerID) bool {
+	for _, streamPins := range pins.offsets {
+		if _, found := streamPins[followerID]; found {
+			return true
+		}
+	}
+	return false
+}
+
+// updateFollowerPins pins the WAL of each stream at the earliest offset that
+// each connected follower has reached on any of its tables, keeps the pins of
+// followers that have failed until they've been gone for longer than
+// MaxFollowAge (or defaultFollowerPinTTL) and then publishes the result for
+// walPinFor.
+func (db *DB) updateFollowerPins(pins *followerPins, streams map[string]map[string]*partitionSpec, followers map[common.FollowerID]*follower, now time.Time) {
+	for stream, partitions := range streams {
+		current := make(map[common.FollowerID]wal.Offset)
+		for _, partition := range partitions {
+			for _, table := range partition.tables {
+				for _, specs := range table.followersByPartition {
+					for followerID, spec := range specs {
+						f := followers[followerID]
+						if f == nil || f.failed() {
+							continue
+						}
+						offset, found := current[followerID]
+						if !found || offset.After(spec.offset) {
+							current[followerID] = spec.offset
+						}
+					}
+				}
+			}
+		}
+		streamPins := pins.offsets[stream]
+		if streamPins == nil {
+			streamPins = make(map[common.FollowerID]wal.Offset, len(current))
+			pins.offsets[stream] = streamPins
+		}
+		for followerID, offset := range current {
+			streamPins[followerID] = offset
+		}
+	}
+
+	ttl := db.opts.MaxFollowAge
+	if ttl <= 0 {
+		ttl = defaultFollowerPinTTL
+	}
+	for followerID, f := range followers {
+		if !f.failed() {
+			delete(pins.departed, followerID)
+			continue
+		}
+		departed, found := pins.departed[followerID]
+		if !found {
+			if pins.has(followerID) {
+				pins.departed[followerID] = now
+			}
+			continue
+		}
+		if now.Sub(departed) > ttl {
+			db.log.Debugf("Follower %v departed at %v, no longer retaining WAL for it", followerID, departed)
+			delete(pins.departed, followerID)
+			for _, streamPins := range pins.offsets {
+				delete(streamPins, followerID)
+			}
+		}
+	}
+
+	followerOffsets := make(map[string]map[common.FollowerID]wal.Offset, len(pins.offsets))
+	for stream, streamPins := range pins.offsets {
+		offsets := make(map[common.FollowerID]wal.Offset, len(streamPins))
+		for followerID, offset := range streamPins {
+			offsets[followerID] = offset
+		}
+		followerOffsets[stream] = offsets
+	}
+	db.recordFollowerOffsets(followerOffsets)
+}
@@ -0,0 +1,202 @@
+package zenodb
+
+import (
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getlantern/golog"
+	"github.com/getlantern/vtime"
+	"github.com/getlantern/wal"
+	"github.com/getlantern/zenodb/common"
+	"github.com/getlantern/zenodb/sql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWALPinFor(t *testing.T) {
+	now := time.Date(2017, time.January, 2, 0, 0, 0, 0, time.UTC)
+	db := &DB{
+		opts:   &DBOpts{},
+		log:    golog.LoggerFor("wal_retention_test"),
+		clock:  vtime.NewVirtualClock(now),
+		tables: make(map[string]*table),
+	}
+	addTable := func(name string, stream string, durableOffset wal.Offset) {
+		tbl := &table{
+			TableOpts:       &TableOpts{Name: name},
+			Query:           sql.Query{From: stream},
+			db:              db,
+			retentionPeriod: int64(12 * time.Hour),
+		}
+		tbl.rowStore = &rowStore{t: tbl, durableOffsets: common.OffsetsBySource{0: durableOffset}}
+		db.tables[name] = tbl
+	}
+	follower := common.FollowerID{Partition: 1, ID: 1}
+	offsetAt := func(hoursAgo int) wal.Offset {
+		return wal.NewOffsetForTS(now.Add(-1 * time.Duration(hoursAgo) * time.Hour))
+	}
+
+	assert.Nil(t, db.walPinFor("inbound"), "Nothing should pin an unused stream")
+
+	addTable("table_a", "inbound", offsetAt(1))
+	addTable("table_b", "inbound", offsetAt(2))
+	addTable("table_c", "other", offsetAt(10))
+	pin := db.walPinFor("inbound")
+	if assert.NotNil(t, pin) {
+		assert.Equal(t, "table table_b", pin.by)
+		assert.Equal(t, offsetAt(2), pin.offset)
+	}
+
+	addTable("table_d", "inbound", nil)
+	pin = db.walPinFor("inbound")
+	if assert.NotNil(t, pin) {
+		assert.Equal(t, "table table_d", pin.by)
+		assert.Equal(t, offsetAt(12), pin.offset, "Table should not pin data older than its retention period")
+	}
+
+	db.recordFollowerOffsets(map[string]map[common.FollowerID]wal.Offset{"inbound": {follower: offsetAt(24)}})
+	pin = db.walPinFor("inbound")
+	if assert.NotNil(t, pin) {
+		assert.Equal(t, "follower 1.1", pin.by)
+		assert.Equal(t, offsetAt(24), pin.offset)
+	}
+
+	db.opts.MaxFollowAge = 18 * time.Hour
+	pin = db.walPinFor("inbound")
+	if assert.NotNil(t, pin) {
+		assert.Equal(t, "follower 1.1", pin.by)
+		assert.Equal(t, offsetAt(18), pin.offset, "Follower should not pin data older than MaxFollowAge")
+	}
+}
+
+func TestTruncateWAL(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "walretention")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newWALRetentionTestDB()
+	w, cutoffs, ok := openSegmentedWAL(t, tmpDir)
+	if !ok {
+		return
+	}
+	defer w.Close()
+
+	db.truncateWAL("inbound", w)
+	assert.Equal(t, 3, numWALSegments(t, tmpDir), "Unpinned WAL should only be truncated by MaxWALSize")
+
+	db.recordFollowerOffsets(map[string]map[common.FollowerID]wal.Offset{"inbound": {common.FollowerID{Partition: 1, ID: 1}: wal.NewOffsetForTS(cutoffs[0])}})
+	db.truncateWAL("inbound", w)
+	assert.Equal(t, 2, numWALSegments(t, tmpDir), "Segment before the follower's durable offset should have been removed")
+}
+
+func TestUpdateFollowerPins(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "walretention")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db := newWALRetentionTestDB()
+	db.opts.MaxFollowAge = time.Hour
+	w, cutoffs, ok := openSegmentedWAL(t, tmpDir)
+	if !ok {
+		return
+	}
+	defer w.Close()
+
+	followerID := common.FollowerID{Partition: 1, ID: 1}
+	f := &follower{Follow: common.Follow{FollowerID: followerID}}
+	followers := map[common.FollowerID]*follower{followerID: f}
+	specA := &followSpec{followerID: followerID, offset: wal.NewOffsetForTS(cutoffs[0].Add(-1 * time.Hour))}
+	specB := &followSpec{followerID: followerID, offset: wal.NewOffsetForTS(cutoffs[0].Add(-1 * time.Hour))}
+	streams := map[string]map[string]*partitionSpec{
+		"inbound": {
+			"": &partitionSpec{tables: map[string]*tableSpec{
+				"table_a": {followersByPartition: map[int]map[common.FollowerID]*followSpec{1: {followerID: specA}}},
+				"table_b": {followersByPartition: map[int]map[common.FollowerID]*followSpec{1: {followerID: specB}}},
+			}},
+		},
+	}
+	pins := newFollowerPins()
+	now := time.Now()
+
+	db.updateFollowerPins(pins, streams, followers, now)
+	db.truncateWAL("inbound", w)
+	assert.Equal(t, 3, numWALSegments(t, tmpDir), "Follower should pin the WAL at the offset it joined with")
+
+	// Sending entries to the connected follower advances its offsets
+	specA.offset = wal.NewOffsetForTS(cutoffs[1])
+	db.updateFollowerPins(pins, streams, followers, now)
+	db.truncateWAL("inbound", w)
+	assert.Equal(t, 3, numWALSegments(t, tmpDir), "Follower should remain pinned at the earliest offset of any of its tables")
+
+	specB.offset = wal.NewOffsetForTS(cutoffs[0])
+	db.updateFollowerPins(pins, streams, followers, now)
+	db.truncateWAL("inbound", w)
+	assert.Equal(t, 2, numWALSegments(t, tmpDir), "Truncation should advance while the follower is connected")
+
+	// Once the follower fails, its pin stays put until it expires
+	atomic.StoreInt32(&f.hasFailed, 1)
+	specB.offset = wal.NewOffsetForTS(cutoffs[1])
+	db.updateFollowerPins(pins, streams, followers, now)
+	assert.Equal(t, wal.NewOffsetForTS(cutoffs[0]), db.followerOffsets["inbound"][followerID], "Failed follower should remain pinned at its last offset")
+	db.updateFollowerPins(pins, streams, followers, now.Add(30*time.Minute))
+	assert.Equal(t, wal.NewOffsetForTS(cutoffs[0]), db.followerOffsets["inbound"][followerID], "Failed follower should remain pinned until MaxFollowAge has passed")
+	db.updateFollowerPins(pins, streams, followers, now.Add(2*time.Hour))
+	assert.Empty(t, db.followerOffsets["inbound"], "Failed follower's pin should have expired")
+}
+
+func newWALRetentionTestDB() *DB {
+	return &DB{
+		opts: &DBOpts{
+			MaxWALSize:         1024 * 1024 * 1024,
+			WALCompressionSize: 1024 * 1024 * 1024,
+		},
+		log:    golog.LoggerFor("wal_retention_test"),
+		clock:  vtime.RealClock,
+		tables: make(map[string]*table),
+	}
+}
+
+// openSegmentedWAL opens a WAL in the given dir with 3 segments, returning the
+// times before which the 2nd and 3rd segments were started.
+func openSegmentedWAL(t *testing.T, dir string) (*wal.WAL, []time.Time, bool) {
+	// Every time the WAL is opened, it starts a new segment
+	var w *wal.WAL
+	var cutoffs []time.Time
+	for i := 0; i < 3; i++ {
+		if w != nil {
+			w.Close()
+		}
+		if i > 0 {
+			cutoffs = append(cutoffs, time.Now())
+		}
+		time.Sleep(10 * time.Millisecond)
+		var err error
+		w, err = wal.Open(&wal.Opts{Dir: dir})
+		if !assert.NoError(t, err) {
+			return nil, nil, false
+		}
+		if !assert.NoError(t, w.Write([]byte("data"))) {
+			w.Close()
+			return nil, nil, false
+		}
+	}
+	if !assert.Equal(t, 3, numWALSegments(t, dir)) {
+		w.Close()
+		return nil, nil, false
+	}
+	return w, cutoffs, true
+}
+
+func numWALSegments(t *testing.T, dir string) int {
+	files, err := ioutil.ReadDir(dir)
+	if !assert.NoError(t, err) {
+		return 0
+	}
+	return len(files)
+}
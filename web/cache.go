@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"time"
@@ -207,6 +208,23 @@ func (c *cache) put(sql string, ce cacheEntry) error {
 	})
 }
 
+// cancel records a canceled cache entry under its permalink without caching it
+// for the sql, so that subsequent requests for the same sql run the query anew.
+func (c *cache) cancel(sql string, ce cacheEntry) error {
+	key := []byte(sql)
+
+	return c.db.Update(func(tx *bolt.Tx) error {
+		cb := tx.Bucket(cacheBucket)
+		pb := tx.Bucket(permalinkBucket)
+		pb.Put(ce.permalinkBytes(), ce)
+		existing := cacheEntry(cb.Get(key))
+		if existing != nil && bytes.Equal(existing.permalinkBytes(), ce.permalinkBytes()) {
+			cb.Delete(key)
+		}
+		return nil
+	})
+}
+
 func (c *cache) Close() error {
 	return c.db.Close()
 }
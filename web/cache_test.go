@@ -1,6 +1,7 @@
 package web
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -82,3 +83,41 @@ func TestCache(t *testing.T) {
 	assert.EqualValues(t, []byte("1"), ce.data())
 	assert.EqualValues(t, statusSuccess, ce.status())
 }
+
+func TestCacheCancel(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(cacheDir)
+
+	cache, err := newCache(cacheDir, 1*time.Hour)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cache.Close()
+
+	ce, _, err := cache.getOrBegin("a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	permalink := ce.permalink()
+	err = cache.cancel("a", ce.fail(errors.New("query canceled")))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ce, err = cache.getByPermalink(permalink)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.EqualValues(t, statusError, ce.status())
+	assert.Equal(t, "query canceled", string(ce.error()))
+
+	ce, created, err := cache.getOrBegin("a")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, created, "Canceled query should not be cached for its sql")
+	assert.NotEqual(t, permalink, ce.permalink())
+}
@@ -31,6 +31,14 @@ type Opts struct {
 	QueryTimeout          time.Duration
 	QueryConcurrencyLimit int
 	MaxResponseBytes      int
+
+	// PrometheusStreamLabel is the label used to determine which stream
+	// Prometheus remote-write samples are inserted into. Defaults to
+	// DefaultPrometheusStreamLabel.
+	PrometheusStreamLabel string
+	// PrometheusDefaultStream is the stream into which Prometheus remote-write
+	// samples are inserted if they lack a PrometheusStreamLabel.
+	PrometheusDefaultStream string
 }
 
 type handler struct {
@@ -113,11 +121,17 @@ func Configure(db *zenodb.DB, router *mux.Router, opts *Opts) (func(), error) {
 
 	router.StrictSlash(true)
 	router.HandleFunc("/insert/{stream}", h.insert)
+	router.HandleFunc("/prometheus/write", h.prometheusWrite)
+	router.HandleFunc("/write", h.influxWrite)
 	router.HandleFunc("/oauth/code", h.oauthCode)
 	router.PathPrefix("/async").HandlerFunc(h.asyncQuery)
 	router.PathPrefix("/immediate").HandlerFunc(h.immediateQuery)
 	router.PathPrefix("/run").HandlerFunc(h.runQuery)
 	router.PathPrefix("/cached/{permalink}").HandlerFunc(h.cachedQuery)
+	router.PathPrefix("/cancel/{permalink}").HandlerFunc(h.cancelQuery)
+	router.PathPrefix("/queries").HandlerFunc(h.runningQueries)
+	router.PathPrefix("/schema").HandlerFunc(h.schema)
+	router.PathPrefix("/snapshot").HandlerFunc(h.snapshot)
 	router.PathPrefix("/favicon").Handler(http.NotFoundHandler())
 	router.PathPrefix("/report/{permalink}").HandlerFunc(h.index)
 	router.PathPrefix("/metrics").HandlerFunc(h.metrics)
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/getlantern/zenodb/lineprotocol"
+)
+
+// influxWrite handles writes using InfluxDB line protocol, similar to
+// InfluxDB's own /write endpoint. Valid points are inserted even if some lines
+// fail to parse, in which case the parse errors are reported with a 400.
+func (h *handler) influxWrite(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(resp, "Method %v not allowed\n", req.Method)
+		return
+	}
+
+	precision, err := lineprotocol.ParsePrecision(req.URL.Query().Get("precision"))
+	if err != nil {
+		badRequest(resp, "%v", err)
+		return
+	}
+
+	body, err := requestBody(req)
+	if err != nil {
+		badRequest(resp, "%v", err)
+		return
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		badRequest(resp, "Error reading request: %v", err)
+		return
+	}
+
+	points, parseErrs := lineprotocol.Parse(data, precision, time.Now())
+	_, insertErr := lineprotocol.Insert(h.db, points)
+	if insertErr != nil {
+		internalServerError(resp, "Error submitting points: %v", insertErr)
+		return
+	}
+	if len(parseErrs) > 0 {
+		badRequest(resp, "Unable to parse %d lines, first error: %v", len(parseErrs), parseErrs[0])
+		return
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
@@ -1,12 +1,18 @@
 package web
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/getlantern/msgpack"
+	"github.com/getlantern/zenodb/rpc"
+	"github.com/golang/snappy"
 	"github.com/gorilla/mux"
 )
 
@@ -14,60 +20,167 @@ const (
 	// ContentType is the key for the Content-Type header
 	ContentType = "Content-Type"
 
-	// ContentTypeJSON is the allowed content type
+	// ContentTypeJSON is the JSON content type
 	ContentTypeJSON = "application/json"
+
+	// ContentTypeMsgPack is the MessagePack content type
+	ContentTypeMsgPack = "application/msgpack"
+
+	// ContentEncoding is the key for the Content-Encoding header
+	ContentEncoding = "Content-Encoding"
+
+	// ContentEncodingGzip indicates a gzip compressed request body
+	ContentEncodingGzip = "gzip"
+
+	// ContentEncodingSnappy indicates a snappy (framed format) compressed
+	// request body
+	ContentEncodingSnappy = "snappy"
 )
 
 type Point struct {
-	Ts   time.Time              `json:"ts,omitempty"`
-	Dims map[string]interface{} `json:"dims,omitempty"`
-	Vals map[string]interface{} `json:"vals,omitempty"`
+	Ts   time.Time              `json:"ts,omitempty" msgpack:"ts,omitempty"`
+	Dims map[string]interface{} `json:"dims,omitempty" msgpack:"dims,omitempty"`
+	Vals map[string]interface{} `json:"vals,omitempty" msgpack:"vals,omitempty"`
 }
 
+// pointInserter inserts individual points, see zenodb.DB.Insert
+type pointInserter interface {
+	Insert(stream string, ts time.Time, dims map[string]interface{}, vals map[string]interface{}) error
+}
+
+// insert inserts a stream of points into the given stream.
+//
+// Points are validated and inserted one at a time as they're decoded, so
+// requests of any size can be inserted without buffering them in memory. By
+// default, the first bad point results in a 400 and the points preceding it
+// remain inserted.
+//
+// If the batch parameter is true, invalid points are skipped and the valid
+// ones are inserted. The response is a JSON rpc.InsertReport that reports any
+// errors by the index of the point in the request.
 func (h *handler) insert(resp http.ResponseWriter, req *http.Request) {
+	insertPoints(h.db, resp, req)
+}
+
+func insertPoints(db pointInserter, resp http.ResponseWriter, req *http.Request) {
 	if req.Method != http.MethodPost {
 		resp.WriteHeader(http.StatusMethodNotAllowed)
 		fmt.Fprintf(resp, "Method %v not allowed\n", req.Method)
 		return
 	}
 
+	batch, _ := strconv.ParseBool(req.URL.Query().Get("batch"))
+
+	body, err := requestBody(req)
+	if err != nil {
+		badRequest(resp, "%v", err)
+		return
+	}
+	defer body.Close()
+
+	// decode decodes the next Point from the stream
+	var decode func(point *Point) error
 	contentType := req.Header.Get(ContentType)
-	if contentType != ContentTypeJSON {
+	switch contentType {
+	case ContentTypeJSON:
+		dec := json.NewDecoder(body)
+		decode = func(point *Point) error {
+			return dec.Decode(point)
+		}
+	case ContentTypeMsgPack:
+		dec := msgpack.NewDecoder(body)
+		decode = func(point *Point) error {
+			return dec.Decode(point)
+		}
+	default:
 		resp.WriteHeader(http.StatusUnsupportedMediaType)
 		fmt.Fprintf(resp, "Media type %v unsupported\n", contentType)
 		return
 	}
 
 	stream := mux.Vars(req)["stream"]
-	dec := json.NewDecoder(req.Body)
-	for {
+	now := time.Now()
+	report := &rpc.InsertReport{
+		Errors: make(map[int]string),
+	}
+	for i := 0; ; i++ {
 		point := &Point{}
-		err := dec.Decode(point)
+		err := decode(point)
 		if err == io.EOF {
 			// Done reading points
-			resp.WriteHeader(http.StatusCreated)
-			return
+			break
 		}
 		if err != nil {
-			badRequest(resp, "Error decoding JSON: %v", err)
-			return
+			if !batch {
+				badRequest(resp, "Error decoding point %d after inserting %d points: %v", i, report.Succeeded, err)
+				return
+			}
+			// We can't continue decoding after a decoding error, so stop here
+			report.Received++
+			report.Errors[i] = fmt.Sprintf("Error decoding: %v", err)
+			break
 		}
-		if len(point.Dims) == 0 {
-			badRequest(resp, "Need at least one dim")
-			return
-		}
-		if len(point.Vals) == 0 {
-			badRequest(resp, "Need at least one val")
-			return
+		report.Received++
+		if validateErr := validatePoint(point); validateErr != "" {
+			if !batch {
+				badRequest(resp, "Invalid point %d after inserting %d points: %v", i, report.Succeeded, validateErr)
+				return
+			}
+			report.Errors[i] = validateErr
+			continue
 		}
 		if point.Ts.IsZero() {
-			point.Ts = time.Now()
+			point.Ts = now
 		}
-
-		insertErr := h.db.Insert(stream, point.Ts, point.Dims, point.Vals)
+		insertErr := db.Insert(stream, point.Ts, point.Dims, point.Vals)
 		if insertErr != nil {
-			internalServerError(resp, "Error submitting point: %v", insertErr)
+			if !batch {
+				internalServerError(resp, "Error submitting point %d after inserting %d points: %v", i, report.Succeeded, insertErr)
+				return
+			}
+			report.Errors[i] = fmt.Sprintf("Unable to insert: %v", insertErr)
+			continue
+		}
+		report.Succeeded++
+	}
+
+	if !batch {
+		resp.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	resp.Header().Set(ContentType, ContentTypeJSON)
+	resp.WriteHeader(http.StatusOK)
+	json.NewEncoder(resp).Encode(report)
+}
+
+func validatePoint(point *Point) string {
+	if len(point.Dims) == 0 {
+		return "Need at least one dim"
+	}
+	if len(point.Vals) == 0 {
+		return "Need at least one val"
+	}
+	return ""
+}
+
+// requestBody returns the body of the given request, decompressing it
+// according to the Content-Encoding header.
+func requestBody(req *http.Request) (io.ReadCloser, error) {
+	contentEncoding := req.Header.Get(ContentEncoding)
+	switch contentEncoding {
+	case "", "identity":
+		return req.Body, nil
+	case ContentEncodingGzip:
+		gzr, err := gzip.NewReader(req.Body)
+		if err != nil {
+			return nil, fmt.Errorf("Error decompressing request: %v", err)
 		}
+		return gzr, nil
+	case ContentEncodingSnappy:
+		return ioutil.NopCloser(snappy.NewReader(req.Body)), nil
+	default:
+		return nil, fmt.Errorf("Content encoding %v unsupported", contentEncoding)
 	}
 }
 
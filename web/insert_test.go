@@ -0,0 +1,173 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getlantern/msgpack"
+	"github.com/getlantern/zenodb/rpc"
+	"github.com/golang/snappy"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestBody(t *testing.T) {
+	data := []byte(`{"dims": {"a": 1}, "vals": {"b": 2}}`)
+
+	gzipped := &bytes.Buffer{}
+	gzw := gzip.NewWriter(gzipped)
+	gzw.Write(data)
+	gzw.Close()
+
+	snappied := &bytes.Buffer{}
+	sw := snappy.NewBufferedWriter(snappied)
+	sw.Write(data)
+	sw.Close()
+
+	for encoding, body := range map[string][]byte{
+		"":                    data,
+		ContentEncodingGzip:   gzipped.Bytes(),
+		ContentEncodingSnappy: snappied.Bytes(),
+	} {
+		req, _ := http.NewRequest(http.MethodPost, "/insert/stream", bytes.NewReader(body))
+		req.Header.Set(ContentEncoding, encoding)
+		r, err := requestBody(req)
+		if !assert.NoError(t, err, encoding) {
+			continue
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if assert.NoError(t, err, encoding) {
+			assert.Equal(t, string(data), string(decoded), encoding)
+		}
+	}
+
+	req, _ := http.NewRequest(http.MethodPost, "/insert/stream", bytes.NewReader(data))
+	req.Header.Set(ContentEncoding, "br")
+	_, err := requestBody(req)
+	assert.Error(t, err, "Unsupported encoding should fail")
+}
+
+// recordingInserter records inserted points and fails to insert points with
+// a "fail" dim.
+type recordingInserter struct {
+	streams []string
+	points  []*Point
+}
+
+func (ri *recordingInserter) Insert(stream string, ts time.Time, dims map[string]interface{}, vals map[string]interface{}) error {
+	if dims["fail"] != nil {
+		return fmt.Errorf("failing as requested")
+	}
+	ri.streams = append(ri.streams, stream)
+	ri.points = append(ri.points, &Point{Ts: ts, Dims: dims, Vals: vals})
+	return nil
+}
+
+func doInsert(db pointInserter, query string, contentType string, contentEncoding string, body []byte) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.HandleFunc("/insert/{stream}", func(resp http.ResponseWriter, req *http.Request) {
+		insertPoints(db, resp, req)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/insert/inbound"+query, bytes.NewReader(body))
+	req.Header.Set(ContentType, contentType)
+	req.Header.Set(ContentEncoding, contentEncoding)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	return resp
+}
+
+func TestInsert(t *testing.T) {
+	ts := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := []byte(`{"ts": "2020-01-01T00:00:00Z", "dims": {"a": 1}, "vals": {"b": 2}}
+{"dims": {"a": 2}, "vals": {"b": 3}}`)
+
+	gzipped := &bytes.Buffer{}
+	gzw := gzip.NewWriter(gzipped)
+	gzw.Write(data)
+	gzw.Close()
+
+	snappied := &bytes.Buffer{}
+	sw := snappy.NewBufferedWriter(snappied)
+	sw.Write(data)
+	sw.Close()
+
+	for encoding, body := range map[string][]byte{
+		"":                    data,
+		ContentEncodingGzip:   gzipped.Bytes(),
+		ContentEncodingSnappy: snappied.Bytes(),
+	} {
+		db := &recordingInserter{}
+		resp := doInsert(db, "", ContentTypeJSON, encoding, body)
+		assert.Equal(t, http.StatusCreated, resp.Code, encoding)
+		if assert.Len(t, db.points, 2, encoding) {
+			assert.Equal(t, []string{"inbound", "inbound"}, db.streams, encoding)
+			assert.True(t, ts.Equal(db.points[0].Ts), encoding)
+			assert.False(t, db.points[1].Ts.IsZero(), "Missing timestamp should default to now")
+			assert.EqualValues(t, 2, db.points[1].Dims["a"], encoding)
+			assert.EqualValues(t, 3, db.points[1].Vals["b"], encoding)
+		}
+	}
+
+	// Points are inserted up to the first invalid point
+	db := &recordingInserter{}
+	resp := doInsert(db, "", ContentTypeJSON, "", []byte(`{"dims": {"a": 1}, "vals": {"b": 2}}
+{"dims": {"a": 2}}
+{"dims": {"a": 3}, "vals": {"b": 4}}`))
+	assert.Equal(t, http.StatusBadRequest, resp.Code)
+	assert.Len(t, db.points, 1)
+
+	resp = doInsert(db, "", "text/plain", "", data)
+	assert.Equal(t, http.StatusUnsupportedMediaType, resp.Code)
+}
+
+func TestInsertMsgPack(t *testing.T) {
+	body := &bytes.Buffer{}
+	enc := msgpack.NewEncoder(body)
+	for i := 0; i < 3; i++ {
+		if !assert.NoError(t, enc.Encode(&Point{Dims: map[string]interface{}{"a": i}, Vals: map[string]interface{}{"b": float64(i)}})) {
+			return
+		}
+	}
+
+	db := &recordingInserter{}
+	resp := doInsert(db, "", ContentTypeMsgPack, "", body.Bytes())
+	assert.Equal(t, http.StatusCreated, resp.Code)
+	if assert.Len(t, db.points, 3) {
+		assert.EqualValues(t, 2, db.points[2].Dims["a"])
+		assert.EqualValues(t, 2, db.points[2].Vals["b"])
+	}
+}
+
+func TestInsertBatchReport(t *testing.T) {
+	db := &recordingInserter{}
+	resp := doInsert(db, "?batch=true", ContentTypeJSON, "", []byte(`{"dims": {"a": 1}, "vals": {"b": 2}}
+{"dims": {"a": 2}}
+{"dims": {"fail": 1}, "vals": {"b": 3}}
+{"vals": {"b": 4}}
+{"dims": {"a": 5}, "vals": {"b": 5}}
+{"dims": `))
+	if !assert.Equal(t, http.StatusOK, resp.Code) {
+		return
+	}
+	assert.Len(t, db.points, 2)
+
+	report := &rpc.InsertReport{}
+	if !assert.NoError(t, json.NewDecoder(resp.Body).Decode(report)) {
+		return
+	}
+	assert.Equal(t, 6, report.Received)
+	assert.Equal(t, 2, report.Succeeded)
+	if assert.Len(t, report.Errors, 4) {
+		assert.Equal(t, "Need at least one val", report.Errors[1])
+		assert.Contains(t, report.Errors[2], "Unable to insert")
+		assert.Equal(t, "Need at least one dim", report.Errors[3])
+		assert.Contains(t, report.Errors[5], "Error decoding")
+	}
+}
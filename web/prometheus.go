@@ -0,0 +1,223 @@
+package web
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/getlantern/bytemap"
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// DefaultPrometheusStreamLabel is the label that identifies the stream to
+	// which a Prometheus time series is inserted.
+	DefaultPrometheusStreamLabel = "__stream__"
+
+	prometheusNameLabel = "__name__"
+)
+
+// promTimeSeries is a minimal representation of a Prometheus remote-write
+// TimeSeries.
+type promTimeSeries struct {
+	labels  map[string]interface{}
+	samples []promSample
+	name    string
+	stream  string
+}
+
+type promSample struct {
+	value float64
+	ts    int64
+}
+
+// prometheusWrite handles Prometheus remote-write requests. Each sample's
+// labels become dims and the metric name and value become the val. The stream
+// is taken from the PrometheusStreamLabel label if present, otherwise from
+// PrometheusDefaultStream.
+func (h *handler) prometheusWrite(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(resp, "Method %v not allowed\n", req.Method)
+		return
+	}
+
+	compressed, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		badRequest(resp, "Error reading remote-write request: %v", err)
+		return
+	}
+	data, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		badRequest(resp, "Error decompressing remote-write request: %v", err)
+		return
+	}
+	series, err := decodePrometheusWriteRequest(data)
+	if err != nil {
+		badRequest(resp, "Error decoding remote-write request: %v", err)
+		return
+	}
+
+	streamLabel := h.PrometheusStreamLabel
+	if streamLabel == "" {
+		streamLabel = DefaultPrometheusStreamLabel
+	}
+
+	// Validate the whole request before inserting anything, since Prometheus
+	// retries failed requests in full and would otherwise duplicate samples.
+	err = preparePrometheusSeries(series, streamLabel, h.PrometheusDefaultStream, h.db.HasStream)
+	if err != nil {
+		badRequest(resp, "%v", err)
+		return
+	}
+
+	for _, ts := range series {
+		dims := bytemap.New(ts.labels)
+		for _, sample := range ts.samples {
+			if math.IsNaN(sample.value) || math.IsInf(sample.value, 0) {
+				// Prometheus uses NaN for staleness markers, skip these
+				continue
+			}
+			vals := bytemap.NewFloat(map[string]float64{ts.name: sample.value})
+			insertErr := h.db.InsertRaw(ts.stream, time.Unix(0, sample.ts*int64(time.Millisecond)), dims, vals)
+			if insertErr != nil {
+				internalServerError(resp, "Error submitting sample: %v", insertErr)
+				return
+			}
+		}
+	}
+
+	resp.WriteHeader(http.StatusNoContent)
+}
+
+// preparePrometheusSeries validates all of the given series and determines
+// their metric names and streams, removing the corresponding labels so that
+// only the dims remain.
+func preparePrometheusSeries(series []*promTimeSeries, streamLabel string, defaultStream string, hasStream func(string) bool) error {
+	for _, ts := range series {
+		name, _ := ts.labels[prometheusNameLabel].(string)
+		if name == "" {
+			return fmt.Errorf("Time series missing %v label", prometheusNameLabel)
+		}
+		stream, _ := ts.labels[streamLabel].(string)
+		if stream == "" {
+			stream = defaultStream
+		}
+		if stream == "" {
+			return fmt.Errorf("Time series %v missing %v label and no default stream configured", name, streamLabel)
+		}
+		if !hasStream(stream) {
+			return fmt.Errorf("Time series %v has unknown stream %v", name, stream)
+		}
+		delete(ts.labels, prometheusNameLabel)
+		delete(ts.labels, streamLabel)
+		if len(ts.labels) == 0 {
+			return fmt.Errorf("Time series %v needs at least one label besides %v", name, streamLabel)
+		}
+		ts.name = name
+		ts.stream = stream
+	}
+	return nil
+}
+
+// decodePrometheusWriteRequest decodes the protobuf encoded WriteRequest,
+// ignoring any fields other than timeseries.
+func decodePrometheusWriteRequest(b []byte) ([]*promTimeSeries, error) {
+	var result []*promTimeSeries
+	err := decodeProtoFields(b, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if num != 1 || typ != protowire.BytesType {
+			return nil
+		}
+		ts, err := decodePrometheusTimeSeries(field)
+		if err != nil {
+			return err
+		}
+		result = append(result, ts)
+		return nil
+	})
+	return result, err
+}
+
+func decodePrometheusTimeSeries(b []byte) (*promTimeSeries, error) {
+	ts := &promTimeSeries{labels: make(map[string]interface{})}
+	err := decodeProtoFields(b, func(num protowire.Number, typ protowire.Type, field []byte) error {
+		if typ != protowire.BytesType {
+			return nil
+		}
+		switch num {
+		case 1:
+			var name, value string
+			err := decodeProtoFields(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				if typ != protowire.BytesType {
+					return nil
+				}
+				switch num {
+				case 1:
+					name = string(field)
+				case 2:
+					value = string(field)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.labels[name] = value
+		case 2:
+			var sample promSample
+			err := decodeProtoFields(field, func(num protowire.Number, typ protowire.Type, field []byte) error {
+				switch {
+				case num == 1 && typ == protowire.Fixed64Type:
+					v, _ := protowire.ConsumeFixed64(field)
+					sample.value = math.Float64frombits(v)
+				case num == 2 && typ == protowire.VarintType:
+					v, _ := protowire.ConsumeVarint(field)
+					sample.ts = int64(v)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			ts.samples = append(ts.samples, sample)
+		}
+		return nil
+	})
+	return ts, err
+}
+
+// decodeProtoFields iterates over the fields of a protobuf message, calling
+// onField with the raw value of each field. For length-delimited fields, the
+// value is the contents without the length prefix.
+func decodeProtoFields(b []byte, onField func(num protowire.Number, typ protowire.Type, field []byte) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var field []byte
+		if typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field = v
+			b = b[n:]
+		} else {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			field = b[:n]
+			b = b[n:]
+		}
+		if err := onField(num, typ, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestDecodePrometheusWriteRequest(t *testing.T) {
+	label := func(name, value string) []byte {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, name)
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendString(b, value)
+		return b
+	}
+	sample := func(value float64, ts int64) []byte {
+		var b []byte
+		b = protowire.AppendTag(b, 1, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(value))
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(ts))
+		return b
+	}
+
+	var series []byte
+	series = protowire.AppendTag(series, 1, protowire.BytesType)
+	series = protowire.AppendBytes(series, label(prometheusNameLabel, "requests"))
+	series = protowire.AppendTag(series, 1, protowire.BytesType)
+	series = protowire.AppendBytes(series, label("path", "/index.html"))
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample(56, 1000))
+	series = protowire.AppendTag(series, 2, protowire.BytesType)
+	series = protowire.AppendBytes(series, sample(34, 2000))
+
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendBytes(req, series)
+	// Metadata should be ignored
+	req = protowire.AppendTag(req, 3, protowire.BytesType)
+	req = protowire.AppendBytes(req, label("ignored", "ignored"))
+
+	result, err := decodePrometheusWriteRequest(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.Len(t, result, 1) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{prometheusNameLabel: "requests", "path": "/index.html"}, result[0].labels)
+	assert.Equal(t, []promSample{{56, 1000}, {34, 2000}}, result[0].samples)
+
+	_, err = decodePrometheusWriteRequest(req[:len(req)-3])
+	assert.Error(t, err, "Truncated request should fail to decode")
+}
+
+func TestPreparePrometheusSeries(t *testing.T) {
+	hasStream := func(stream string) bool {
+		return stream == "default" || stream == "other"
+	}
+	newSeries := func(labels ...string) *promTimeSeries {
+		ts := &promTimeSeries{labels: make(map[string]interface{})}
+		for i := 0; i < len(labels); i += 2 {
+			ts.labels[labels[i]] = labels[i+1]
+		}
+		return ts
+	}
+
+	series := []*promTimeSeries{
+		newSeries(prometheusNameLabel, "requests", "path", "/"),
+		newSeries(prometheusNameLabel, "errors", "path", "/", DefaultPrometheusStreamLabel, "other"),
+	}
+	if assert.NoError(t, preparePrometheusSeries(series, DefaultPrometheusStreamLabel, "default", hasStream)) {
+		assert.Equal(t, "requests", series[0].name)
+		assert.Equal(t, "default", series[0].stream)
+		assert.Equal(t, "errors", series[1].name)
+		assert.Equal(t, "other", series[1].stream)
+		assert.Equal(t, map[string]interface{}{"path": "/"}, series[1].labels)
+	}
+
+	for _, bad := range []*promTimeSeries{
+		newSeries("path", "/"),
+		newSeries(prometheusNameLabel, "requests", "path", "/", DefaultPrometheusStreamLabel, "unknown"),
+		newSeries(prometheusNameLabel, "requests"),
+	} {
+		series := []*promTimeSeries{newSeries(prometheusNameLabel, "requests", "path", "/"), bad}
+		assert.Error(t, preparePrometheusSeries(series, DefaultPrometheusStreamLabel, "default", hasStream), "Any invalid series should fail the whole request")
+	}
+	assert.Error(t, preparePrometheusSeries([]*promTimeSeries{newSeries(prometheusNameLabel, "requests", "path", "/")}, DefaultPrometheusStreamLabel, "", hasStream), "Missing default stream should fail")
+}
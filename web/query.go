@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/dustin/go-humanize"
+	"github.com/getlantern/zenodb"
 	"github.com/getlantern/zenodb/common"
 	"github.com/getlantern/zenodb/core"
 	"github.com/getlantern/zenodb/encoding"
@@ -42,9 +43,10 @@ type QueryResult struct {
 }
 
 type ResultRow struct {
-	TS   int64
-	Key  map[string]interface{}
-	Vals []float64
+	TS  int64
+	Key map[string]interface{}
+	// Vals holds the value of each field, nil (null in JSON) for NULL values
+	Vals []*float64
 }
 
 type query struct {
@@ -82,6 +84,41 @@ func (h *handler) cachedQuery(resp http.ResponseWriter, req *http.Request) {
 	h.respondWithCacheEntry(resp, req, ce, err, shortTimeout)
 }
 
+// runningQueries lists the queries that are currently running on this node
+// and its followers.
+func (h *handler) runningQueries(resp http.ResponseWriter, req *http.Request) {
+	if !h.authenticate(resp, req) {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	queries, _, err := h.db.ClusterRunningQueries(req.Context())
+	if err != nil {
+		log.Error(err)
+		resp.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(resp, err.Error())
+		return
+	}
+	resp.Header().Set(ContentType, ContentTypeJSON)
+	json.NewEncoder(resp).Encode(queries)
+}
+
+// cancelQuery cancels the running query identified by the given permalink.
+func (h *handler) cancelQuery(resp http.ResponseWriter, req *http.Request) {
+	if !h.authenticate(resp, req) {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	permalink := mux.Vars(req)["permalink"]
+	if !h.db.CancelQuery(permalink) {
+		http.NotFound(resp, req)
+		return
+	}
+	log.Debugf("Canceled query %v", permalink)
+	resp.WriteHeader(http.StatusOK)
+}
+
 func (h *handler) sqlQuery(resp http.ResponseWriter, req *http.Request, timeout time.Duration, immediate bool) {
 	if !h.authenticate(resp, req) {
 		resp.WriteHeader(http.StatusForbidden)
@@ -92,12 +129,24 @@ func (h *handler) sqlQuery(resp http.ResponseWriter, req *http.Request, timeout
 	sqlString, _ := url.QueryUnescape(req.URL.RawQuery)
 
 	ce, err := h.query(req, sqlString, immediate)
+	if err == nil && timeout == longTimeout {
+		// The client is waiting for the results, cancel the query if it goes away
+		defer func() {
+			if req.Context().Err() != nil && h.db.CancelQuery(ce.permalink()) {
+				log.Debugf("Client disconnected, canceled query %v", ce.permalink())
+			}
+		}()
+	}
 	h.respondWithCacheEntry(resp, req, ce, err, timeout)
 }
 
 func (h *handler) respondWithCacheEntry(resp http.ResponseWriter, req *http.Request, ce cacheEntry, err error, timeout time.Duration) {
 	limit := int(timeout / pauseTime)
 	for i := 0; i < limit; i++ {
+		if req.Context().Err() != nil {
+			// Client went away
+			return
+		}
 		if err != nil {
 			log.Error(err)
 			resp.WriteHeader(http.StatusInternalServerError)
@@ -113,7 +162,11 @@ func (h *handler) respondWithCacheEntry(resp http.ResponseWriter, req *http.Requ
 			return
 		case statusPending:
 			// Pause a little bit and try again
-			time.Sleep(pauseTime)
+			select {
+			case <-req.Context().Done():
+				continue
+			case <-time.After(pauseTime):
+			}
 			ce, err = h.cache.getByPermalink(ce.permalink())
 		}
 	}
@@ -217,7 +270,14 @@ func (h *handler) execQuery(wg *sync.WaitGroup, query *query) {
 	defer wg.Done()
 	sqlString := query.sqlString
 	ce := query.ce
-	result, err := h.doQuery(sqlString, ce.permalink())
+	ctx, finished := h.db.RegisterQuery(context.Background(), ce.permalink(), zenodb.QueryOriginWeb, sqlString)
+	defer finished()
+	result, err := h.doQuery(ctx, sqlString, ce.permalink())
+	if err == core.ErrCanceled {
+		log.Debugf("Query %v canceled: %v", ce.permalink(), sqlString)
+		h.cache.cancel(sqlString, ce.fail(err))
+		return
+	}
 	if err != nil {
 		err = fmt.Errorf("Unable to query: %v", err)
 		log.Error(err)
@@ -262,7 +322,7 @@ func compress(resultBytes []byte, err error) ([]byte, error) {
 	return compressed, nil
 }
 
-func (h *handler) doQuery(sqlString string, permalink string) (*QueryResult, error) {
+func (h *handler) doQuery(ctx context.Context, sqlString string, permalink string) (*QueryResult, error) {
 	rs, err := h.db.Query(sqlString, false, nil, false)
 	if err != nil {
 		log.Errorf("Error running query: %v", err)
@@ -307,9 +367,9 @@ func (h *handler) doQuery(sqlString string, permalink string) (*QueryResult, err
 
 	estimatedResultBytes := 0
 	var mx sync.Mutex
-	ctx, cancel := context.WithTimeout(context.Background(), h.QueryTimeout)
+	ctx, cancel := context.WithTimeout(ctx, h.QueryTimeout)
 	defer cancel()
-	stats, _ := rs.Iterate(ctx, func(inFields core.Fields) error {
+	stats, iterateErr := rs.Iterate(ctx, func(inFields core.Fields) error {
 		fields = inFields
 		for _, field := range fields {
 			result.Fields = append(result.Fields, field.Name)
@@ -348,11 +408,16 @@ func (h *handler) doQuery(sqlString string, permalink string) (*QueryResult, err
 		resultRow := &ResultRow{
 			TS:   common.NanosToMillis(row.TS),
 			Key:  key,
-			Vals: make([]float64, 0, len(row.Values)),
+			Vals: make([]*float64, 0, len(row.Values)),
 		}
 
 		for i, value := range row.Values {
-			resultRow.Vals = append(resultRow.Vals, value)
+			if !row.IsSet(i) {
+				resultRow.Vals = append(resultRow.Vals, nil)
+				continue
+			}
+			value := value
+			resultRow.Vals = append(resultRow.Vals, &value)
 			encoding.Binary.PutUint64(cbytes, math.Float64bits(value))
 			fieldCardinalities[i].Add(cbytes)
 		}
@@ -361,6 +426,10 @@ func (h *handler) doQuery(sqlString string, permalink string) (*QueryResult, err
 		return true, nil
 	})
 
+	if iterateErr == core.ErrCanceled {
+		return nil, iterateErr
+	}
+
 	result.TSCardinality = tsCardinality.Count()
 	result.Dims = make([]string, 0, len(dimCardinalities))
 	for dim := range dimCardinalities {
@@ -0,0 +1,52 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// schema returns the current schema as JSON on GET and executes a CREATE,
+// ALTER or DROP statement supplied in the request body on POST.
+func (h *handler) schema(resp http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		if !h.authenticate(resp, req) {
+			resp.WriteHeader(http.StatusForbidden)
+			return
+		}
+		resp.Header().Set(ContentType, ContentTypeJSON)
+		json.NewEncoder(resp).Encode(h.db.Schema())
+	case http.MethodPost:
+		if !h.authorizeSchemaChange(resp, req) {
+			resp.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			badRequest(resp, "Error reading request: %v", err)
+			return
+		}
+		err = h.db.ExecuteDDL(string(body))
+		if err != nil {
+			badRequest(resp, "Unable to change schema: %v", err)
+			return
+		}
+		resp.WriteHeader(http.StatusOK)
+	default:
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(resp, "Method %v not allowed\n", req.Method)
+	}
+}
+
+// authorizeSchemaChange requires schema changes (and other administrative
+// operations like snapshots) to present the static auth token if one is
+// configured, regardless of any GitHub session. Without a configured password,
+// schema changes are authenticated like everything else.
+func (h *handler) authorizeSchemaChange(resp http.ResponseWriter, req *http.Request) bool {
+	if h.Opts.Password != "" {
+		return req.Header.Get(authheader) == h.Opts.Password
+	}
+	return h.authenticate(resp, req)
+}
@@ -0,0 +1,34 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// snapshot creates a point-in-time snapshot of the database named by the name
+// parameter on POST (see zenodb.DB.SnapshotNamed) and returns information
+// about the snapshot as JSON.
+func (h *handler) snapshot(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		resp.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(resp, "Method %v not allowed\n", req.Method)
+		return
+	}
+	if !h.authorizeSchemaChange(resp, req) {
+		resp.WriteHeader(http.StatusForbidden)
+		return
+	}
+	name := req.FormValue("name")
+	if name == "" {
+		badRequest(resp, "Please specify a name")
+		return
+	}
+	info, err := h.db.SnapshotNamed(name)
+	if err != nil {
+		badRequest(resp, "Unable to snapshot: %v", err)
+		return
+	}
+	resp.Header().Set(ContentType, ContentTypeJSON)
+	json.NewEncoder(resp).Encode(info)
+}
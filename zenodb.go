@@ -24,6 +24,7 @@ import (
 	"github.com/getlantern/zenodb/metrics"
 	"github.com/getlantern/zenodb/planner"
 	"github.com/getlantern/zenodb/sql"
+	"github.com/getlantern/zenodb/tiered"
 	"github.com/go-redis/redis/v8"
 	"github.com/oxtoacart/bpool"
 	"github.com/rickar/props"
@@ -63,6 +64,30 @@ type DBOpts struct {
 	// SchemaFile points at a YAML schema file that configures the tables and
 	// views in the database.
 	SchemaFile string
+	// RestoreFrom points at a snapshot directory (see DB.Snapshot) from which to
+	// initialize Dir. It's ignored if Dir already exists, so it's safe to leave
+	// it set across restarts. The snapshot itself is left untouched. If
+	// SchemaFile isn't specified, the schema from the snapshot is used.
+	RestoreFrom string
+	// RecoverTo, if set, makes tables stop replaying the WAL once they reach a
+	// position that was written after it, which allows recovering the data as
+	// of a point in time, for example from before accidentally inserted data.
+	// WAL positions are timestamped when each WAL segment file is started, which
+	// happens whenever the DB is opened and every 100 MB, so recovery is only as
+	// precise as that. While RecoverTo is set, the DB declines new inserts, so
+	// it's only meant for recovery (see DB.WaitForWALReplay). Combine with
+	// RestoreFrom to replay the WAL from a snapshot.
+	RecoverTo time.Time
+	// ColdStore is the secondary storage to which tables with a ColdAfter
+	// offload their old segments, see package tiered.
+	ColdStore tiered.Store
+	// SnapshotRoot is the directory in which snapshots requested by name (see
+	// DB.SnapshotNamed, which is how remote clients take snapshots) are created.
+	// If empty, snapshots can't be requested by name.
+	SnapshotRoot string
+	// DeleteDroppedTables, if true, deletes the data of dropped tables. By
+	// default, it's moved into Dir/_dropped instead.
+	DeleteDroppedTables bool
 	// AliasesFile points at a file that contains expression aliases in the form
 	// name=template(%v, %v), with one alias per line.
 	AliasesFile string
@@ -86,7 +111,9 @@ type DBOpts struct {
 	WALSyncInterval time.Duration
 	// MaxWALMemoryBacklog sets the maximum number of writes to buffer in memory.
 	MaxWALMemoryBacklog int
-	// MaxWALSize limits how much WAL data to keep (in bytes)
+	// MaxWALSize limits how much WAL data to keep (in bytes). Independently of
+	// this limit, WAL data is removed once it's no longer needed by any table or
+	// follower.
 	MaxWALSize int
 	// WALCompressionSize specifies the size beyond which to compress WAL segments
 	WALCompressionSize int
@@ -100,7 +127,11 @@ type DBOpts struct {
 	// parallel
 	IterationConcurrency int
 	// MaxBackupWait limits how long we're willing to wait for a backup before
-	// resuming file operations
+	// resuming file operations.
+	//
+	// Deprecated: external backups that create a .backup_lock file in Dir are
+	// still honored for now, but will stop being honored in the next release.
+	// Use DB.Snapshot instead.
 	MaxBackupWait time.Duration
 	// Passthrough flags this node as a passthrough (won't store data in tables,
 	// just WAL). Passthrough nodes will also outsource queries to specific
@@ -120,7 +151,8 @@ type DBOpts struct {
 	// for followers to answer a query
 	ClusterQueryTimeout time.Duration
 	// MaxFollowAge limits how far back to go when follower pulls data from
-	// leader
+	// leader. It also limits how long the WAL is retained for followers that
+	// have disconnected (defaults to 24 hours for that purpose).
 	MaxFollowAge time.Duration
 	// MaxFollowQueue limits how many rows to queue for any single follower (defaults to 100,000)
 	MaxFollowQueue int
@@ -168,19 +200,24 @@ type DB struct {
 	clock                 vtime.Clock
 	tables                map[string]*table
 	orderedTables         []*table
+	schema                Schema
+	schemaMx              sync.Mutex
+	snapshotMx            sync.RWMutex
 	walBuffers            *bpool.BytePool
 	streams               map[string]*wal.WAL
 	newStreamSubscriber   map[string]chan *tableWithOffsets
 	newStreamSubscriberMx sync.Mutex
 	tablesMutex           sync.RWMutex
-	isSorting             bool
-	nextTableToSort       int
 	memory                uint64
 	logMemStatsCh         chan *memoryInfo
 	flushMutex            sync.Mutex
 	followerJoined        chan *follower
 	processFollowersOnce  sync.Once
+	followerOffsets       map[string]map[common.FollowerID]wal.Offset
+	followerOffsetsMx     sync.RWMutex
 	remoteQueryHandlers   map[int]chan planner.QueryClusterFN
+	runningQueries        map[string]*runningQuery
+	runningQueriesMx      sync.Mutex
 	requestedIterations   chan *iteration
 	coalescedIterations   chan []*iteration
 	tasks                 sync.WaitGroup
@@ -217,6 +254,7 @@ func NewDB(opts *DBOpts) (*DB, error) {
 		logMemStatsCh:       make(chan *memoryInfo),
 		followerJoined:      make(chan *follower, opts.NumPartitions),
 		remoteQueryHandlers: make(map[int]chan planner.QueryClusterFN),
+		runningQueries:      make(map[string]*runningQuery),
 		requestedIterations: make(chan *iteration, 1000), // TODO, make the iteration backlog tunable
 		coalescedIterations: make(chan []*iteration, opts.IterationConcurrency),
 		closing:             make(chan interface{}),
@@ -246,6 +284,22 @@ func NewDB(opts *DBOpts) (*DB, error) {
 	if db.opts.ReadOnly {
 		db.log.Debugf("DB is ReadOnly, will not persist data to disk")
 	} else {
+		if opts.RestoreFrom != "" {
+			err = db.restore()
+			if err != nil {
+				return nil, err
+			}
+			restoredSchemaFile := filepath.Join(opts.Dir, snapshotSchemaFilename)
+			if _, statErr := os.Stat(restoredSchemaFile); opts.SchemaFile == "" && statErr == nil {
+				opts.SchemaFile = restoredSchemaFile
+			}
+		}
+		if !opts.RecoverTo.IsZero() {
+			if opts.Follow != nil {
+				return nil, fmt.Errorf("RecoverTo can't be used with Follow")
+			}
+			db.log.Debugf("Recovering data as of %v, not replaying later WAL positions", opts.RecoverTo.In(time.UTC))
+		}
 		// Create db dir
 		err = os.MkdirAll(opts.Dir, 0755)
 		if err != nil && !os.IsExist(err) {
@@ -348,6 +402,15 @@ func (db *DB) Close() {
 	db.log.Debug("Closed")
 }
 
+func (db *DB) isClosing() bool {
+	select {
+	case <-db.closing:
+		return true
+	default:
+		return false
+	}
+}
+
 func (db *DB) registerAliases(aliasesFile string) {
 	db.log.Debugf("Registering aliases from file at %v", aliasesFile)
 
@@ -405,14 +468,18 @@ func (db *DB) AllTableStats() map[string]TableStats {
 func (db *DB) PrintTableStats(table string) string {
 	stats := db.TableStats(table)
 	now := db.clock.Now()
-	return fmt.Sprintf("%v (%v)\tFiltered: %v    Queued: %v    Inserted: %v    Dropped: %v    Expired: %v",
+	return fmt.Sprintf("%v (%v)\tFiltered: %v    Queued: %v    Inserted: %v    Dropped: %v    Expired: %v    Expired Segments: %v (%v)    Offloaded Segments: %v (%v)",
 		table,
 		now.In(time.UTC),
 		humanize.Comma(stats.FilteredPoints),
 		humanize.Comma(stats.QueuedPoints),
 		humanize.Comma(stats.InsertedPoints),
 		humanize.Comma(stats.DroppedPoints),
-		humanize.Comma(stats.ExpiredValues))
+		humanize.Comma(stats.ExpiredValues),
+		humanize.Comma(stats.ExpiredSegments),
+		humanize.Bytes(uint64(stats.ExpiredBytes)),
+		humanize.Comma(stats.OffloadedSegments),
+		humanize.Bytes(uint64(stats.OffloadedBytes)))
 }
 
 func (db *DB) getTable(table string) *table {
@@ -426,27 +493,6 @@ func (db *DB) now(table string) time.Time {
 	return db.clock.Now()
 }
 
-func (db *DB) capWALAge(wal *wal.WAL, stop <-chan interface{}) {
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-stop:
-			return
-		default:
-			db.waitForBackupToFinish(stop)
-			err := wal.TruncateToSize(int64(db.opts.MaxWALSize))
-			if err != nil {
-				db.log.Errorf("Error truncating WAL: %v", err)
-			}
-			err = wal.CompressBeforeSize(int64(db.opts.WALCompressionSize))
-			if err != nil {
-				db.log.Errorf("Error compressing WAL: %v", err)
-			}
-		}
-	}
-}
-
 func (db *DB) trackMemStats() {
 	for {
 		db.updateMemStats()
@@ -563,7 +609,8 @@ func (a byCurrentSize) Len() int           { return len(a) }
 func (a byCurrentSize) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a byCurrentSize) Less(i, j int) bool { return a[i].size > a[j].size }
 
-// waitForBackupToFinish waits until there's no .backup_lock file in the dbdir
+// waitForBackupToFinish waits until there's no .backup_lock file in the dbdir.
+// This is deprecated in favor of DB.Snapshot, see DBOpts.MaxBackupWait.
 func (db *DB) waitForBackupToFinish(stop <-chan interface{}) {
 	lockFile := filepath.Join(db.opts.Dir, ".backup_lock")
 	start := time.Now()
@@ -580,7 +627,7 @@ func (db *DB) waitForBackupToFinish(stop <-chan interface{}) {
 			db.log.Debugf("%v is older than %v, continuing", lockFile, db.opts.MaxBackupWait)
 			return
 		}
-		db.log.Debugf("Waiting for backup to finish")
+		db.log.Debugf("Waiting for backup to finish (.backup_lock is deprecated, use snapshots instead)")
 		select {
 		case <-stop:
 			return
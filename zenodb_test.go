@@ -370,7 +370,7 @@ view_a:
 	if !isClustered {
 		table := db.getTable("test_a")
 		fields := table.getFields()
-		table.iterate(context.Background(), fields, true, func(dims bytemap.ByteMap, vals []encoding.Sequence) (bool, error) {
+		table.iterate(context.Background(), fields, true, time.Time{}, time.Time{}, func(dims bytemap.ByteMap, vals []encoding.Sequence) (bool, error) {
 			log.Debugf("Dims: %v")
 			for i, val := range vals {
 				field := fields[i]